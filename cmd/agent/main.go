@@ -2,32 +2,29 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
+	"github.com/yanmxa/straw/pkg/apis"
+	workv1alpha1 "github.com/yanmxa/straw/pkg/apis/work/v1alpha1"
+	"github.com/yanmxa/straw/pkg/cluster"
+	"github.com/yanmxa/straw/pkg/controller"
 	informers "github.com/yanmxa/straw/pkg/informer"
 	"github.com/yanmxa/straw/pkg/option"
 	"github.com/yanmxa/straw/pkg/provider"
 	"github.com/yanmxa/straw/pkg/transport"
 	"github.com/yanmxa/straw/pkg/utils"
-	appsv1 "k8s.io/api/apps/v1"
 )
 
 func init() {
@@ -39,38 +36,53 @@ func main() {
 	defer cancel()
 
 	opt := option.ParseOptionFromFlag()
+	// the agent serves the requests to its cluster, and lists and watches the resources from the hub
+	if err := opt.RenderTopics(opt.ClusterName, utils.HubClusterName); err != nil {
+		klog.Fatal(err)
+	}
 	restConfig, err := clientcmd.BuildConfigFromFlags("", opt.KubeConfig)
 	if err != nil {
 		panic(err.Error())
 	}
 
 	// transport for both informer and provider
-	transporter := transport.NewMqttTransport(ctx, opt)
+	transporter, err := transport.New(ctx, opt)
+	if err != nil {
+		klog.Fatalf("failed to create the transport: %v", err)
+	}
 
-	// start a provider to list/watch local resource and send to transporter
+	feedbackRules := []*controller.FeedbackRules{}
+	if opt.StatusFeedbackConfig != "" {
+		feedbackRules, err = controller.LoadFeedbackRules(opt.StatusFeedbackConfig)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+	feedbackTweak := controller.FeedbackTweakFunc(feedbackRules)
+
+	// start a provider to list/watch local resource and send to transporter, the status feedback declared by the
+	// rules is extracted into the resources
 	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+	provider.WatchCacheCapacity = opt.WatchCacheCapacity
 	p := provider.NewDefaultProvider(opt.ClusterName, dynamicClient, transporter,
-		opt.ProviderSendTopic, opt.ProviderReceiveTopic,
+		opt.ProviderSendTopic, opt.ProviderReceiveTopic, opt.ListChunkSize,
 		func(obj metav1.Object, clusterName string) {
-			obj.SetNamespace(clusterName)
-
-			byteObj, err := json.Marshal(obj)
-			if err != nil {
-				klog.Errorf("marshal object failed: %v", err)
+			// the applied works keep the namespace of the works on the hub
+			if !controller.IsManifestWork(obj) {
+				obj.SetNamespace(clusterName)
 			}
-			labels := obj.GetLabels()
-			if labels == nil {
-				labels = map[string]string{}
-			}
-			labels["object"] = string(byteObj)
-			obj.SetLabels(labels)
+			feedbackTweak(obj, clusterName)
 		})
 	go p.Run(ctx)
 
-	gvr := schema.GroupVersionResource{
-		Group:    "apps",
-		Version:  "v1",
-		Resource: "deployments",
+	// the manager caches the resources of the cluster once it's registered by the heartbeats
+	if opt.HeartbeatTopic != "" {
+		go cluster.TransportHeartbeat(ctx, transporter, opt.HeartbeatTopic, opt.ClusterName, opt.HeartbeatInterval)
+	}
+
+	gvrs, err := opt.GroupVersionResources()
+	if err != nil {
+		klog.Fatal(err)
 	}
 
 	// wait until the provider is ready
@@ -81,132 +93,41 @@ func main() {
 	informerFactory := informers.NewSharedMessageInformerFactory(ctx, transporter, time.Minute*5,
 		opt.InformerSendTopic, opt.InformerReceiveTopic, opt.ClusterName, nil)
 
-	deployInformer := informerFactory.ForResource(gvr)
-	addInformerHandler(ctx, deployInformer.Informer(), restConfig, gvr)
-	informerFactory.Start()
-
-	<-ctx.Done()
-	time.Sleep(2 * time.Second) // wait for the informer send stop signal to transporter
-	transporter.Stop()
-}
-
-func addInformerHandler(ctx context.Context, informer cache.SharedIndexInformer, restConfig *rest.Config, gvr schema.GroupVersionResource) {
-	dynamicClient, err := dynamic.NewForConfig(restConfig)
-	if err != nil {
-		panic(err.Error())
-	}
-	kubeClient, err := kubernetes.NewForConfig(restConfig)
-	if err != nil {
-		panic(err.Error())
+	manifestController := controller.NewManifestController(ctx, dynamicClient)
+	for _, gvr := range gvrs {
+		resourceInformer := informerFactory.ForResourceWithMode(gvr, informers.UnstructuredObject)
+		manifestController.AddInformer(gvr, resourceInformer.Informer())
 	}
-	// sharedIndexInformer := informer.Informer()
-	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			accessor, _ := meta.Accessor(obj)
-			validateNamespace(kubeClient, accessor.GetNamespace())
-
-			namespace := accessor.GetNamespace()
-			lastAppliedConfigJSON := accessor.GetAnnotations()["kubectl.kubernetes.io/last-applied-configuration"]
-			deployment := &appsv1.Deployment{}
-			err = json.Unmarshal([]byte(lastAppliedConfigJSON), deployment)
-			if err != nil {
-				panic(err.Error())
-			}
-
-			// pay, _ := json.MarshalIndent(deployment, "", "  ")
-			// klog.Infof("create deployment: %s", string(pay))
-			deployment.SetResourceVersion("")
-			deployment.SetUID("")
-			deployment.SetGeneration(0)
-			deployment.SetManagedFields(nil)
-			deployment.SetNamespace(namespace)
-			_, err = kubeClient.AppsV1().Deployments(accessor.GetNamespace()).Create(context.Background(), deployment, metav1.CreateOptions{})
-			// _, err = dynamicClient.Resource(gvr).Namespace(accessor.GetNamespace()).Create(ctx, &unstructured.Unstructured{Object: unstructuredObj}, metav1.CreateOptions{})
-			if errors.IsAlreadyExists(err) {
-				klog.Infof("already exists %s/%s", accessor.GetNamespace(), accessor.GetName())
-				return
-			} else if err != nil {
-				klog.Errorf("create %s/%s failed: %v", accessor.GetNamespace(), accessor.GetName(), err)
-				return
-			}
-
-			klog.Infof("Added %s/%s", accessor.GetNamespace(), accessor.GetName())
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			// oldAccessor, _ := meta.Accessor(oldObj)
-			newAccessor, _ := meta.Accessor(newObj)
-
-			objJSON := newAccessor.GetLabels()["object"]
-			deployment := &appsv1.Deployment{}
-			err = json.Unmarshal([]byte(objJSON), deployment)
-			if err != nil {
-				panic(err.Error())
-			}
-
-			oldUnstructured, err := dynamicClient.Resource(gvr).Namespace(newAccessor.GetNamespace()).Get(ctx, newAccessor.GetName(), metav1.GetOptions{})
-			if errors.IsNotFound(err) {
-				createDeploy := &appsv1.Deployment{}
-				createDeploy.SetName(deployment.GetName())
-				createDeploy.SetNamespace(deployment.GetNamespace())
-				createDeploy.SetLabels(deployment.GetLabels())
-				createDeploy.SetAnnotations(deployment.GetAnnotations())
-				createDeploy.Spec = deployment.Spec
-				_, err := kubeClient.AppsV1().Deployments(deployment.GetNamespace()).Create(ctx, createDeploy, metav1.CreateOptions{})
-				if err != nil {
-					klog.Error(err)
-				}
-				klog.Infof("create %s/%s", newAccessor.GetNamespace(), newAccessor.GetName())
-				return
-			} else if err != nil {
-				klog.Infof("get %s/%s failed: %v", newAccessor.GetNamespace(), newAccessor.GetName(), err)
-				return
-			}
-
-			oldUnstructuredObj := oldUnstructured.Object
-
-			oldUnstructuredObj["labels"] = deployment.Labels
-			oldUnstructuredObj["annotations"] = deployment.Annotations
-			spec := oldUnstructuredObj["spec"].(map[string]interface{})
-			spec["replicas"] = *deployment.Spec.Replicas
-			oldUnstructuredObj["spec"] = spec
-
-			_, err = dynamicClient.Resource(gvr).Namespace(newAccessor.GetNamespace()).Update(ctx, &unstructured.Unstructured{Object: oldUnstructuredObj}, metav1.UpdateOptions{})
-			if err != nil {
-				klog.Error(err)
-				return
-			}
-			klog.Infof("Updated %s/%s", newAccessor.GetNamespace(), newAccessor.GetName())
-		},
-		DeleteFunc: func(obj interface{}) {
-			accessor, _ := meta.Accessor(obj)
-			err := dynamicClient.Resource(gvr).Namespace(accessor.GetNamespace()).Delete(ctx, accessor.GetName(), metav1.DeleteOptions{})
-			if err != nil {
-				klog.Error(err)
-				return
-			}
-			klog.Infof("Deleted %s/%s", accessor.GetNamespace(), accessor.GetName())
-		},
-	})
-}
+	informerFactory.Start()
 
-func validateNamespace(kubeClient *kubernetes.Clientset, namespace string) error {
-	_, err := kubeClient.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		_, err = kubeClient.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: namespace,
-			},
-		}, metav1.CreateOptions{})
+	// the works may target the cluster from any namespace of the hub, and their manifests are mapped to the resources
+	// by the discovery of the spoke
+	if opt.EnableManifestWork {
+		informers.RegisterObjectFunc(apis.ToGVRString(workv1alpha1.ManifestWorkGVR), func() runtime.Object {
+			return &workv1alpha1.ManifestWork{}
+		})
+		workInformerFactory := informers.NewSharedMessageInformerFactory(ctx, transporter, time.Minute*5,
+			opt.InformerSendTopic, opt.InformerReceiveTopic, metav1.NamespaceAll, nil)
+		mapper := restmapper.NewDeferredDiscoveryRESTMapper(
+			memory.NewMemCacheClient(discovery.NewDiscoveryClientForConfigOrDie(restConfig)))
+		workController := controller.NewWorkController(ctx, opt.ClusterName, dynamicClient, mapper)
+		workController.AddInformer(workInformerFactory.ForResourceWithMode(workv1alpha1.ManifestWorkGVR,
+			informers.TypedObject).Informer())
+		workInformerFactory.Start()
 	}
-	return err
-}
 
-func convertUnstructuredToDeployment(unstructuredObj *unstructured.Unstructured) (*appsv1.Deployment, error) {
-	deploymentObj := &appsv1.Deployment{}
-	err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredObj.Object, deploymentObj)
-	if err != nil {
-		return nil, err
+	// remove the resources deleted from the hub while the agent is down
+	for gvr, synced := range informerFactory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			continue
+		}
+		store := informerFactory.ForResourceWithMode(gvr, informers.UnstructuredObject).Informer().GetStore()
+		if err := manifestController.Cleanup(gvr, store); err != nil {
+			klog.Errorf("failed to clean up %s: %v", gvr, err)
+		}
 	}
 
-	return deploymentObj, nil
+	<-ctx.Done()
+	time.Sleep(2 * time.Second) // wait for the informer send stop signal to transporter
+	transporter.Stop()
 }
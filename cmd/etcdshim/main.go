@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/yanmxa/straw/pkg/etcdshim"
+	"github.com/yanmxa/straw/pkg/informer"
+	"github.com/yanmxa/straw/pkg/option"
+	"github.com/yanmxa/straw/pkg/transport"
+	"github.com/yanmxa/straw/pkg/utils"
+)
+
+// ./bin/etcdshim --broker 127.0.0.1:1883 --client-id etcdshim-id --informer-send /informer/signal --informer-receive /provider/payload --listen-address 127.0.0.1:2379 --resources secrets.v1.
+// kube-apiserver --etcd-servers http://127.0.0.1:2379 ...
+
+func init() {
+	klog.SetLogger(utils.DefaultLogger())
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	opt := option.ParseOptionFromFlag()
+
+	transporter, err := transport.New(ctx, opt)
+	if err != nil {
+		klog.Fatalf("failed to create the transport: %v", err)
+	}
+
+	store := etcdshim.NewStore()
+	syncer := etcdshim.NewSyncer(store, etcdshim.DefaultKeyFunc("/registry"))
+
+	// the informers keep the store up to date with the resources from the transport
+	gvrs, err := opt.GroupVersionResources()
+	if err != nil {
+		klog.Fatal(err)
+	}
+	for _, gvr := range gvrs {
+		resourceInformer := informer.NewFilteredUnstructuredInformer(ctx, transporter, gvr, metav1.NamespaceAll,
+			time.Minute*5, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil,
+			opt.InformerSendTopic, opt.InformerReceiveTopic)
+		syncer.AddInformer(gvr, resourceInformer.Informer())
+		go resourceInformer.Informer().Run(ctx.Done())
+	}
+
+	listener, err := net.Listen("tcp", opt.ListenAddress)
+	if err != nil {
+		klog.Fatalf("failed to listen on %s: %v", opt.ListenAddress, err)
+	}
+	if err := etcdshim.NewServer(store).Serve(ctx, listener); err != nil {
+		klog.Errorf("etcdshim shut down with error: %v", err)
+	}
+
+	time.Sleep(2 * time.Second) // wait for the informer send stop signal to transporter
+	transporter.Stop()
+}
@@ -36,7 +36,7 @@ func main() {
 
 	opt := option.ParseOptionFromFlag()
 
-	transportClient, err := transport.CloudeventsClient(ctx, opt)
+	transportClient, err := transport.NewCloudeventsClient(ctx, opt)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -52,7 +52,7 @@ func main() {
 	informerFactory := informers.NewSharedEventInformerFactory(ctx, transportClient, time.Minute*5, metav1.NamespaceAll, func(options *metav1.ListOptions) {
 		options.LabelSelector = fmt.Sprintf("%s=", utils.TargetResourceLabelKey)
 	})
-	secretInformer := informerFactory.ForResource(gvr)
+	secretInformer := informerFactory.ForResourceWithMode(gvr, informers.TypedObject)
 
 	// restConfig, err := clientcmd.BuildConfigFromFlags("", opt.KubeConfig)
 	// if err != nil {
@@ -12,7 +12,7 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
-	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/cluster"
 	"github.com/yanmxa/straw/pkg/option"
 	"github.com/yanmxa/straw/pkg/provider"
 	"github.com/yanmxa/straw/pkg/transport"
@@ -32,7 +32,7 @@ func main() {
 
 	opt := option.ParseOptionFromFlag()
 
-	transportClient, err := transport.CloudeventsClient(ctx, opt)
+	transportClient, err := transport.NewCloudeventsClient(ctx, opt)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -44,14 +44,16 @@ func main() {
 			klog.Fatalf("failed to build config, %v", err)
 		}
 		dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+		provider.WatchCacheCapacity = opt.WatchCacheCapacity
 
-		p := provider.NewProvider(opt.ClusterName, dynamicClient, transportClient,
+		p := provider.NewProvider(opt.ClusterName, dynamicClient, transportClient, opt.ListChunkSize,
 			func(obj metav1.Object, clusterName string) {
 				labels := obj.GetLabels()
 				if labels == nil {
 					labels = map[string]string{}
 				}
 				labels[utils.ClusterLabelKey] = clusterName
+				obj.SetLabels(labels)
 			})
 
 		err = p.Run(ctx)
@@ -60,21 +62,8 @@ func main() {
 		}
 	}()
 
-	// heartbeat to send the signal to hub cluster
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-Loop:
-	for {
-		select {
-		case <-ctx.Done():
-			break Loop
-		case <-ticker.C:
-			err := transportClient.Send(ctx, apis.NewHeartbeatEvent(opt.ClusterName))
-			if err != nil {
-				klog.Errorf("failed to send heartbeat event")
-			}
-		}
-	}
+	// heartbeat to renew the lease of the cluster on the hub cluster, the cluster is unregistered once it's stopped
+	cluster.Heartbeat(ctx, transportClient, opt.ClusterName, opt.HeartbeatInterval)
 	klog.Info("provider shut down gracefully")
 	time.Sleep(5 * time.Second)
 }
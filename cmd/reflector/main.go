@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
+	_ "modernc.org/sqlite"
 
 	"github.com/yanmxa/straw/pkg/option"
 	"github.com/yanmxa/straw/pkg/reflector"
@@ -29,14 +33,45 @@ func main() {
 
 	opt := option.ParseOptionFromFlag()
 
-	transportClient, err := transport.CloudeventsClient(ctx, opt)
+	transportClient, err := transport.NewCloudeventsClient(ctx, opt)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
 
-	refactorFactory := reflector.NewReflectorFactory(ctx, gvr, transportClient)
+	var store cache.Indexer = reflector.NewClusterIndexer()
+	switch {
+	case opt.SQLDataSource != "":
+		db, err := sql.Open(opt.SQLDriver, opt.SQLDataSource)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		store, err = reflector.NewSQLStore(db, opt.SQLDriver, gvr)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case opt.StorePath != "":
+		boltStore, err := reflector.NewBoltStore(opt.StorePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer boltStore.Close()
+		store = boltStore
+	}
+	refactorFactory := reflector.NewReflectorFactoryWithStore(ctx, gvr, transportClient, opt.LeaseDuration, store)
+	refactorFactory.AddEventHandler(reflector.ClusterEventHandlerFuncs{
+		AddFunc: func(cluster string, obj interface{}, isInInitialList bool) {
+			klog.Infof("OnAdd %s: %s", cluster, objectName(obj))
+		},
+		UpdateFunc: func(cluster string, oldObj, newObj interface{}) {
+			klog.Infof("OnUpdate %s: %s", cluster, objectName(newObj))
+		},
+		DeleteFunc: func(cluster string, obj interface{}) {
+			klog.Infof("OnDelete %s: %s", cluster, objectName(obj))
+		},
+	})
 
 	stopChan := make(chan struct{})
 	defer close(stopChan)
@@ -48,3 +83,11 @@ func main() {
 	refactorFactory.Run(stopChan)
 	time.Sleep(2 * time.Second) // wait for the informer send stop signal to transporter
 }
+
+func objectName(obj interface{}) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err.Error()
+	}
+	return accessor.GetNamespace() + "/" + accessor.GetName()
+}
@@ -18,6 +18,8 @@ type RequestEvent struct {
 type ListResponseEvent struct {
 	Objects   *unstructured.UnstructuredList `json:"objects"`
 	EndOfList bool                           `json:"endOfList"`
+	// Error is the Status of the failed list, it's carried by the terminal response
+	Error *metav1.Status `json:"error,omitempty"`
 }
 
 func EventListResponseType(gvr schema.GroupVersionResource) string {
@@ -62,9 +64,18 @@ func ParseEventType(t string) (string, schema.GroupVersionResource, error) {
 	}, nil
 }
 
+// NewHeartbeatEvent registers the cluster, or renews its lease if it's registered already
 func NewHeartbeatEvent(cluster string) cloudevents.Event {
 	e := cloudevents.NewEvent()
 	e.SetSource(cluster)
 	e.SetType(string(ModeRegister))
 	return e
 }
+
+// NewUnregisterEvent unregisters the cluster once it's shut down gracefully
+func NewUnregisterEvent(cluster string) cloudevents.Event {
+	e := cloudevents.NewEvent()
+	e.SetSource(cluster)
+	e.SetType(string(ModeUnregister))
+	return e
+}
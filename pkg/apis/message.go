@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/google/uuid"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -27,6 +28,16 @@ type TransportMessage struct {
 	Payload []byte `json:"payload"`
 }
 
+// NewHeartbeatMessage registers the cluster, or renews its lease if it's registered already
+func NewHeartbeatMessage(cluster string) TransportMessage {
+	return TransportMessage{Type: string(ModeRegister), ID: uuid.New().String(), Source: cluster}
+}
+
+// NewUnregisterMessage unregisters the cluster once it's shut down gracefully
+func NewUnregisterMessage(cluster string) TransportMessage {
+	return TransportMessage{Type: string(ModeUnregister), ID: uuid.New().String(), Source: cluster}
+}
+
 type RequestMessage struct {
 	Namespace string             `json:"namespace"`
 	Options   metav1.ListOptions `json:"options"`
@@ -35,6 +46,8 @@ type RequestMessage struct {
 type ListResponseMessage struct {
 	Objects   *unstructured.UnstructuredList `json:"objects"`
 	EndOfList bool                           `json:"endOfList"`
+	// Error is the Status of the failed list, it's carried by the terminal response
+	Error *metav1.Status `json:"error,omitempty"`
 }
 
 type WatchResponseMessage struct {
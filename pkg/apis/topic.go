@@ -0,0 +1,71 @@
+package apis
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ClusterPlaceholder is replaced with the cluster of the provider
+	ClusterPlaceholder = "{cluster}"
+	// DirectionPlaceholder is replaced with the Direction of the message
+	DirectionPlaceholder = "{direction}"
+	// GVRPlaceholder is replaced with the resource(apis.ToGVRString) of the message
+	GVRPlaceholder = "{gvr}"
+
+	// topicWildcard matches a single level of the topic, like the MQTT "+"
+	topicWildcard = "+"
+)
+
+type Direction string
+
+const (
+	// DirectionRequest is the direction of the list/watch requests sent by the informers to the providers
+	DirectionRequest Direction = "request"
+	// DirectionResponse is the direction of the responses sent by the providers to the informers
+	DirectionResponse Direction = "response"
+	// DirectionHeartbeat is the direction of the heartbeats sent by the clusters to the hub
+	DirectionHeartbeat Direction = "heartbeat"
+
+	// heartbeatResource fills the {gvr} of the heartbeat topic, the heartbeats aren't of any resource
+	heartbeatResource = "lease"
+)
+
+// TopicTemplate routes the messages of each cluster, direction and resource to their own topic, e.g.
+// straw/{cluster}/{direction}/{gvr}. The cluster is always the one of the provider, so a provider only subscribes to
+// the requests addressed to itself, and the informers of the hub subscribe to the responses of all the clusters by
+// the wildcard.
+type TopicTemplate string
+
+// Render replaces the cluster and direction of the template, the {gvr} is kept for the ResourceTopic and TopicFilter.
+// The empty cluster is rendered into the wildcard, which is only valid for the subscriptions.
+func (t TopicTemplate) Render(cluster string, direction Direction) string {
+	if cluster == "" {
+		cluster = topicWildcard
+	}
+	return strings.NewReplacer(ClusterPlaceholder, cluster, DirectionPlaceholder, string(direction)).Replace(string(t))
+}
+
+// HeartbeatTopic renders the topic the clusters renew their leases on the hub with, e.g. straw/hub/heartbeat/lease.
+// It's shared by all the clusters, so that the hub subscribes to it without the wildcards.
+func (t TopicTemplate) HeartbeatTopic(hub string) string {
+	return strings.NewReplacer(ClusterPlaceholder, hub, DirectionPlaceholder, string(DirectionHeartbeat),
+		GVRPlaceholder, heartbeatResource).Replace(string(t))
+}
+
+// ResourceTopic renders the {gvr} of the topic with the resource, the topic without the placeholder is returned as is
+func ResourceTopic(topic string, gvr schema.GroupVersionResource) string {
+	return strings.ReplaceAll(topic, GVRPlaceholder, ToGVRString(gvr))
+}
+
+// TopicFilter renders the placeholders left in the topic into the wildcards, so that it subscribes to all of them
+func TopicFilter(topic string) string {
+	return strings.NewReplacer(ClusterPlaceholder, topicWildcard, DirectionPlaceholder, topicWildcard,
+		GVRPlaceholder, topicWildcard).Replace(topic)
+}
+
+// ClusterTopic renders the {cluster} of the topic with the cluster
+func ClusterTopic(topic, cluster string) string {
+	return strings.ReplaceAll(topic, ClusterPlaceholder, cluster)
+}
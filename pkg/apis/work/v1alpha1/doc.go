@@ -0,0 +1,4 @@
+// Package v1alpha1 contains the ManifestWork API, which wraps the manifests delivered to the clusters.
+// +k8s:deepcopy-gen=package
+// +groupName=work.straw.io
+package v1alpha1
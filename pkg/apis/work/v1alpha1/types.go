@@ -0,0 +1,123 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ManifestWork wraps the manifests delivered to the target clusters by the transport. The agent of each cluster
+// applies the manifests, and reports their conditions and status feedback in the status of the cluster.
+type ManifestWork struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ManifestWorkSpec   `json:"spec"`
+	Status ManifestWorkStatus `json:"status,omitempty"`
+}
+
+type ManifestWorkSpec struct {
+	// Clusters are the names of the target clusters, the work targets the cluster named after its namespace if it's
+	// empty
+	Clusters []string `json:"clusters,omitempty"`
+
+	// Workload are the manifests applied to the clusters
+	Workload ManifestsTemplate `json:"workload,omitempty"`
+
+	// DeleteOption decides whether the applied resources are deleted with the work, they're deleted by default
+	DeleteOption *DeleteOption `json:"deleteOption,omitempty"`
+
+	// ManifestConfigs declare the status feedback of the manifests
+	ManifestConfigs []ManifestConfig `json:"manifestConfigs,omitempty"`
+}
+
+type ManifestsTemplate struct {
+	Manifests []Manifest `json:"manifests,omitempty"`
+}
+
+// Manifest is a resource applied to the clusters
+type Manifest struct {
+	runtime.RawExtension `json:",inline"`
+}
+
+type DeletePropagationPolicyType string
+
+const (
+	// DeletePropagationPolicyTypeForeground deletes the applied resources with the work
+	DeletePropagationPolicyTypeForeground DeletePropagationPolicyType = "Foreground"
+	// DeletePropagationPolicyTypeOrphan keeps the applied resources once the work is deleted
+	DeletePropagationPolicyTypeOrphan DeletePropagationPolicyType = "Orphan"
+)
+
+type DeleteOption struct {
+	PropagationPolicy DeletePropagationPolicyType `json:"propagationPolicy"`
+}
+
+// ManifestConfig declares the feedback rules of the manifest identified by the ResourceIdentifier
+type ManifestConfig struct {
+	ResourceIdentifier ResourceIdentifier `json:"resourceIdentifier"`
+	FeedbackRules      []FeedbackRule     `json:"feedbackRules,omitempty"`
+}
+
+type ResourceIdentifier struct {
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// FeedbackRule reports the value of the JSONPath(e.g. .status.readyReplicas) of the applied resource as the field Name
+type FeedbackRule struct {
+	Name     string `json:"name"`
+	JSONPath string `json:"jsonPath"`
+}
+
+type ManifestWorkStatus struct {
+	// Clusters are the statuses reported by the target clusters
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
+}
+
+type ClusterStatus struct {
+	ClusterName string `json:"clusterName"`
+	// Conditions of the work on the cluster, e.g. the Applied condition is true once all the manifests are applied
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Manifests are the conditions of each manifest
+	Manifests []ManifestCondition `json:"manifests,omitempty"`
+}
+
+const (
+	// WorkApplied indicates whether the manifest(s) are applied
+	WorkApplied = "Applied"
+	// WorkAvailable indicates whether the manifest exists on the cluster
+	WorkAvailable = "Available"
+)
+
+type ManifestCondition struct {
+	ResourceMeta ManifestResourceMeta `json:"resourceMeta"`
+	// StatusFeedback is the JSON object of the values found by the feedback rules of the manifest
+	StatusFeedback *runtime.RawExtension `json:"statusFeedback,omitempty"`
+	Conditions     []metav1.Condition    `json:"conditions,omitempty"`
+}
+
+// ManifestResourceMeta identifies the manifest, the Ordinal is its index in the workload
+type ManifestResourceMeta struct {
+	Ordinal   int32  `json:"ordinal"`
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Resource  string `json:"resource,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ManifestWorkList is a list of the ManifestWork
+type ManifestWorkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ManifestWork `json:"items"`
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"k8s.io/klog/v2"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/transport"
+)
+
+// Heartbeat registers the cluster to the hub and renews its lease every interval until the context is done, then
+// the cluster is unregistered. The interval should be shorter than the lease duration of the hub registry.
+func Heartbeat(ctx context.Context, client cloudevents.Client, clusterName string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if result := client.Send(ctx, apis.NewHeartbeatEvent(clusterName)); cloudevents.IsUndelivered(result) {
+			klog.Errorf("failed to send the heartbeat of %s: %v", clusterName, result)
+		}
+		select {
+		case <-ctx.Done():
+			// the context is done, so the unregistration is sent with a fresh one
+			sendCtx, cancel := context.WithTimeout(context.Background(), interval)
+			defer cancel()
+			if result := client.Send(sendCtx, apis.NewUnregisterEvent(clusterName)); cloudevents.IsUndelivered(result) {
+				klog.Errorf("failed to unregister %s: %v", clusterName, result)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TransportHeartbeat registers the cluster to the hub by the messages sent to the topic, like the Heartbeat. The hub
+// receives them by the Registry.Receive.
+func TransportHeartbeat(ctx context.Context, t transport.Transport, topic, clusterName string,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := t.Send(topic, apis.NewHeartbeatMessage(clusterName)); err != nil {
+			klog.Errorf("failed to send the heartbeat of %s: %v", clusterName, err)
+		}
+		select {
+		case <-ctx.Done():
+			if err := t.Send(topic, apis.NewUnregisterMessage(clusterName)); err != nil {
+				klog.Errorf("failed to unregister %s: %v", clusterName, err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
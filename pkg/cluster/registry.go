@@ -0,0 +1,210 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/transport"
+)
+
+type State string
+
+const (
+	// StateAvailable means the lease of the cluster is renewed by its heartbeats
+	StateAvailable State = "Available"
+	// StateUnknown means the lease of the cluster is expired, the cluster may be down or disconnected
+	StateUnknown State = "Unknown"
+)
+
+// Cluster is the liveness of a registered cluster
+type Cluster struct {
+	Name     string
+	State    State
+	LastSeen time.Time
+}
+
+// Registry tracks the liveness of the clusters by the leases renewed by their heartbeats. The onAvailable is invoked
+// once a cluster is registered or comes back, and the onUnavailable once its lease is expired or it's unregistered.
+// The callbacks of a cluster are serialized, and always alternate between the two.
+type Registry struct {
+	mutex    sync.RWMutex
+	clusters map[string]*Cluster
+	// notified is the state the callbacks of each cluster are invoked for, the notifying lock of a cluster
+	// serializes its callbacks, it's kept after the cluster is unregistered in case it comes back
+	notified      map[string]State
+	notifying     map[string]*sync.Mutex
+	leaseDuration time.Duration
+	clock         clock.Clock
+	onAvailable   func(name string)
+	onUnavailable func(name string)
+}
+
+func NewRegistry(leaseDuration time.Duration, onAvailable, onUnavailable func(name string)) *Registry {
+	return newRegistry(leaseDuration, clock.RealClock{}, onAvailable, onUnavailable)
+}
+
+func newRegistry(leaseDuration time.Duration, clock clock.Clock,
+	onAvailable, onUnavailable func(name string),
+) *Registry {
+	return &Registry{
+		clusters:      map[string]*Cluster{},
+		notified:      map[string]State{},
+		notifying:     map[string]*sync.Mutex{},
+		leaseDuration: leaseDuration,
+		clock:         clock,
+		onAvailable:   onAvailable,
+		onUnavailable: onUnavailable,
+	}
+}
+
+// Heartbeat renews the lease of the cluster, and registers it if it's new or unknown
+func (r *Registry) Heartbeat(name string) {
+	r.mutex.Lock()
+	c, ok := r.clusters[name]
+	if !ok {
+		c = &Cluster{Name: name}
+		r.clusters[name] = c
+	}
+	c.LastSeen = r.clock.Now()
+	available := c.State != StateAvailable
+	c.State = StateAvailable
+	r.mutex.Unlock()
+
+	if available {
+		klog.Infof("cluster %s is available", name)
+		r.notify(name)
+	}
+}
+
+// Unregister removes the cluster, which is shut down gracefully
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	c, ok := r.clusters[name]
+	delete(r.clusters, name)
+	r.mutex.Unlock()
+
+	if ok && c.State == StateAvailable {
+		klog.Infof("cluster %s is unregistered", name)
+		r.notify(name)
+	}
+}
+
+// Get returns a copy of the registered cluster
+func (r *Registry) Get(name string) (Cluster, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	c, ok := r.clusters[name]
+	if !ok {
+		return Cluster{}, false
+	}
+	return *c, true
+}
+
+// List returns the copies of the registered clusters sorted by the name
+func (r *Registry) List() []Cluster {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	clusters := make([]Cluster, 0, len(r.clusters))
+	for _, c := range r.clusters {
+		clusters = append(clusters, *c)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	return clusters
+}
+
+// Receive registers the clusters by the heartbeats sent to the topic by the TransportHeartbeat until the context is
+// done
+func (r *Registry) Receive(ctx context.Context, t transport.Transport, topic string) error {
+	receiver, err := t.Receive(topic)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-receiver.MessageChan():
+			if !ok {
+				return nil
+			}
+			switch apis.Mode(msg.Type) {
+			case apis.ModeRegister:
+				klog.V(4).Infof("received the heartbeat of %s", msg.Source)
+				r.Heartbeat(msg.Source)
+			case apis.ModeUnregister:
+				r.Unregister(msg.Source)
+			}
+			transport.Ack(receiver)
+		}
+	}
+}
+
+// Run checks the leases of the clusters until the stopCh is closed
+func (r *Registry) Run(stopCh <-chan struct{}) {
+	interval := r.leaseDuration / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	wait.Until(r.expire, interval, stopCh)
+}
+
+// expire marks the available clusters whose leases aren't renewed in time as unknown
+func (r *Registry) expire() {
+	expired := []string{}
+	r.mutex.Lock()
+	now := r.clock.Now()
+	for name, c := range r.clusters {
+		if c.State == StateAvailable && now.Sub(c.LastSeen) > r.leaseDuration {
+			c.State = StateUnknown
+			expired = append(expired, name)
+		}
+	}
+	r.mutex.Unlock()
+
+	for _, name := range expired {
+		klog.Warningf("the lease of cluster %s is expired", name)
+		r.notify(name)
+	}
+}
+
+// notify invokes the callback for the current state of the cluster unless it's notified already. The state is read
+// under the notifying lock of the cluster, so that the callback of a stale transition, which loses the race to
+// the lock, can't overwrite the one of the latest transition.
+func (r *Registry) notify(name string) {
+	r.mutex.Lock()
+	notifying, ok := r.notifying[name]
+	if !ok {
+		notifying = &sync.Mutex{}
+		r.notifying[name] = notifying
+	}
+	r.mutex.Unlock()
+
+	notifying.Lock()
+	defer notifying.Unlock()
+	r.mutex.Lock()
+	available := false
+	if c, ok := r.clusters[name]; ok {
+		available = c.State == StateAvailable
+	}
+	wasAvailable := r.notified[name] == StateAvailable
+	if available {
+		r.notified[name] = StateAvailable
+	} else {
+		r.notified[name] = StateUnknown
+	}
+	r.mutex.Unlock()
+
+	switch {
+	case available && !wasAvailable:
+		r.onAvailable(name)
+	case !available && wasAvailable:
+		r.onUnavailable(name)
+	}
+}
@@ -0,0 +1,220 @@
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	testingclock "k8s.io/utils/clock/testing"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/transport"
+)
+
+type recorder struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(prefix string) func(string) {
+	return func(name string) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.events = append(r.events, prefix+" "+name)
+	}
+}
+
+func (r *recorder) list() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.events...)
+}
+
+func TestRegistry(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now())
+	events := &recorder{}
+	registry := newRegistry(time.Minute, clock, events.record("available"), events.record("unavailable"))
+
+	registry.Heartbeat("cluster1")
+	registry.Heartbeat("cluster2")
+	clock.Step(40 * time.Second)
+	registry.Heartbeat("cluster1") // renewed, not registered again
+	clock.Step(40 * time.Second)
+	registry.expire()
+
+	if c, _ := registry.Get("cluster1"); c.State != StateAvailable || !c.LastSeen.Equal(clock.Now().Add(-40*time.Second)) {
+		t.Fatalf("expected cluster1 available, got %v", c)
+	}
+	if c, _ := registry.Get("cluster2"); c.State != StateUnknown {
+		t.Fatalf("expected the lease of cluster2 expired, got %v", c)
+	}
+
+	// the unknown cluster comes back, and the other one leaves
+	registry.Heartbeat("cluster2")
+	registry.Unregister("cluster1")
+	registry.Unregister("cluster3")
+	if clusters := registry.List(); len(clusters) != 1 || clusters[0].Name != "cluster2" {
+		t.Fatalf("expected only cluster2 registered, got %v", clusters)
+	}
+
+	expected := []string{"available cluster1", "available cluster2", "unavailable cluster2", "available cluster2",
+		"unavailable cluster1"}
+	if !reflect.DeepEqual(events.list(), expected) {
+		t.Fatalf("expected the events %v, got %v", expected, events.list())
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := transport.NewMemoryBroker()
+	hub, err := transport.MemoryCloudeventsClient(broker, "signal", "heartbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, err := transport.MemoryCloudeventsClient(broker, "heartbeat", "signal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := &recorder{}
+	registry := NewRegistry(time.Minute, events.record("available"), events.record("unavailable"))
+	go func() {
+		_ = hub.StartReceiver(ctx, func(event cloudevents.Event) {
+			switch event.Type() {
+			case string(apis.ModeRegister):
+				registry.Heartbeat(event.Source())
+			case string(apis.ModeUnregister):
+				registry.Unregister(event.Source())
+			}
+		})
+	}()
+
+	agentCtx, stopAgent := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		Heartbeat(agentCtx, agent, "cluster1", 10*time.Millisecond)
+		close(stopped)
+	}()
+	waitFor(t, func() bool {
+		c, ok := registry.Get("cluster1")
+		return ok && c.State == StateAvailable
+	})
+
+	stopAgent()
+	<-stopped
+	waitFor(t, func() bool {
+		_, ok := registry.Get("cluster1")
+		return !ok
+	})
+	if expected := []string{"available cluster1", "unavailable cluster1"}; !reflect.DeepEqual(events.list(),
+		expected) {
+		t.Fatalf("expected the events %v, got %v", expected, events.list())
+	}
+}
+
+func TestTransportHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := transport.NewMemoryBroker()
+	hub := transport.NewMemoryTransport(broker)
+	defer hub.Stop()
+	agent := transport.NewMemoryTransport(broker)
+	defer agent.Stop()
+
+	topic := apis.TopicTemplate("straw/{cluster}/{direction}/{gvr}").HeartbeatTopic("hub")
+	events := &recorder{}
+	registry := NewRegistry(time.Minute, events.record("available"), events.record("unavailable"))
+	received := make(chan error)
+	receiverCtx, stopReceiver := context.WithCancel(ctx)
+	go func() {
+		received <- registry.Receive(receiverCtx, hub, topic)
+	}()
+
+	// the heartbeats sent before the receiver subscribes to the topic are dropped, the later ones register the cluster
+	agentCtx, stopAgent := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		TransportHeartbeat(agentCtx, agent, topic, "cluster1", 10*time.Millisecond)
+		close(stopped)
+	}()
+	waitFor(t, func() bool {
+		c, ok := registry.Get("cluster1")
+		return ok && c.State == StateAvailable
+	})
+
+	stopAgent()
+	<-stopped
+	waitFor(t, func() bool {
+		_, ok := registry.Get("cluster1")
+		return !ok
+	})
+	if expected := []string{"available cluster1", "unavailable cluster1"}; !reflect.DeepEqual(events.list(),
+		expected) {
+		t.Fatalf("expected the events %v, got %v", expected, events.list())
+	}
+	stopReceiver()
+	if err := <-received; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the condition")
+}
+
+func TestRegistryCallbacksRace(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now())
+	events := &recorder{}
+	// the slow onAvailable widens the window for the callbacks of the later transitions to overtake it
+	recordAvailable := events.record("available")
+	registry := newRegistry(time.Minute, clock, func(name string) {
+		time.Sleep(time.Millisecond)
+		recordAvailable(name)
+	}, events.record("unavailable"))
+
+	// the heartbeats race against the expiry and unregistering of the cluster
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			registry.Heartbeat("cluster1")
+		}()
+		go func() {
+			defer wg.Done()
+			clock.Step(2 * time.Minute)
+			registry.expire()
+		}()
+		go func() {
+			defer wg.Done()
+			registry.Unregister("cluster1")
+		}()
+	}
+	wg.Wait()
+
+	// the callbacks alternate, and the last one matches the final state of the cluster
+	list := events.list()
+	for i, event := range list {
+		expected := "available cluster1"
+		if i%2 == 1 {
+			expected = "unavailable cluster1"
+		}
+		if event != expected {
+			t.Fatalf("expected the event %d to be %q, got %v", i, expected, list)
+		}
+	}
+	c, ok := registry.Get("cluster1")
+	if available := ok && c.State == StateAvailable; available != (len(list)%2 == 1) {
+		t.Fatalf("expected the events %v to end with the state of the cluster %v", list, c)
+	}
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// FeedbackRule reports the value of the JSONPath(e.g. .status.readyReplicas) of the spoke resource as the field Name
+type FeedbackRule struct {
+	Name     string `json:"name"`
+	JSONPath string `json:"jsonPath"`
+}
+
+// FeedbackConfig declares the feedback rules of a resource(<resource>.<version>.<group>) of the kind
+type FeedbackConfig struct {
+	Resource string         `json:"resource"`
+	Kind     string         `json:"kind"`
+	Rules    []FeedbackRule `json:"rules"`
+}
+
+// FeedbackRules are the parsed rules of a resource
+type FeedbackRules struct {
+	GVR   schema.GroupVersionResource
+	Kind  string
+	rules map[string]*jsonpath.JSONPath
+}
+
+// LoadFeedbackRules reads the list of the FeedbackConfig from the YAML or JSON file
+func LoadFeedbackRules(path string) ([]*FeedbackRules, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configs := []FeedbackConfig{}
+	if err := yaml.UnmarshalStrict(content, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse the status feedback config %s: %v", path, err)
+	}
+
+	feedbackRules := []*FeedbackRules{}
+	for _, config := range configs {
+		rules, err := NewFeedbackRules(config)
+		if err != nil {
+			return nil, err
+		}
+		feedbackRules = append(feedbackRules, rules)
+	}
+	return feedbackRules, nil
+}
+
+func NewFeedbackRules(config FeedbackConfig) (*FeedbackRules, error) {
+	gvr, _ := schema.ParseResourceArg(config.Resource)
+	if gvr == nil {
+		return nil, fmt.Errorf("invalid resource %q, it should be in the format of <resource>.<version>.<group>",
+			config.Resource)
+	}
+	if config.Kind == "" {
+		return nil, fmt.Errorf("the kind of the resource %q is required", config.Resource)
+	}
+
+	rules, err := compileFeedbackRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return &FeedbackRules{GVR: *gvr, Kind: config.Kind, rules: rules}, nil
+}
+
+func compileFeedbackRules(rules []FeedbackRule) (map[string]*jsonpath.JSONPath, error) {
+	compiled := map[string]*jsonpath.JSONPath{}
+	for _, rule := range rules {
+		path := rule.JSONPath
+		if !strings.HasPrefix(path, "{") {
+			path = "{" + path + "}"
+		}
+		j := jsonpath.New(rule.Name).AllowMissingKeys(true)
+		if err := j.Parse(path); err != nil {
+			return nil, fmt.Errorf("invalid jsonPath %q of the rule %s: %v", rule.JSONPath, rule.Name, err)
+		}
+		compiled[rule.Name] = j
+	}
+	return compiled, nil
+}
+
+// Extract returns the values of the rules found in the object, a rule matching several values reports them in a list
+func (r *FeedbackRules) Extract(obj *unstructured.Unstructured) (map[string]interface{}, error) {
+	feedback := map[string]interface{}{}
+	for name, j := range r.rules {
+		results, err := j.FindResults(obj.Object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the values of %s: %v", name, err)
+		}
+		values := []interface{}{}
+		for _, result := range results {
+			for _, value := range result {
+				values = append(values, value.Interface())
+			}
+		}
+		switch len(values) {
+		case 0:
+		case 1:
+			feedback[name] = values[0]
+		default:
+			feedback[name] = values
+		}
+	}
+	return feedback, nil
+}
+
+// FeedbackTweakFunc extracts the feedback of the spoke resources sent by the provider into the
+// utils.StatusFeedbackAnnotationKey annotation, and labels them with the cluster name.
+func FeedbackTweakFunc(feedbackRules []*FeedbackRules) func(obj metav1.Object, clusterName string) {
+	rulesByKind := map[schema.GroupVersionKind]*FeedbackRules{}
+	for _, rules := range feedbackRules {
+		rulesByKind[rules.GVR.GroupVersion().WithKind(rules.Kind)] = rules
+	}
+
+	return func(obj metav1.Object, clusterName string) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		rules, ok := rulesByKind[u.GroupVersionKind()]
+		if !ok {
+			return
+		}
+		feedback, err := rules.Extract(u)
+		if err != nil {
+			klog.Errorf("failed to extract the feedback of %s %s/%s: %v", rules.Kind, u.GetNamespace(), u.GetName(), err)
+			return
+		}
+		value, err := json.Marshal(feedback)
+		if err != nil {
+			klog.Errorf("failed to marshal the feedback of %s %s/%s: %v", rules.Kind, u.GetNamespace(), u.GetName(), err)
+			return
+		}
+
+		annotations := u.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[utils.StatusFeedbackAnnotationKey] = string(value)
+		u.SetAnnotations(annotations)
+
+		labels := u.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[utils.ClusterLabelKey] = clusterName
+		u.SetLabels(labels)
+	}
+}
+
+// FeedbackRecordKey is the annotation key of the feedback record of the cluster on the hub resource
+func FeedbackRecordKey(clusterName string) string {
+	return utils.StatusFeedbackRecordPrefix + "/" + clusterName
+}
+
+// RemoveFeedbackRecords removes the feedback records from the hub resource, so that they aren't delivered back to the
+// spoke clusters.
+func RemoveFeedbackRecords(obj metav1.Object) {
+	annotations := obj.GetAnnotations()
+	for key := range annotations {
+		if strings.HasPrefix(key, utils.StatusFeedbackRecordPrefix+"/") {
+			delete(annotations, key)
+		}
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// FeedbackController writes the feedback of the spoke resources to the per-cluster records(the
+// FeedbackRecordKey annotations) of the hub resources.
+type FeedbackController struct {
+	ctx    context.Context
+	client dynamic.Interface
+}
+
+func NewFeedbackController(ctx context.Context, client dynamic.Interface) *FeedbackController {
+	return &FeedbackController{
+		ctx:    ctx,
+		client: client,
+	}
+}
+
+// AddInformer records the feedback of the spoke resources cached by the informer, the metadata informer is enough
+// since the feedback is carried by the annotation. It should be invoked before the informer is started.
+func (c *FeedbackController) AddInformer(gvr schema.GroupVersionResource, informer cache.SharedIndexInformer) {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if err := c.record(gvr, obj, false); err != nil {
+				klog.Errorf("failed to record the feedback of %s: %v", gvr, err)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if err := c.record(gvr, newObj, false); err != nil {
+				klog.Errorf("failed to record the feedback of %s: %v", gvr, err)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if err := c.record(gvr, obj, true); err != nil {
+				klog.Errorf("failed to remove the feedback of %s: %v", gvr, err)
+			}
+		},
+	})
+}
+
+// record writes the feedback of the spoke resource to the hub resource it's applied from, the record is removed if
+// the spoke resource is deleted
+func (c *FeedbackController) record(gvr schema.GroupVersionResource, obj interface{}, deleted bool) error {
+	spokeObj, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	clusterName := spokeObj.GetLabels()[utils.ClusterLabelKey]
+	if clusterName == "" {
+		clusterName = spokeObj.GetNamespace()
+	}
+	feedback, ok := spokeObj.GetAnnotations()[utils.StatusFeedbackAnnotationKey]
+	if !ok && !deleted {
+		return nil
+	}
+
+	resource := c.client.Resource(gvr).Namespace(spokeObj.GetNamespace())
+	hubObj, err := resource.Get(c.ctx, spokeObj.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// the spoke resource isn't applied from the hub resource, e.g. the hub resource is recreated
+	if uid, ok := spokeObj.GetLabels()[utils.OriginalOwnerReferenceIDLabelKey]; ok && uid != string(hubObj.GetUID()) {
+		return nil
+	}
+
+	key := FeedbackRecordKey(clusterName)
+	annotations := hubObj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if record, exists := annotations[key]; (deleted && !exists) || (!deleted && exists && record == feedback) {
+		return nil
+	}
+	if deleted {
+		delete(annotations, key)
+	} else {
+		annotations[key] = feedback
+	}
+	hubObj.SetAnnotations(annotations)
+
+	if _, err = resource.Update(c.ctx, hubObj, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("recorded the feedback of %s %s/%s from %s", gvr.Resource, spokeObj.GetNamespace(), spokeObj.GetName(),
+		clusterName)
+	return nil
+}
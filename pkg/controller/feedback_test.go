@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yanmxa/straw/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var deploymentGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func newDeployment(namespace, name, uid string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"readyReplicas": int64(2),
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "True"},
+				map[string]interface{}{"type": "Progressing", "status": "True"},
+			},
+		},
+	}}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(types.UID(uid))
+	return obj
+}
+
+func TestLoadFeedbackRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.yaml")
+	config := `
+- resource: deployments.v1.apps
+  kind: Deployment
+  rules:
+  - name: readyReplicas
+    jsonPath: .status.readyReplicas
+  - name: conditionTypes
+    jsonPath: "{.status.conditions[*].type}"
+  - name: replicas
+    jsonPath: .spec.replicas
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	feedbackRules, err := LoadFeedbackRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feedbackRules) != 1 || feedbackRules[0].GVR != deploymentGVR {
+		t.Fatalf("expected the rules of deployments, got %v", feedbackRules)
+	}
+
+	feedback, err := feedbackRules[0].Extract(newDeployment("cluster1", "foo", "spoke-foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the missing replicas isn't reported
+	expected := map[string]interface{}{
+		"readyReplicas":  int64(2),
+		"conditionTypes": []interface{}{"Available", "Progressing"},
+	}
+	if !reflect.DeepEqual(feedback, expected) {
+		t.Fatalf("expected the feedback %v, got %v", expected, feedback)
+	}
+
+	if _, err := NewFeedbackRules(FeedbackConfig{Resource: "deployments", Kind: "Deployment"}); err == nil {
+		t.Fatal("expected the resource without version to be invalid")
+	}
+	if _, err := NewFeedbackRules(FeedbackConfig{Resource: "deployments.v1.apps", Kind: "Deployment",
+		Rules: []FeedbackRule{{Name: "invalid", JSONPath: ".status[.ready"}}}); err == nil {
+		t.Fatal("expected the jsonPath to be invalid")
+	}
+}
+
+func TestFeedback(t *testing.T) {
+	ctx := context.Background()
+	rules, err := NewFeedbackRules(FeedbackConfig{Resource: "deployments.v1.apps", Kind: "Deployment",
+		Rules: []FeedbackRule{{Name: "conditions", JSONPath: ".status.conditions"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the agent extracts the feedback into the spoke resource
+	spokeObj := newDeployment("cluster1", "foo", "spoke-foo")
+	spokeObj.SetLabels(map[string]string{utils.OriginalOwnerReferenceIDLabelKey: "hub-foo"})
+	FeedbackTweakFunc([]*FeedbackRules{rules})(spokeObj, "cluster1")
+	if spokeObj.GetLabels()[utils.ClusterLabelKey] != "cluster1" {
+		t.Fatalf("expected the spoke resource labeled with the cluster, got %v", spokeObj.GetLabels())
+	}
+	feedback := spokeObj.GetAnnotations()[utils.StatusFeedbackAnnotationKey]
+	conditions := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(feedback), &conditions); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(conditions["conditions"].([]interface{})); n != 2 {
+		t.Fatalf("expected 2 conditions in the feedback, got %s", feedback)
+	}
+
+	// the manager records it on the hub resource
+	hubObj := newDeployment("cluster1", "foo", "hub-foo")
+	client := newFakeClient(hubObj)
+	c := NewFeedbackController(ctx, client)
+	if err := c.record(deploymentGVR, spokeObj, false); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := client.Resource(deploymentGVR).Namespace("cluster1").Get(ctx, "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := obj.GetAnnotations()[FeedbackRecordKey("cluster1")]; record != feedback {
+		t.Fatalf("expected the feedback record %s, got %s", feedback, record)
+	}
+
+	// the record isn't delivered back to the spoke
+	RemoveFeedbackRecords(obj)
+	if len(obj.GetAnnotations()) != 0 {
+		t.Fatalf("expected the records to be removed, got %v", obj.GetAnnotations())
+	}
+
+	// the record is removed with the spoke resource
+	if err := c.record(deploymentGVR, spokeObj, true); err != nil {
+		t.Fatal(err)
+	}
+	obj, err = client.Resource(deploymentGVR).Namespace("cluster1").Get(ctx, "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := obj.GetAnnotations()[FeedbackRecordKey("cluster1")]; exists {
+		t.Fatalf("expected the feedback record to be removed, got %v", obj.GetAnnotations())
+	}
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// ManifestController delivers the resources cached by the hub informers to the spoke cluster. The resources are
+// applied by the server-side apply of the straw field manager, and labeled with the uid of the hub resource, so that
+// only the owned resources are deleted once they're removed from the hub.
+type ManifestController struct {
+	ctx    context.Context
+	client dynamic.Interface
+}
+
+func NewManifestController(ctx context.Context, client dynamic.Interface) *ManifestController {
+	return &ManifestController{
+		ctx:    ctx,
+		client: client,
+	}
+}
+
+// AddInformer applies the objects of the informer to the spoke cluster, it should be invoked before the informer is
+// started. The resyncs apply the objects again, which reverts the drifts on the spoke cluster.
+func (c *ManifestController) AddInformer(gvr schema.GroupVersionResource, informer cache.SharedIndexInformer) {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if err := c.apply(gvr, obj); err != nil {
+				klog.Errorf("failed to apply %s: %v", gvr, err)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if err := c.apply(gvr, newObj); err != nil {
+				klog.Errorf("failed to apply %s: %v", gvr, err)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if err := c.delete(gvr, obj); err != nil {
+				klog.Errorf("failed to delete %s: %v", gvr, err)
+			}
+		},
+	})
+}
+
+// Cleanup deletes the owned resources of the spoke cluster which aren't in the synced store of the informer, e.g. they
+// are deleted from the hub while the controller is down. The resources applied from the works are left to the
+// WorkController.
+func (c *ManifestController) Cleanup(gvr schema.GroupVersionResource, store cache.Store) error {
+	owned, err := c.client.Resource(gvr).List(c.ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", utils.ManagedByLabelKey, utils.FieldManager),
+	})
+	if err != nil {
+		return err
+	}
+	for i := range owned.Items {
+		obj := &owned.Items[i]
+		key, _ := cache.MetaNamespaceKeyFunc(obj)
+		hubObj, exists, err := store.GetByKey(key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			accessor, err := meta.Accessor(hubObj)
+			if err != nil {
+				return err
+			}
+			if string(accessor.GetUID()) == obj.GetLabels()[utils.OriginalOwnerReferenceIDLabelKey] {
+				continue
+			}
+		}
+		uid := obj.GetUID()
+		if err := c.client.Resource(gvr).Namespace(obj.GetNamespace()).Delete(c.ctx, obj.GetName(),
+			metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}},
+		); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		klog.Infof("cleaned up %s %s", gvr.Resource, key)
+	}
+	return nil
+}
+
+func (c *ManifestController) apply(gvr schema.GroupVersionResource, obj interface{}) error {
+	hubObj, err := toUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	_, err = c.applyOwned(gvr, hubObj, hubObj.GetUID(), utils.FieldManager)
+	return err
+}
+
+// applyOwned applies the object labeled with the uid of its owner on the hub and the manager applying it, and returns
+// the applied object. Only the objects of the utils.FieldManager are cleaned up as the mirrored resources.
+func (c *ManifestController) applyOwned(gvr schema.GroupVersionResource, obj *unstructured.Unstructured,
+	owner types.UID, manager string,
+) (*unstructured.Unstructured, error) {
+	if err := c.ensureNamespace(obj.GetNamespace()); err != nil {
+		return nil, err
+	}
+
+	applied, err := c.client.Resource(gvr).Namespace(obj.GetNamespace()).Apply(c.ctx, obj.GetName(),
+		manifest(obj, owner, manager), metav1.ApplyOptions{FieldManager: utils.FieldManager, Force: true})
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("applied %s %s/%s", gvr.Resource, obj.GetNamespace(), obj.GetName())
+	return applied, nil
+}
+
+// delete removes the resource from the spoke cluster only if it's applied from the deleted hub resource
+func (c *ManifestController) delete(gvr schema.GroupVersionResource, obj interface{}) error {
+	hubObj, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	return c.deleteOwned(gvr, hubObj.GetNamespace(), hubObj.GetName(), hubObj.GetUID())
+}
+
+// deleteOwned deletes the resource only if it's labeled with the uid of the owner
+func (c *ManifestController) deleteOwned(gvr schema.GroupVersionResource, namespace, name string,
+	owner types.UID,
+) error {
+	resource := c.client.Resource(gvr).Namespace(namespace)
+	spokeObj, err := resource.Get(c.ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if spokeObj.GetLabels()[utils.OriginalOwnerReferenceIDLabelKey] != string(owner) {
+		klog.Infof("skip deleting %s %s/%s, which isn't owned by the hub resource", gvr.Resource, namespace, name)
+		return nil
+	}
+
+	uid := spokeObj.GetUID()
+	err = resource.Delete(c.ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	klog.Infof("deleted %s %s/%s", gvr.Resource, namespace, name)
+	return nil
+}
+
+// ensureNamespace creates the namespace of the resource if it doesn't exist
+func (c *ManifestController) ensureNamespace(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	ns := &unstructured.Unstructured{}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName(namespace)
+	_, err := c.client.Resource(namespaceGVR).Create(c.ctx, ns, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// manifest strips the fields maintained by the hub cluster, and marks the ownership of the resource
+func manifest(hubObj *unstructured.Unstructured, owner types.UID, manager string) *unstructured.Unstructured {
+	obj := hubObj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "status")
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	obj.SetGeneration(0)
+	obj.SetSelfLink("")
+	obj.SetManagedFields(nil)
+	obj.SetOwnerReferences(nil)
+	obj.SetFinalizers(nil)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[utils.ManagedByLabelKey] = manager
+	labels[utils.OriginalOwnerReferenceIDLabelKey] = string(owner)
+	obj.SetLabels(labels)
+	return obj
+}
+
+func toUnstructured(obj interface{}) (*unstructured.Unstructured, error) {
+	switch o := obj.(type) {
+	case *unstructured.Unstructured:
+		return o, nil
+	case *metav1.PartialObjectMetadata:
+		return nil, fmt.Errorf("unable to apply the metadata of %s/%s, the informer should cache the full objects",
+			o.Namespace, o.Name)
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	workv1alpha1 "github.com/yanmxa/straw/pkg/apis/work/v1alpha1"
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// newFakeClient serves the server-side apply by creating or replacing the object in the tracker, which isn't
+// supported by the fake client
+func newFakeClient(objects ...runtime.Object) *fake.FakeDynamicClient {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			configMapGVR:                 "ConfigMapList",
+			deploymentGVR:                "DeploymentList",
+			namespaceGVR:                 "NamespaceList",
+			workv1alpha1.ManifestWorkGVR: "ManifestWorkList",
+		}, objects...)
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		err := client.Tracker().Update(patch.GetResource(), obj, patch.GetNamespace())
+		if errors.IsNotFound(err) {
+			obj.SetUID(types.UID("spoke-" + obj.GetName()))
+			err = client.Tracker().Create(patch.GetResource(), obj, patch.GetNamespace())
+		}
+		return true, obj, err
+	})
+	return client
+}
+
+func newConfigMap(namespace, name, uid string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(types.UID(uid))
+	obj.SetResourceVersion("10")
+	return obj
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	c := NewManifestController(ctx, client)
+
+	hubObj := newConfigMap("cluster1", "foo", "hub-foo")
+	if err := unstructured.SetNestedField(hubObj.Object, "bar", "data", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(hubObj.Object, "ready", "status", "phase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.apply(configMapGVR, hubObj); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Resource(namespaceGVR).Get(ctx, "cluster1", metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected the namespace to be created: %v", err)
+	}
+	obj, err := client.Resource(configMapGVR).Namespace("cluster1").Get(ctx, "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _, _ := unstructured.NestedString(obj.Object, "data", "key"); data != "bar" {
+		t.Fatalf("expected the applied data bar, got %q", data)
+	}
+	if _, exists := obj.Object["status"]; exists {
+		t.Fatalf("expected the status of the hub to be stripped, got %v", obj.Object["status"])
+	}
+	if labels := obj.GetLabels(); labels[utils.ManagedByLabelKey] != utils.FieldManager ||
+		labels[utils.OriginalOwnerReferenceIDLabelKey] != "hub-foo" {
+		t.Fatalf("expected the ownership labels, got %v", labels)
+	}
+
+	// the namespace exists already
+	if err := c.apply(configMapGVR, newConfigMap("cluster1", "baz", "hub-baz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.apply(configMapGVR, &metav1.PartialObjectMetadata{}); err == nil {
+		t.Fatal("expected the metadata can't be applied")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	unowned := newConfigMap("cluster1", "unowned", "spoke-unowned")
+	client := newFakeClient(unowned)
+	c := NewManifestController(ctx, client)
+
+	if err := c.apply(configMapGVR, newConfigMap("cluster1", "owned", "hub-owned")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the resource created on the spoke is kept
+	if err := c.delete(configMapGVR, newConfigMap("cluster1", "unowned", "hub-unowned")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Resource(configMapGVR).Namespace("cluster1").Get(ctx, "unowned",
+		metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected the unowned resource to be kept: %v", err)
+	}
+
+	if err := c.delete(configMapGVR, newConfigMap("cluster1", "owned", "hub-owned")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Resource(configMapGVR).Namespace("cluster1").Get(ctx, "owned",
+		metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected the owned resource to be deleted, got %v", err)
+	}
+
+	// the resource is deleted already
+	if err := c.delete(configMapGVR, newConfigMap("cluster1", "owned", "hub-owned")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	c := NewManifestController(ctx, client)
+
+	for _, obj := range []*unstructured.Unstructured{
+		newConfigMap("cluster1", "kept", "hub-kept"),
+		newConfigMap("cluster1", "deleted", "hub-deleted"),
+		newConfigMap("cluster1", "recreated", "hub-recreated"),
+	} {
+		if err := c.apply(configMapGVR, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the hub resources while the controller is down
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, obj := range []*unstructured.Unstructured{
+		newConfigMap("cluster1", "kept", "hub-kept"),
+		newConfigMap("cluster1", "recreated", "hub-recreated-again"),
+	} {
+		if err := store.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Cleanup(configMapGVR, store); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := client.Resource(configMapGVR).Namespace("cluster1").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].GetName() != "kept" {
+		t.Fatalf("expected only the kept resource, got %v", list.Items)
+	}
+}
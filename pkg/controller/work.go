@@ -0,0 +1,466 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	workv1alpha1 "github.com/yanmxa/straw/pkg/apis/work/v1alpha1"
+	"github.com/yanmxa/straw/pkg/utils"
+	"github.com/yanmxa/straw/pkg/workclient"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// IsManifestWork returns whether the object is a ManifestWork, which keeps its namespace across the clusters
+func IsManifestWork(obj metav1.Object) bool {
+	runtimeObj, ok := obj.(runtime.Object)
+	if !ok {
+		return false
+	}
+	return runtimeObj.GetObjectKind().GroupVersionKind().GroupKind() == workv1alpha1.Kind("ManifestWork")
+}
+
+// WorkTargets returns whether the work targets the cluster
+func WorkTargets(work *workv1alpha1.ManifestWork, clusterName string) bool {
+	if len(work.Spec.Clusters) == 0 {
+		return work.Namespace == clusterName
+	}
+	for _, cluster := range work.Spec.Clusters {
+		if cluster == clusterName {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkController applies the manifests of the works targeting the cluster on the spoke, and records the conditions
+// of the manifests in the applied work, a copy of the work with the status of the cluster only.
+type WorkController struct {
+	ctx         context.Context
+	clusterName string
+	manifests   *ManifestController
+	works       workclient.ManifestWorksGetter
+	mapper      meta.RESTMapper
+}
+
+func NewWorkController(ctx context.Context, clusterName string, client dynamic.Interface,
+	mapper meta.RESTMapper,
+) *WorkController {
+	return &WorkController{
+		ctx:         ctx,
+		clusterName: clusterName,
+		manifests:   NewManifestController(ctx, client),
+		works:       workclient.NewForDynamicClient(client),
+		mapper:      mapper,
+	}
+}
+
+// AddInformer applies the works cached by the informer of the hub, the informer should deliver the typed or
+// unstructured objects. It should be invoked before the informer is started.
+func (c *WorkController) AddInformer(informer cache.SharedIndexInformer) {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if err := c.sync(obj); err != nil {
+				klog.Errorf("failed to apply the work: %v", err)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if err := c.sync(newObj); err != nil {
+				klog.Errorf("failed to apply the work: %v", err)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			work, err := toManifestWork(obj)
+			if err == nil {
+				err = c.remove(work)
+			}
+			if err != nil {
+				klog.Errorf("failed to remove the work: %v", err)
+			}
+		},
+	})
+}
+
+func (c *WorkController) sync(obj interface{}) error {
+	work, err := toManifestWork(obj)
+	if err != nil {
+		return err
+	}
+	if !WorkTargets(work, c.clusterName) {
+		return c.remove(work)
+	}
+
+	applied, err := c.works.ManifestWorks(work.Namespace).Get(c.ctx, work.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		applied = nil
+	} else if err != nil {
+		return err
+	}
+	previous := workv1alpha1.ClusterStatus{}
+	if applied != nil && applied.Labels[utils.OriginalOwnerReferenceIDLabelKey] == string(work.UID) {
+		for _, status := range applied.Status.Clusters {
+			if status.ClusterName == c.clusterName {
+				previous = status
+			}
+		}
+	}
+
+	status := workv1alpha1.ClusterStatus{ClusterName: c.clusterName}
+	appliedCount := 0
+	for i, m := range work.Spec.Workload.Manifests {
+		condition := c.applyManifest(work, int32(i), m, previous.Manifests)
+		if meta.IsStatusConditionTrue(condition.Conditions, workv1alpha1.WorkApplied) {
+			appliedCount++
+		}
+		status.Manifests = append(status.Manifests, condition)
+	}
+
+	// delete the resources removed from the workload
+	for _, old := range previous.Manifests {
+		if findManifestCondition(status.Manifests, old.ResourceMeta) != nil || old.ResourceMeta.Resource == "" ||
+			orphan(work) {
+			continue
+		}
+		if err := c.manifests.deleteOwned(resourceOf(old.ResourceMeta), old.ResourceMeta.Namespace,
+			old.ResourceMeta.Name, work.UID); err != nil {
+			return err
+		}
+	}
+
+	status.Conditions = append(status.Conditions, previous.Conditions...)
+	workCondition := metav1.Condition{
+		Type:               workv1alpha1.WorkApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             "AppliedManifestWorkComplete",
+		Message:            "all the manifests are applied",
+		ObservedGeneration: work.Generation,
+	}
+	if appliedCount < len(work.Spec.Workload.Manifests) {
+		workCondition.Status = metav1.ConditionFalse
+		workCondition.Reason = "AppliedManifestWorkFailed"
+		workCondition.Message = fmt.Sprintf("%d of %d manifests are applied", appliedCount,
+			len(work.Spec.Workload.Manifests))
+	}
+	meta.SetStatusCondition(&status.Conditions, workCondition)
+
+	return c.updateAppliedWork(work, applied, status)
+}
+
+// applyManifest applies the manifest and returns its conditions, the conditions unchanged keep their transition time
+func (c *WorkController) applyManifest(work *workv1alpha1.ManifestWork, ordinal int32, m workv1alpha1.Manifest,
+	previous []workv1alpha1.ManifestCondition,
+) workv1alpha1.ManifestCondition {
+	condition := workv1alpha1.ManifestCondition{ResourceMeta: workv1alpha1.ManifestResourceMeta{Ordinal: ordinal}}
+	applied, err := c.apply(work, m, &condition.ResourceMeta)
+	if old := findManifestCondition(previous, condition.ResourceMeta); old != nil {
+		condition.Conditions = append(condition.Conditions, old.Conditions...)
+	}
+
+	appliedCondition := metav1.Condition{
+		Type:               workv1alpha1.WorkApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             "AppliedManifestComplete",
+		Message:            "the manifest is applied",
+		ObservedGeneration: work.Generation,
+	}
+	availableCondition := metav1.Condition{
+		Type:               workv1alpha1.WorkAvailable,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ResourceAvailable",
+		Message:            "the resource exists on the cluster",
+		ObservedGeneration: work.Generation,
+	}
+	if err != nil {
+		klog.Errorf("failed to apply the manifest %d of the work %s/%s: %v", ordinal, work.Namespace, work.Name, err)
+		appliedCondition.Status = metav1.ConditionFalse
+		appliedCondition.Reason = "AppliedManifestFailed"
+		appliedCondition.Message = err.Error()
+		availableCondition.Status = metav1.ConditionUnknown
+		availableCondition.Reason = "ResourceNotAvailable"
+		availableCondition.Message = "the manifest isn't applied"
+	}
+	meta.SetStatusCondition(&condition.Conditions, appliedCondition)
+	meta.SetStatusCondition(&condition.Conditions, availableCondition)
+
+	if applied != nil {
+		feedback, err := c.feedback(work, condition.ResourceMeta, applied)
+		if err != nil {
+			klog.Errorf("failed to extract the feedback of the manifest %d of the work %s/%s: %v", ordinal,
+				work.Namespace, work.Name, err)
+		}
+		condition.StatusFeedback = feedback
+	}
+	return condition
+}
+
+// apply applies the manifest, and fills in the resource meta as far as it's resolved
+func (c *WorkController) apply(work *workv1alpha1.ManifestWork, m workv1alpha1.Manifest,
+	resourceMeta *workv1alpha1.ManifestResourceMeta,
+) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(m.Raw); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	gvk := obj.GroupVersionKind()
+	resourceMeta.Group, resourceMeta.Version, resourceMeta.Kind = gvk.Group, gvk.Version, gvk.Kind
+	resourceMeta.Name, resourceMeta.Namespace = obj.GetName(), obj.GetNamespace()
+
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	resourceMeta.Resource = mapping.Resource.Resource
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && obj.GetNamespace() == "" {
+		obj.SetNamespace(metav1.NamespaceDefault)
+		resourceMeta.Namespace = metav1.NamespaceDefault
+	}
+	return c.manifests.applyOwned(mapping.Resource, obj, work.UID, utils.WorkManager)
+}
+
+// feedback extracts the values of the feedback rules declared for the manifest
+func (c *WorkController) feedback(work *workv1alpha1.ManifestWork, resourceMeta workv1alpha1.ManifestResourceMeta,
+	obj *unstructured.Unstructured,
+) (*runtime.RawExtension, error) {
+	for _, config := range work.Spec.ManifestConfigs {
+		id := config.ResourceIdentifier
+		if id.Group != resourceMeta.Group || id.Resource != resourceMeta.Resource || id.Name != resourceMeta.Name ||
+			id.Namespace != resourceMeta.Namespace {
+			continue
+		}
+		rules := []FeedbackRule{}
+		for _, rule := range config.FeedbackRules {
+			rules = append(rules, FeedbackRule(rule))
+		}
+		compiled, err := compileFeedbackRules(rules)
+		if err != nil {
+			return nil, err
+		}
+		feedback, err := (&FeedbackRules{rules: compiled}).Extract(obj)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(feedback)
+		if err != nil {
+			return nil, err
+		}
+		return &runtime.RawExtension{Raw: raw}, nil
+	}
+	return nil, nil
+}
+
+// updateAppliedWork creates or updates the applied work on the spoke, which is sent back to the hub by the provider
+func (c *WorkController) updateAppliedWork(work, applied *workv1alpha1.ManifestWork,
+	status workv1alpha1.ClusterStatus,
+) error {
+	works := c.works.ManifestWorks(work.Namespace)
+	labels := map[string]string{
+		utils.TransportResourceLabelKey:        "",
+		utils.ClusterLabelKey:                  c.clusterName,
+		utils.OriginalOwnerReferenceIDLabelKey: string(work.UID),
+	}
+
+	var err error
+	if applied == nil {
+		if err := c.manifests.ensureNamespace(work.Namespace); err != nil {
+			return err
+		}
+		applied, err = works.Create(c.ctx, &workv1alpha1.ManifestWork{
+			ObjectMeta: metav1.ObjectMeta{Name: work.Name, Namespace: work.Namespace, Labels: labels},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+	} else if !equality.Semantic.DeepEqual(applied.Labels, labels) {
+		applied.Labels = labels
+		if applied, err = works.Update(c.ctx, applied, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	clusters := []workv1alpha1.ClusterStatus{status}
+	if equality.Semantic.DeepEqual(applied.Status.Clusters, clusters) {
+		return nil
+	}
+	applied.Status.Clusters = clusters
+	if _, err := works.UpdateStatus(c.ctx, applied, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("applied the work %s/%s", work.Namespace, work.Name)
+	return nil
+}
+
+// remove deletes the applied resources unless they're orphaned, and then the applied work
+func (c *WorkController) remove(work *workv1alpha1.ManifestWork) error {
+	works := c.works.ManifestWorks(work.Namespace)
+	applied, err := works.Get(c.ctx, work.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if applied.Labels[utils.OriginalOwnerReferenceIDLabelKey] != string(work.UID) {
+		return nil
+	}
+
+	if !orphan(work) {
+		for _, status := range applied.Status.Clusters {
+			for _, m := range status.Manifests {
+				if m.ResourceMeta.Resource == "" {
+					continue
+				}
+				if err := c.manifests.deleteOwned(resourceOf(m.ResourceMeta), m.ResourceMeta.Namespace,
+					m.ResourceMeta.Name, work.UID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	uid := applied.UID
+	err = works.Delete(c.ctx, work.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	klog.Infof("removed the work %s/%s", work.Namespace, work.Name)
+	return nil
+}
+
+// WorkStatusController merges the status of the applied works sent by the agents into the works on the hub
+type WorkStatusController struct {
+	ctx   context.Context
+	works workclient.ManifestWorksGetter
+}
+
+func NewWorkStatusController(ctx context.Context, client dynamic.Interface) *WorkStatusController {
+	return &WorkStatusController{
+		ctx:   ctx,
+		works: workclient.NewForDynamicClient(client),
+	}
+}
+
+// AddInformer records the status of the applied works cached by the informer, the informer should deliver the typed
+// or unstructured objects. It should be invoked before the informer is started.
+func (c *WorkStatusController) AddInformer(informer cache.SharedIndexInformer) {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if err := c.record(obj, false); err != nil {
+				klog.Errorf("failed to record the status of the work: %v", err)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if err := c.record(newObj, false); err != nil {
+				klog.Errorf("failed to record the status of the work: %v", err)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if err := c.record(obj, true); err != nil {
+				klog.Errorf("failed to remove the status of the work: %v", err)
+			}
+		},
+	})
+}
+
+// record writes the status of the cluster to the work on the hub, the status is removed if the applied work is deleted
+func (c *WorkStatusController) record(obj interface{}, deleted bool) error {
+	applied, err := toManifestWork(obj)
+	if err != nil {
+		return err
+	}
+	clusterName := applied.Labels[utils.ClusterLabelKey]
+	if clusterName == "" {
+		return nil
+	}
+
+	works := c.works.ManifestWorks(applied.Namespace)
+	work, err := works.Get(c.ctx, applied.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// the work is recreated
+	if applied.Labels[utils.OriginalOwnerReferenceIDLabelKey] != string(work.UID) {
+		return nil
+	}
+
+	clusters := []workv1alpha1.ClusterStatus{}
+	for _, status := range work.Status.Clusters {
+		if status.ClusterName != clusterName {
+			clusters = append(clusters, status)
+		}
+	}
+	if !deleted {
+		for _, status := range applied.Status.Clusters {
+			if status.ClusterName == clusterName {
+				clusters = append(clusters, status)
+			}
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].ClusterName < clusters[j].ClusterName })
+	if len(clusters) == 0 {
+		clusters = nil
+	}
+	if equality.Semantic.DeepEqual(work.Status.Clusters, clusters) {
+		return nil
+	}
+
+	work.Status.Clusters = clusters
+	if _, err := works.UpdateStatus(c.ctx, work, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("recorded the status of the work %s/%s from %s", work.Namespace, work.Name, clusterName)
+	return nil
+}
+
+func toManifestWork(obj interface{}) (*workv1alpha1.ManifestWork, error) {
+	switch work := obj.(type) {
+	case *workv1alpha1.ManifestWork:
+		return work, nil
+	case *unstructured.Unstructured:
+		return workclient.FromUnstructured(work)
+	default:
+		return nil, fmt.Errorf("expected the typed or unstructured work, got %T", obj)
+	}
+}
+
+func findManifestCondition(conditions []workv1alpha1.ManifestCondition,
+	resourceMeta workv1alpha1.ManifestResourceMeta,
+) *workv1alpha1.ManifestCondition {
+	for i, condition := range conditions {
+		m := condition.ResourceMeta
+		if m.Group == resourceMeta.Group && m.Kind == resourceMeta.Kind && m.Name == resourceMeta.Name &&
+			m.Namespace == resourceMeta.Namespace {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func resourceOf(resourceMeta workv1alpha1.ManifestResourceMeta) schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    resourceMeta.Group,
+		Version:  resourceMeta.Version,
+		Resource: resourceMeta.Resource,
+	}
+}
+
+func orphan(work *workv1alpha1.ManifestWork) bool {
+	return work.Spec.DeleteOption != nil &&
+		work.Spec.DeleteOption.PropagationPolicy == workv1alpha1.DeletePropagationPolicyTypeOrphan
+}
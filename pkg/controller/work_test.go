@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	workv1alpha1 "github.com/yanmxa/straw/pkg/apis/work/v1alpha1"
+	"github.com/yanmxa/straw/pkg/utils"
+	"github.com/yanmxa/straw/pkg/workclient"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newWork(namespace, name, uid string, manifests ...*unstructured.Unstructured) *workv1alpha1.ManifestWork {
+	work := &workv1alpha1.ManifestWork{
+		TypeMeta:   metav1.TypeMeta{APIVersion: workv1alpha1.SchemeGroupVersion.String(), Kind: "ManifestWork"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(uid), Generation: 1},
+	}
+	for _, m := range manifests {
+		raw, err := m.MarshalJSON()
+		if err != nil {
+			panic(err)
+		}
+		work.Spec.Workload.Manifests = append(work.Spec.Workload.Manifests,
+			workv1alpha1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+	}
+	return work
+}
+
+func newRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	return mapper
+}
+
+func TestIsManifestWork(t *testing.T) {
+	obj, err := workclient.ToUnstructured(newWork("default", "foo", "hub-foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsManifestWork(obj) {
+		t.Fatal("expected the unstructured work")
+	}
+	if IsManifestWork(newConfigMap("default", "foo", "hub-foo")) {
+		t.Fatal("expected the configmap isn't a work")
+	}
+
+	work := newWork("cluster1", "foo", "hub-foo")
+	if !WorkTargets(work, "cluster1") || WorkTargets(work, "cluster2") {
+		t.Fatal("expected the work targets the cluster of its namespace")
+	}
+	work.Spec.Clusters = []string{"cluster2"}
+	if WorkTargets(work, "cluster1") || !WorkTargets(work, "cluster2") {
+		t.Fatal("expected the work targets the clusters")
+	}
+}
+
+func TestWork(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	c := NewWorkController(ctx, "cluster1", client, newRESTMapper())
+	works := workclient.NewForDynamicClient(client).ManifestWorks("default")
+
+	deployment := newDeployment("apps", "foo", "")
+	work := newWork("default", "foo", "hub-work", newConfigMap("", "foo", ""), deployment,
+		newConfigMap("default", "invalid", ""))
+	work.Spec.Clusters = []string{"cluster1"}
+	work.Spec.Workload.Manifests[2].Raw = []byte(`{"apiVersion":"v1","kind":"Unknown","metadata":{"name":"bar"}}`)
+	work.Spec.ManifestConfigs = []workv1alpha1.ManifestConfig{{
+		ResourceIdentifier: workv1alpha1.ResourceIdentifier{Group: "apps", Resource: "deployments", Name: "foo",
+			Namespace: "apps"},
+		FeedbackRules: []workv1alpha1.FeedbackRule{{Name: "readyReplicas", JSONPath: ".status.readyReplicas"}},
+	}}
+	if err := c.sync(work); err != nil {
+		t.Fatal(err)
+	}
+
+	// the configmap without namespace is applied to the default namespace
+	for gvr, key := range map[schema.GroupVersionResource][2]string{
+		configMapGVR:  {"default", "foo"},
+		deploymentGVR: {"apps", "foo"},
+	} {
+		obj, err := client.Resource(gvr).Namespace(key[0]).Get(ctx, key[1], metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.GetLabels()[utils.OriginalOwnerReferenceIDLabelKey] != "hub-work" {
+			t.Fatalf("expected the %s owned by the work, got %v", gvr.Resource, obj.GetLabels())
+		}
+	}
+
+	applied, err := works.Get(ctx, "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied.Status.Clusters) != 1 || applied.Status.Clusters[0].ClusterName != "cluster1" {
+		t.Fatalf("expected the status of cluster1, got %v", applied.Status.Clusters)
+	}
+	status := applied.Status.Clusters[0]
+	if meta.IsStatusConditionTrue(status.Conditions, workv1alpha1.WorkApplied) {
+		t.Fatal("expected the work isn't applied with the invalid manifest")
+	}
+	if len(status.Manifests) != 3 ||
+		!meta.IsStatusConditionTrue(status.Manifests[0].Conditions, workv1alpha1.WorkApplied) ||
+		!meta.IsStatusConditionTrue(status.Manifests[1].Conditions, workv1alpha1.WorkAvailable) ||
+		meta.IsStatusConditionTrue(status.Manifests[2].Conditions, workv1alpha1.WorkApplied) {
+		t.Fatalf("unexpected conditions of the manifests: %v", status.Manifests)
+	}
+	// the status of the deployment isn't applied, so the applied one has no feedback value
+	if feedback := status.Manifests[1].StatusFeedback; feedback == nil || string(feedback.Raw) != "{}" {
+		t.Fatalf("expected the empty feedback of the deployment, got %v", feedback)
+	}
+
+	// the manager merges the status of the cluster into the work on the hub
+	hubClient := newFakeClient()
+	hubWorks := workclient.NewForDynamicClient(hubClient).ManifestWorks("default")
+	if _, err := hubWorks.Create(ctx, work, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	statusController := NewWorkStatusController(ctx, hubClient)
+	if err := statusController.record(applied, false); err != nil {
+		t.Fatal(err)
+	}
+	hubWork, err := hubWorks.Get(ctx, "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hubWork.Status.Clusters) != 1 || len(hubWork.Status.Clusters[0].Manifests) != 3 {
+		t.Fatalf("expected the status of cluster1 on the hub, got %v", hubWork.Status.Clusters)
+	}
+	if err := statusController.record(applied, true); err != nil {
+		t.Fatal(err)
+	}
+	if hubWork, err = hubWorks.Get(ctx, "foo", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(hubWork.Status.Clusters) != 0 {
+		t.Fatalf("expected the status of cluster1 removed, got %v", hubWork.Status.Clusters)
+	}
+
+	// the configmap removed from the workload is deleted
+	work.Spec.Workload.Manifests = work.Spec.Workload.Manifests[1:2]
+	if err := c.sync(work); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, "foo",
+		metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected the configmap to be deleted, got %v", err)
+	}
+
+	// the deployment is kept with the work orphaning it, while the applied work is removed
+	work.Spec.DeleteOption = &workv1alpha1.DeleteOption{PropagationPolicy: workv1alpha1.DeletePropagationPolicyTypeOrphan}
+	work.Spec.Clusters = []string{"cluster2"}
+	if err := c.sync(work); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Resource(deploymentGVR).Namespace("apps").Get(ctx, "foo", metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected the deployment to be kept: %v", err)
+	}
+	if _, err := works.Get(ctx, "foo", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected the applied work to be deleted, got %v", err)
+	}
+}
+
+func TestWorkCleanup(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	manifests := NewManifestController(ctx, client)
+	works := NewWorkController(ctx, "cluster1", client, newRESTMapper())
+
+	// the configmaps are mirrored and applied from the work on the same cluster
+	if err := manifests.apply(configMapGVR, newConfigMap("default", "mirrored", "hub-mirrored")); err != nil {
+		t.Fatal(err)
+	}
+	work := newWork("cluster1", "foo", "hub-work", newConfigMap("default", "applied", ""))
+	if err := works.sync(work); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, "applied", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager := applied.GetLabels()[utils.ManagedByLabelKey]; manager != utils.WorkManager {
+		t.Fatalf("expected the configmap managed by the work, got %q", manager)
+	}
+
+	// the cleanup of the mirrored configmaps keeps the one applied from the work
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	if err := manifests.Cleanup(configMapGVR, store); err != nil {
+		t.Fatal(err)
+	}
+	list, err := client.Resource(configMapGVR).Namespace("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].GetName() != "applied" {
+		t.Fatalf("expected only the configmap applied from the work, got %v", list.Items)
+	}
+}
+
+func TestWorkFeedback(t *testing.T) {
+	c := NewWorkController(context.Background(), "cluster1", newFakeClient(), newRESTMapper())
+	work := newWork("cluster1", "foo", "hub-work")
+	work.Spec.ManifestConfigs = []workv1alpha1.ManifestConfig{{
+		ResourceIdentifier: workv1alpha1.ResourceIdentifier{Group: "apps", Resource: "deployments", Name: "foo",
+			Namespace: "apps"},
+		FeedbackRules: []workv1alpha1.FeedbackRule{{Name: "readyReplicas", JSONPath: ".status.readyReplicas"}},
+	}}
+	resourceMeta := workv1alpha1.ManifestResourceMeta{Group: "apps", Version: "v1", Kind: "Deployment",
+		Resource: "deployments", Name: "foo", Namespace: "apps"}
+	feedback, err := c.feedback(work, resourceMeta, newDeployment("apps", "foo", "spoke-foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(feedback.Raw, &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["readyReplicas"] != float64(2) {
+		t.Fatalf("expected the readyReplicas 2, got %s", feedback.Raw)
+	}
+
+	resourceMeta.Name = "bar"
+	if feedback, err = c.feedback(work, resourceMeta, newDeployment("apps", "bar", "spoke-bar")); err != nil ||
+		feedback != nil {
+		t.Fatalf("expected no feedback without the rules, got %v, %v", feedback, err)
+	}
+}
@@ -0,0 +1,182 @@
+package etcdshim
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"k8s.io/klog/v2"
+)
+
+type lease struct {
+	id     int64
+	ttl    int64
+	expiry time.Time
+	keys   map[string]struct{}
+}
+
+func (l *lease) remainingTTL() int64 {
+	remaining := time.Until(l.expiry).Seconds()
+	if remaining < 0 {
+		return 0
+	}
+	return int64(remaining)
+}
+
+// leaseKeeper tracks the leases granted by the store, the keys attached to an expired lease are deleted
+type leaseKeeper struct {
+	store *Store
+	// mutex guards the leases, the store lock is always acquired before it
+	mutex  sync.Mutex
+	leases map[int64]*lease
+}
+
+func newLeaseKeeper(s *Store) *leaseKeeper {
+	return &leaseKeeper{
+		store:  s,
+		leases: map[int64]*lease{},
+	}
+}
+
+func (k *leaseKeeper) grant(id, ttl int64) (*lease, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if id == 0 {
+		for id == 0 || k.leases[id] != nil {
+			id = rand.Int63()
+		}
+	}
+	if _, ok := k.leases[id]; ok {
+		return nil, rpctypes.ErrGRPCLeaseExist
+	}
+	l := &lease{
+		id:     id,
+		ttl:    ttl,
+		expiry: time.Now().Add(time.Duration(ttl) * time.Second),
+		keys:   map[string]struct{}{},
+	}
+	k.leases[id] = l
+	return l, nil
+}
+
+func (k *leaseKeeper) renew(id int64) (int64, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	l, ok := k.leases[id]
+	if !ok {
+		return 0, rpctypes.ErrGRPCLeaseNotFound
+	}
+	l.expiry = time.Now().Add(time.Duration(l.ttl) * time.Second)
+	return l.ttl, nil
+}
+
+func (k *leaseKeeper) get(id int64) (lease, bool) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	l, ok := k.leases[id]
+	if !ok {
+		return lease{}, false
+	}
+	copied := *l
+	copied.keys = map[string]struct{}{}
+	for key := range l.keys {
+		copied.keys[key] = struct{}{}
+	}
+	return copied, true
+}
+
+func (k *leaseKeeper) list() []int64 {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	ids := make([]int64, 0, len(k.leases))
+	for id := range k.leases {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// revoke deletes the lease and all the keys attached to it within a single revision
+func (k *leaseKeeper) revoke(id int64) error {
+	txn := k.store.write()
+	k.mutex.Lock()
+	l, ok := k.leases[id]
+	if !ok {
+		k.mutex.Unlock()
+		txn.abort()
+		return rpctypes.ErrGRPCLeaseNotFound
+	}
+	delete(k.leases, id)
+	keys := l.keys
+	k.mutex.Unlock()
+
+	for key := range keys {
+		txn.deleteRange(&pb.DeleteRangeRequest{Key: []byte(key)})
+	}
+	txn.commit()
+	return nil
+}
+
+// attachLocked moves the key from the lease of its previous value to the new lease, requires the store lock
+func (k *leaseKeeper) attachLocked(key string, prev *mvccpb.KeyValue, id int64) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if id != 0 {
+		l, ok := k.leases[id]
+		if !ok {
+			return rpctypes.ErrGRPCLeaseNotFound
+		}
+		l.keys[key] = struct{}{}
+	}
+	if prev != nil && prev.Lease != 0 && prev.Lease != id {
+		if l, ok := k.leases[prev.Lease]; ok {
+			delete(l.keys, key)
+		}
+	}
+	return nil
+}
+
+// detachLocked removes the key from the lease, requires the store lock
+func (k *leaseKeeper) detachLocked(key string, id int64) {
+	if id == 0 {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if l, ok := k.leases[id]; ok {
+		delete(l.keys, key)
+	}
+}
+
+// run revokes the expired leases periodically until the context is done
+func (k *leaseKeeper) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, id := range k.expired(now) {
+				if err := k.revoke(id); err != nil && err != rpctypes.ErrGRPCLeaseNotFound {
+					klog.Errorf("failed to revoke the expired lease(%d): %v", id, err)
+				}
+			}
+		}
+	}
+}
+
+func (k *leaseKeeper) expired(now time.Time) []int64 {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	ids := []int64{}
+	for id, l := range k.leases {
+		if now.After(l.expiry) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,326 @@
+package etcdshim
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+)
+
+const (
+	clusterID = 0x5374726177 // "Straw"
+	memberID  = 0x5374726177
+	raftTerm  = 1
+
+	// the etcd version reported to the clients, the kube-apiserver requires it to be 3.x
+	etcdVersion = "3.5.10"
+
+	// the max number of events within a single watch response
+	maxEventsPerResponse = 1000
+)
+
+var (
+	// progressNotifyInterval is the interval to send the progress notification to the watchers asked for it
+	progressNotifyInterval = 10 * time.Minute
+	// leaseCheckInterval is the interval to revoke the expired leases
+	leaseCheckInterval = 500 * time.Millisecond
+)
+
+// Server serves the etcd v3 KV, Watch, Lease and Maintenance gRPC APIs from the store. So that a
+// kube-apiserver can use it as the --etcd-servers.
+type Server struct {
+	store      *Store
+	grpcServer *grpc.Server
+}
+
+func NewServer(store *Store, opts ...grpc.ServerOption) *Server {
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterKVServer(grpcServer, &kvServer{store: store})
+	pb.RegisterWatchServer(grpcServer, &watchServer{store: store})
+	pb.RegisterLeaseServer(grpcServer, &leaseServer{store: store})
+	pb.RegisterMaintenanceServer(grpcServer, &maintenanceServer{store: store})
+	return &Server{
+		store:      store,
+		grpcServer: grpcServer,
+	}
+}
+
+// Serve blocks until the context is done or the listener fails.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go s.store.leases.run(ctx, leaseCheckInterval)
+	go func() {
+		<-ctx.Done()
+		s.grpcServer.GracefulStop()
+	}()
+	klog.Infof("etcdshim is serving on %s", listener.Addr())
+	return s.grpcServer.Serve(listener)
+}
+
+type kvServer struct {
+	pb.UnimplementedKVServer
+	store *Store
+}
+
+func (s *kvServer) Range(ctx context.Context, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	return s.store.Range(r)
+}
+
+func (s *kvServer) Put(ctx context.Context, r *pb.PutRequest) (*pb.PutResponse, error) {
+	return s.store.PutRequest(r)
+}
+
+func (s *kvServer) DeleteRange(ctx context.Context, r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	return s.store.DeleteRangeRequest(r)
+}
+
+func (s *kvServer) Txn(ctx context.Context, r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	return s.store.Txn(r)
+}
+
+func (s *kvServer) Compact(ctx context.Context, r *pb.CompactionRequest) (*pb.CompactionResponse, error) {
+	if err := s.store.Compact(r.Revision); err != nil {
+		return nil, err
+	}
+	return &pb.CompactionResponse{Header: s.store.header()}, nil
+}
+
+type watchServer struct {
+	pb.UnimplementedWatchServer
+	store *Store
+}
+
+func (s *watchServer) Watch(stream pb.Watch_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	// the grpc stream doesn't support to send messages from multiple goroutines concurrently
+	var sendLock sync.Mutex
+	send := func(resp *pb.WatchResponse) error {
+		sendLock.Lock()
+		defer sendLock.Unlock()
+		return stream.Send(resp)
+	}
+
+	var lock sync.Mutex
+	nextID := int64(0)
+	watchers := map[int64]context.CancelFunc{}
+	defer func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, stop := range watchers {
+			stop()
+		}
+	}()
+
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case req.GetCreateRequest() != nil:
+			createReq := req.GetCreateRequest()
+			lock.Lock()
+			id := createReq.WatchId
+			if id == 0 {
+				for watchers[nextID] != nil {
+					nextID++
+				}
+				id = nextID
+			}
+			if _, ok := watchers[id]; ok {
+				lock.Unlock()
+				if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: id, Created: true,
+					Canceled: true, CancelReason: rpctypes.ErrGRPCDuplicateKey.Error()}); err != nil {
+					return err
+				}
+				continue
+			}
+			w := newWatcher(id, createReq)
+			history, compactRev, err := s.store.watch(w, createReq.StartRevision)
+			if err != nil {
+				lock.Unlock()
+				// like etcd, the watcher is created and then canceled with the compact revision, so that the
+				// clients(e.g. the kube-apiserver) get the ErrCompacted and relist
+				if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: id, Created: true}); err != nil {
+					return err
+				}
+				if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: id, Canceled: true,
+					CompactRevision: compactRev, CancelReason: err.Error()}); err != nil {
+					return err
+				}
+				continue
+			}
+			watchCtx, stop := context.WithCancel(ctx)
+			watchers[id] = stop
+			lock.Unlock()
+
+			if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: id, Created: true}); err != nil {
+				s.store.unwatch(w)
+				return err
+			}
+			go func() {
+				defer s.store.unwatch(w)
+				if err := s.serveWatcher(watchCtx, w, history, createReq.ProgressNotify, send); err != nil {
+					klog.V(4).Infof("etcdshim watcher(%d) is stopped: %v", w.id, err)
+				}
+				lock.Lock()
+				delete(watchers, w.id)
+				lock.Unlock()
+			}()
+
+		case req.GetCancelRequest() != nil:
+			id := req.GetCancelRequest().WatchId
+			lock.Lock()
+			stop, ok := watchers[id]
+			delete(watchers, id)
+			lock.Unlock()
+			if ok {
+				stop()
+			}
+			if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: id, Canceled: true}); err != nil {
+				return err
+			}
+
+		case req.GetProgressRequest() != nil:
+			// -1 is the watch id of the progress notification for the whole stream
+			if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: -1}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *watchServer) serveWatcher(ctx context.Context, w *watcher, history []*mvccpb.Event,
+	progressNotify bool, send func(*pb.WatchResponse) error,
+) error {
+	for len(history) > 0 {
+		size := len(history)
+		if size > maxEventsPerResponse {
+			size = maxEventsPerResponse
+		}
+		if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: w.id, Events: history[:size]}); err != nil {
+			return err
+		}
+		history = history[size:]
+	}
+
+	var progress <-chan time.Time
+	if progressNotify {
+		ticker := time.NewTicker(progressNotifyInterval)
+		defer ticker.Stop()
+		progress = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.slow:
+			return send(&pb.WatchResponse{Header: s.store.header(), WatchId: w.id, Canceled: true,
+				CancelReason: "etcdshim: the watcher is too slow to consume the events"})
+		case events := <-w.events:
+			resp := &pb.WatchResponse{Header: s.store.header(), WatchId: w.id, Events: events}
+			// the header revision must be the revision of the events rather than the latest one
+			resp.Header.Revision = events[len(events)-1].Kv.ModRevision
+			if err := send(resp); err != nil {
+				return err
+			}
+		case <-progress:
+			if len(w.events) > 0 {
+				continue
+			}
+			if err := send(&pb.WatchResponse{Header: s.store.header(), WatchId: w.id}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type leaseServer struct {
+	pb.UnimplementedLeaseServer
+	store *Store
+}
+
+func (s *leaseServer) LeaseGrant(ctx context.Context, r *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	l, err := s.store.leases.grant(r.ID, r.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LeaseGrantResponse{Header: s.store.header(), ID: l.id, TTL: l.ttl}, nil
+}
+
+func (s *leaseServer) LeaseRevoke(ctx context.Context, r *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	if err := s.store.leases.revoke(r.ID); err != nil {
+		return nil, err
+	}
+	return &pb.LeaseRevokeResponse{Header: s.store.header()}, nil
+}
+
+func (s *leaseServer) LeaseKeepAlive(stream pb.Lease_LeaseKeepAliveServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		resp := &pb.LeaseKeepAliveResponse{Header: s.store.header(), ID: req.ID}
+		ttl, err := s.store.leases.renew(req.ID)
+		if err == nil {
+			// the ttl 0 tells the client the lease is not found
+			resp.TTL = ttl
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *leaseServer) LeaseTimeToLive(ctx context.Context, r *pb.LeaseTimeToLiveRequest) (
+	*pb.LeaseTimeToLiveResponse, error,
+) {
+	resp := &pb.LeaseTimeToLiveResponse{Header: s.store.header(), ID: r.ID, TTL: -1}
+	l, ok := s.store.leases.get(r.ID)
+	if !ok {
+		return resp, nil
+	}
+	resp.TTL = l.remainingTTL()
+	resp.GrantedTTL = l.ttl
+	if r.Keys {
+		for key := range l.keys {
+			resp.Keys = append(resp.Keys, []byte(key))
+		}
+	}
+	return resp, nil
+}
+
+func (s *leaseServer) LeaseLeases(ctx context.Context, r *pb.LeaseLeasesRequest) (*pb.LeaseLeasesResponse, error) {
+	resp := &pb.LeaseLeasesResponse{Header: s.store.header()}
+	for _, id := range s.store.leases.list() {
+		resp.Leases = append(resp.Leases, &pb.LeaseStatus{ID: id})
+	}
+	return resp, nil
+}
+
+type maintenanceServer struct {
+	pb.UnimplementedMaintenanceServer
+	store *Store
+}
+
+func (s *maintenanceServer) Status(ctx context.Context, r *pb.StatusRequest) (*pb.StatusResponse, error) {
+	header := s.store.header()
+	return &pb.StatusResponse{
+		Header:           header,
+		Version:          etcdVersion,
+		Leader:           memberID,
+		RaftIndex:        uint64(header.Revision),
+		RaftTerm:         raftTerm,
+		RaftAppliedIndex: uint64(header.Revision),
+	}, nil
+}
@@ -0,0 +1,314 @@
+package etcdshim
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// newTestClient serves the store on a loopback listener, and returns the etcd client connected to it
+func newTestClient(t *testing.T, store *Store) *clientv3.Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = NewServer(store).Serve(ctx, listener)
+	}()
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{listener.Addr().String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestRangeAndPut(t *testing.T) {
+	client := newTestClient(t, NewStore())
+	ctx := testContext(t)
+
+	first, err := client.Put(ctx, "/registry/secrets/default/a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, "/registry/secrets/default/b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := client.Put(ctx, "/registry/secrets/default/a", "3", clientv3.WithPrevKV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.PrevKv == nil || string(updated.PrevKv.Value) != "1" {
+		t.Fatalf("expected the previous value 1, got %v", updated.PrevKv)
+	}
+
+	resp, err := client.Get(ctx, "/registry/secrets/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Count != 2 || string(resp.Kvs[0].Value) != "3" || resp.Kvs[0].Version != 2 ||
+		resp.Kvs[0].CreateRevision != first.Header.Revision {
+		t.Fatalf("expected the 2 keys with a at the version 2, got %v", resp.Kvs)
+	}
+
+	// the read at the previous revision sees the old value
+	resp, err = client.Get(ctx, "/registry/secrets/default/a", clientv3.WithRev(first.Header.Revision))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "1" {
+		t.Fatalf("expected the value 1 at the revision %d, got %v", first.Header.Revision, resp.Kvs)
+	}
+
+	resp, err = client.Get(ctx, "/registry/secrets/", clientv3.WithPrefix(), clientv3.WithLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || !resp.More || resp.Count != 2 {
+		t.Fatalf("expected a page of 1 key with more, got %d keys(more: %v)", len(resp.Kvs), resp.More)
+	}
+
+	deleted, err := client.Delete(ctx, "/registry/secrets/default/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Deleted != 1 {
+		t.Fatalf("expected 1 key is deleted, got %d", deleted.Deleted)
+	}
+	resp, err = client.Get(ctx, "/registry/secrets/default/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Count != 0 {
+		t.Fatalf("expected the key b is deleted, got %v", resp.Kvs)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	client := newTestClient(t, NewStore())
+	ctx := testContext(t)
+	key := "/registry/secrets/default/a"
+
+	// the create of the kube-apiserver: put the key if it doesn't exist
+	created, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, "1")).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Succeeded {
+		t.Fatal("expected the key is created")
+	}
+
+	// the update with the stale revision fails and reads the current value
+	stale, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", created.Header.Revision-1)).
+		Then(clientv3.OpPut(key, "2")).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.Succeeded {
+		t.Fatal("expected the update with the stale revision to fail")
+	}
+	if kvs := stale.Responses[0].GetResponseRange().Kvs; len(kvs) != 1 || string(kvs[0].Value) != "1" {
+		t.Fatalf("expected the current value 1, got %v", kvs)
+	}
+
+	// a key can't be changed twice within a txn
+	_, err = client.Txn(ctx).Then(clientv3.OpDelete(key), clientv3.OpPut(key, "3")).Commit()
+	if err == nil || err.Error() != rpctypes.ErrDuplicateKey.Error() {
+		t.Fatalf("expected the duplicate key error, got %v", err)
+	}
+	resp, err := client.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "1" || resp.Header.Revision != created.Header.Revision {
+		t.Fatalf("expected the rejected txn changes nothing, got %v at the revision %d", resp.Kvs,
+			resp.Header.Revision)
+	}
+}
+
+func TestWatchFromRevision(t *testing.T) {
+	client := newTestClient(t, NewStore())
+	ctx := testContext(t)
+
+	first, err := client.Put(ctx, "/registry/secrets/default/a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, "/registry/secrets/default/a", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the events since the revision are replayed, followed by the new ones
+	watchChan := client.Watch(ctx, "/registry/secrets/", clientv3.WithPrefix(),
+		clientv3.WithRev(first.Header.Revision), clientv3.WithPrevKV())
+	if _, err := client.Delete(ctx, "/registry/secrets/default/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		eventType mvccpb.Event_EventType
+		value     string
+	}{{mvccpb.PUT, "1"}, {mvccpb.PUT, "2"}, {mvccpb.DELETE, ""}}
+	events := []*clientv3.Event{}
+	for len(events) < len(expected) {
+		select {
+		case resp := <-watchChan:
+			if err := resp.Err(); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, resp.Events...)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for the events, got %d", len(events))
+		}
+	}
+	for i, e := range events {
+		if e.Type != expected[i].eventType || string(e.Kv.Value) != expected[i].value {
+			t.Fatalf("expected the event %d to be %s %q, got %s %q", i, expected[i].eventType, expected[i].value,
+				e.Type, e.Kv.Value)
+		}
+	}
+	if events[2].PrevKv == nil || string(events[2].PrevKv.Value) != "2" {
+		t.Fatalf("expected the delete event with the previous value 2, got %v", events[2].PrevKv)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	client := newTestClient(t, NewStore())
+	ctx := testContext(t)
+	key := "/registry/secrets/default/a"
+
+	first, err := client.Put(ctx, key, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.Put(ctx, key, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Compact(ctx, second.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Get(ctx, key, clientv3.WithRev(first.Header.Revision)); err == nil ||
+		err.Error() != rpctypes.ErrCompacted.Error() {
+		t.Fatalf("expected the read of the compacted revision to fail, got %v", err)
+	}
+	// the latest value is kept
+	resp, err := client.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "2" {
+		t.Fatalf("expected the value 2, got %v", resp.Kvs)
+	}
+
+	// the watch from the compacted revision is canceled with the compact revision, so that the watcher relists
+	watchResp := <-client.Watch(ctx, key, clientv3.WithRev(first.Header.Revision))
+	if !watchResp.Canceled || watchResp.CompactRevision != second.Header.Revision {
+		t.Fatalf("expected the watch to be canceled with the compact revision %d, got %d(canceled: %v)",
+			second.Header.Revision, watchResp.CompactRevision, watchResp.Canceled)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	client := newTestClient(t, NewStore())
+	ctx := testContext(t)
+
+	lease, err := client.Grant(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, "/registry/events/default/a", "1", clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := client.TimeToLive(ctx, lease.ID, clientv3.WithAttachedKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ttl.Keys) != 1 {
+		t.Fatalf("expected 1 key attached to the lease, got %d", len(ttl.Keys))
+	}
+
+	// the key is deleted once the lease is expired
+	for {
+		resp, err := client.Get(ctx, "/registry/events/default/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Count == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("the key isn't deleted after the lease is expired")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if _, err := client.KeepAliveOnce(ctx, lease.ID); err == nil ||
+		err.Error() != rpctypes.ErrLeaseNotFound.Error() {
+		t.Fatalf("expected the expired lease to be revoked, got %v", err)
+	}
+}
+
+func TestMirroredKeysReadOnly(t *testing.T) {
+	store := NewStore()
+	store.Mirror("/registry/secrets/")
+	client := newTestClient(t, store)
+	ctx := testContext(t)
+	key := "/registry/secrets/default/a"
+
+	// the mirrored keys are written by the syncer only
+	rev := store.Put(key, []byte("1"))
+
+	isPermissionDenied := func(err error) bool {
+		return err != nil && err.Error() == rpctypes.ErrPermissionDenied.Error()
+	}
+	if _, err := client.Put(ctx, key, "2"); !isPermissionDenied(err) {
+		t.Fatalf("expected the put of the mirrored key to be denied, got %v", err)
+	}
+	_, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpPut("/registry/configmaps/default/a", "1"), clientv3.OpPut(key, "2")).
+		Commit()
+	if !isPermissionDenied(err) {
+		t.Fatalf("expected the txn writing the mirrored key to be denied, got %v", err)
+	}
+	if _, err := client.Delete(ctx, "/registry/", clientv3.WithPrefix()); !isPermissionDenied(err) {
+		t.Fatalf("expected the delete of the range covering the mirrored keys to be denied, got %v", err)
+	}
+	if resp, err := client.Get(ctx, "/registry/", clientv3.WithPrefix()); err != nil || len(resp.Kvs) != 1 ||
+		string(resp.Kvs[0].Value) != "1" || resp.Header.Revision != rev {
+		t.Fatalf("expected the denied writes change nothing, got %v(%v)", resp, err)
+	}
+
+	// the other keys are still writable
+	if _, err := client.Put(ctx, "/registry/configmaps/default/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Delete(ctx, "/registry/configmaps/", clientv3.WithPrefix()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,581 @@
+package etcdshim
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"k8s.io/klog/v2"
+)
+
+// Store is an in-memory, revisioned key-value store which mimics the mvcc layer of etcd. Every write
+// bumps the store revision, the previous revisions of a key are kept until they're compacted, and all
+// the changes are recorded in an event log so that a watcher can be replayed from any revision newer
+// than the compacted one.
+type Store struct {
+	mutex sync.RWMutex
+
+	currentRev int64
+	compactRev int64
+
+	// history holds the revisions of each key in ascending order, the last one is the latest
+	history map[string][]*mvccpb.KeyValue
+	// tombstones marks the revisions at which a key was deleted, keyed by <key, modRevision>
+	tombstones map[string]map[int64]bool
+	// events is the change log used to replay the watchers, it's truncated by the compaction
+	events []*mvccpb.Event
+
+	watchers map[*watcher]struct{}
+	leases   *leaseKeeper
+	// mirrored is the prefixes of the keys mirrored from the transport, they're read-only to the clients
+	mirrored []string
+}
+
+func NewStore() *Store {
+	s := &Store{
+		currentRev: 1,
+		history:    map[string][]*mvccpb.KeyValue{},
+		tombstones: map[string]map[int64]bool{},
+		watchers:   map[*watcher]struct{}{},
+	}
+	s.leases = newLeaseKeeper(s)
+	return s
+}
+
+// Revision returns the current revision of the store
+func (s *Store) Revision() int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.currentRev
+}
+
+// Get returns the latest value of the key, it's a shortcut of Range for a single key
+func (s *Store) Get(key string) (*mvccpb.KeyValue, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	kv := s.latestLocked(key, s.currentRev)
+	return kv, kv != nil
+}
+
+// Mirror marks the keys of the prefix as mirrored from the transport. The clients can't write them, otherwise the
+// writes would never be sent to the transport and be overwritten by the next mirrored change silently.
+func (s *Store) Mirror(prefix string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.mirrored = append(s.mirrored, prefix)
+}
+
+// Put writes the value of the key at a new revision
+func (s *Store) Put(key string, value []byte) int64 {
+	txn := s.write()
+	txn.put(&pb.PutRequest{Key: []byte(key), Value: value})
+	return txn.commit()
+}
+
+// Delete removes the key at a new revision, nothing happens if the key doesn't exist
+func (s *Store) Delete(key string) int64 {
+	txn := s.write()
+	_, _ = txn.deleteRange(&pb.DeleteRangeRequest{Key: []byte(key)})
+	return txn.commit()
+}
+
+func (s *Store) Range(r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.rangeLocked(r)
+}
+
+func (s *Store) rangeLocked(r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	rev := r.Revision
+	if rev <= 0 {
+		rev = s.currentRev
+	}
+	if rev > s.currentRev {
+		return nil, rpctypes.ErrGRPCFutureRev
+	}
+	if rev < s.compactRev {
+		return nil, rpctypes.ErrGRPCCompacted
+	}
+
+	resp := &pb.RangeResponse{Header: s.headerLocked()}
+	for _, key := range s.keysLocked(r.Key, r.RangeEnd) {
+		kv := s.latestLocked(key, rev)
+		if kv == nil {
+			continue
+		}
+		if !matchRevisionFilters(kv, r) {
+			continue
+		}
+		resp.Count++
+		if r.CountOnly {
+			continue
+		}
+		if r.Limit > 0 && int64(len(resp.Kvs)) >= r.Limit {
+			resp.More = true
+			continue
+		}
+		if r.KeysOnly {
+			kv = &mvccpb.KeyValue{Key: kv.Key, CreateRevision: kv.CreateRevision, ModRevision: kv.ModRevision,
+				Version: kv.Version, Lease: kv.Lease}
+		}
+		resp.Kvs = append(resp.Kvs, kv)
+	}
+	return resp, nil
+}
+
+func (s *Store) Txn(r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	txn := s.clientWrite()
+	resp, err := txn.txn(r)
+	if err != nil {
+		txn.abort()
+		return nil, err
+	}
+	txn.commit()
+	resp.Header = s.header()
+	return resp, nil
+}
+
+func (s *Store) PutRequest(r *pb.PutRequest) (*pb.PutResponse, error) {
+	txn := s.clientWrite()
+	resp, err := txn.put(r)
+	if err != nil {
+		txn.abort()
+		return nil, err
+	}
+	txn.commit()
+	resp.Header = s.header()
+	return resp, nil
+}
+
+func (s *Store) DeleteRangeRequest(r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	txn := s.clientWrite()
+	resp, err := txn.deleteRange(r)
+	if err != nil {
+		txn.abort()
+		return nil, err
+	}
+	txn.commit()
+	resp.Header = s.header()
+	return resp, nil
+}
+
+// Compact drops the revisions and events older than rev, the latest revision of each key is retained
+func (s *Store) Compact(rev int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if rev <= s.compactRev {
+		return rpctypes.ErrGRPCCompacted
+	}
+	if rev > s.currentRev {
+		return rpctypes.ErrGRPCFutureRev
+	}
+
+	for key, revisions := range s.history {
+		// keep the latest revision which is not newer than rev, it's still visible to the reads at rev
+		keep := 0
+		for i, kv := range revisions {
+			if kv.ModRevision <= rev {
+				keep = i
+			}
+		}
+		if latest := revisions[keep]; latest.ModRevision <= rev && s.tombstones[key][latest.ModRevision] {
+			keep++
+		}
+		for _, kv := range revisions[:keep] {
+			delete(s.tombstones[key], kv.ModRevision)
+		}
+		revisions = revisions[keep:]
+		if len(revisions) == 0 {
+			delete(s.history, key)
+			delete(s.tombstones, key)
+			continue
+		}
+		s.history[key] = revisions
+	}
+
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].Kv.ModRevision > rev })
+	s.events = append([]*mvccpb.Event{}, s.events[i:]...)
+	s.compactRev = rev
+	klog.V(4).Infof("etcdshim compacted to revision %d", rev)
+	return nil
+}
+
+func (s *Store) header() *pb.ResponseHeader {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.headerLocked()
+}
+
+func (s *Store) headerLocked() *pb.ResponseHeader {
+	return &pb.ResponseHeader{
+		ClusterId: clusterID,
+		MemberId:  memberID,
+		Revision:  s.currentRev,
+		RaftTerm:  raftTerm,
+	}
+}
+
+// keysLocked returns the sorted keys within the range [key, rangeEnd)
+func (s *Store) keysLocked(key, rangeEnd []byte) []string {
+	if len(rangeEnd) == 0 {
+		if _, ok := s.history[string(key)]; ok {
+			return []string{string(key)}
+		}
+		return nil
+	}
+	keys := []string{}
+	for k := range s.history {
+		if inRange([]byte(k), key, rangeEnd) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// latestLocked returns the visible value of the key at the revision, nil if it doesn't exist
+func (s *Store) latestLocked(key string, rev int64) *mvccpb.KeyValue {
+	revisions := s.history[key]
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if revisions[i].ModRevision > rev {
+			continue
+		}
+		if s.tombstones[key][revisions[i].ModRevision] {
+			return nil
+		}
+		return revisions[i]
+	}
+	return nil
+}
+
+func inRange(key, start, end []byte) bool {
+	if len(end) == 0 {
+		return bytes.Equal(key, start)
+	}
+	// the range end '\0' means all the keys greater than or equal to the start
+	if len(end) == 1 && end[0] == 0 {
+		return bytes.Compare(key, start) >= 0
+	}
+	return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) < 0
+}
+
+func matchRevisionFilters(kv *mvccpb.KeyValue, r *pb.RangeRequest) bool {
+	if r.MinModRevision > 0 && kv.ModRevision < r.MinModRevision {
+		return false
+	}
+	if r.MaxModRevision > 0 && kv.ModRevision > r.MaxModRevision {
+		return false
+	}
+	if r.MinCreateRevision > 0 && kv.CreateRevision < r.MinCreateRevision {
+		return false
+	}
+	if r.MaxCreateRevision > 0 && kv.CreateRevision > r.MaxCreateRevision {
+		return false
+	}
+	return true
+}
+
+// writeTxn holds the store lock, all the changes within it share the same revision
+type writeTxn struct {
+	store   *Store
+	rev     int64
+	changes []*mvccpb.Event
+	// written is the keys changed by the txn, a key can't be changed twice at the same revision like etcd
+	written map[string]bool
+	// client is true for the writes of the clients, which can't change the mirrored keys
+	client bool
+}
+
+func (s *Store) write() *writeTxn {
+	s.mutex.Lock()
+	return &writeTxn{store: s, rev: s.currentRev + 1, written: map[string]bool{}}
+}
+
+func (s *Store) clientWrite() *writeTxn {
+	txn := s.write()
+	txn.client = true
+	return txn
+}
+
+// mirroredLocked returns true if any key within the range [key, rangeEnd) is under the mirrored prefixes
+func (s *Store) mirroredLocked(key, rangeEnd []byte) bool {
+	for _, prefix := range s.mirrored {
+		start := []byte(prefix)
+		end := prefixRangeEnd(start)
+		if len(rangeEnd) == 0 {
+			if bytes.HasPrefix(key, start) {
+				return true
+			}
+			continue
+		}
+		// the ranges [key, rangeEnd) and [start, end) overlap
+		if (len(end) == 0 || bytes.Compare(key, end) < 0) &&
+			((len(rangeEnd) == 1 && rangeEnd[0] == 0) || bytes.Compare(rangeEnd, start) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixRangeEnd returns the range end of the keys with the prefix like the clientv3.GetPrefixRangeEnd, it's empty
+// if all the keys greater than the prefix are within the range
+func prefixRangeEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// abort reverts the uncommitted changes and releases the store lock
+func (t *writeTxn) abort() {
+	s := t.store
+	for i := len(t.changes) - 1; i >= 0; i-- {
+		change := t.changes[i]
+		key := string(change.Kv.Key)
+		s.history[key] = s.history[key][:len(s.history[key])-1]
+		delete(s.tombstones[key], t.rev)
+		if len(s.history[key]) == 0 {
+			delete(s.history, key)
+			delete(s.tombstones, key)
+		}
+		if change.Type == mvccpb.PUT {
+			s.leases.detachLocked(key, change.Kv.Lease)
+		}
+		if change.PrevKv != nil && change.PrevKv.Lease != 0 {
+			_ = s.leases.attachLocked(key, nil, change.PrevKv.Lease)
+		}
+	}
+	s.mutex.Unlock()
+}
+
+// commit releases the store lock, bumps the revision and notifies the watchers if anything changed
+func (t *writeTxn) commit() int64 {
+	s := t.store
+	if len(t.changes) == 0 {
+		rev := s.currentRev
+		s.mutex.Unlock()
+		return rev
+	}
+	s.currentRev = t.rev
+	s.events = append(s.events, t.changes...)
+	for w := range s.watchers {
+		w.notify(t.changes)
+	}
+	s.mutex.Unlock()
+	return t.rev
+}
+
+func (t *writeTxn) put(r *pb.PutRequest) (*pb.PutResponse, error) {
+	s := t.store
+	key := string(r.Key)
+	if len(key) == 0 {
+		return nil, rpctypes.ErrGRPCEmptyKey
+	}
+	if t.written[key] {
+		return nil, rpctypes.ErrGRPCDuplicateKey
+	}
+	if t.client && s.mirroredLocked(r.Key, nil) {
+		return nil, rpctypes.ErrGRPCPermissionDenied
+	}
+	prev := s.latestLocked(key, t.rev)
+	if (r.IgnoreValue || r.IgnoreLease) && prev == nil {
+		return nil, rpctypes.ErrGRPCKeyNotFound
+	}
+
+	kv := &mvccpb.KeyValue{
+		Key:            r.Key,
+		CreateRevision: t.rev,
+		ModRevision:    t.rev,
+		Version:        1,
+		Value:          r.Value,
+		Lease:          r.Lease,
+	}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+		kv.Version = prev.Version + 1
+		if r.IgnoreValue {
+			kv.Value = prev.Value
+		}
+		if r.IgnoreLease {
+			kv.Lease = prev.Lease
+		}
+	}
+	if err := s.leases.attachLocked(key, prev, kv.Lease); err != nil {
+		return nil, err
+	}
+
+	s.history[key] = append(s.history[key], kv)
+	t.written[key] = true
+	t.changes = append(t.changes, &mvccpb.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: prev})
+
+	resp := &pb.PutResponse{}
+	if r.PrevKv {
+		resp.PrevKv = prev
+	}
+	return resp, nil
+}
+
+func (t *writeTxn) deleteRange(r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	s := t.store
+	if t.client && s.mirroredLocked(r.Key, r.RangeEnd) {
+		return nil, rpctypes.ErrGRPCPermissionDenied
+	}
+	resp := &pb.DeleteRangeResponse{}
+	for _, key := range s.keysLocked(r.Key, r.RangeEnd) {
+		if t.written[key] {
+			return nil, rpctypes.ErrGRPCDuplicateKey
+		}
+		prev := s.latestLocked(key, t.rev)
+		if prev == nil {
+			continue
+		}
+		s.leases.detachLocked(key, prev.Lease)
+
+		tombstone := &mvccpb.KeyValue{Key: []byte(key), ModRevision: t.rev}
+		s.history[key] = append(s.history[key], tombstone)
+		if s.tombstones[key] == nil {
+			s.tombstones[key] = map[int64]bool{}
+		}
+		s.tombstones[key][t.rev] = true
+		t.written[key] = true
+		t.changes = append(t.changes, &mvccpb.Event{Type: mvccpb.DELETE, Kv: tombstone, PrevKv: prev})
+
+		resp.Deleted++
+		if r.PrevKv {
+			resp.PrevKvs = append(resp.PrevKvs, prev)
+		}
+	}
+	return resp, nil
+}
+
+func (t *writeTxn) txn(r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	succeeded := true
+	for _, c := range r.Compare {
+		if !t.compare(c) {
+			succeeded = false
+			break
+		}
+	}
+	ops := r.Success
+	if !succeeded {
+		ops = r.Failure
+	}
+
+	resp := &pb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		switch {
+		case op.GetRequestRange() != nil:
+			rangeReq := *op.GetRequestRange()
+			// the reads within the txn should see the writes of it
+			rangeResp, err := t.rangeUncommitted(&rangeReq)
+			if err != nil {
+				return nil, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{
+				Response: &pb.ResponseOp_ResponseRange{ResponseRange: rangeResp},
+			})
+		case op.GetRequestPut() != nil:
+			putResp, err := t.put(op.GetRequestPut())
+			if err != nil {
+				return nil, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{
+				Response: &pb.ResponseOp_ResponsePut{ResponsePut: putResp},
+			})
+		case op.GetRequestDeleteRange() != nil:
+			deleteResp, err := t.deleteRange(op.GetRequestDeleteRange())
+			if err != nil {
+				return nil, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{
+				Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: deleteResp},
+			})
+		case op.GetRequestTxn() != nil:
+			txnResp, err := t.txn(op.GetRequestTxn())
+			if err != nil {
+				return nil, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{
+				Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: txnResp},
+			})
+		}
+	}
+	return resp, nil
+}
+
+func (t *writeTxn) rangeUncommitted(r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	s := t.store
+	currentRev := s.currentRev
+	if r.Revision <= 0 {
+		s.currentRev = t.rev
+		defer func() { s.currentRev = currentRev }()
+	}
+	return s.rangeLocked(r)
+}
+
+func (t *writeTxn) compare(c *pb.Compare) bool {
+	s := t.store
+	keys := s.keysLocked(c.Key, c.RangeEnd)
+	if len(c.RangeEnd) == 0 {
+		keys = []string{string(c.Key)}
+	}
+	for _, key := range keys {
+		kv := s.latestLocked(key, t.rev)
+		if kv == nil {
+			// the missing key is treated as a zero value for the revisions and version
+			if c.Target == pb.Compare_VALUE {
+				return false
+			}
+			kv = &mvccpb.KeyValue{}
+		}
+		if !compareKeyValue(kv, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareKeyValue(kv *mvccpb.KeyValue, c *pb.Compare) bool {
+	var result int
+	switch c.Target {
+	case pb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, c.GetValue())
+	case pb.Compare_VERSION:
+		result = compareInt64(kv.Version, c.GetVersion())
+	case pb.Compare_CREATE:
+		result = compareInt64(kv.CreateRevision, c.GetCreateRevision())
+	case pb.Compare_MOD:
+		result = compareInt64(kv.ModRevision, c.GetModRevision())
+	case pb.Compare_LEASE:
+		result = compareInt64(kv.Lease, c.GetLease())
+	}
+
+	switch c.Result {
+	case pb.Compare_EQUAL:
+		return result == 0
+	case pb.Compare_NOT_EQUAL:
+		return result != 0
+	case pb.Compare_GREATER:
+		return result > 0
+	case pb.Compare_LESS:
+		return result < 0
+	}
+	return false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
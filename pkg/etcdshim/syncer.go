@@ -0,0 +1,128 @@
+package etcdshim
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// KeyFunc builds the etcd key of the object, it should be consistent with the storage layout of the apiserver. The key
+// of the object without the namespace and name is the prefix of the resource.
+type KeyFunc func(gvr schema.GroupVersionResource, obj metav1.Object) string
+
+// resourcePrefixes are the resources stored under the prefixes other than their names by the kube-apiserver
+var resourcePrefixes = map[schema.GroupResource]string{
+	{Resource: "nodes"}:                                    "minions",
+	{Resource: "services"}:                                 "services/specs",
+	{Resource: "endpoints"}:                                "services/endpoints",
+	{Resource: "replicationcontrollers"}:                   "controllers",
+	{Group: "networking.k8s.io", Resource: "ingresses"}:    "ingress",
+	{Group: "extensions", Resource: "ingresses"}:           "ingress",
+	{Group: "policy", Resource: "podsecuritypolicies"}:     "podsecuritypolicy",
+	{Group: "extensions", Resource: "podsecuritypolicies"}: "podsecuritypolicy",
+}
+
+// DefaultKeyFunc returns the key in the layout of the kube-apiserver: <prefix>/<resource>/<namespace>/<name> for
+// the built-in resources regardless of their groups(except the ones of the resourcePrefixes, e.g. the nodes are
+// stored under the minions), and <prefix>/<group>/<resource>/<namespace>/<name> for the custom resources.
+func DefaultKeyFunc(prefix string) KeyFunc {
+	return func(gvr schema.GroupVersionResource, obj metav1.Object) string {
+		key := path.Join(prefix, resourcePrefix(gvr.GroupResource()))
+		if obj.GetNamespace() != "" {
+			key = path.Join(key, obj.GetNamespace())
+		}
+		return path.Join(key, obj.GetName())
+	}
+}
+
+// resourcePrefix returns the prefix of the resource under the root of the apiserver storage, the groups of the
+// kube-apiserver are the ones registered in the client-go scheme
+func resourcePrefix(gr schema.GroupResource) string {
+	if prefix, ok := resourcePrefixes[gr]; ok {
+		return prefix
+	}
+	if gr.Group == "" || scheme.Scheme.IsGroupRegistered(gr.Group) {
+		return gr.Resource
+	}
+	return path.Join(gr.Group, gr.Resource)
+}
+
+// Syncer mirrors the objects cached by the informers into the store. The informers are kept up to date by the
+// transport, so that the clients of the store(like the kube-apiserver) can read and watch the resources from it.
+type Syncer struct {
+	store   *Store
+	keyFunc KeyFunc
+}
+
+func NewSyncer(store *Store, keyFunc KeyFunc) *Syncer {
+	return &Syncer{
+		store:   store,
+		keyFunc: keyFunc,
+	}
+}
+
+// AddInformer writes the objects of the informer into the store, it should be invoked before the informer is started.
+// The keys of the resource are read-only to the clients of the store.
+func (s *Syncer) AddInformer(gvr schema.GroupVersionResource, informer cache.SharedIndexInformer) {
+	// the key without the namespace and name is the prefix of all the keys of the resource
+	s.store.Mirror(s.keyFunc(gvr, &metav1.ObjectMeta{}) + "/")
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.put(gvr, obj)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			s.put(gvr, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				klog.Errorf("failed to access the deleted object: %v", err)
+				return
+			}
+			key := s.keyFunc(gvr, accessor)
+			rev := s.store.Delete(key)
+			klog.V(4).Infof("etcdshim deleted %s at revision %d", key, rev)
+		},
+	})
+}
+
+func (s *Syncer) put(gvr schema.GroupVersionResource, obj interface{}) {
+	runtimeObj, ok := obj.(runtime.Object)
+	if !ok {
+		klog.Errorf("unexpected object type %T", obj)
+		return
+	}
+	// the resourceVersion of the stored object is always derived from the mod revision of the key
+	runtimeObj = runtimeObj.DeepCopyObject()
+	accessor, err := meta.Accessor(runtimeObj)
+	if err != nil {
+		klog.Errorf("failed to access the object: %v", err)
+		return
+	}
+	accessor.SetResourceVersion("")
+
+	value, err := json.Marshal(runtimeObj)
+	if err != nil {
+		klog.Errorf("failed to marshal the object %s/%s: %v", accessor.GetNamespace(), accessor.GetName(), err)
+		return
+	}
+
+	key := s.keyFunc(gvr, accessor)
+	// skip the resync events, otherwise the revision is bumped without any change
+	if kv, ok := s.store.Get(key); ok && bytes.Equal(kv.Value, value) {
+		return
+	}
+	rev := s.store.Put(key, value)
+	klog.V(4).Infof("etcdshim put %s at revision %d", key, rev)
+}
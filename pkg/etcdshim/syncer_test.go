@@ -0,0 +1,46 @@
+package etcdshim
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestDefaultKeyFunc(t *testing.T) {
+	keyFunc := DefaultKeyFunc("/registry")
+	cases := []struct {
+		gvr       schema.GroupVersionResource
+		namespace string
+		expected  string
+	}{
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, namespace: "default",
+			expected: "/registry/secrets/default/foo"},
+		// the built-in resources of the non-core groups are stored without the group
+		{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, namespace: "default",
+			expected: "/registry/deployments/default/foo"},
+		{gvr: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, namespace: "default",
+			expected: "/registry/jobs/default/foo"},
+		{gvr: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"},
+			expected: "/registry/clusterroles/foo"},
+		// the resources stored under the other prefixes
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, expected: "/registry/minions/foo"},
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "services"}, namespace: "default",
+			expected: "/registry/services/specs/default/foo"},
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}, namespace: "default",
+			expected: "/registry/services/endpoints/default/foo"},
+		{gvr: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+			namespace: "default", expected: "/registry/ingress/default/foo"},
+		// the custom resources and the resources of the other apiservers are stored with the group
+		{gvr: schema.GroupVersionResource{Group: "work.straw.io", Version: "v1alpha1", Resource: "manifestworks"},
+			namespace: "default", expected: "/registry/work.straw.io/manifestworks/default/foo"},
+		{gvr: schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1",
+			Resource: "customresourcedefinitions"}, expected: "/registry/apiextensions.k8s.io/customresourcedefinitions/foo"},
+	}
+	for _, c := range cases {
+		obj := &metav1.ObjectMeta{Namespace: c.namespace, Name: "foo"}
+		if key := keyFunc(c.gvr, obj); key != c.expected {
+			t.Errorf("expected the key of %s to be %s, got %s", c.gvr, c.expected, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package etcdshim
+
+import (
+	"sync"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// the number of the pending revisions a watcher can hold before it's canceled as a slow watcher
+const watcherBufferSize = 1024
+
+type watcher struct {
+	id       int64
+	key      []byte
+	rangeEnd []byte
+	prevKv   bool
+	noPut    bool
+	noDelete bool
+
+	events chan []*mvccpb.Event
+	// slow is closed when the events buffer is overflowed, then the watcher should be canceled
+	slow     chan struct{}
+	slowOnce sync.Once
+}
+
+func newWatcher(id int64, r *pb.WatchCreateRequest) *watcher {
+	w := &watcher{
+		id:       id,
+		key:      r.Key,
+		rangeEnd: r.RangeEnd,
+		prevKv:   r.PrevKv,
+		events:   make(chan []*mvccpb.Event, watcherBufferSize),
+		slow:     make(chan struct{}),
+	}
+	for _, filter := range r.Filters {
+		switch filter {
+		case pb.WatchCreateRequest_NOPUT:
+			w.noPut = true
+		case pb.WatchCreateRequest_NODELETE:
+			w.noDelete = true
+		}
+	}
+	return w
+}
+
+// filter returns the events the watcher is interested in
+func (w *watcher) filter(events []*mvccpb.Event) []*mvccpb.Event {
+	filtered := []*mvccpb.Event{}
+	for _, e := range events {
+		if !inRange(e.Kv.Key, w.key, w.rangeEnd) {
+			continue
+		}
+		if (e.Type == mvccpb.PUT && w.noPut) || (e.Type == mvccpb.DELETE && w.noDelete) {
+			continue
+		}
+		if !w.prevKv && e.PrevKv != nil {
+			e = &mvccpb.Event{Type: e.Type, Kv: e.Kv}
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
+
+// notify is invoked with the store lock held, so it never blocks
+func (w *watcher) notify(changes []*mvccpb.Event) {
+	events := w.filter(changes)
+	if len(events) == 0 {
+		return
+	}
+	select {
+	case w.events <- events:
+	default:
+		w.slowOnce.Do(func() { close(w.slow) })
+	}
+}
+
+// watch registers the watcher to the store and returns the history events since the start revision.
+func (s *Store) watch(w *watcher, startRev int64) ([]*mvccpb.Event, int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if startRev > 0 && startRev <= s.compactRev {
+		return nil, s.compactRev, rpctypes.ErrGRPCCompacted
+	}
+
+	history := []*mvccpb.Event{}
+	if startRev > 0 {
+		for _, e := range s.events {
+			if e.Kv.ModRevision >= startRev {
+				history = append(history, e)
+			}
+		}
+	}
+	s.watchers[w] = struct{}{}
+	return w.filter(history), 0, nil
+}
+
+func (s *Store) unwatch(w *watcher) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.watchers, w)
+}
@@ -10,24 +10,24 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/informers"
-	"k8s.io/client-go/metadata/metadatalister"
 	"k8s.io/client-go/tools/cache"
 )
 
 var _ informers.GenericInformer = (*eventInformer)(nil)
 
 type eventInformer struct {
-	informer cache.SharedIndexInformer
-	gvr      schema.GroupVersionResource
+	informer  cache.SharedIndexInformer
+	converter *objectConverter
 }
 
-// NewFilteredEventInformer constructs a new informer for a metadata type.
+// NewFilteredEventInformer constructs a new informer which caches the objects in the type of the mode.
 func NewFilteredEventInformer(ctx context.Context, t cloudevents.Client, gvr schema.GroupVersionResource,
 	namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakOptions TweakListOptionsFunc,
+	mode ObjectMode,
 ) informers.GenericInformer {
-	lw := NewEventListWatcher(ctx, t, namespace, gvr, "informer")
+	lw := newEventListWatcher(ctx, t, namespace, gvr, "informer", mode)
 	return &eventInformer{
-		gvr: gvr,
+		converter: lw.converter,
 		informer: cache.NewSharedIndexInformer(
 			&cache.ListWatch{
 				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
@@ -43,7 +43,7 @@ func NewFilteredEventInformer(ctx context.Context, t cloudevents.Client, gvr sch
 					return lw.Watch(options)
 				},
 			},
-			&metav1.PartialObjectMetadata{},
+			lw.converter.objectType(),
 			resyncPeriod,
 			indexers,
 		),
@@ -55,5 +55,5 @@ func (d *eventInformer) Informer() cache.SharedIndexInformer {
 }
 
 func (d *eventInformer) Lister() cache.GenericLister {
-	return metadatalister.NewRuntimeObjectShim(metadatalister.New(d.informer.GetIndexer(), d.gvr))
+	return d.converter.lister(d.informer.GetIndexer())
 }
@@ -8,7 +8,7 @@ import (
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/google/uuid"
 	"github.com/yanmxa/straw/pkg/apis"
-	"github.com/yanmxa/straw/pkg/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -30,29 +30,39 @@ type eventListWatcher struct {
 	watcher        EventWatcher
 	listResultChan map[types.UID]chan apis.ListResponseEvent
 	rwlock         sync.RWMutex
+	// converter converts the objects into the type cached by the informer
+	converter *objectConverter
 
 	transporter cloudevents.Client
 }
 
+// NewEventListWatcher lists and watches the metadata of the resources from the cloudevents client
 func NewEventListWatcher(ctx context.Context, t cloudevents.Client, namespace string,
 	gvr schema.GroupVersionResource, source string,
 ) cache.ListerWatcher {
+	return newEventListWatcher(ctx, t, namespace, gvr, source, MetadataObject)
+}
+
+func newEventListWatcher(ctx context.Context, t cloudevents.Client, namespace string,
+	gvr schema.GroupVersionResource, source string, mode ObjectMode,
+) *eventListWatcher {
 	lw := &eventListWatcher{
 		ctx:            ctx,
 		gvr:            gvr,
 		namespace:      namespace,
 		source:         source,
 		listResultChan: map[types.UID]chan apis.ListResponseEvent{},
+		converter:      newObjectConverter(gvr, mode),
 		transporter:    t,
 	}
 
 	go t.StartReceiver(ctx, func(event cloudevents.Event) error {
-		lw.rwlock.RLock()
-		defer lw.rwlock.RUnlock()
 		klog.Infof("received response event %s", event.Type())
 		switch event.Type() {
 		case apis.EventListResponseType(gvr):
+			lw.rwlock.RLock()
 			resultChan, ok := lw.listResultChan[types.UID(event.ID())]
+			lw.rwlock.RUnlock()
 			if !ok {
 				return fmt.Errorf("unable to find the related uid for list %s", event.ID())
 			}
@@ -61,12 +71,18 @@ func NewEventListWatcher(ctx context.Context, t cloudevents.Client, namespace st
 			if err != nil {
 				return err
 			}
-			resultChan <- *response
+			select {
+			case resultChan <- *response:
+			case <-ctx.Done():
+			}
 		case apis.EventWatchResponseType(gvr):
-			if lw.watcher == nil {
+			lw.rwlock.RLock()
+			watcher := lw.watcher
+			lw.rwlock.RUnlock()
+			if watcher == nil {
 				return fmt.Errorf("unable to find the watcher for gvr %s", gvr)
 			}
-			return lw.watcher.Add(event)
+			return watcher.Add(event)
 		}
 		return nil
 	})
@@ -80,34 +96,42 @@ func (e *eventListWatcher) List(options metav1.ListOptions) (runtime.Object, err
 	if err != nil {
 		return nil, err
 	}
+	// the result chan must be ready before the request is sent, otherwise the response might be dropped
+	resultChan := make(chan apis.ListResponseEvent)
+	e.rwlock.Lock()
+	e.listResultChan[types.UID(sessionId)] = resultChan
+	e.rwlock.Unlock()
+	defer func() {
+		e.rwlock.Lock()
+		delete(e.listResultChan, types.UID(sessionId))
+		e.rwlock.Unlock()
+	}()
+
 	result := e.transporter.Send(e.ctx, listRequestEvent)
 	if cloudevents.IsUndelivered(result) {
 		return nil, fmt.Errorf("failed to send list event, %v", result)
 	}
 	klog.Infof("request to list event: %s", listRequestEvent.Type())
-	e.listResultChan[types.UID(sessionId)] = make(chan apis.ListResponseEvent)
-	defer delete(e.listResultChan, types.UID(sessionId))
 
 	objectList := &unstructured.UnstructuredList{}
-	// TODO
-	defer func() {
-		utils.PrettyPrint(objectList)
-	}()
 	for {
 		select {
-		case response, ok := <-e.listResultChan[types.UID(sessionId)]:
+		case response, ok := <-resultChan:
 			if !ok {
 				klog.Errorf("listResult chan(%s) is closed: %s", sessionId, listRequestEvent.Type())
 				return objectList, nil
 			}
 
-			if objectList.Object == nil {
-				objectList.Object = response.Objects.Object
+			// the list failed on the provider, so that the reflector backs off and relists
+			if response.Error != nil {
+				return nil, apierrors.FromObject(response.Error)
 			}
+			// the list metadata(resourceVersion and continue) of the last chunk is the one of the whole list
+			objectList.Object = response.Objects.Object
 
 			objectList.Items = append(objectList.Items, response.Objects.Items...)
 			if response.EndOfList {
-				return objectList, nil
+				return e.converter.convertList(objectList)
 			}
 		case <-e.ctx.Done():
 			return objectList, nil
@@ -121,13 +145,18 @@ func (e *eventListWatcher) Watch(options metav1.ListOptions) (watch.Interface, e
 	if err != nil {
 		return nil, err
 	}
+	// the watcher must be ready before the request is sent, otherwise the early responses might be dropped
+	watcher := newEventWatcher(types.UID(sessionId), e.gvr, e.converter, 10, e.watcherStop)
+	e.rwlock.Lock()
+	e.watcher = watcher
+	e.rwlock.Unlock()
+
 	result := e.transporter.Send(e.ctx, watchRequestEvent)
 	if cloudevents.IsUndelivered(result) {
 		return nil, fmt.Errorf("failed to send watch event: %v", result)
 	}
 	klog.Infof("request to watch: %s", watchRequestEvent.Type())
-	e.watcher = newEventWatcher(types.UID(sessionId), e.gvr, 10, e.watcherStop)
-	return e.watcher, nil
+	return watcher, nil
 }
 
 func (e *eventListWatcher) watcherStop(watcherId string) {
@@ -140,7 +169,7 @@ func (e *eventListWatcher) watcherStop(watcherId string) {
 	// since the context is canceled, we should send the message with a new context
 	result := e.transporter.Send(context.TODO(), stopWatchRequestEvent)
 	if cloudevents.IsUndelivered(result) {
-		klog.Error("failed to send watch event: %v", result)
+		klog.Errorf("failed to send stopwatch event: %v", result)
 	}
 }
 
@@ -2,10 +2,11 @@ package informer
 
 import (
 	"fmt"
-	"reflect"
+	"sync"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/yanmxa/straw/pkg/apis"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
@@ -23,15 +24,25 @@ type eventWatcher struct {
 	uid             types.UID
 	gvr             schema.GroupVersionResource
 	stop            func(id string)
+	converter       *objectConverter
 	watchResultChan chan watch.Event
+
+	// the result chan is closed by the Stop, the lock prevents Add from sending to the closed chan
+	mutex   sync.Mutex
+	stopped bool
+	done    chan struct{}
 }
 
-func newEventWatcher(uid types.UID, gvr schema.GroupVersionResource, chanSize int, stop func(id string)) EventWatcher {
+func newEventWatcher(uid types.UID, gvr schema.GroupVersionResource, converter *objectConverter, chanSize int,
+	stop func(id string),
+) EventWatcher {
 	return &eventWatcher{
 		uid:             uid,
 		gvr:             gvr,
+		converter:       converter,
 		watchResultChan: make(chan watch.Event, chanSize),
 		stop:            stop,
+		done:            make(chan struct{}),
 	}
 }
 
@@ -41,12 +52,17 @@ func (w *eventWatcher) ResultChan() <-chan watch.Event {
 
 func (w *eventWatcher) Stop() {
 	w.stop(string(w.uid))
+	// unblock the pending Add before taking the lock
+	close(w.done)
+	w.mutex.Lock()
+	w.stopped = true
 	close(w.watchResultChan)
+	w.mutex.Unlock()
 }
 
 func (w *eventWatcher) Add(event cloudevents.Event) error {
 	if w.uid != types.UID(event.ID()) {
-		return fmt.Errorf("unable to find the related event uid(%s) for watcher(%s) %s", event.ID(), w.uid)
+		return fmt.Errorf("unable to find the related event uid(%s) for watcher(%s)", event.ID(), w.uid)
 	}
 
 	watchResponse := &apis.WatchResponseEvent{}
@@ -55,22 +71,27 @@ func (w *eventWatcher) Add(event cloudevents.Event) error {
 		return err
 	}
 
-	// obj := GetObject(apis.ToGVRString(w.gvr))
-	// if obj == nil {
-	// 	return fmt.Errorf("the informer is unable to find the runtime object for gvr(%s)", apis.ToGVRString(w.gvr))
-	// }
-
-	// err = runtime.DefaultUnstructuredConverter.FromUnstructured(watchResponse.Object.Object, obj)
-	// if err != nil {
-	// 	return err
-	// }
-	t := reflect.TypeOf(watchResponse.Object)
-	fmt.Println("received unstructured event type", t)
+	// the reflector relists or rewatches by the Status
+	var obj runtime.Object
+	if watchResponse.Type == watch.Error {
+		obj, err = convertToStatus(watchResponse.Object)
+	} else {
+		obj, err = w.converter.convert(watchResponse.Object)
+	}
+	if err != nil {
+		return err
+	}
 
 	// utils.PrettyPrint(obj)
-	w.watchResultChan <- watch.Event{
-		Type:   watchResponse.Type,
-		Object: watchResponse.Object,
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped {
+		return nil
+	}
+	select {
+	case w.watchResultChan <- watch.Event{Type: watchResponse.Type, Object: obj}:
+	case <-w.done:
+		return nil
 	}
 	klog.Info("watcher add event: ", event.Type())
 	return nil
@@ -10,13 +10,12 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/informers"
-	"k8s.io/client-go/metadata/metadatalister"
 	"k8s.io/client-go/tools/cache"
 )
 
 type messageInformer struct {
-	informer cache.SharedIndexInformer
-	gvr      schema.GroupVersionResource
+	informer  cache.SharedIndexInformer
+	converter *objectConverter
 }
 
 // NewFilteredMetadataInformer constructs a new informer for a metadata type.
@@ -24,26 +23,52 @@ func NewFilteredMetadataInformer(ctx context.Context, t transport.Transport, gvr
 	namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakOptions TweakListOptionsFunc,
 	sendTopic, receiveTopic string,
 ) informers.GenericInformer {
-	lw := NewMessageListWatcher(ctx, t, namespace, gvr, sendTopic, receiveTopic)
+	return NewFilteredMessageInformer(ctx, t, gvr, namespace, resyncPeriod, indexers, tweakOptions,
+		sendTopic, receiveTopic, MetadataObject)
+}
+
+// NewFilteredUnstructuredInformer constructs a new informer which caches the full unstructured objects rather than
+// the metadata.
+func NewFilteredUnstructuredInformer(ctx context.Context, t transport.Transport, gvr schema.GroupVersionResource,
+	namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakOptions TweakListOptionsFunc,
+	sendTopic, receiveTopic string,
+) informers.GenericInformer {
+	return NewFilteredMessageInformer(ctx, t, gvr, namespace, resyncPeriod, indexers, tweakOptions,
+		sendTopic, receiveTopic, UnstructuredObject)
+}
 
+// NewFilteredMessageInformer constructs a new informer which caches the objects in the type of the mode.
+func NewFilteredMessageInformer(ctx context.Context, t transport.Transport, gvr schema.GroupVersionResource,
+	namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakOptions TweakListOptionsFunc,
+	sendTopic, receiveTopic string, mode ObjectMode,
+) informers.GenericInformer {
+	return newMessageInformer(NewMessageListWatcher(ctx, t, namespace, gvr, sendTopic, receiveTopic),
+		newObjectConverter(gvr, mode), resyncPeriod, indexers, tweakOptions)
+}
+
+// newMessageInformer constructs a new informer on the list-watcher, which might be shared with the informers of the
+// resource in the other modes, the objects are converted by the converter of the mode.
+func newMessageInformer(lw *MessageListWatcher, converter *objectConverter, resyncPeriod time.Duration,
+	indexers cache.Indexers, tweakOptions TweakListOptionsFunc,
+) informers.GenericInformer {
 	return &messageInformer{
-		gvr: gvr,
+		converter: converter,
 		informer: cache.NewSharedIndexInformer(
 			&cache.ListWatch{
 				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
 					if tweakOptions != nil {
 						tweakOptions(&options)
 					}
-					return lw.List(options)
+					return lw.List(options, converter)
 				},
 				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 					if tweakOptions != nil {
 						tweakOptions(&options)
 					}
-					return lw.Watch(options)
+					return lw.Watch(options, converter)
 				},
 			},
-			&metav1.PartialObjectMetadata{},
+			converter.objectType(),
 			resyncPeriod,
 			indexers,
 		),
@@ -55,5 +80,5 @@ func (d *messageInformer) Informer() cache.SharedIndexInformer {
 }
 
 func (d *messageInformer) Lister() cache.GenericLister {
-	return metadatalister.NewRuntimeObjectShim(metadatalister.New(d.informer.GetIndexer(), d.gvr))
+	return d.converter.lister(d.informer.GetIndexer())
 }
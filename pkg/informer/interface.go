@@ -12,6 +12,8 @@ type SharedInformerFactory interface {
 	Start()
 	// ForResource gives generic access to a shared informer of the matching type.
 	ForResource(gvr schema.GroupVersionResource) informers.GenericInformer
+	// ForResourceWithMode gives generic access to a shared informer caching the objects in the type of the mode.
+	ForResourceWithMode(gvr schema.GroupVersionResource, mode ObjectMode) informers.GenericInformer
 	// WaitForCacheSync blocks until all started informers' caches were synced
 	// or the stop channel gets closed.
 	WaitForCacheSync(stopCh <-chan struct{}) map[schema.GroupVersionResource]bool
@@ -31,6 +33,19 @@ type SharedInformerFactory interface {
 	ListAll()
 }
 
+// MultiClusterSharedInformerFactory caches the resources of the clusters, which are added once they're known
+type MultiClusterSharedInformerFactory interface {
+	SharedInformerFactory
+	// AddCluster lists and watches the resources of the informers from the cluster as well
+	AddCluster(cluster string)
+}
+
+// informerKey identifies the informers of a factory, the same resource can be cached in several types
+type informerKey struct {
+	gvr  schema.GroupVersionResource
+	mode ObjectMode
+}
+
 // TweakListOptionsFunc defines the signature of a helper function
 // that wants to provide more listing options to API
 type TweakListOptionsFunc func(*metav1.ListOptions)
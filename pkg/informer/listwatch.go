@@ -10,6 +10,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/yanmxa/straw/pkg/apis"
 	"github.com/yanmxa/straw/pkg/transport"
+	"github.com/yanmxa/straw/pkg/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -19,29 +22,58 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// MessageListWatcher lists and watches the resource by a single receiver of the topic, it's shared by the informers
+// of the resource in the object modes. The responses are routed to the lists and watchers by the uid of the requests,
+// and converted into the types cached by the informers.
 type MessageListWatcher struct {
 	ctx            context.Context
 	gvr            schema.GroupVersionResource
 	namespace      string
-	watcher        *messageWatcher
+	watchers       map[types.UID]*messageWatcher
 	listResultChan map[types.UID]chan apis.ListResponseMessage
 	rwlock         sync.RWMutex
+	// disconnected indicates the transport is disconnected from the broker
+	disconnected bool
 
 	transporter             transport.Transport
 	sendTopic, receiveTopic string
+	// cluster labels the objects of the provider with the utils.ClusterLabelKey if it's set
+	cluster string
 }
 
+// NewMessageListWatcher lists and watches the resource from the provider by the send and receive topics, the {gvr}
+// placeholder of the topics(apis.TopicTemplate) is rendered with the resource.
 func NewMessageListWatcher(ctx context.Context, t transport.Transport, namespace string,
 	gvr schema.GroupVersionResource, send, receive string,
 ) *MessageListWatcher {
+	return newMessageListWatcher(ctx, t, namespace, gvr, send, receive, "")
+}
+
+// NewClusterMessageListWatcher lists and watches the resource from the provider of the cluster by rendering the
+// {cluster} of the send and receive topics with it, and labels the objects with the cluster, so that the objects of
+// the clusters can be told apart once they're aggregated, see NewSharedMultiClusterMessageInformerFactory.
+func NewClusterMessageListWatcher(ctx context.Context, t transport.Transport, namespace string,
+	gvr schema.GroupVersionResource, send, receive string, cluster string,
+) *MessageListWatcher {
+	return newMessageListWatcher(ctx, t, namespace, gvr, apis.ClusterTopic(send, cluster),
+		apis.ClusterTopic(receive, cluster), cluster)
+}
+
+func newMessageListWatcher(ctx context.Context, t transport.Transport, namespace string,
+	gvr schema.GroupVersionResource, send, receive string, cluster string,
+) *MessageListWatcher {
+	send = apis.ResourceTopic(send, gvr)
+	receive = apis.TopicFilter(apis.ResourceTopic(receive, gvr))
 	lw := &MessageListWatcher{
 		ctx:            ctx,
 		gvr:            gvr,
 		namespace:      namespace,
+		watchers:       map[types.UID]*messageWatcher{},
 		listResultChan: map[types.UID]chan apis.ListResponseMessage{},
 		transporter:    t,
 		sendTopic:      send,
 		receiveTopic:   receive,
+		cluster:        cluster,
 	}
 
 	receiver, err := t.Receive(receive)
@@ -49,17 +81,26 @@ func NewMessageListWatcher(ctx context.Context, t transport.Transport, namespace
 		panic(err)
 	}
 
+	if notifier, ok := t.(transport.ConnectionNotifier); ok {
+		notifier.AddConnectionStateHandler(lw.onConnectionStateChange)
+	}
+
 	go func() {
 		for {
 			select {
 			case <-ctx.Done():
 				klog.Info("context done! stop receive message...")
 				return
-			case transportMsg := <-receiver.MessageChan():
+			case transportMsg, ok := <-receiver.MessageChan():
+				if !ok {
+					klog.Info("receiver is stopped! stop receive message...")
+					return
+				}
 				err := lw.process(ctx, &transportMsg)
 				if err != nil {
 					klog.Error(err)
 				}
+				transport.Ack(receiver)
 			}
 		}
 	}()
@@ -67,14 +108,35 @@ func NewMessageListWatcher(ctx context.Context, t transport.Transport, namespace
 	return lw
 }
 
+// onConnectionStateChange expires the watchers once the transport is reconnected, the informers relist the resources
+// since the messages might be lost during the outage.
+func (lw *MessageListWatcher) onConnectionStateChange(state transport.ConnectionState) {
+	lw.rwlock.Lock()
+	reconnected := state == transport.Connected && lw.disconnected
+	lw.disconnected = state == transport.Disconnected
+	watchers := make([]*messageWatcher, 0, len(lw.watchers))
+	for _, watcher := range lw.watchers {
+		watchers = append(watchers, watcher)
+	}
+	lw.rwlock.Unlock()
+
+	if reconnected && len(watchers) > 0 {
+		klog.Infof("transport is reconnected, relist the %s", apis.ToGVRString(lw.gvr))
+		// the expiry blocks until the reflector receives it, it mustn't hold the callback goroutine of the transport
+		for _, watcher := range watchers {
+			go watcher.expire("the transport is reconnected, the events might be lost during the outage")
+		}
+	}
+}
+
 func (lw *MessageListWatcher) process(ctx context.Context, transportMessage *apis.TransportMessage) error {
-	lw.rwlock.RLock()
-	defer lw.rwlock.RUnlock()
 	// klog.Infof("received message(%s): %s", transportMessage.ID, transportMessage.Type)
 
 	switch transportMessage.Type {
 	case apis.MessageListResponseType(lw.gvr): // response.list.%s
+		lw.rwlock.RLock()
 		resultChan, ok := lw.listResultChan[types.UID(transportMessage.ID)]
+		lw.rwlock.RUnlock()
 		if !ok {
 			return fmt.Errorf("unable to find the related uid for list %s", transportMessage.ID)
 		}
@@ -84,12 +146,19 @@ func (lw *MessageListWatcher) process(ctx context.Context, transportMessage *api
 		if err != nil {
 			return err
 		}
-		resultChan <- *listResponse
+		select {
+		case resultChan <- *listResponse:
+		case <-ctx.Done():
+		}
 	case apis.MessageWatchResponseType(lw.gvr):
-		if lw.watcher == nil {
-			return fmt.Errorf("unable to find the related uid for watch %s", transportMessage.ID)
+		lw.rwlock.RLock()
+		watcher, ok := lw.watchers[types.UID(transportMessage.ID)]
+		lw.rwlock.RUnlock()
+		// the events might be still on the way after the watcher is stopped
+		if !ok {
+			return nil
 		}
-		err := lw.watcher.process(*transportMessage)
+		err := watcher.process(*transportMessage)
 		if err != nil {
 			return fmt.Errorf("unable to process message %s", transportMessage.Type)
 		}
@@ -97,24 +166,77 @@ func (lw *MessageListWatcher) process(ctx context.Context, transportMessage *api
 	return nil
 }
 
-func (e *MessageListWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
-	return e.list(e.ctx, options)
+// List lists the objects in the type of the converter
+func (e *MessageListWatcher) List(options metav1.ListOptions, converter *objectConverter) (runtime.Object, error) {
+	objectList, err := e.list(e.ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	list, err := converter.convertList(objectList)
+	if err != nil || e.cluster == "" {
+		return list, err
+	}
+	if err := meta.EachListItem(list, e.label); err != nil {
+		return nil, err
+	}
+	return list, nil
 }
 
-func (e *MessageListWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+// Watch watches the objects in the type of the converter
+func (e *MessageListWatcher) Watch(options metav1.ListOptions, converter *objectConverter) (watch.Interface, error) {
 	watchMessage := newListWatchMsg("informer", apis.MessageWatchType(e.gvr), e.namespace, e.gvr, options)
 	transportMessage := watchMessage.ToMessage()
 
-	e.transporter.Send(e.sendTopic, transportMessage)
+	// the watcher must be ready before the request is sent, otherwise the early responses might be dropped
+	watcher := newMessageWatcher(watchMessage.uid, func() { e.watcherStop(watchMessage.uid) }, e.gvr, converter, 10)
+	e.rwlock.Lock()
+	e.watchers[watchMessage.uid] = watcher
+	e.rwlock.Unlock()
+
+	if err := e.transporter.Send(e.sendTopic, transportMessage); err != nil {
+		e.rwlock.Lock()
+		delete(e.watchers, watchMessage.uid)
+		e.rwlock.Unlock()
+		return nil, err
+	}
 	klog.Infof("request to watch message(%s) to %s", transportMessage.Type, e.sendTopic)
+	if e.cluster == "" {
+		return watcher, nil
+	}
+	return watch.Filter(watcher, func(in watch.Event) (watch.Event, bool) {
+		if in.Type != watch.Error && in.Type != watch.Bookmark {
+			if err := e.label(in.Object); err != nil {
+				return in, false
+			}
+		}
+		return in, true
+	}), nil
+}
 
-	e.watcher = newMessageWatcher(watchMessage.uid, e.watcherStop, e.gvr, 10)
-	return e.watcher, nil
+// label labels the object with the cluster of the provider
+func (e *MessageListWatcher) label(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	labels := accessor.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[utils.ClusterLabelKey] = e.cluster
+	accessor.SetLabels(labels)
+	return nil
 }
 
-func (e *MessageListWatcher) watcherStop() {
+// watcherStop asks the provider to stop the watch session with the uid
+func (e *MessageListWatcher) watcherStop(uid types.UID) {
+	e.rwlock.Lock()
+	delete(e.watchers, uid)
+	e.rwlock.Unlock()
+
 	stopWatchMessage := newListWatchMsg("informer", apis.MessageStopWatchType(e.gvr), e.namespace, e.gvr,
 		metav1.ListOptions{})
+	stopWatchMessage.uid = uid
 	transportMessage := stopWatchMessage.ToMessage()
 
 	klog.Infof("request to stop watch message(%s): %s", transportMessage.Type, e.sendTopic)
@@ -124,10 +246,21 @@ func (e *MessageListWatcher) watcherStop() {
 	}
 }
 
-func (e *MessageListWatcher) list(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+func (e *MessageListWatcher) list(ctx context.Context, options metav1.ListOptions) (*unstructured.UnstructuredList, error) {
 	listMessageRequest := newListWatchMsg("informer", apis.MessageListType(e.gvr), e.namespace, e.gvr, options)
 	transportMessage := listMessageRequest.ToMessage()
 
+	// the result chan must be ready before the request is sent, otherwise the response might be dropped
+	resultChan := make(chan apis.ListResponseMessage)
+	e.rwlock.Lock()
+	e.listResultChan[listMessageRequest.uid] = resultChan
+	e.rwlock.Unlock()
+	defer func() {
+		e.rwlock.Lock()
+		delete(e.listResultChan, listMessageRequest.uid)
+		e.rwlock.Unlock()
+	}()
+
 	klog.Infof("request to list message(%s) to %s", transportMessage.Type, e.sendTopic)
 	err := e.transporter.Send(e.sendTopic, transportMessage)
 	if err != nil {
@@ -136,21 +269,22 @@ func (e *MessageListWatcher) list(ctx context.Context, options metav1.ListOption
 
 	objectList := &unstructured.UnstructuredList{}
 	// now start to receive the list response until endOfList is false
-	e.listResultChan[listMessageRequest.uid] = make(chan apis.ListResponseMessage)
-	defer delete(e.listResultChan, listMessageRequest.uid)
 	listRunning := false
 	for {
 		select {
-		case response, ok := <-e.listResultChan[listMessageRequest.uid]:
+		case response, ok := <-resultChan:
 			if !ok {
 				klog.Errorf("listResult chan(%s) is closed: %s", transportMessage.ID, transportMessage.Type)
 				return objectList, nil
 			}
 
 			listRunning = true
-			if objectList.Object == nil {
-				objectList.Object = response.Objects.Object
+			// the list failed on the provider, so that the reflector backs off and relists
+			if response.Error != nil {
+				return nil, apierrors.FromObject(response.Error)
 			}
+			// the list metadata(resourceVersion and continue) of the last chunk is the one of the whole list
+			objectList.Object = response.Objects.Object
 
 			objectList.Items = append(objectList.Items, response.Objects.Items...)
 			if response.EndOfList {
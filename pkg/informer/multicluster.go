@@ -0,0 +1,470 @@
+package informer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var (
+	_ cache.SharedIndexInformer = &multiClusterInformer{}
+	_ cache.Indexer             = &multiClusterStore{}
+)
+
+// clusterKeySeparator separates the cluster from the namespace/name of the keys of the multiClusterStore, the same as
+// the reflector.ClusterMetaNamespaceKeyFunc
+const clusterKeySeparator = "#"
+
+// multiClusterInformer caches the resource of each cluster by its own informer, since the store of an informer is keyed
+// by the namespace/name, the objects of the same namespace and name in the clusters would overwrite each other. The
+// objects are labeled with the utils.ClusterLabelKey by the NewClusterMessageListWatcher, and the handlers are notified
+// of the objects of all the clusters. The clusters can be added once the informer is running, e.g. they're registered
+// by their heartbeats, and the handlers, indexers and the rest set on the informer are carried over to them.
+type multiClusterInformer struct {
+	listWatcher  func(cluster string) *MessageListWatcher
+	converter    *objectConverter
+	resyncPeriod time.Duration
+	indexers     cache.Indexers
+	tweakOptions TweakListOptionsFunc
+	store        *multiClusterStore
+
+	mutex             sync.RWMutex
+	clusters          []string
+	informers         map[string]cache.SharedIndexInformer
+	registrations     []*multiClusterRegistration
+	watchErrorHandler cache.WatchErrorHandler
+	transform         cache.TransformFunc
+	// stopCh is set once the informer is running, the informers of the clusters added later are run with it
+	stopCh <-chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newMultiClusterInformer constructs the informer on the list-watchers of the clusters, which might be shared with the
+// informers of the resource in the other modes.
+func newMultiClusterInformer(listWatcher func(cluster string) *MessageListWatcher, converter *objectConverter,
+	resyncPeriod time.Duration, indexers cache.Indexers, tweakOptions TweakListOptionsFunc, clusters []string,
+) informers.GenericInformer {
+	i := &multiClusterInformer{
+		listWatcher:  listWatcher,
+		converter:    converter,
+		resyncPeriod: resyncPeriod,
+		indexers:     cache.Indexers{},
+		tweakOptions: tweakOptions,
+		informers:    map[string]cache.SharedIndexInformer{},
+	}
+	for name, indexFunc := range indexers {
+		i.indexers[name] = indexFunc
+	}
+	i.store = &multiClusterStore{informer: i}
+	for _, cluster := range clusters {
+		if err := i.addCluster(cluster); err != nil {
+			klog.Errorf("failed to add the cluster %s: %v", cluster, err)
+		}
+	}
+	return i
+}
+
+// addCluster starts caching the resource of the cluster, the informer of the cluster is run if the multiClusterInformer
+// is running
+func (i *multiClusterInformer) addCluster(cluster string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if _, ok := i.informers[cluster]; ok {
+		return nil
+	}
+	informer := newMessageInformer(i.listWatcher(cluster), i.converter, i.resyncPeriod, i.indexers,
+		i.tweakOptions).Informer()
+	if i.watchErrorHandler != nil {
+		if err := informer.SetWatchErrorHandler(i.watchErrorHandler); err != nil {
+			return err
+		}
+	}
+	if i.transform != nil {
+		if err := informer.SetTransform(i.transform); err != nil {
+			return err
+		}
+	}
+	for _, registration := range i.registrations {
+		if err := registration.add(cluster, informer); err != nil {
+			return err
+		}
+	}
+	i.clusters = append(i.clusters, cluster)
+	i.informers[cluster] = informer
+	if i.stopCh != nil {
+		i.run(informer)
+	}
+	return nil
+}
+
+// run runs the informer of a cluster until the stopCh is closed, the lock must be held by the caller
+func (i *multiClusterInformer) run(informer cache.SharedIndexInformer) {
+	i.wg.Add(1)
+	go func(stopCh <-chan struct{}) {
+		defer i.wg.Done()
+		informer.Run(stopCh)
+	}(i.stopCh)
+}
+
+// clusterInformers returns the informers of the clusters in the order they're added
+func (i *multiClusterInformer) clusterInformers() ([]string, []cache.SharedIndexInformer) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	informers := make([]cache.SharedIndexInformer, 0, len(i.clusters))
+	for _, cluster := range i.clusters {
+		informers = append(informers, i.informers[cluster])
+	}
+	return append([]string{}, i.clusters...), informers
+}
+
+// clusterInformer returns the informer of the cluster
+func (i *multiClusterInformer) clusterInformer(cluster string) (cache.SharedIndexInformer, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	informer, ok := i.informers[cluster]
+	return informer, ok
+}
+
+func (i *multiClusterInformer) Informer() cache.SharedIndexInformer {
+	return i
+}
+
+// Lister lists the objects of all the clusters, the key of an object is in the format of <cluster>#<namespace>/<name>
+func (i *multiClusterInformer) Lister() cache.GenericLister {
+	return i.converter.lister(i.store)
+}
+
+func (i *multiClusterInformer) AddEventHandler(handler cache.ResourceEventHandler,
+) (cache.ResourceEventHandlerRegistration, error) {
+	return i.AddEventHandlerWithResyncPeriod(handler, 0)
+}
+
+// AddEventHandlerWithResyncPeriod adds the handler to the informer of each cluster, including the ones added later
+func (i *multiClusterInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler,
+	resyncPeriod time.Duration,
+) (cache.ResourceEventHandlerRegistration, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	registration := &multiClusterRegistration{
+		handler:       handler,
+		resyncPeriod:  resyncPeriod,
+		registrations: map[string]cache.ResourceEventHandlerRegistration{},
+	}
+	for _, cluster := range i.clusters {
+		if err := registration.add(cluster, i.informers[cluster]); err != nil {
+			return nil, err
+		}
+	}
+	i.registrations = append(i.registrations, registration)
+	return registration, nil
+}
+
+func (i *multiClusterInformer) RemoveEventHandler(handle cache.ResourceEventHandlerRegistration) error {
+	registration, ok := handle.(*multiClusterRegistration)
+	if !ok {
+		return fmt.Errorf("the registration %v isn't added to the multi-cluster informer", handle)
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for index, r := range i.registrations {
+		if r == registration {
+			i.registrations = append(i.registrations[:index], i.registrations[index+1:]...)
+			break
+		}
+	}
+	for cluster, r := range registration.list() {
+		if err := i.informers[cluster].RemoveEventHandler(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *multiClusterInformer) GetStore() cache.Store {
+	return i.store
+}
+
+func (i *multiClusterInformer) GetIndexer() cache.Indexer {
+	return i.store
+}
+
+// AddIndexers adds the indexers to the informers of the clusters, including the ones added later
+func (i *multiClusterInformer) AddIndexers(indexers cache.Indexers) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for _, cluster := range i.clusters {
+		if err := i.informers[cluster].AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	for name, indexFunc := range indexers {
+		i.indexers[name] = indexFunc
+	}
+	return nil
+}
+
+// GetController returns the informer itself, which runs the informers of the clusters
+func (i *multiClusterInformer) GetController() cache.Controller {
+	return i
+}
+
+// Run runs the informers of the clusters until the stopCh is closed, including the ones added later
+func (i *multiClusterInformer) Run(stopCh <-chan struct{}) {
+	i.mutex.Lock()
+	if i.stopCh != nil {
+		i.mutex.Unlock()
+		klog.Warning("the multi-cluster informer is running already")
+		return
+	}
+	i.stopCh = stopCh
+	for _, cluster := range i.clusters {
+		i.run(i.informers[cluster])
+	}
+	i.mutex.Unlock()
+
+	<-stopCh
+	i.wg.Wait()
+}
+
+// HasSynced returns true once the informers of all the clusters are synced
+func (i *multiClusterInformer) HasSynced() bool {
+	_, informers := i.clusterInformers()
+	for _, informer := range informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// LastSyncResourceVersion is always empty, since the resourceVersions of the clusters aren't comparable
+func (i *multiClusterInformer) LastSyncResourceVersion() string {
+	return ""
+}
+
+func (i *multiClusterInformer) SetWatchErrorHandler(handler cache.WatchErrorHandler) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for _, cluster := range i.clusters {
+		if err := i.informers[cluster].SetWatchErrorHandler(handler); err != nil {
+			return err
+		}
+	}
+	i.watchErrorHandler = handler
+	return nil
+}
+
+func (i *multiClusterInformer) SetTransform(handler cache.TransformFunc) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for _, cluster := range i.clusters {
+		if err := i.informers[cluster].SetTransform(handler); err != nil {
+			return err
+		}
+	}
+	i.transform = handler
+	return nil
+}
+
+func (i *multiClusterInformer) IsStopped() bool {
+	_, informers := i.clusterInformers()
+	for _, informer := range informers {
+		if !informer.IsStopped() {
+			return false
+		}
+	}
+	return true
+}
+
+// multiClusterRegistration is the registrations of a handler to the informers of the clusters
+type multiClusterRegistration struct {
+	handler      cache.ResourceEventHandler
+	resyncPeriod time.Duration
+
+	mutex         sync.Mutex
+	registrations map[string]cache.ResourceEventHandlerRegistration
+}
+
+// add adds the handler to the informer of the cluster
+func (r *multiClusterRegistration) add(cluster string, informer cache.SharedIndexInformer) error {
+	registration, err := informer.AddEventHandlerWithResyncPeriod(r.handler, r.resyncPeriod)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.registrations[cluster] = registration
+	return nil
+}
+
+func (r *multiClusterRegistration) list() map[string]cache.ResourceEventHandlerRegistration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	registrations := make(map[string]cache.ResourceEventHandlerRegistration, len(r.registrations))
+	for cluster, registration := range r.registrations {
+		registrations[cluster] = registration
+	}
+	return registrations
+}
+
+func (r *multiClusterRegistration) HasSynced() bool {
+	for _, registration := range r.list() {
+		if !registration.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// multiClusterStore is the read-only view of the stores of the clusters, its keys are in the format of
+// <cluster>#<namespace>/<name> or <cluster>#<name>
+type multiClusterStore struct {
+	informer *multiClusterInformer
+}
+
+func (s *multiClusterStore) Add(obj interface{}) error {
+	return fmt.Errorf("the multi-cluster store is read-only")
+}
+
+func (s *multiClusterStore) Update(obj interface{}) error {
+	return fmt.Errorf("the multi-cluster store is read-only")
+}
+
+func (s *multiClusterStore) Delete(obj interface{}) error {
+	return fmt.Errorf("the multi-cluster store is read-only")
+}
+
+func (s *multiClusterStore) Replace(list []interface{}, resourceVersion string) error {
+	return fmt.Errorf("the multi-cluster store is read-only")
+}
+
+func (s *multiClusterStore) Resync() error {
+	return nil
+}
+
+func (s *multiClusterStore) List() []interface{} {
+	objs := []interface{}{}
+	_, informers := s.informer.clusterInformers()
+	for _, informer := range informers {
+		objs = append(objs, informer.GetIndexer().List()...)
+	}
+	return objs
+}
+
+func (s *multiClusterStore) ListKeys() []string {
+	keys := []string{}
+	clusters, informers := s.informer.clusterInformers()
+	for index, informer := range informers {
+		keys = append(keys, clusterKeys(clusters[index], informer.GetIndexer().ListKeys())...)
+	}
+	return keys
+}
+
+// Get returns the object of the cluster labeled on the obj
+func (s *multiClusterStore) Get(obj interface{}) (interface{}, bool, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	informer, ok := s.informer.clusterInformer(accessor.GetLabels()[utils.ClusterLabelKey])
+	if !ok {
+		return nil, false, nil
+	}
+	return informer.GetIndexer().Get(obj)
+}
+
+func (s *multiClusterStore) GetByKey(key string) (interface{}, bool, error) {
+	cluster, key, found := strings.Cut(key, clusterKeySeparator)
+	if !found {
+		return nil, false, fmt.Errorf("the key %s has no cluster", key)
+	}
+	informer, ok := s.informer.clusterInformer(cluster)
+	if !ok {
+		return nil, false, nil
+	}
+	return informer.GetIndexer().GetByKey(key)
+}
+
+func (s *multiClusterStore) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	objs := []interface{}{}
+	_, informers := s.informer.clusterInformers()
+	for _, informer := range informers {
+		items, err := informer.GetIndexer().Index(indexName, obj)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, items...)
+	}
+	return objs, nil
+}
+
+func (s *multiClusterStore) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	keys := []string{}
+	clusters, informers := s.informer.clusterInformers()
+	for index, informer := range informers {
+		items, err := informer.GetIndexer().IndexKeys(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, clusterKeys(clusters[index], items)...)
+	}
+	return keys, nil
+}
+
+func (s *multiClusterStore) ListIndexFuncValues(indexName string) []string {
+	values := map[string]bool{}
+	_, informers := s.informer.clusterInformers()
+	for _, informer := range informers {
+		for _, value := range informer.GetIndexer().ListIndexFuncValues(indexName) {
+			values[value] = true
+		}
+	}
+	res := make([]string, 0, len(values))
+	for value := range values {
+		res = append(res, value)
+	}
+	return res
+}
+
+func (s *multiClusterStore) ByIndex(indexName, indexedValue string) ([]interface{}, error) {
+	objs := []interface{}{}
+	_, informers := s.informer.clusterInformers()
+	for _, informer := range informers {
+		items, err := informer.GetIndexer().ByIndex(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, items...)
+	}
+	return objs, nil
+}
+
+// GetIndexers returns the indexers of the informers of the clusters
+func (s *multiClusterStore) GetIndexers() cache.Indexers {
+	s.informer.mutex.RLock()
+	defer s.informer.mutex.RUnlock()
+	indexers := cache.Indexers{}
+	for name, indexFunc := range s.informer.indexers {
+		indexers[name] = indexFunc
+	}
+	return indexers
+}
+
+func (s *multiClusterStore) AddIndexers(indexers cache.Indexers) error {
+	return s.informer.AddIndexers(indexers)
+}
+
+func clusterKeys(cluster string, keys []string) []string {
+	res := make([]string, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, cluster+clusterKeySeparator+key)
+	}
+	return res
+}
@@ -0,0 +1,108 @@
+package informer
+
+import (
+	"github.com/yanmxa/straw/pkg/apis"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata/metadatalister"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// ObjectMode decides the type of the objects cached by an informer
+type ObjectMode int
+
+const (
+	// MetadataObject caches the metadata of the objects as the *metav1.PartialObjectMetadata
+	MetadataObject ObjectMode = iota
+	// UnstructuredObject caches the full objects as the *unstructured.Unstructured
+	UnstructuredObject
+	// TypedObject caches the full objects as the typed ones created by the RegisterObjectFunc of the resource, it
+	// falls back to the UnstructuredObject if nothing is registered
+	TypedObject
+)
+
+func (m ObjectMode) String() string {
+	switch m {
+	case UnstructuredObject:
+		return "unstructured"
+	case TypedObject:
+		return "typed"
+	default:
+		return "metadata"
+	}
+}
+
+// objectConverter converts the objects received from the transport into the type of the mode
+type objectConverter struct {
+	gvr  schema.GroupVersionResource
+	mode ObjectMode
+}
+
+func newObjectConverter(gvr schema.GroupVersionResource, mode ObjectMode) *objectConverter {
+	if mode == TypedObject && GetObject(apis.ToGVRString(gvr)) == nil {
+		klog.Warningf("no object is registered for %s, the unstructured objects are cached", apis.ToGVRString(gvr))
+		mode = UnstructuredObject
+	}
+	return &objectConverter{gvr: gvr, mode: mode}
+}
+
+// objectType returns the expected type of the objects cached by the informer
+func (c *objectConverter) objectType() runtime.Object {
+	switch c.mode {
+	case UnstructuredObject:
+		return &unstructured.Unstructured{}
+	case TypedObject:
+		return GetObject(apis.ToGVRString(c.gvr))
+	default:
+		return &metav1.PartialObjectMetadata{}
+	}
+}
+
+// lister returns the lister of the objects cached in the indexer
+func (c *objectConverter) lister(indexer cache.Indexer) cache.GenericLister {
+	if c.mode == MetadataObject {
+		return metadatalister.NewRuntimeObjectShim(metadatalister.New(indexer, c.gvr))
+	}
+	return cache.NewGenericLister(indexer, c.gvr.GroupResource())
+}
+
+func (c *objectConverter) convert(obj *unstructured.Unstructured) (runtime.Object, error) {
+	switch c.mode {
+	case UnstructuredObject:
+		return obj, nil
+	case TypedObject:
+		typedObj := GetObject(apis.ToGVRString(c.gvr))
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typedObj); err != nil {
+			return nil, err
+		}
+		return typedObj, nil
+	default:
+		return convertToPartialObjectMetadata(obj)
+	}
+}
+
+// convertList keeps the listed objects in the same type with the watched ones
+func (c *objectConverter) convertList(list *unstructured.UnstructuredList) (runtime.Object, error) {
+	switch c.mode {
+	case UnstructuredObject:
+		return list, nil
+	case TypedObject:
+		// the typed objects are carried by the raw extensions, which can be extracted by the reflector
+		typedList := &metav1.List{}
+		typedList.SetResourceVersion(list.GetResourceVersion())
+		typedList.SetContinue(list.GetContinue())
+		for i := range list.Items {
+			obj, err := c.convert(&list.Items[i])
+			if err != nil {
+				return nil, err
+			}
+			typedList.Items = append(typedList.Items, runtime.RawExtension{Object: obj})
+		}
+		return typedList, nil
+	default:
+		return convertToPartialObjectMetadataList(list)
+	}
+}
@@ -19,10 +19,10 @@ type eventSharedInformerFactory struct {
 	namespace     string
 
 	lock      sync.Mutex
-	informers map[schema.GroupVersionResource]informers.GenericInformer
+	informers map[informerKey]informers.GenericInformer
 	// startedInformers is used for tracking which informers have been started.
 	// This allows Start() to be called multiple times safely.
-	startedInformers map[schema.GroupVersionResource]bool
+	startedInformers map[informerKey]bool
 	tweakListOptions TweakListOptionsFunc
 	// wg tracks how many goroutines were started.
 	wg sync.WaitGroup
@@ -49,23 +49,29 @@ func NewFilteredEventSharedInformerFactory(ctx context.Context, t cloudevents.Cl
 		ctx:              ctx,
 		defaultResync:    defaultResync,
 		namespace:        namespace,
-		informers:        map[schema.GroupVersionResource]informers.GenericInformer{},
-		startedInformers: make(map[schema.GroupVersionResource]bool),
+		informers:        map[informerKey]informers.GenericInformer{},
+		startedInformers: make(map[informerKey]bool),
 		tweakListOptions: tweakListOptions,
 		transporter:      t,
 	}
 }
 
+// ForResource gives the informer caching the metadata of the objects
 func (f *eventSharedInformerFactory) ForResource(gvr schema.GroupVersionResource) informers.GenericInformer {
+	return f.ForResourceWithMode(gvr, MetadataObject)
+}
+
+func (f *eventSharedInformerFactory) ForResourceWithMode(gvr schema.GroupVersionResource, mode ObjectMode,
+) informers.GenericInformer {
 	f.lock.Lock()
 	defer f.lock.Unlock()
-	key := gvr
+	key := informerKey{gvr: gvr, mode: mode}
 	informer, exists := f.informers[key]
 	if exists {
 		return informer
 	}
 
-	informer = NewFilteredEventInformer(f.ctx, f.transporter, gvr, f.namespace, f.defaultResync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+	informer = NewFilteredEventInformer(f.ctx, f.transporter, gvr, f.namespace, f.defaultResync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions, mode)
 	f.informers[key] = informer
 
 	return informer
@@ -86,11 +92,11 @@ func (f *eventSharedInformerFactory) Start() {
 
 // WaitForCacheSync waits for all started informers' cache were synced.
 func (f *eventSharedInformerFactory) WaitForCacheSync(stopCh <-chan struct{}) map[schema.GroupVersionResource]bool {
-	informers := func() map[schema.GroupVersionResource]cache.SharedIndexInformer {
+	informers := func() map[informerKey]cache.SharedIndexInformer {
 		f.lock.Lock()
 		defer f.lock.Unlock()
 
-		informers := map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+		informers := map[informerKey]cache.SharedIndexInformer{}
 		for informerType, informer := range f.informers {
 			if f.startedInformers[informerType] {
 				informers[informerType] = informer.Informer()
@@ -99,19 +105,24 @@ func (f *eventSharedInformerFactory) WaitForCacheSync(stopCh <-chan struct{}) ma
 		return informers
 	}()
 
+	// the resource is synced only if all its informers are synced
 	res := map[schema.GroupVersionResource]bool{}
 	for informType, informer := range informers {
-		res[informType] = cache.WaitForCacheSync(stopCh, informer.HasSynced)
+		synced := cache.WaitForCacheSync(stopCh, informer.HasSynced)
+		if s, ok := res[informType.gvr]; ok {
+			synced = synced && s
+		}
+		res[informType.gvr] = synced
 	}
 	return res
 }
 
 func (f *eventSharedInformerFactory) ListAll() {
-	informers := func() map[schema.GroupVersionResource]cache.SharedIndexInformer {
+	informers := func() map[informerKey]cache.SharedIndexInformer {
 		f.lock.Lock()
 		defer f.lock.Unlock()
 
-		informers := map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+		informers := map[informerKey]cache.SharedIndexInformer{}
 		for informerType, informer := range f.informers {
 			if f.startedInformers[informerType] {
 				informers[informerType] = informer.Informer()
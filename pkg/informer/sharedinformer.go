@@ -9,19 +9,22 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
 )
 
-var _ SharedInformerFactory = &messageSharedInformerFactory{}
+var _ MultiClusterSharedInformerFactory = &messageSharedInformerFactory{}
 
 type messageSharedInformerFactory struct {
 	defaultResync time.Duration
 	namespace     string
 
 	lock      sync.Mutex
-	informers map[schema.GroupVersionResource]informers.GenericInformer
+	informers map[informerKey]informers.GenericInformer
+	// listWatchers are shared by the informers of the same resource in the modes, keyed by the gvr and cluster
+	listWatchers map[listWatcherKey]*MessageListWatcher
 	// startedInformers is used for tracking which informers have been started.
 	// This allows Start() to be called multiple times safely.
-	startedInformers map[schema.GroupVersionResource]bool
+	startedInformers map[informerKey]bool
 	tweakListOptions TweakListOptionsFunc
 	// wg tracks how many goroutines were started.
 	wg sync.WaitGroup
@@ -34,6 +37,10 @@ type messageSharedInformerFactory struct {
 	transporter  transport.Transport
 	sendTopic    string
 	receiveTopic string
+	// multiCluster fans the requests out to the clusters by the {cluster} of the sendTopic, the clusters can be added
+	// once the informers are running
+	multiCluster bool
+	clusters     []string
 }
 
 // NewSharedMessageInformerFactory constructs a new instance of metadataSharedInformerFactory for all namespaces.
@@ -41,6 +48,23 @@ func NewSharedMessageInformerFactory(ctx context.Context, t transport.Transport,
 	return NewFilteredSharedInformerFactory(ctx, t, defaultResync, namespace, tweakOptions, send, receive)
 }
 
+// NewSharedMultiClusterMessageInformerFactory constructs a new instance of metadataSharedInformerFactory, whose
+// informers list and watch the resources from the provider of each cluster by the NewClusterMessageListWatcher. The
+// objects are labeled with the cluster, and the keys of the stores are in the format of <cluster>#<namespace>/<name>.
+// The clusters besides the initial ones are added by the AddCluster, e.g. once they're registered by the heartbeats.
+func NewSharedMultiClusterMessageInformerFactory(ctx context.Context, t transport.Transport,
+	defaultResync time.Duration, send, receive string, clusters []string, namespace string,
+	tweakOptions TweakListOptionsFunc,
+) MultiClusterSharedInformerFactory {
+	f := NewFilteredSharedInformerFactory(ctx, t, defaultResync, namespace, tweakOptions, send,
+		receive).(*messageSharedInformerFactory)
+	f.multiCluster = true
+	for _, cluster := range clusters {
+		f.AddCluster(cluster)
+	}
+	return f
+}
+
 // NewFilteredSharedInformerFactory constructs a new instance of metadataSharedInformerFactory.
 // Listers obtained via this factory will be subject to the same filters as specified here.
 func NewFilteredSharedInformerFactory(ctx context.Context, t transport.Transport, defaultResync time.Duration, namespace string, tweakListOptions TweakListOptionsFunc, send, receive string) SharedInformerFactory {
@@ -48,8 +72,9 @@ func NewFilteredSharedInformerFactory(ctx context.Context, t transport.Transport
 		ctx:              ctx,
 		defaultResync:    defaultResync,
 		namespace:        namespace,
-		informers:        map[schema.GroupVersionResource]informers.GenericInformer{},
-		startedInformers: make(map[schema.GroupVersionResource]bool),
+		informers:        map[informerKey]informers.GenericInformer{},
+		listWatchers:     map[listWatcherKey]*MessageListWatcher{},
+		startedInformers: make(map[informerKey]bool),
 		tweakListOptions: tweakListOptions,
 		transporter:      t,
 		sendTopic:        send,
@@ -57,21 +82,83 @@ func NewFilteredSharedInformerFactory(ctx context.Context, t transport.Transport
 	}
 }
 
+// ForResource gives the informer caching the metadata of the objects
 func (f *messageSharedInformerFactory) ForResource(gvr schema.GroupVersionResource) informers.GenericInformer {
+	return f.ForResourceWithMode(gvr, MetadataObject)
+}
+
+func (f *messageSharedInformerFactory) ForResourceWithMode(gvr schema.GroupVersionResource, mode ObjectMode,
+) informers.GenericInformer {
 	f.lock.Lock()
 	defer f.lock.Unlock()
-	key := gvr
+	key := informerKey{gvr: gvr, mode: mode}
 	informer, exists := f.informers[key]
 	if exists {
 		return informer
 	}
 
-	informer = NewFilteredMetadataInformer(f.ctx, f.transporter, gvr, f.namespace, f.defaultResync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions, f.sendTopic, f.receiveTopic)
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	converter := newObjectConverter(gvr, mode)
+	if f.multiCluster {
+		informer = newMultiClusterInformer(func(cluster string) *MessageListWatcher {
+			return f.listWatcher(gvr, cluster)
+		}, converter, f.defaultResync, indexers, f.tweakListOptions, f.clusters)
+	} else {
+		informer = newMessageInformer(f.listWatcher(gvr, ""), converter, f.defaultResync, indexers,
+			f.tweakListOptions)
+	}
 	f.informers[key] = informer
 
 	return informer
 }
 
+// AddCluster lists and watches the resources of the informers from the cluster as well, the informers running already
+// start caching the cluster right away. It's a no-op for the cluster added already.
+func (f *messageSharedInformerFactory) AddCluster(cluster string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.multiCluster {
+		klog.Errorf("the cluster %s can't be added to the factory of a single cluster", cluster)
+		return
+	}
+	for _, c := range f.clusters {
+		if c == cluster {
+			return
+		}
+	}
+	klog.Infof("informers start caching the resources of cluster %s", cluster)
+	f.clusters = append(f.clusters, cluster)
+	for key, informer := range f.informers {
+		if err := informer.(*multiClusterInformer).addCluster(cluster); err != nil {
+			klog.Errorf("failed to cache %s of cluster %s: %v", key.gvr, cluster, err)
+		}
+	}
+}
+
+type listWatcherKey struct {
+	gvr     schema.GroupVersionResource
+	cluster string
+}
+
+// listWatcher returns the list-watcher of the resource from the provider of the cluster, so that there is a single
+// receiver of the topic for the informers of the resource in the modes. The lock must be held by the caller.
+func (f *messageSharedInformerFactory) listWatcher(gvr schema.GroupVersionResource, cluster string,
+) *MessageListWatcher {
+	key := listWatcherKey{gvr: gvr, cluster: cluster}
+	if lw, ok := f.listWatchers[key]; ok {
+		return lw
+	}
+	var lw *MessageListWatcher
+	if cluster == "" {
+		lw = NewMessageListWatcher(f.ctx, f.transporter, f.namespace, gvr, f.sendTopic, f.receiveTopic)
+	} else {
+		lw = NewClusterMessageListWatcher(f.ctx, f.transporter, f.namespace, gvr, f.sendTopic, f.receiveTopic,
+			cluster)
+	}
+	f.listWatchers[key] = lw
+	return lw
+}
+
 // Start initializes all requested informers.
 func (f *messageSharedInformerFactory) Start() {
 	f.lock.Lock()
@@ -87,11 +174,11 @@ func (f *messageSharedInformerFactory) Start() {
 
 // WaitForCacheSync waits for all started informers' cache were synced.
 func (f *messageSharedInformerFactory) WaitForCacheSync(stopCh <-chan struct{}) map[schema.GroupVersionResource]bool {
-	informers := func() map[schema.GroupVersionResource]cache.SharedIndexInformer {
+	informers := func() map[informerKey]cache.SharedIndexInformer {
 		f.lock.Lock()
 		defer f.lock.Unlock()
 
-		informers := map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+		informers := map[informerKey]cache.SharedIndexInformer{}
 		for informerType, informer := range f.informers {
 			if f.startedInformers[informerType] {
 				informers[informerType] = informer.Informer()
@@ -100,19 +187,24 @@ func (f *messageSharedInformerFactory) WaitForCacheSync(stopCh <-chan struct{})
 		return informers
 	}()
 
+	// the resource is synced only if all its informers are synced
 	res := map[schema.GroupVersionResource]bool{}
 	for informType, informer := range informers {
-		res[informType] = cache.WaitForCacheSync(stopCh, informer.HasSynced)
+		synced := cache.WaitForCacheSync(stopCh, informer.HasSynced)
+		if s, ok := res[informType.gvr]; ok {
+			synced = synced && s
+		}
+		res[informType.gvr] = synced
 	}
 	return res
 }
 
 func (f *messageSharedInformerFactory) ListAll() {
-	informers := func() map[schema.GroupVersionResource]cache.SharedIndexInformer {
+	informers := func() map[informerKey]cache.SharedIndexInformer {
 		f.lock.Lock()
 		defer f.lock.Unlock()
 
-		informers := map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+		informers := map[informerKey]cache.SharedIndexInformer{}
 		for informerType, informer := range f.informers {
 			if f.startedInformers[informerType] {
 				informers[informerType] = informer.Informer()
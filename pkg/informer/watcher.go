@@ -2,8 +2,10 @@ package informer
 
 import (
 	"encoding/json"
+	"sync"
 
 	"github.com/yanmxa/straw/pkg/apis"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -17,14 +19,24 @@ type messageWatcher struct {
 	gvr              schema.GroupVersionResource
 	result           chan watch.Event
 	externalStopFunc func()
+	converter        *objectConverter
+
+	// the result chan is closed by the Stop, the lock prevents process from sending to the closed chan
+	mutex   sync.Mutex
+	stopped bool
+	done    chan struct{}
 }
 
-func newMessageWatcher(uid types.UID, externalStopFunc func(), gvr schema.GroupVersionResource, chanSize int) *messageWatcher {
+func newMessageWatcher(uid types.UID, externalStopFunc func(), gvr schema.GroupVersionResource,
+	converter *objectConverter, chanSize int,
+) *messageWatcher {
 	return &messageWatcher{
 		uid:              uid,
 		gvr:              gvr,
+		converter:        converter,
 		result:           make(chan watch.Event, chanSize),
 		externalStopFunc: externalStopFunc,
+		done:             make(chan struct{}),
 	}
 }
 
@@ -33,7 +45,12 @@ func (w *messageWatcher) ResultChan() <-chan watch.Event {
 }
 
 func (w *messageWatcher) Stop() {
+	// unblock the pending process before taking the lock
+	close(w.done)
+	w.mutex.Lock()
+	w.stopped = true
 	close(w.result)
+	w.mutex.Unlock()
 	if w.externalStopFunc != nil {
 		// klog.Info("stop watch message from transport ", w.gvr)
 		w.externalStopFunc()
@@ -61,20 +78,43 @@ func (w *messageWatcher) process(transportMsg apis.TransportMessage) error {
 	// 	return err
 	// }
 	// fmt.Println(string(watchRes))
-	partialObj, err := convertToPartialObjectMetadata(watchResponse.Object)
+	var obj runtime.Object
+	if watchResponse.Type == watch.Error {
+		// the reflector relists or rewatches by the Status
+		obj, err = convertToStatus(watchResponse.Object)
+	} else {
+		obj, err = w.converter.convert(watchResponse.Object)
+	}
 	if err != nil {
 		return err
 	}
 
 	watchEvent := &watch.Event{
 		Type:   watchResponse.Type,
-		Object: partialObj,
+		Object: obj,
 	}
 	// klog.Infof("send watch event(%s/%s): %s", partialObj.Namespace, partialObj.Name, watchEvent.Type)
-	w.result <- *watchEvent
+	w.send(*watchEvent)
 	return nil
 }
 
+// expire ends the watcher with the 410 Expired error, so that the reflector relists the resources
+func (w *messageWatcher) expire(message string) {
+	w.send(watch.Event{Type: watch.Error, Object: &apierrors.NewResourceExpired(message).ErrStatus})
+}
+
+func (w *messageWatcher) send(event watch.Event) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped {
+		return
+	}
+	select {
+	case w.result <- event:
+	case <-w.done:
+	}
+}
+
 func convertToPartialObjectMetadata(obj *unstructured.Unstructured) (*v1.PartialObjectMetadata, error) {
 	partialObj := &v1.PartialObjectMetadata{}
 	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, partialObj)
@@ -83,3 +123,26 @@ func convertToPartialObjectMetadata(obj *unstructured.Unstructured) (*v1.Partial
 	}
 	return partialObj, nil
 }
+
+func convertToStatus(obj *unstructured.Unstructured) (*v1.Status, error) {
+	status := &v1.Status{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func convertToPartialObjectMetadataList(list *unstructured.UnstructuredList) (*v1.PartialObjectMetadataList, error) {
+	partialList := &v1.PartialObjectMetadataList{}
+	partialList.SetResourceVersion(list.GetResourceVersion())
+	partialList.SetContinue(list.GetContinue())
+	for i := range list.Items {
+		partialObj, err := convertToPartialObjectMetadata(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		partialList.Items = append(partialList.Items, *partialObj)
+	}
+	return partialList, nil
+}
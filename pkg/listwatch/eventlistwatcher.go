@@ -8,7 +8,7 @@ import (
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/google/uuid"
 	"github.com/yanmxa/straw/pkg/apis"
-	"github.com/yanmxa/straw/pkg/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -22,56 +22,100 @@ import (
 
 var _ cache.ListerWatcher = (*eventListWatcher)(nil)
 
+// EventListWatcher is the ListerWatcher whose response events are received by the caller, so that the receiver of the
+// cloudevents client can be shared by the ListerWatchers of several clusters.
+type EventListWatcher interface {
+	cache.ListerWatcher
+	// Handle passes the response event to the pending list or the watcher
+	Handle(event cloudevents.Event) error
+}
+
 type eventListWatcher struct {
 	ctx            context.Context
 	gvr            schema.GroupVersionResource
 	namespace      string
 	source         string
+	cluster        string
 	watcher        EventWatcher
-	listResultChan map[types.UID]chan apis.ListResponseEvent
+	listResultChan map[types.UID]*listResult
 	rwlock         sync.RWMutex
 
 	transporter cloudevents.Client
 }
 
+// listResult receives the responses of a pending list, the done is closed once the list returns
+type listResult struct {
+	responses chan apis.ListResponseEvent
+	done      chan struct{}
+}
+
+// NewEventListWatcher lists and watches the resource from the providers by the cloudevents client, it receives the
+// response events from the client.
 func NewEventListWatcher(ctx context.Context, t cloudevents.Client, namespace string,
 	gvr schema.GroupVersionResource, source string,
 ) cache.ListerWatcher {
-	lw := &eventListWatcher{
+	lw := newEventListWatcher(ctx, t, namespace, gvr, source, "")
+	go t.StartReceiver(ctx, lw.Handle)
+	return lw
+}
+
+// NewClusterEventListWatcher lists and watches the resource only from the provider of the cluster, which is the
+// subject of the request events. The response events(their source is the cluster) are passed to the Handle by the
+// caller.
+func NewClusterEventListWatcher(ctx context.Context, t cloudevents.Client, namespace string,
+	gvr schema.GroupVersionResource, source, cluster string,
+) EventListWatcher {
+	return newEventListWatcher(ctx, t, namespace, gvr, source, cluster)
+}
+
+func newEventListWatcher(ctx context.Context, t cloudevents.Client, namespace string,
+	gvr schema.GroupVersionResource, source, cluster string,
+) *eventListWatcher {
+	return &eventListWatcher{
 		ctx:            ctx,
 		gvr:            gvr,
 		namespace:      namespace,
 		source:         source,
-		listResultChan: map[types.UID]chan apis.ListResponseEvent{},
+		cluster:        cluster,
+		listResultChan: map[types.UID]*listResult{},
 		transporter:    t,
 	}
+}
 
-	go t.StartReceiver(ctx, func(event cloudevents.Event) error {
-		lw.rwlock.RLock()
-		defer lw.rwlock.RUnlock()
-		klog.Infof("received response event %s", event.Type())
-		switch event.Type() {
-		case apis.EventListResponseType(gvr):
-			resultChan, ok := lw.listResultChan[types.UID(event.ID())]
-			if !ok {
-				return fmt.Errorf("unable to find the related uid for list %s", event.ID())
-			}
-			response := &apis.ListResponseEvent{}
-			err := event.DataAs(response)
-			if err != nil {
-				return err
-			}
-			resultChan <- *response
-		case apis.EventWatchResponseType(gvr):
-			if lw.watcher == nil {
-				return fmt.Errorf("unable to find the watcher for gvr %s", gvr)
-			}
-			return lw.watcher.Add(event)
-		}
+func (e *eventListWatcher) Handle(event cloudevents.Event) error {
+	if e.cluster != "" && event.Source() != e.cluster {
 		return nil
-	})
-
-	return lw
+	}
+	klog.Infof("received response event %s", event.Type())
+	switch event.Type() {
+	case apis.EventListResponseType(e.gvr):
+		e.rwlock.RLock()
+		result, ok := e.listResultChan[types.UID(event.ID())]
+		e.rwlock.RUnlock()
+		if !ok {
+			return fmt.Errorf("unable to find the related uid for list %s", event.ID())
+		}
+		response := &apis.ListResponseEvent{}
+		err := event.DataAs(response)
+		if err != nil {
+			return err
+		}
+		// the list might return before receiving the response, e.g. it fails on an error response
+		select {
+		case result.responses <- *response:
+		case <-result.done:
+		case <-e.ctx.Done():
+		}
+	case apis.EventWatchResponseType(e.gvr):
+		e.rwlock.RLock()
+		watcher := e.watcher
+		e.rwlock.RUnlock()
+		if watcher == nil {
+			return fmt.Errorf("unable to find the watcher for gvr %s", e.gvr)
+		}
+		return watcher.Add(event)
+	}
+	return nil
 }
 
 func (e *eventListWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
@@ -80,30 +124,38 @@ func (e *eventListWatcher) List(options metav1.ListOptions) (runtime.Object, err
 	if err != nil {
 		return nil, err
 	}
-	result := e.transporter.Send(e.ctx, listRequestEvent)
-	if cloudevents.IsUndelivered(result) {
-		return nil, fmt.Errorf("failed to send list event, %v", result)
+	// the result channel is ready before the request, so that the early responses aren't dropped
+	result := &listResult{responses: make(chan apis.ListResponseEvent), done: make(chan struct{})}
+	e.rwlock.Lock()
+	e.listResultChan[types.UID(sessionId)] = result
+	e.rwlock.Unlock()
+	defer func() {
+		e.rwlock.Lock()
+		delete(e.listResultChan, types.UID(sessionId))
+		e.rwlock.Unlock()
+		close(result.done)
+	}()
+
+	if sent := e.transporter.Send(e.ctx, listRequestEvent); cloudevents.IsUndelivered(sent) {
+		return nil, fmt.Errorf("failed to send list event, %v", sent)
 	}
 	klog.Infof("request to list event: %s", listRequestEvent.Type())
-	e.listResultChan[types.UID(sessionId)] = make(chan apis.ListResponseEvent)
-	defer delete(e.listResultChan, types.UID(sessionId))
 
 	objectList := &unstructured.UnstructuredList{}
-	// TODO
-	defer func() {
-		utils.PrettyPrint(objectList)
-	}()
 	for {
 		select {
-		case response, ok := <-e.listResultChan[types.UID(sessionId)]:
+		case response, ok := <-result.responses:
 			if !ok {
 				klog.Errorf("listResult chan(%s) is closed: %s", sessionId, listRequestEvent.Type())
 				return objectList, nil
 			}
 
-			if objectList.Object == nil {
-				objectList.Object = response.Objects.Object
+			// the list failed on the provider, so that the reflector backs off and relists
+			if response.Error != nil {
+				return nil, apierrors.FromObject(response.Error)
 			}
+			// the list metadata(resourceVersion and continue) of the last chunk is the one of the whole list
+			objectList.Object = response.Objects.Object
 
 			objectList.Items = append(objectList.Items, response.Objects.Items...)
 			if response.EndOfList {
@@ -121,13 +173,17 @@ func (e *eventListWatcher) Watch(options metav1.ListOptions) (watch.Interface, e
 	if err != nil {
 		return nil, err
 	}
+	watcher := newEventWatcher(types.UID(sessionId), e.gvr, 10, e.watcherStop)
+	e.rwlock.Lock()
+	e.watcher = watcher
+	e.rwlock.Unlock()
+
 	result := e.transporter.Send(e.ctx, watchRequestEvent)
 	if cloudevents.IsUndelivered(result) {
 		return nil, fmt.Errorf("failed to send watch event: %v", result)
 	}
 	klog.Infof("request to watch: %s", watchRequestEvent.Type())
-	e.watcher = newEventWatcher(types.UID(sessionId), e.gvr, 10, e.watcherStop)
-	return e.watcher, nil
+	return watcher, nil
 }
 
 func (e *eventListWatcher) watcherStop(watcherId string) {
@@ -149,6 +205,9 @@ func (e *eventListWatcher) toEvent(id string, eventType string, options metav1.L
 	event.SetID(id)
 	event.SetType(eventType)
 	event.SetSource(e.source)
+	if e.cluster != "" {
+		event.SetSubject(e.cluster)
+	}
 	data := &apis.RequestEvent{
 		Namespace: e.namespace,
 		Options:   options,
@@ -2,7 +2,7 @@ package listwatch
 
 import (
 	"fmt"
-	"reflect"
+	"sync"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/yanmxa/straw/pkg/apis"
@@ -24,6 +24,11 @@ type eventWatcher struct {
 	gvr             schema.GroupVersionResource
 	stop            func(id string)
 	watchResultChan chan watch.Event
+
+	// the result chan is closed by the Stop, the lock prevents Add from sending to the closed chan
+	mutex   sync.Mutex
+	stopped bool
+	done    chan struct{}
 }
 
 func newEventWatcher(uid types.UID, gvr schema.GroupVersionResource, chanSize int, stop func(id string)) EventWatcher {
@@ -32,6 +37,7 @@ func newEventWatcher(uid types.UID, gvr schema.GroupVersionResource, chanSize in
 		gvr:             gvr,
 		watchResultChan: make(chan watch.Event, chanSize),
 		stop:            stop,
+		done:            make(chan struct{}),
 	}
 }
 
@@ -40,13 +46,18 @@ func (w *eventWatcher) ResultChan() <-chan watch.Event {
 }
 
 func (w *eventWatcher) Stop() {
-	w.stop(string(w.uid))
+	// unblock the pending Add before taking the lock
+	close(w.done)
+	w.mutex.Lock()
+	w.stopped = true
 	close(w.watchResultChan)
+	w.mutex.Unlock()
+	w.stop(string(w.uid))
 }
 
 func (w *eventWatcher) Add(event cloudevents.Event) error {
 	if w.uid != types.UID(event.ID()) {
-		return fmt.Errorf("unable to find the related event uid(%s) for watcher(%s) %s", event.ID(), w.uid)
+		return fmt.Errorf("unable to find the related event uid(%s) for watcher(%s)", event.ID(), w.uid)
 	}
 
 	watchResponse := &apis.WatchResponseEvent{}
@@ -64,13 +75,14 @@ func (w *eventWatcher) Add(event cloudevents.Event) error {
 	// if err != nil {
 	// 	return err
 	// }
-	t := reflect.TypeOf(watchResponse.Object)
-	fmt.Println("received unstructured event type", t)
-
-	// utils.PrettyPrint(obj)
-	w.watchResultChan <- watch.Event{
-		Type:   watchResponse.Type,
-		Object: watchResponse.Object,
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped {
+		return nil
+	}
+	select {
+	case w.watchResultChan <- watch.Event{Type: watchResponse.Type, Object: watchResponse.Object}:
+	case <-w.done:
 	}
 	klog.Info("watcher add event: ", event.Type())
 	return nil
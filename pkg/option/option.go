@@ -1,20 +1,24 @@
 package option
 
 import (
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	goflag "flag"
 
 	flag "github.com/spf13/pflag"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type Options struct {
 	*TLSConfig
 	KubeConfig           string
 	Broker               string
-	QoS                  byte
 	ClientID             string
-	Retained             bool
 	ProviderSendTopic    string
 	ProviderReceiveTopic string
 	InformerSendTopic    string
@@ -22,6 +26,24 @@ type Options struct {
 	ClusterName          string
 	ReceiveTopic         string
 	SendTopic            string
+	ListenAddress        string
+	Resources            []string
+	StatusFeedbackConfig string
+	EnableManifestWork   bool
+	ListChunkSize        int64
+	WatchCacheCapacity   int
+	ReconnectMinBackoff  time.Duration
+	ReconnectMaxBackoff  time.Duration
+	HeartbeatInterval    time.Duration
+	LeaseDuration        time.Duration
+	TopicTemplate        string
+	Clusters             []string
+	HeartbeatTopic       string
+	StorePath            string
+	SQLDriver            string
+	SQLDataSource        string
+	// TransportOptions are the options bound to the flags of the transports, keyed by the name of the transport
+	TransportOptions map[string]interface{}
 }
 
 type TLSConfig struct {
@@ -31,18 +53,31 @@ type TLSConfig struct {
 	ClientKey  string
 }
 
+// TransportFlagsFunc adds the flags of a transport to the flag set, and returns the options bound to them
+type TransportFlagsFunc func(fs *flag.FlagSet) interface{}
+
+var transportFlags = map[string]TransportFlagsFunc{}
+
+// AddTransportFlags is invoked by the transport implementations on init to register their own flags, the options are
+// put into the Options.TransportOptions under the name after the flags are parsed.
+func AddTransportFlags(name string, addFlags TransportFlagsFunc) {
+	transportFlags[name] = addFlags
+}
+
 func ParseOptionFromFlag() *Options {
 	opt := &Options{
-		TLSConfig: &TLSConfig{},
+		TLSConfig:        &TLSConfig{},
+		TransportOptions: map[string]interface{}{},
 	}
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
 	flag.StringVarP(&opt.KubeConfig, "kubeconfig", "k", "", "the kubeconfig for apiserver")
-	flag.StringVarP(&opt.Broker, "broker", "b", "", "the MQTT server")
+	flag.StringVarP(&opt.Broker, "broker", "b", "",
+		"the URL of the broker, the scheme(mqtt, mqtts, kafka, mem, ...) selects the transport, mqtt is used without it")
 	flag.BoolVarP(&opt.EnableTLS, "tls", "", false, "whether to enable the TLS connection")
 	flag.StringVarP(&opt.CACert, "ca-crt", "", "", "the ca certificate path")
 	flag.StringVarP(&opt.ClientCert, "client-crt", "", "", "the client certificate path")
 	flag.StringVarP(&opt.ClientKey, "client-key", "", "", "the client key path")
-	flag.StringVarP(&opt.ClientID, "client-id", "", "sender", "the client id for the MQTT")
+	flag.StringVarP(&opt.ClientID, "client-id", "", "sender", "the client id for the transport")
 	flag.StringVarP(&opt.ProviderSendTopic, "provider-send", "", "", "the topic for provider send payload")
 	flag.StringVarP(&opt.ProviderReceiveTopic, "provider-receive", "", "", "the topic for provider receive payload")
 	flag.StringVarP(&opt.InformerSendTopic, "informer-send", "", "", "the topic for informer send payload")
@@ -50,12 +85,43 @@ func ParseOptionFromFlag() *Options {
 	flag.StringVarP(&opt.SendTopic, "send-topic", "", "", "the topic for send payload")
 	flag.StringVarP(&opt.ReceiveTopic, "receive-topic", "", "", "the topic for receive payload")
 	flag.StringVarP(&opt.ClusterName, "cluster", "", "hub", "the cluster where the syncer is running ")
-	QoS := flag.IntP("QoS", "q", 0,
-		"the level of reliability and assurance of message delivery between an MQTT client and broker")
-	flag.BoolVarP(&opt.Retained, "retained", "", false, "retain the MQTT message or not")
+	flag.StringVarP(&opt.ListenAddress, "listen-address", "", "127.0.0.1:2379", "the address the etcdshim serves on")
+	flag.StringSliceVarP(&opt.Resources, "resources", "", []string{"secrets.v1."},
+		"the resources(<resource>.<version>.<group>) synced from the transport")
+	flag.StringVarP(&opt.StatusFeedbackConfig, "status-feedback-config", "", "",
+		"the file declaring the JSONPath rules of the status fed back from the resources")
+	flag.BoolVarP(&opt.EnableManifestWork, "manifest-work", "", false,
+		"whether to deliver the ManifestWorks(manifestworks.v1alpha1.work.straw.io) and report their status")
+	flag.Int64VarP(&opt.ListChunkSize, "list-chunk-size", "", 500,
+		"the max number of objects within a list response message, 0 means the whole list in a single message")
+	flag.IntVarP(&opt.WatchCacheCapacity, "watch-cache-capacity", "", 100,
+		"the number of the recent events kept by the provider to resume the watchers, raise it on the spool sites "+
+			"so that the watchers are resumed across the gaps between the bundles")
+	flag.DurationVarP(&opt.ReconnectMinBackoff, "reconnect-min-backoff", "", time.Second,
+		"the initial delay to reconnect the broker, it's doubled for each failed attempt")
+	flag.DurationVarP(&opt.ReconnectMaxBackoff, "reconnect-max-backoff", "", 2*time.Minute,
+		"the max delay to reconnect the broker")
+	flag.DurationVarP(&opt.HeartbeatInterval, "heartbeat-interval", "", 10*time.Second,
+		"the interval the cluster renews its lease on the hub")
+	flag.DurationVarP(&opt.LeaseDuration, "lease-duration", "", 40*time.Second,
+		"the duration the hub keeps a cluster available without its heartbeat")
+	flag.StringVarP(&opt.TopicTemplate, "topic-template", "", "",
+		"the template(e.g. straw/{cluster}/{direction}/{gvr}) routing the messages of each cluster to their own topics, "+
+			"it overrides the provider and informer topics")
+	flag.StringSliceVarP(&opt.Clusters, "clusters", "", []string{},
+		"the clusters the informers of the hub list and watch from with the topic template, besides the ones "+
+			"registered by their heartbeats")
+	flag.StringVarP(&opt.StorePath, "store-path", "", "",
+		"the file persisting the objects reflected from the clusters, they're kept only in memory if it's empty")
+	flag.StringVarP(&opt.SQLDriver, "sql-driver", "", "sqlite",
+		"the database/sql driver of the --sql-dsn, sqlite or sqlite3")
+	flag.StringVarP(&opt.SQLDataSource, "sql-dsn", "", "",
+		"the data source name of the SQL database the objects reflected from the clusters are written into")
+	for name, addFlags := range transportFlags {
+		opt.TransportOptions[name] = addFlags(flag.CommandLine)
+	}
 
 	flag.Parse()
-	opt.QoS = byte(*QoS)
 	if opt.Broker == "" {
 		opt.Broker = os.Getenv("BROKER")
 	}
@@ -64,3 +130,41 @@ func ParseOptionFromFlag() *Options {
 	}
 	return opt
 }
+
+// RenderTopics overrides the provider and informer topics by the TopicTemplate if it's set. The provider serves the
+// requests addressed to its cluster, and the informer sends the requests to the provider of the informerCluster. The
+// empty informerCluster keeps the {cluster} of the informer topics, so that they're rendered with each of the
+// clusters. The {gvr} is subscribed by the wildcard, so it's rejected on Kafka, whose topics have no wildcards.
+func (o *Options) RenderTopics(providerCluster, informerCluster string) error {
+	if o.TopicTemplate == "" {
+		return nil
+	}
+	if strings.HasPrefix(o.Broker, "kafka://") && strings.Contains(o.TopicTemplate, apis.GVRPlaceholder) {
+		return fmt.Errorf("the Kafka topics have no wildcards to subscribe the %s of the topic template %q, "+
+			"use a template without it, e.g. straw.{cluster}.{direction}", apis.GVRPlaceholder, o.TopicTemplate)
+	}
+	template := apis.TopicTemplate(o.TopicTemplate)
+	o.HeartbeatTopic = template.HeartbeatTopic(utils.HubClusterName)
+	o.ProviderReceiveTopic = template.Render(providerCluster, apis.DirectionRequest)
+	o.ProviderSendTopic = template.Render(providerCluster, apis.DirectionResponse)
+	if informerCluster == "" {
+		informerCluster = apis.ClusterPlaceholder
+	}
+	o.InformerSendTopic = template.Render(informerCluster, apis.DirectionRequest)
+	o.InformerReceiveTopic = template.Render(informerCluster, apis.DirectionResponse)
+	return nil
+}
+
+// GroupVersionResources parses the resources in the format of <resource>.<version>.<group>
+func (o *Options) GroupVersionResources() ([]schema.GroupVersionResource, error) {
+	gvrs := []schema.GroupVersionResource{}
+	for _, resource := range o.Resources {
+		gvr, _ := schema.ParseResourceArg(resource)
+		if gvr == nil {
+			return nil, fmt.Errorf("invalid resource %q, it should be in the format of <resource>.<version>.<group>",
+				resource)
+		}
+		gvrs = append(gvrs, *gvr)
+	}
+	return gvrs, nil
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+// listInChunks lists the resources page by page and hands each page to the send func, so that a large list won't be
+// sent in a single message. The Limit/Continue of the options are honored: at most Limit objects are sent for the
+// request, and the last chunk carries the continue token for the next page. Each chunk carries the continue token
+// and the resourceVersion of its own page, so the last one has the final resourceVersion of the list. The chunkSize
+// 0 means the whole page is sent within a single chunk. Once a page fails to be listed, the terminal chunk carrying the
+// error Status is sent, so that the requester fails the list at once instead of waiting for the rest of the chunks.
+func listInChunks(lw ListWatcher, namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions,
+	chunkSize int64, send func(chunk *unstructured.UnstructuredList, endOfList bool, status *metav1.Status) error,
+) error {
+	remaining := options.Limit
+	chunkOptions := options
+	for {
+		chunkOptions.Limit = chunkSize
+		if remaining > 0 && (chunkSize <= 0 || remaining < chunkSize) {
+			chunkOptions.Limit = remaining
+		}
+
+		chunk, err := lw.List(namespace, gvr, chunkOptions)
+		if err != nil {
+			if sendErr := send(&unstructured.UnstructuredList{}, true, errorStatus(err)); sendErr != nil {
+				klog.Errorf("failed to send the list error: %v", sendErr)
+			}
+			return err
+		}
+
+		if options.Limit > 0 {
+			remaining -= int64(len(chunk.Items))
+		}
+		endOfList := chunk.GetContinue() == "" || (options.Limit > 0 && remaining <= 0)
+		if err := send(chunk, endOfList, nil); err != nil {
+			return err
+		}
+		if endOfList {
+			return nil
+		}
+
+		// the following pages are consistent with the first one by the continue token
+		chunkOptions.Continue = chunk.GetContinue()
+		chunkOptions.ResourceVersion = ""
+		chunkOptions.ResourceVersionMatch = ""
+	}
+}
@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// pagingListWatcher lists the named objects page by page, the continue token is the offset of the next page
+type pagingListWatcher struct {
+	names []string
+	// failAt fails the page starting at the offset, e.g. the continue token is expired
+	failAt int
+}
+
+func (l *pagingListWatcher) List(namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) (
+	*unstructured.UnstructuredList, error,
+) {
+	offset := 0
+	if options.Continue != "" {
+		offset, _ = strconv.Atoi(options.Continue)
+	}
+	if l.failAt > 0 && offset == l.failAt {
+		return nil, apierrors.NewResourceExpired("the continue token is expired")
+	}
+	end := len(l.names)
+	list := &unstructured.UnstructuredList{}
+	if options.Limit > 0 && offset+int(options.Limit) < end {
+		end = offset + int(options.Limit)
+		list.SetContinue(strconv.Itoa(end))
+	}
+	for _, name := range l.names[offset:end] {
+		obj := unstructured.Unstructured{}
+		obj.SetName(name)
+		list.Items = append(list.Items, obj)
+	}
+	list.SetResourceVersion(strconv.Itoa(end))
+	return list, nil
+}
+
+func (l *pagingListWatcher) Watch(namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) (
+	watch.Interface, error,
+) {
+	return watch.NewEmptyWatch(), nil
+}
+
+func TestListInChunks(t *testing.T) {
+	names := []string{}
+	for i := 0; i < 7; i++ {
+		names = append(names, fmt.Sprintf("obj-%d", i))
+	}
+
+	cases := []struct {
+		name         string
+		options      metav1.ListOptions
+		chunkSize    int64
+		chunks       []int
+		lastContinue string
+		lastRV       string
+	}{
+		{name: "single chunk", chunkSize: 0, chunks: []int{7}, lastRV: "7"},
+		{name: "chunks", chunkSize: 3, chunks: []int{3, 3, 1}, lastRV: "7"},
+		{name: "limit", options: metav1.ListOptions{Limit: 5}, chunkSize: 3, chunks: []int{3, 2},
+			lastContinue: "5", lastRV: "5"},
+		{name: "continue", options: metav1.ListOptions{Limit: 5, Continue: "5"}, chunkSize: 3, chunks: []int{2},
+			lastRV: "7"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chunks := []int{}
+			var last *unstructured.UnstructuredList
+			err := listInChunks(&pagingListWatcher{names: names}, "", schema.GroupVersionResource{}, c.options,
+				c.chunkSize, func(chunk *unstructured.UnstructuredList, endOfList bool, status *metav1.Status) error {
+					if last != nil {
+						t.Fatal("unexpected chunk after the end of list")
+					}
+					if status != nil {
+						t.Fatalf("unexpected error status %v", status)
+					}
+					chunks = append(chunks, len(chunk.Items))
+					if endOfList {
+						last = chunk
+					}
+					return nil
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(chunks) != fmt.Sprint(c.chunks) {
+				t.Errorf("expected chunks %v, got %v", c.chunks, chunks)
+			}
+			if last == nil {
+				t.Fatal("the end of list isn't sent")
+			}
+			if last.GetContinue() != c.lastContinue || last.GetResourceVersion() != c.lastRV {
+				t.Errorf("expected the last chunk with continue %q and resourceVersion %q, got %q and %q",
+					c.lastContinue, c.lastRV, last.GetContinue(), last.GetResourceVersion())
+			}
+		})
+	}
+}
+
+func TestListInChunksError(t *testing.T) {
+	lw := &pagingListWatcher{names: []string{"obj-0", "obj-1", "obj-2"}, failAt: 2}
+	chunks := []int{}
+	var last *metav1.Status
+	err := listInChunks(lw, "", schema.GroupVersionResource{}, metav1.ListOptions{}, 2,
+		func(chunk *unstructured.UnstructuredList, endOfList bool, status *metav1.Status) error {
+			chunks = append(chunks, len(chunk.Items))
+			if endOfList {
+				last = status
+			}
+			return nil
+		})
+	if !apierrors.IsResourceExpired(err) {
+		t.Fatalf("expected the expired error, got %v", err)
+	}
+	// the chunk listed before the failure is followed by the terminal one carrying the error
+	if fmt.Sprint(chunks) != fmt.Sprint([]int{2, 0}) {
+		t.Errorf("expected chunks [2 0], got %v", chunks)
+	}
+	if last == nil || !apierrors.IsResourceExpired(apierrors.FromObject(last)) {
+		t.Fatalf("expected the terminal chunk with the expired status, got %v", last)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/yanmxa/straw/pkg/apis"
 	transport "github.com/yanmxa/straw/pkg/transport"
@@ -11,30 +12,38 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/klog/v2"
 )
 
 type defaultProvider struct {
 	clusterName string
-	lw          ListWatcher // used to list and watch local resource
+	lw          *sharedListWatcher // used to list and watch local resource
 	transporter transport.Transport
 	watchStop   map[types.UID]context.CancelFunc
+	mutex       sync.Mutex
 
-	sendTopic    string
-	receiveTopic string
-	adapter      func(obj metav1.Object, clusterName string)
+	sendTopic     string
+	receiveTopic  string
+	listChunkSize int64
+	adapter       func(obj metav1.Object, clusterName string)
 }
 
-func NewDefaultProvider(clusterName string, dynamicClient *dynamic.DynamicClient, t transport.Transport, send, receive string, adapter func(obj metav1.Object, clusterName string)) Provider {
+// NewDefaultProvider creates a provider serves the requests from the transport, the list responses are sent in
+// chunks of the listChunkSize objects. The {cluster} of the topics(apis.TopicTemplate) is rendered with the
+// clusterName, the requests of all the resources are received by the wildcard of the {gvr}, and the responses are
+// sent to the topic of their resources. The sessions of the same resources share a cache watching the apiserver.
+func NewDefaultProvider(clusterName string, dynamicClient dynamic.Interface, t transport.Transport, send, receive string, listChunkSize int64, adapter func(obj metav1.Object, clusterName string)) Provider {
 	return &defaultProvider{
-		clusterName:  clusterName,
-		lw:           NewDynamicListWatcher(dynamicClient),
-		transporter:  t,
-		watchStop:    map[types.UID]context.CancelFunc{},
-		sendTopic:    send,
-		receiveTopic: receive,
-		adapter:      adapter,
+		clusterName:   clusterName,
+		lw:            newSharedListWatcher(NewDynamicListWatcher(dynamicClient)),
+		transporter:   t,
+		watchStop:     map[types.UID]context.CancelFunc{},
+		sendTopic:     apis.ClusterTopic(send, clusterName),
+		receiveTopic:  apis.TopicFilter(apis.ClusterTopic(receive, clusterName)),
+		listChunkSize: listChunkSize,
+		adapter:       adapter,
 	}
 }
 
@@ -44,23 +53,32 @@ func (d *defaultProvider) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	defer d.lw.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			klog.Info("context done!")
 			return nil
-		case transportMsg := <-receiver.MessageChan():
+		case transportMsg, ok := <-receiver.MessageChan():
+			if !ok {
+				klog.Info("receiver is stopped!")
+				return nil
+			}
 			err := d.process(ctx, transportMsg)
 			if err != nil {
 				klog.Error(err)
 			}
+			transport.Ack(receiver)
 		}
 	}
 }
 
 func (d *defaultProvider) StopAll() {
-	for _, stop := range d.watchStop {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for id, stop := range d.watchStop {
 		stop()
+		delete(d.watchStop, id)
 	}
 }
 
@@ -82,12 +100,20 @@ func (d *defaultProvider) process(ctx context.Context, transportMsg apis.Transpo
 			klog.Errorf("failed to send list response with error: %v", err)
 		}
 	case string(apis.ModeWatch):
-		go d.watchResponse(ctx, types.UID(transportMsg.ID), req.Namespace, gvr, req.Options)
+		// register the watcher before it's started, so that the stop request following it won't be missed
+		watchCtx, stop := context.WithCancel(ctx)
+		d.mutex.Lock()
+		d.watchStop[types.UID(transportMsg.ID)] = stop
+		d.mutex.Unlock()
+		go d.watchResponse(watchCtx, types.UID(transportMsg.ID), req.Namespace, gvr, req.Options)
 	case string(apis.ModeStop):
+		d.mutex.Lock()
 		cancelFunc, ok := d.watchStop[types.UID(transportMsg.ID)]
+		delete(d.watchStop, types.UID(transportMsg.ID))
+		d.mutex.Unlock()
 		if ok {
 			cancelFunc()
-			delete(d.watchStop, types.UID(transportMsg.ID))
+			klog.Infof("provider stop watcher(%s): %s", apis.MessageWatchResponseType(gvr), transportMsg.ID)
 		}
 	default:
 		klog.Warningf("unknown message type: %s", transportMsg.Type)
@@ -95,31 +121,54 @@ func (d *defaultProvider) process(ctx context.Context, transportMsg apis.Transpo
 	return nil
 }
 
-func (d *defaultProvider) watchResponse(ctx context.Context, id types.UID, namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) {
+func (d *defaultProvider) watchResponse(watchCtx context.Context, id types.UID, namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) {
+	defer func() {
+		d.mutex.Lock()
+		if stop, ok := d.watchStop[id]; ok {
+			stop()
+			delete(d.watchStop, id)
+		}
+		d.mutex.Unlock()
+	}()
+
 	klog.Infof("provider start a watcher(%s: %s) to %s", apis.MessageWatchResponseType(gvr), namespace, d.sendTopic)
 	w, err := d.lw.Watch(namespace, gvr, options)
 	if err != nil {
-		klog.Error(err)
+		klog.Errorf("failed to start watcher(%s) with error: %v", id, err)
+		d.sendWatchResponse(id, gvr, watch.Error, errorObject(err))
+		return
 	}
+	defer func() { w.Stop() }()
 
-	watchCtx, stop := context.WithCancel(ctx)
-	d.watchStop[id] = stop
-	defer w.Stop()
-
+	// the resourceVersion of the last event delivered to the informer, the watcher is resumed from it
+	lastResourceVersion := options.ResourceVersion
 	for {
 		select {
 		// TODO: can add a ticker to send all the watch events periodically like informer resync
 		case e, ok := <-w.ResultChan():
 			if !ok {
-				klog.Infof("watcher(%s) is closed, restart a new watcher to %s!", apis.MessageWatchResponseType(gvr),
-					d.sendTopic)
-				w, err = d.lw.Watch(namespace, gvr, options)
+				klog.Infof("watcher(%s) is closed, resume the watcher from %q to %s!",
+					apis.MessageWatchResponseType(gvr), lastResourceVersion, d.sendTopic)
+				// release the closed watcher, e.g. its reference to the shared watch cache
+				w.Stop()
+				w, err = d.lw.Watch(namespace, gvr, resumeOptions(options, lastResourceVersion))
 				if err != nil {
-					klog.Errorf("failed to restart watcher(%s) with error: %v", id, err)
+					// e.g. the 410 Expired, the informer should relist
+					klog.Errorf("failed to resume watcher(%s) with error: %v", id, err)
+					w = watch.NewEmptyWatch()
+					d.sendWatchResponse(id, gvr, watch.Error, errorObject(err))
+					return
 				}
 				continue
 			}
 
+			if e.Type == watch.Error {
+				// the watcher is ended by the error, leave the informer to decide whether to relist or rewatch
+				klog.Warningf("watcher(%s) is ended with error: %v", id, e.Object)
+				d.sendWatchResponse(id, gvr, watch.Error, statusObject(e.Object))
+				return
+			}
+
 			obj, ok := e.Object.(*unstructured.Unstructured)
 			if !ok {
 				klog.Warning("failed to convert object to unstructured")
@@ -129,28 +178,8 @@ func (d *defaultProvider) watchResponse(ctx context.Context, id types.UID, names
 				d.adapter(obj, d.clusterName)
 			}
 
-			// pay, _ := json.MarshalIndent(obj, "", "  ")
-			// klog.Infof("watch new obj: %s", string(pay))
-
-			response := &apis.WatchResponseMessage{
-				Type:   e.Type,
-				Object: obj,
-			}
-			res, err := json.Marshal(response)
-			if err != nil {
-				klog.Warning(err)
-			}
-
-			msg := apis.TransportMessage{}
-			msg.ID = string(id)
-			msg.Type = apis.MessageWatchResponseType(gvr)
-			msg.Source = d.clusterName
-			msg.Payload = res
-
-			klog.Infof("provider %s - %s: %s/%s", msg.Type, e.Type, obj.GetNamespace(), obj.GetName())
-			err = d.transporter.Send(d.sendTopic, msg)
-			if err != nil {
-				klog.Warning("failed to send watch object with error: %v", err)
+			if d.sendWatchResponse(id, gvr, e.Type, obj) {
+				lastResourceVersion = obj.GetResourceVersion()
 			}
 		case <-watchCtx.Done():
 			return
@@ -158,43 +187,73 @@ func (d *defaultProvider) watchResponse(ctx context.Context, id types.UID, names
 	}
 }
 
-func (d *defaultProvider) sendListResponses(ctx context.Context, id types.UID, namespace string,
-	gvr schema.GroupVersionResource, options metav1.ListOptions,
-) error {
-	objs, err := d.lw.List(namespace, gvr, options)
-	if err != nil {
-		klog.Errorf("failed to list resource with err: %v", err)
-		return err
-	}
-
-	if d.adapter != nil {
-		for _, obj := range objs.Items {
-			d.adapter(&obj, d.clusterName)
-		}
-	}
-
-	response := &apis.ListResponseMessage{
-		Objects:   objs,
-		EndOfList: true,
+// sendWatchResponse returns whether the watch event is sent to the transport
+func (d *defaultProvider) sendWatchResponse(id types.UID, gvr schema.GroupVersionResource, eventType watch.EventType,
+	obj *unstructured.Unstructured,
+) bool {
+	response := &apis.WatchResponseMessage{
+		Type:   eventType,
+		Object: obj,
 	}
 	res, err := json.Marshal(response)
 	if err != nil {
-		return err
+		klog.Warning(err)
+		return false
 	}
-	// fmt.Println("send list response: ", response.Objects.Items[0].GetName())
 
 	msg := apis.TransportMessage{}
 	msg.ID = string(id)
-	msg.Type = apis.MessageListResponseType(gvr)
+	msg.Type = apis.MessageWatchResponseType(gvr)
 	msg.Source = d.clusterName
 	msg.Payload = res
 
-	klog.Infof("provider send list response message(%s) to %s", msg.Type, d.sendTopic)
-	err = d.transporter.Send(d.sendTopic, msg)
+	klog.Infof("provider %s - %s: %s/%s", msg.Type, eventType, obj.GetNamespace(), obj.GetName())
+	err = d.transporter.Send(apis.ResourceTopic(d.sendTopic, gvr), msg)
+	if err != nil {
+		klog.Warningf("failed to send watch object with error: %v", err)
+		return false
+	}
+	return true
+}
+
+func (d *defaultProvider) sendListResponses(ctx context.Context, id types.UID, namespace string,
+	gvr schema.GroupVersionResource, options metav1.ListOptions,
+) error {
+	err := listInChunks(d.lw, namespace, gvr, options, d.listChunkSize,
+		func(objs *unstructured.UnstructuredList, endOfList bool, status *metav1.Status) error {
+			if d.adapter != nil {
+				for i := range objs.Items {
+					d.adapter(&objs.Items[i], d.clusterName)
+				}
+			}
+
+			response := &apis.ListResponseMessage{
+				Objects:   objs,
+				EndOfList: endOfList,
+				Error:     status,
+			}
+			res, err := json.Marshal(response)
+			if err != nil {
+				return err
+			}
+
+			msg := apis.TransportMessage{}
+			msg.ID = string(id)
+			msg.Type = apis.MessageListResponseType(gvr)
+			msg.Source = d.clusterName
+			msg.Payload = res
 
+			klog.Infof("provider send list response message(%s) with %d objects to %s", msg.Type, len(objs.Items),
+				d.sendTopic)
+			err = d.transporter.Send(apis.ResourceTopic(d.sendTopic, gvr), msg)
+			if err != nil {
+				klog.Errorf("failed to send list objects with error: %v", err)
+				return err
+			}
+			return nil
+		})
 	if err != nil {
-		klog.Errorf("failed to send list objects with error: %v", err)
-		return err
+		klog.Errorf("failed to list resource with err: %v", err)
 	}
-	return nil
+	return err
 }
@@ -2,15 +2,16 @@ package provider
 
 import (
 	"context"
+	"sync"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/yanmxa/straw/pkg/apis"
-	"github.com/yanmxa/straw/pkg/utils"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/klog/v2"
 )
@@ -18,17 +19,22 @@ import (
 type genericProvider struct {
 	clusterName   string
 	watcherStop   map[types.UID]context.CancelFunc
+	mutex         sync.Mutex
 	tweakFunc     func(obj metav1.Object, clusterName string)
 	transporter   cloudevents.Client
-	dynamicClient *dynamic.DynamicClient
+	lw            *sharedListWatcher
+	listChunkSize int64
 }
 
-func NewProvider(clusterName string, dynamicClient *dynamic.DynamicClient, t cloudevents.Client,
+// NewProvider creates a provider serves the request events from the cloudevents client, the list responses are sent
+// in chunks of the listChunkSize objects. The sessions of the same resources share a cache watching the apiserver.
+func NewProvider(clusterName string, dynamicClient dynamic.Interface, t cloudevents.Client, listChunkSize int64,
 	tweakFunc func(obj metav1.Object, clusterName string),
 ) Provider {
 	return &genericProvider{
 		clusterName:   clusterName,
-		dynamicClient: dynamicClient,
+		lw:            newSharedListWatcher(NewDynamicListWatcher(dynamicClient)),
+		listChunkSize: listChunkSize,
 		transporter:   t,
 		watcherStop:   map[types.UID]context.CancelFunc{},
 		tweakFunc:     tweakFunc,
@@ -36,7 +42,12 @@ func NewProvider(clusterName string, dynamicClient *dynamic.DynamicClient, t clo
 }
 
 func (p *genericProvider) Run(ctx context.Context) error {
+	defer p.lw.Stop()
 	return p.transporter.StartReceiver(ctx, func(evt cloudevents.Event) error {
+		// the request targets another cluster
+		if evt.Subject() != "" && evt.Subject() != p.clusterName {
+			return nil
+		}
 		mode, gvr, err := apis.ParseEventType(evt.Type())
 		if err != nil {
 			return err
@@ -56,12 +67,19 @@ func (p *genericProvider) Run(ctx context.Context) error {
 				klog.Errorf("failed to send list response with error: %v", err)
 			}
 		case string(apis.ModeWatch):
-			go p.watchResponse(ctx, types.UID(evt.ID()), reqEvent.Namespace, gvr, reqEvent.Options)
+			// register the watcher before it's started, so that the stop request following it won't be missed
+			watchCtx, cancel := context.WithCancel(ctx)
+			p.mutex.Lock()
+			p.watcherStop[types.UID(evt.ID())] = cancel
+			p.mutex.Unlock()
+			go p.watchResponse(watchCtx, types.UID(evt.ID()), reqEvent.Namespace, gvr, reqEvent.Options)
 		case string(apis.ModeStop):
+			p.mutex.Lock()
 			cancelFunc, ok := p.watcherStop[types.UID(evt.ID())]
+			delete(p.watcherStop, types.UID(evt.ID()))
+			p.mutex.Unlock()
 			if ok {
 				cancelFunc()
-				delete(p.watcherStop, types.UID(evt.ID()))
 				klog.Info("provider stop watcher: ", evt.Type(), " - ", evt.ID())
 			}
 		default:
@@ -71,30 +89,54 @@ func (p *genericProvider) Run(ctx context.Context) error {
 	})
 }
 
-func (p *genericProvider) watchResponse(ctx context.Context, id types.UID, namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) {
-	watchCtx, cancel := context.WithCancel(ctx)
-	p.watcherStop[id] = cancel
+func (p *genericProvider) watchResponse(watchCtx context.Context, id types.UID, namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) {
+	defer func() {
+		p.mutex.Lock()
+		if cancel, ok := p.watcherStop[id]; ok {
+			cancel()
+			delete(p.watcherStop, id)
+		}
+		p.mutex.Unlock()
+	}()
 
-	watcher, err := p.dynamicClient.Resource(gvr).Namespace(namespace).Watch(watchCtx, options)
+	watcher, err := p.lw.Watch(namespace, gvr, options)
 	if err != nil {
-		klog.Error(err)
+		klog.Errorf("failed to start watcher(%s) with error: %v", id, err)
+		p.sendWatchResponse(watchCtx, id, gvr, watch.Error, errorObject(err))
+		return
 	}
-	defer watcher.Stop()
+	defer func() { watcher.Stop() }()
 	klog.Info("provider start watcher: ", apis.EventWatchResponseType(gvr), " - ", id)
 
+	// the resourceVersion of the last event delivered to the informer, the watcher is resumed from it
+	lastResourceVersion := options.ResourceVersion
 	for {
 		select {
 		// TODO: can add a ticker to send all the watch events periodically like informer resync
 		case e, ok := <-watcher.ResultChan():
 			if !ok {
-				klog.Infof("provider watcher is closed, restart a new watcher: %s - %s", apis.MessageWatchResponseType(gvr), id)
-				watcher, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Watch(watchCtx, options)
+				klog.Infof("provider watcher is closed, resume the watcher from %q: %s - %s", lastResourceVersion,
+					apis.MessageWatchResponseType(gvr), id)
+				// release the closed watcher, e.g. its reference to the shared watch cache
+				watcher.Stop()
+				watcher, err = p.lw.Watch(namespace, gvr, resumeOptions(options, lastResourceVersion))
 				if err != nil {
-					klog.Errorf("failed to restart watcher(%s) with error: %v", id, err)
+					// e.g. the 410 Expired, the informer should relist
+					klog.Errorf("failed to resume watcher(%s) with error: %v", id, err)
+					watcher = watch.NewEmptyWatch()
+					p.sendWatchResponse(watchCtx, id, gvr, watch.Error, errorObject(err))
+					return
 				}
 				continue
 			}
 
+			if e.Type == watch.Error {
+				// the watcher is ended by the error, leave the informer to decide whether to relist or rewatch
+				klog.Warningf("provider watcher(%s) is ended with error: %v", id, e.Object)
+				p.sendWatchResponse(watchCtx, id, gvr, watch.Error, statusObject(e.Object))
+				return
+			}
+
 			obj, ok := e.Object.(*unstructured.Unstructured)
 			if !ok {
 				klog.Warning("failed to convert object to unstructured")
@@ -104,22 +146,8 @@ func (p *genericProvider) watchResponse(ctx context.Context, id types.UID, names
 				p.tweakFunc(obj, p.clusterName)
 			}
 
-			response := &apis.WatchResponseEvent{
-				Type:   e.Type,
-				Object: obj,
-			}
-
-			evt := cloudevents.NewEvent()
-			evt.SetID(string(id))
-			evt.SetType(apis.EventWatchResponseType(gvr))
-			evt.SetSource(p.clusterName)
-			evt.SetData(cloudevents.ApplicationJSON, response)
-
-			klog.Infof("provider send %s", evt.Type())
-			utils.PrettyPrint(response)
-			result := p.transporter.Send(ctx, evt)
-			if cloudevents.IsUndelivered(result) {
-				klog.Errorf("failed to send watch response with error: %v", result)
+			if p.sendWatchResponse(watchCtx, id, gvr, e.Type, obj) {
+				lastResourceVersion = obj.GetResourceVersion()
 			}
 		case <-watchCtx.Done():
 			klog.Info("provider cancel watcher: ", apis.EventWatchResponseType(gvr), " - ", id)
@@ -128,38 +156,68 @@ func (p *genericProvider) watchResponse(ctx context.Context, id types.UID, names
 	}
 }
 
-func (p *genericProvider) sendListResponses(ctx context.Context, id types.UID, namespace string,
-	gvr schema.GroupVersionResource, options metav1.ListOptions,
-) error {
-	unstructuredList, err := p.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, options)
-	if err != nil {
-		klog.Errorf("failed to list resource with err: %v", err)
-		return err
-	}
-
-	if p.tweakFunc != nil {
-		for _, obj := range unstructuredList.Items {
-			p.tweakFunc(&obj, p.clusterName)
-		}
-	}
-
-	response := &apis.ListResponseEvent{
-		Objects:   unstructuredList,
-		EndOfList: true,
+// sendWatchResponse returns whether the watch event is sent to the transport
+func (p *genericProvider) sendWatchResponse(ctx context.Context, id types.UID, gvr schema.GroupVersionResource,
+	eventType watch.EventType, obj *unstructured.Unstructured,
+) bool {
+	response := &apis.WatchResponseEvent{
+		Type:   eventType,
+		Object: obj,
 	}
 
 	evt := cloudevents.NewEvent()
 	evt.SetID(string(id))
-	evt.SetType(apis.EventListResponseType(gvr))
+	evt.SetType(apis.EventWatchResponseType(gvr))
 	evt.SetSource(p.clusterName)
-	evt.SetData(cloudevents.ApplicationJSON, response)
+	if err := evt.SetData(cloudevents.ApplicationJSON, response); err != nil {
+		klog.Warning(err)
+		return false
+	}
 
-	klog.Infof("provider send %v", evt.Type())
-	utils.PrettyPrint(response)
+	klog.Infof("provider send %s - %s", evt.Type(), eventType)
 	result := p.transporter.Send(ctx, evt)
 	if cloudevents.IsUndelivered(result) {
-		klog.Errorf("failed to send list response with error: %v", result)
-		return result
+		klog.Errorf("failed to send watch response with error: %v", result)
+		return false
+	}
+	return true
+}
+
+func (p *genericProvider) sendListResponses(ctx context.Context, id types.UID, namespace string,
+	gvr schema.GroupVersionResource, options metav1.ListOptions,
+) error {
+	err := listInChunks(p.lw, namespace, gvr, options, p.listChunkSize,
+		func(unstructuredList *unstructured.UnstructuredList, endOfList bool, status *metav1.Status) error {
+			if p.tweakFunc != nil {
+				for i := range unstructuredList.Items {
+					p.tweakFunc(&unstructuredList.Items[i], p.clusterName)
+				}
+			}
+
+			response := &apis.ListResponseEvent{
+				Objects:   unstructuredList,
+				EndOfList: endOfList,
+				Error:     status,
+			}
+
+			evt := cloudevents.NewEvent()
+			evt.SetID(string(id))
+			evt.SetType(apis.EventListResponseType(gvr))
+			evt.SetSource(p.clusterName)
+			if err := evt.SetData(cloudevents.ApplicationJSON, response); err != nil {
+				return err
+			}
+
+			klog.Infof("provider send %v with %d objects", evt.Type(), len(unstructuredList.Items))
+			result := p.transporter.Send(ctx, evt)
+			if cloudevents.IsUndelivered(result) {
+				klog.Errorf("failed to send list response with error: %v", result)
+				return result
+			}
+			return nil
+		})
+	if err != nil {
+		klog.Errorf("failed to list resource with err: %v", err)
 	}
-	return nil
+	return err
 }
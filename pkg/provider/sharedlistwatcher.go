@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+)
+
+// listCacheGracePeriod is how long the cache started by a list is kept for the watch following it
+var listCacheGracePeriod = time.Minute
+
+var _ ListWatcher = (*sharedListWatcher)(nil)
+
+// sharedListWatcher shares a watchCache for the sessions of the same (gvr, namespace, selectors), so that there is
+// a single list/watch against the apiserver however many informers are watching it. The cache is stopped once all
+// its watchers are stopped.
+type sharedListWatcher struct {
+	lw ListWatcher
+
+	mutex  sync.Mutex
+	caches map[watchCacheKey]*sharedCache
+}
+
+type sharedCache struct {
+	*watchCache
+	watchers int
+}
+
+func newSharedListWatcher(lw ListWatcher) *sharedListWatcher {
+	return &sharedListWatcher{
+		lw:     lw,
+		caches: map[watchCacheKey]*sharedCache{},
+	}
+}
+
+// List serves the list from the cache, the Limit/Continue are honored. The objects are always as fresh as the cache,
+// no matter which resourceVersion is asked.
+func (s *sharedListWatcher) List(namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) (
+	*unstructured.UnstructuredList, error,
+) {
+	c, created := s.get(cacheKey(namespace, gvr, options), false)
+	if created {
+		time.AfterFunc(listCacheGracePeriod, func() { s.release(c, false) })
+	}
+	if err := c.waitUntilSynced(); err != nil {
+		s.release(c, false)
+		return nil, err
+	}
+	return c.list(options), nil
+}
+
+// Watch starts a watcher of the cache from the resourceVersion of the options
+func (s *sharedListWatcher) Watch(namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) (
+	watch.Interface, error,
+) {
+	c, _ := s.get(cacheKey(namespace, gvr, options), true)
+	if err := c.waitUntilSynced(); err != nil {
+		s.release(c, true)
+		return nil, err
+	}
+	w, err := c.watch(options.ResourceVersion, func() { s.release(c, true) })
+	if err != nil {
+		s.release(c, true)
+		return nil, err
+	}
+	return w, nil
+}
+
+// Stop stops all the caches
+func (s *sharedListWatcher) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, c := range s.caches {
+		c.stop()
+		delete(s.caches, key)
+	}
+}
+
+// get returns the cache of the key, and whether it's created by the call. The watcher is counted under the same lock
+// looking the cache up, so that the cache can't be released between the two.
+func (s *sharedListWatcher) get(key watchCacheKey, watching bool) (*sharedCache, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.caches[key]
+	if !ok {
+		c = &sharedCache{watchCache: newWatchCache(key)}
+		s.caches[key] = c
+		klog.Infof("provider start the cache of %s", key)
+		go c.run(s.lw)
+	}
+	if watching {
+		c.watchers++
+	}
+	return c, !ok
+}
+
+// release stops the cache if there is no watcher, the watcher is released if it's watching
+func (s *sharedListWatcher) release(c *sharedCache, watching bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if watching {
+		c.watchers--
+	}
+	if c.watchers > 0 || s.caches[c.key] != c {
+		return
+	}
+	klog.Infof("provider stop the cache of %s", c.key)
+	delete(s.caches, c.key)
+	c.stop()
+}
+
+func cacheKey(namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) watchCacheKey {
+	return watchCacheKey{
+		gvr:           gvr,
+		namespace:     namespace,
+		labelSelector: options.LabelSelector,
+		fieldSelector: options.FieldSelector,
+	}
+}
@@ -0,0 +1,328 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// countingListWatcher serves the list of the objects at the resourceVersion 10, and records the lists and watchers
+type countingListWatcher struct {
+	objects []string
+
+	mutex    sync.Mutex
+	lists    int
+	watchers []*watch.RaceFreeFakeWatcher
+}
+
+func (l *countingListWatcher) List(namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) (
+	*unstructured.UnstructuredList, error,
+) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lists++
+	list := &unstructured.UnstructuredList{}
+	list.SetAPIVersion("v1")
+	list.SetKind("SecretList")
+	for _, name := range l.objects {
+		list.Items = append(list.Items, *newObject(name, "1"))
+	}
+	list.SetResourceVersion("10")
+	return list, nil
+}
+
+func (l *countingListWatcher) Watch(namespace string, gvr schema.GroupVersionResource, options metav1.ListOptions) (
+	watch.Interface, error,
+) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	w := watch.NewRaceFreeFake()
+	l.watchers = append(l.watchers, w)
+	return w, nil
+}
+
+func (l *countingListWatcher) watcher(t *testing.T) *watch.RaceFreeFakeWatcher {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mutex.Lock()
+		if len(l.watchers) > 0 {
+			defer l.mutex.Unlock()
+			if len(l.watchers) != 1 {
+				t.Fatalf("expected a single watch against the apiserver, got %d", len(l.watchers))
+			}
+			return l.watchers[0]
+		}
+		l.mutex.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the watch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newObject(name, resourceVersion string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Secret")
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetResourceVersion(resourceVersion)
+	return obj
+}
+
+func receive(t *testing.T, w watch.Interface) watch.Event {
+	t.Helper()
+	select {
+	case e, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatal("the watcher is closed")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+	return watch.Event{}
+}
+
+func TestSharedListWatcher(t *testing.T) {
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	lw := &countingListWatcher{objects: []string{"a", "b", "c"}}
+	s := newSharedListWatcher(lw)
+	defer s.Stop()
+
+	// the pages are served from the cache
+	first, err := s.List("default", gvr, metav1.ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Items) != 2 || first.GetContinue() == "" || first.GetResourceVersion() != "10" {
+		t.Fatalf("expected the first page with 2 objects at the resourceVersion 10, got %d objects(continue=%q, rv=%q)",
+			len(first.Items), first.GetContinue(), first.GetResourceVersion())
+	}
+	second, err := s.List("default", gvr, metav1.ListOptions{Limit: 2, Continue: first.GetContinue()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Items) != 1 || second.Items[0].GetName() != "c" || second.GetContinue() != "" {
+		t.Fatalf("expected the last page with the object c, got %v", second.Items)
+	}
+
+	// the watchers of the sessions share the watch against the apiserver
+	w1, err := s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2, err := s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiserverWatcher := lw.watcher(t)
+	apiserverWatcher.Add(newObject("d", "11"))
+	for _, w := range []watch.Interface{w1, w2} {
+		if e := receive(t, w); e.Type != watch.Added || e.Object.(*unstructured.Unstructured).GetName() != "d" {
+			t.Fatalf("expected the object d is added, got %s %v", e.Type, e.Object)
+		}
+	}
+	lw.mutex.Lock()
+	if lw.lists != 1 {
+		t.Fatalf("expected the apiserver to be listed once, got %d", lw.lists)
+	}
+	lw.mutex.Unlock()
+
+	// the watcher is resumed from the ring of the cache
+	w3, err := s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := receive(t, w3); e.Object.(*unstructured.Unstructured).GetResourceVersion() != "11" {
+		t.Fatalf("expected the event at the resourceVersion 11 to be replayed, got %v", e.Object)
+	}
+
+	// the watch against the apiserver is stopped with the last watcher
+	w1.Stop()
+	w2.Stop()
+	if apiserverWatcher.IsStopped() {
+		t.Fatal("expected the watch against the apiserver to be kept for the watcher w3")
+	}
+	w3.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for !apiserverWatcher.IsStopped() {
+		if time.Now().After(deadline) {
+			t.Fatal("the watch against the apiserver isn't stopped after all the watchers are stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// cacheResourceVersion returns the max resourceVersion of the caches
+func cacheResourceVersion(s *sharedListWatcher) uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var rv uint64
+	for _, c := range s.caches {
+		c.mutex.RLock()
+		if c.resourceVersion > rv {
+			rv = c.resourceVersion
+		}
+		c.mutex.RUnlock()
+	}
+	return rv
+}
+
+func TestSharedListWatcherSlowWatcher(t *testing.T) {
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	lw := &countingListWatcher{}
+	s := newSharedListWatcher(lw)
+	defer s.Stop()
+
+	w, err := s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiserverWatcher := lw.watcher(t)
+	// the watcher is closed once its buffer is full
+	for i := 0; i <= watcherBufferSize; i++ {
+		apiserverWatcher.Add(newObject(fmt.Sprintf("obj-%d", i), strconv.Itoa(11+i)))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cacheResourceVersion(s) < uint64(11+watcherBufferSize) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the events to be cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-w.ResultChan():
+			closed = !ok
+		case <-time.After(time.Until(deadline)):
+			t.Fatal("the slow watcher isn't closed")
+		}
+	}
+
+	// the closed watcher still holds the cache until it's stopped, like the providers do before resuming
+	if apiserverWatcher.IsStopped() {
+		t.Fatal("expected the watch against the apiserver to be kept until the closed watcher is stopped")
+	}
+	w.Stop()
+	for !apiserverWatcher.IsStopped() {
+		if time.Now().After(deadline) {
+			t.Fatal("the watch against the apiserver isn't stopped after the closed watcher is stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSharedListWatcherListReleaseRace(t *testing.T) {
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	key := cacheKey("default", gvr, metav1.ListOptions{})
+
+	// the grace period of the list fires right after the watch looks the cache up
+	s := newSharedListWatcher(&countingListWatcher{})
+	listed, _ := s.get(key, false)
+	watched, _ := s.get(key, true)
+	s.release(listed, false)
+	s.mutex.Lock()
+	if s.caches[key] != watched || watched.watchers != 1 {
+		s.mutex.Unlock()
+		t.Fatal("expected the cache looked up by the watch to be kept")
+	}
+	s.mutex.Unlock()
+	select {
+	case <-watched.stopCh:
+		t.Fatal("expected the cache looked up by the watch not to be stopped")
+	default:
+	}
+	s.release(watched, true)
+	s.Stop()
+
+	for i := 0; i < 100; i++ {
+		lw := &countingListWatcher{objects: []string{"a"}}
+		s := newSharedListWatcher(lw)
+
+		// the cache started by a list is released by its grace period while a watch is attaching to it
+		c, _ := s.get(key, false)
+		var wg sync.WaitGroup
+		var w watch.Interface
+		var err error
+		s.mutex.Lock()
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w, err = s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "10"})
+		}()
+		go func() {
+			defer wg.Done()
+			s.release(c, false)
+		}()
+		time.Sleep(time.Millisecond)
+		s.mutex.Unlock()
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the watched cache is kept, and it's still the one shared by the key
+		s.mutex.Lock()
+		shared, ok := s.caches[key]
+		if !ok || shared.watchers != 1 {
+			s.mutex.Unlock()
+			t.Fatalf("expected the cache to be kept for the watcher, got %v", shared)
+		}
+		s.mutex.Unlock()
+		w.Stop()
+		s.Stop()
+	}
+}
+
+func TestSharedListWatcherWatchCacheCapacity(t *testing.T) {
+	capacity := WatchCacheCapacity
+	WatchCacheCapacity = 2
+	defer func() { WatchCacheCapacity = capacity }()
+
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	lw := &countingListWatcher{}
+	s := newSharedListWatcher(lw)
+	defer s.Stop()
+
+	w, err := s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	apiserverWatcher := lw.watcher(t)
+	for i := 0; i < 3; i++ {
+		apiserverWatcher.Add(newObject(fmt.Sprintf("obj-%d", i), strconv.Itoa(11+i)))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cacheResourceVersion(s) < 13 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the events to be cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the ring keeps the last 2 events, so the watcher is resumed from the resourceVersion 11 but not from 10
+	if _, err := s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "10"}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("expected the resourceVersion 10 to be expired, got %v", err)
+	}
+	resumed, err := s.Watch("default", gvr, metav1.ListOptions{ResourceVersion: "11"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Stop()
+	for _, rv := range []string{"12", "13"} {
+		if e := receive(t, resumed); e.Object.(*unstructured.Unstructured).GetResourceVersion() != rv {
+			t.Fatalf("expected the event at the resourceVersion %s to be replayed, got %v", rv, e.Object)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// resumeOptions returns the options to restart the watch from the last resourceVersion delivered to the informer,
+// so that the events happen during the restart won't be lost.
+func resumeOptions(options metav1.ListOptions, lastResourceVersion string) metav1.ListOptions {
+	resumed := options
+	resumed.ResourceVersion = lastResourceVersion
+	resumed.ResourceVersionMatch = ""
+	return resumed
+}
+
+// errorObject converts the error of the watch request into the object of the watch ERROR event, like the
+// apiserver does. e.g. the reflector relists once it receives the 410 Expired Status.
+func errorObject(err error) *unstructured.Unstructured {
+	return statusObject(errorStatus(err))
+}
+
+// errorStatus converts the error into the Status, the error without the status is the 500 InternalError
+func errorStatus(err error) *metav1.Status {
+	if status, ok := err.(apierrors.APIStatus); ok {
+		s := status.Status()
+		return &s
+	}
+	return &apierrors.NewInternalError(err).ErrStatus
+}
+
+// statusObject converts the object of the watch ERROR event into the unstructured Status, so that it can be sent
+// over the transport.
+func statusObject(obj runtime.Object) *unstructured.Unstructured {
+	switch o := obj.(type) {
+	case *unstructured.Unstructured:
+		return o
+	case *metav1.Status:
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+		if err != nil {
+			return errorObject(err)
+		}
+		status := &unstructured.Unstructured{Object: content}
+		status.SetAPIVersion("v1")
+		status.SetKind("Status")
+		return status
+	default:
+		return errorObject(fmt.Errorf("unexpected object %T of the %s event", obj, watch.Error))
+	}
+}
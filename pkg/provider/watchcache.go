@@ -0,0 +1,406 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// WatchCacheCapacity is the number of the recent events kept by a watch cache to resume the watchers, the watchers
+// resumed from an older resourceVersion are expired(410) and relist
+var WatchCacheCapacity = 100
+
+const (
+	// watcherBufferSize is the number of the events buffered for a watcher, the watcher is closed if it's full
+	watcherBufferSize = 100
+)
+
+// watchCacheKey identifies the resources cached by a watchCache
+type watchCacheKey struct {
+	gvr           schema.GroupVersionResource
+	namespace     string
+	labelSelector string
+	fieldSelector string
+}
+
+func (k watchCacheKey) String() string {
+	return fmt.Sprintf("%s(namespace=%q, labels=%q, fields=%q)", k.gvr.String(), k.namespace, k.labelSelector,
+		k.fieldSelector)
+}
+
+type watchCacheEvent struct {
+	eventType       watch.EventType
+	object          *unstructured.Unstructured
+	resourceVersion uint64
+}
+
+// watchCache is the store of a reflector watching the apiserver, like the watch cache of the apiserver. It serves
+// the lists from the cached objects, and fans the events out to the watchers of the sessions. The recent events are
+// kept in a ring, so that a watcher can be resumed from a resourceVersion within it.
+type watchCache struct {
+	key    watchCacheKey
+	synced chan struct{}
+	failed chan struct{}
+	err    error
+	stopCh chan struct{}
+	once   sync.Once
+
+	mutex           sync.RWMutex
+	store           cache.Store
+	isSynced        bool
+	apiVersion      string
+	listKind        string
+	resourceVersion uint64
+	// the events after the oldestResourceVersion are kept in the events
+	oldestResourceVersion uint64
+	events                []watchCacheEvent
+	watchers              map[*cacheWatcher]struct{}
+}
+
+var _ cache.Store = (*watchCache)(nil)
+
+func newWatchCache(key watchCacheKey) *watchCache {
+	return &watchCache{
+		key:      key,
+		synced:   make(chan struct{}),
+		failed:   make(chan struct{}),
+		stopCh:   make(chan struct{}),
+		store:    cache.NewStore(cache.MetaNamespaceKeyFunc),
+		watchers: map[*cacheWatcher]struct{}{},
+	}
+}
+
+// run starts the reflector to keep the cache up to date with the apiserver until the cache is stopped
+func (c *watchCache) run(lw ListWatcher) {
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(c.key.namespace, c.key.gvr, c.withSelectors(options))
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if err != nil {
+				if !c.isSynced {
+					// the requests waiting for the cache get the error, e.g. the resource isn't found
+					c.once.Do(func() {
+						c.err = err
+						close(c.failed)
+					})
+				}
+				return nil, err
+			}
+			c.apiVersion, c.listKind = list.GetAPIVersion(), list.GetKind()
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return lw.Watch(c.key.namespace, c.key.gvr, c.withSelectors(options))
+		},
+	}
+	reflector := cache.NewNamedReflector(c.key.String(), listWatch, &unstructured.Unstructured{}, c, 0)
+	reflector.Run(c.stopCh)
+}
+
+func (c *watchCache) withSelectors(options metav1.ListOptions) metav1.ListOptions {
+	options.LabelSelector = c.key.labelSelector
+	options.FieldSelector = c.key.fieldSelector
+	return options
+}
+
+func (c *watchCache) stop() {
+	close(c.stopCh)
+	c.mutex.Lock()
+	watchers := c.watchers
+	c.watchers = map[*cacheWatcher]struct{}{}
+	c.mutex.Unlock()
+	for w := range watchers {
+		w.close()
+	}
+}
+
+// waitUntilSynced returns the error if the cache fails to list the resources at the first time
+func (c *watchCache) waitUntilSynced() error {
+	select {
+	case <-c.synced:
+		return nil
+	case <-c.failed:
+		return c.err
+	case <-c.stopCh:
+		return fmt.Errorf("the cache of %s is stopped", c.key)
+	}
+}
+
+// list returns the cached objects in the order of their keys. The continue token is the key of the last object
+// returned, so that the next page starts after it.
+func (c *watchCache) list(options metav1.ListOptions) *unstructured.UnstructuredList {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	keys := c.store.ListKeys()
+	sort.Strings(keys)
+	start := 0
+	if options.Continue != "" {
+		start = sort.Search(len(keys), func(i int) bool { return keys[i] > options.Continue })
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetAPIVersion(c.apiVersion)
+	list.SetKind(c.listKind)
+	for i := start; i < len(keys); i++ {
+		if options.Limit > 0 && int64(len(list.Items)) == options.Limit {
+			list.SetContinue(keys[i-1])
+			break
+		}
+		obj, exists, _ := c.store.GetByKey(keys[i])
+		if exists {
+			list.Items = append(list.Items, *obj.(*unstructured.Unstructured).DeepCopy())
+		}
+	}
+	list.SetResourceVersion(formatResourceVersion(c.resourceVersion))
+	return list
+}
+
+// watch starts a watcher from the resourceVersion. The watcher gets the current objects as the ADDED events if the
+// resourceVersion is empty or "0", otherwise the events after the resourceVersion are replayed from the ring, and it
+// fails with the 410 Expired if the events have been evicted.
+func (c *watchCache) watch(resourceVersion string, onStop func()) (*cacheWatcher, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	initEvents := []watch.Event{}
+	switch resourceVersion {
+	case "", "0":
+		for _, obj := range c.store.List() {
+			initEvents = append(initEvents, watch.Event{
+				Type:   watch.Added,
+				Object: obj.(*unstructured.Unstructured).DeepCopy(),
+			})
+		}
+	default:
+		rv, err := strconv.ParseUint(resourceVersion, 10, 64)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid resource version %q: %v", resourceVersion, err))
+		}
+		if rv < c.oldestResourceVersion {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", rv,
+				c.oldestResourceVersion))
+		}
+		for _, e := range c.events {
+			if e.resourceVersion > rv {
+				initEvents = append(initEvents, watch.Event{Type: e.eventType, Object: e.object.DeepCopy()})
+			}
+		}
+	}
+
+	var w *cacheWatcher
+	w = newCacheWatcher(initEvents, func() {
+		c.mutex.Lock()
+		delete(c.watchers, w)
+		c.mutex.Unlock()
+		onStop()
+	})
+	c.watchers[w] = struct{}{}
+	return w, nil
+}
+
+func (c *watchCache) process(eventType watch.EventType, obj interface{}) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object %T", obj)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var err error
+	if eventType == watch.Deleted {
+		err = c.store.Delete(u)
+	} else {
+		err = c.store.Update(u)
+	}
+	if err != nil {
+		return err
+	}
+
+	event := watchCacheEvent{eventType: eventType, object: u, resourceVersion: parseResourceVersion(u)}
+	c.events = append(c.events, event)
+	if len(c.events) > WatchCacheCapacity {
+		c.oldestResourceVersion = c.events[0].resourceVersion
+		c.events = c.events[1:]
+	}
+	for w := range c.watchers {
+		w.add(watch.Event{Type: eventType, Object: u.DeepCopy()})
+	}
+	return nil
+}
+
+func (c *watchCache) Add(obj interface{}) error {
+	return c.process(watch.Added, obj)
+}
+
+func (c *watchCache) Update(obj interface{}) error {
+	return c.process(watch.Modified, obj)
+}
+
+func (c *watchCache) Delete(obj interface{}) error {
+	return c.process(watch.Deleted, obj)
+}
+
+// Replace resets the cache with the list of the reflector. The events between the last watched resourceVersion and
+// the relist are unknown, so the watchers are terminated with the 410 Expired to relist.
+func (c *watchCache) Replace(list []interface{}, resourceVersion string) error {
+	c.mutex.Lock()
+	if err := c.store.Replace(list, resourceVersion); err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+	rv, _ := strconv.ParseUint(resourceVersion, 10, 64)
+	c.resourceVersion = rv
+	c.oldestResourceVersion = rv
+	c.events = nil
+	watchers := c.watchers
+	c.watchers = map[*cacheWatcher]struct{}{}
+	if !c.isSynced {
+		c.isSynced = true
+		close(c.synced)
+	}
+	c.mutex.Unlock()
+
+	for w := range watchers {
+		klog.Infof("terminate the watcher of %s since the cache is relisted", c.key)
+		w.terminate(apierrors.NewResourceExpired("the cache is relisted"))
+	}
+	return nil
+}
+
+// UpdateResourceVersion is invoked by the reflector after each event, including the bookmark
+func (c *watchCache) UpdateResourceVersion(resourceVersion string) {
+	rv, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if rv > c.resourceVersion {
+		c.resourceVersion = rv
+	}
+}
+
+func (c *watchCache) List() []interface{} {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.store.List()
+}
+
+func (c *watchCache) ListKeys() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.store.ListKeys()
+}
+
+func (c *watchCache) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.store.Get(obj)
+}
+
+func (c *watchCache) GetByKey(key string) (item interface{}, exists bool, err error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.store.GetByKey(key)
+}
+
+func (c *watchCache) Resync() error {
+	return nil
+}
+
+func parseResourceVersion(obj metav1.Object) uint64 {
+	rv, _ := strconv.ParseUint(obj.GetResourceVersion(), 10, 64)
+	return rv
+}
+
+func formatResourceVersion(rv uint64) string {
+	if rv == 0 {
+		return ""
+	}
+	return strconv.FormatUint(rv, 10)
+}
+
+// cacheWatcher delivers the events of the watchCache to a session. It never blocks the cache: the watcher is closed
+// once its buffer is full, then the session resumes it from the last delivered resourceVersion.
+type cacheWatcher struct {
+	result   chan watch.Event
+	onStop   func()
+	stopOnce sync.Once
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+var _ watch.Interface = (*cacheWatcher)(nil)
+
+func newCacheWatcher(initEvents []watch.Event, onStop func()) *cacheWatcher {
+	w := &cacheWatcher{
+		result: make(chan watch.Event, len(initEvents)+watcherBufferSize),
+		onStop: onStop,
+	}
+	for _, e := range initEvents {
+		w.result <- e
+	}
+	return w
+}
+
+func (w *cacheWatcher) add(event watch.Event) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.result <- event:
+	default:
+		klog.Warning("the watcher can't keep up with the events, close it to resume")
+		w.closed = true
+		close(w.result)
+	}
+}
+
+// terminate sends the error event and closes the watcher
+func (w *cacheWatcher) terminate(err apierrors.APIStatus) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	status := err.Status()
+	select {
+	case w.result <- watch.Event{Type: watch.Error, Object: &status}:
+	default:
+	}
+	w.closed = true
+	close(w.result)
+}
+
+func (w *cacheWatcher) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.result)
+	}
+}
+
+func (w *cacheWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *cacheWatcher) Stop() {
+	w.stopOnce.Do(w.onStop)
+	w.close()
+}
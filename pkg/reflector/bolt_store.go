@@ -0,0 +1,247 @@
+package reflector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/yanmxa/straw/pkg/utils"
+)
+
+var (
+	objectsBucket          = []byte("objects")
+	resourceVersionsBucket = []byte("resourceVersions")
+)
+
+// ResumableStore persists the resourceVersion of each cluster, the reflector of a cluster resumes watching from it
+// instead of relisting the cluster. The resourceVersion is the one the reflector reports after a relist or on a
+// bookmark, rather than the ones of the objects, e.g. the list and the bookmarks aren't older than their objects.
+type ResumableStore interface {
+	cache.Indexer
+	// ResourceVersion returns the latest resourceVersion of the cluster, or empty if the cluster should be relisted
+	ResourceVersion(cluster string) string
+	// SetResourceVersion records the resourceVersion the reflector of the cluster has synced to
+	SetResourceVersion(cluster, resourceVersion string) error
+	// ResetResourceVersion makes the cluster relisted, e.g. its objects are stale
+	ResetResourceVersion(cluster string) error
+	// ReplaceCluster replaces the objects of the cluster with the list, and records the resourceVersion of the list
+	// unless it's empty. The objects of the other clusters are kept.
+	ReplaceCluster(cluster string, list []interface{}, resourceVersion string) error
+}
+
+// BoltStore is the durable store of the reflected objects backed by a bbolt file. The objects are loaded into the
+// indexer of NewClusterIndexer on opening, and the writes go through to the file.
+type BoltStore struct {
+	cache.Indexer
+	db *bolt.DB
+}
+
+var _ ResumableStore = &BoltStore{}
+
+// NewBoltStore opens or creates the store file in the path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &BoltStore{Indexer: NewClusterIndexer(), db: db}
+	if err := s.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load creates the buckets if they're missing, and loads the persisted objects into the indexer
+func (s *BoltStore) load() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(resourceVersionsBucket); err != nil {
+			return err
+		}
+		objects, err := tx.CreateBucketIfNotExists(objectsBucket)
+		if err != nil {
+			return err
+		}
+		return objects.ForEach(func(key, value []byte) error {
+			obj := &unstructured.Unstructured{}
+			if err := obj.UnmarshalJSON(value); err != nil {
+				return fmt.Errorf("failed to decode the object %s: %v", key, err)
+			}
+			return s.Indexer.Add(obj)
+		})
+	})
+}
+
+func (s *BoltStore) Add(obj interface{}) error {
+	if err := s.put(obj); err != nil {
+		return err
+	}
+	return s.Indexer.Add(obj)
+}
+
+func (s *BoltStore) Update(obj interface{}) error {
+	if err := s.put(obj); err != nil {
+		return err
+	}
+	return s.Indexer.Update(obj)
+}
+
+func (s *BoltStore) Delete(obj interface{}) error {
+	key, err := ClusterMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return KeyError{obj, err}
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(objectsBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return err
+	}
+	return s.Indexer.Delete(obj)
+}
+
+// Replace replaces the objects of the clusters in the list by the ReplaceCluster, the objects of the other clusters are
+// kept
+func (s *BoltStore) Replace(list []interface{}, resourceVersion string) error {
+	return replaceClusters(s, list, resourceVersion)
+}
+
+// ReplaceCluster replaces the persisted objects of the cluster with the list
+func (s *BoltStore) ReplaceCluster(cluster string, list []interface{}, resourceVersion string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		objects := tx.Bucket(objectsBucket)
+		prefix := []byte(cluster + "#")
+		var stale [][]byte
+		c := objects.Cursor()
+		for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+			stale = append(stale, append([]byte{}, key...))
+		}
+		for _, key := range stale {
+			if err := objects.Delete(key); err != nil {
+				return err
+			}
+		}
+		for _, obj := range list {
+			key, value, err := encode(obj)
+			if err != nil {
+				return err
+			}
+			if err := objects.Put(key, value); err != nil {
+				return err
+			}
+		}
+		if resourceVersion == "" {
+			return nil
+		}
+		return tx.Bucket(resourceVersionsBucket).Put([]byte(cluster), []byte(resourceVersion))
+	})
+	if err != nil {
+		return err
+	}
+	return replaceIndexedCluster(s.Indexer, cluster, list)
+}
+
+func (s *BoltStore) ResourceVersion(cluster string) string {
+	resourceVersion := ""
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		resourceVersion = string(tx.Bucket(resourceVersionsBucket).Get([]byte(cluster)))
+		return nil
+	})
+	return resourceVersion
+}
+
+func (s *BoltStore) SetResourceVersion(cluster, resourceVersion string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(resourceVersionsBucket).Put([]byte(cluster), []byte(resourceVersion))
+	})
+}
+
+func (s *BoltStore) ResetResourceVersion(cluster string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(resourceVersionsBucket).Delete([]byte(cluster))
+	})
+}
+
+// Close closes the store file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// put persists the object
+func (s *BoltStore) put(obj interface{}) error {
+	key, value, err := encode(obj)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(objectsBucket).Put(key, value)
+	})
+}
+
+func encode(obj interface{}) ([]byte, []byte, error) {
+	key, err := ClusterMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, nil, KeyError{obj, err}
+	}
+	value, err := json.Marshal(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(key), value, nil
+}
+
+// replaceClusters replaces the objects of each cluster in the list by the ReplaceCluster of the store
+func replaceClusters(store ResumableStore, list []interface{}, resourceVersion string) error {
+	clusters := map[string][]interface{}{}
+	for _, obj := range list {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return KeyError{obj, err}
+		}
+		cluster := accessor.GetLabels()[utils.ClusterLabelKey]
+		clusters[cluster] = append(clusters[cluster], obj)
+	}
+	for cluster, objs := range clusters {
+		if err := store.ReplaceCluster(cluster, objs, resourceVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceIndexedCluster replaces the objects of the cluster in the indexer of NewClusterIndexer with the list
+func replaceIndexedCluster(indexer cache.Indexer, cluster string, list []interface{}) error {
+	keys := make(map[string]bool, len(list))
+	for _, obj := range list {
+		key, err := ClusterMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			return KeyError{obj, err}
+		}
+		keys[key] = true
+		if err := indexer.Update(obj); err != nil {
+			return err
+		}
+	}
+	objs, err := indexer.ByIndex(ClusterIndex, cluster)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		key, err := ClusterMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			return KeyError{obj, err}
+		}
+		if keys[key] {
+			continue
+		}
+		if err := indexer.Delete(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package reflector
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reflector.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withRV := func(obj *unstructured.Unstructured, resourceVersion string) *unstructured.Unstructured {
+		obj.SetResourceVersion(resourceVersion)
+		return obj
+	}
+	for _, obj := range []*unstructured.Unstructured{
+		withRV(newSecret("cluster1", "foo"), "10"),
+		withRV(newSecret("cluster1", "bar"), "11"),
+		withRV(newSecret("cluster2", "foo"), "5"),
+	} {
+		if err := store.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Update(withRV(newSecret("cluster2", "foo"), "6")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(withRV(newSecret("cluster1", "foo"), "10")); err != nil {
+		t.Fatal(err)
+	}
+	// the resourceVersions are the ones reported by the reflectors rather than the ones of the objects
+	if rv := store.ResourceVersion("cluster1"); rv != "" {
+		t.Fatalf("expected no resourceVersion of cluster1 recorded by its objects, got %q", rv)
+	}
+	for cluster, resourceVersion := range map[string]string{"cluster1": "15", "cluster2": "6"} {
+		if err := store.SetResourceVersion(cluster, resourceVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the objects and the resourceVersions are restored after the restart
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if keys := store.ListKeys(); len(keys) != 2 {
+		t.Fatalf("expected the 2 persisted objects, got %v", keys)
+	}
+	obj, exists, err := store.GetByKey("cluster2#default/foo")
+	if err != nil || !exists || obj.(*unstructured.Unstructured).GetResourceVersion() != "6" {
+		t.Fatalf("expected the updated object of cluster2, got %v(exists=%v), %v", obj, exists, err)
+	}
+	if objs, err := store.ByIndex(ClusterIndex, "cluster1"); err != nil || len(objs) != 1 {
+		t.Fatalf("expected the indexed object of cluster1, got %v, %v", objs, err)
+	}
+	for cluster, expected := range map[string]string{"cluster1": "15", "cluster2": "6", "cluster3": ""} {
+		if rv := store.ResourceVersion(cluster); rv != expected {
+			t.Fatalf("expected the resourceVersion %q of %s, got %q", expected, cluster, rv)
+		}
+	}
+
+	// the persisted clusters are resumed, and the unavailable ones are relisted once they come back
+	r := NewReflectorFactoryWithStore(context.Background(),
+		schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, nil, time.Minute, store)
+	r.RegisterRefactor("cluster1")
+	if rv := r.clusters["cluster1"].resumeResourceVersion; rv != "15" {
+		t.Fatalf("expected cluster1 resumed from 15, got %q", rv)
+	}
+	r.Registry().Heartbeat("cluster2")
+	queue := r.clusters["cluster2"].store.(cache.ResourceVersionUpdater)
+	r.Registry().Unregister("cluster2")
+	if rv := store.ResourceVersion("cluster2"); rv != "" {
+		t.Fatalf("expected the resourceVersion of the stale cluster2 reset, got %q", rv)
+	}
+	// the bookmark of the stopped reflector doesn't resume the stale cluster2
+	queue.UpdateResourceVersion("7")
+	if rv := store.ResourceVersion("cluster2"); rv != "" {
+		t.Fatalf("expected the resourceVersion of the stale cluster2 kept reset, got %q", rv)
+	}
+	r.RegisterRefactor("cluster2")
+	if rv := r.clusters["cluster2"].resumeResourceVersion; rv != "" {
+		t.Fatalf("expected cluster2 relisted, got the resourceVersion %q", rv)
+	}
+}
+
+func TestBoltStoreReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reflector.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []*unstructured.Unstructured{
+		newSecret("cluster1", "foo"), newSecret("cluster1", "bar"), newSecret("cluster2", "foo"),
+	} {
+		if err := store.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the relist of cluster1 only replaces the objects of cluster1
+	if err := store.Replace([]interface{}{newSecret("cluster1", "baz")}, ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"cluster1#default/baz", "cluster2#default/foo"}
+	if keys := store.ListKeys(); !sets.NewString(keys...).Equal(sets.NewString(expected...)) {
+		t.Fatalf("expected %v, got %v", expected, keys)
+	}
+	// the empty relist of cluster2 removes its objects
+	if err := store.ReplaceCluster("cluster2", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if keys := store.ListKeys(); len(keys) != 1 || keys[0] != "cluster1#default/baz" {
+		t.Fatalf("expected the persisted object of cluster1, got %v", keys)
+	}
+}
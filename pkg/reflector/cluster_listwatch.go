@@ -0,0 +1,100 @@
+package reflector
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/yanmxa/straw/pkg/listwatch"
+	"github.com/yanmxa/straw/pkg/utils"
+)
+
+// clusterListerWatcher lists and watches the resource from the provider of the cluster, and labels the objects with
+// the cluster, so that they're keyed by the ClusterMetaNamespaceKeyFunc in the shared queue
+type clusterListerWatcher struct {
+	listwatch.EventListWatcher
+	cluster string
+}
+
+func newClusterListerWatcher(ctx context.Context, t cloudevents.Client, gvr schema.GroupVersionResource,
+	cluster string,
+) *clusterListerWatcher {
+	return &clusterListerWatcher{
+		EventListWatcher: listwatch.NewClusterEventListWatcher(ctx, t, metav1.NamespaceAll, gvr,
+			utils.HubClusterName, cluster),
+		cluster: cluster,
+	}
+}
+
+func (lw *clusterListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	list, err := lw.EventListWatcher.List(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := meta.EachListItem(list, lw.label); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (lw *clusterListerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := lw.EventListWatcher.Watch(options)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		if in.Type != watch.Error && in.Type != watch.Bookmark {
+			if err := lw.label(in.Object); err != nil {
+				return in, false
+			}
+		}
+		return in, true
+	}), nil
+}
+
+func (lw *clusterListerWatcher) label(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	labels := accessor.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[utils.ClusterLabelKey] = lw.cluster
+	accessor.SetLabels(labels)
+	return nil
+}
+
+// clusterQueue feeds the shared queue with the objects of the cluster, its Replace keeps the objects of the other
+// clusters
+type clusterQueue struct {
+	*DeltaFIFO
+	cluster string
+	// synced is invoked with the resourceVersion the reflector reports after a relist or on a bookmark, once the
+	// deltas queued before it are processed
+	synced func(resourceVersion string)
+}
+
+var _ cache.ResourceVersionUpdater = &clusterQueue{}
+
+func (q *clusterQueue) Replace(list []interface{}, resourceVersion string) error {
+	if err := q.ReplaceCluster(q.cluster, list, resourceVersion); err != nil {
+		return err
+	}
+	q.UpdateResourceVersion(resourceVersion)
+	return nil
+}
+
+func (q *clusterQueue) UpdateResourceVersion(resourceVersion string) {
+	if q.synced == nil || resourceVersion == "" {
+		return
+	}
+	q.AfterQueued(func() { q.synced(resourceVersion) })
+}
@@ -23,6 +23,7 @@ import (
 	"math/rand"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -121,12 +122,16 @@ type ClusterRefactor struct {
 	// See https://github.com/kubernetes/enhancements/tree/master/keps/sig-api-machinery/3157-watch-list#design-details
 	UseWatchList bool
 
-	// internalStopCh is used to signal the reflector to stop.
+	// resumeResourceVersion is the resourceVersion the first ListAndWatch resumes watching from without listing, e.g.
+	// the one persisted before the restart
+	resumeResourceVersion string
+
+	// internalStopCh is closed to signal the reflector to stop, the cluster is stopped by the factory once its
+	// lease is expired
 	internalStopCh chan struct{}
-	// isRunning is true if the reflector is running.
-	IsRunning      bool
-	latestSyncTime time.Time
-	timeout        time.Duration
+	stopOnce       sync.Once
+	// IsRunning is true if the reflector is running, it's set by the factory and the reflector concurrently
+	IsRunning atomic.Bool
 }
 
 // NewClusterReflector creates a new Reflector object which will keep the
@@ -156,8 +161,6 @@ func NewClusterReflector(name string, typeDesc string, lw cache.ListerWatcher, e
 		watchErrorHandler:      WatchErrorHandler(DefaultWatchErrorHandler),
 		expectedType:           reflect.TypeOf(expectedType),
 		internalStopCh:         make(chan struct{}),
-		IsRunning:              false,
-		timeout:                10 * time.Minute,
 	}
 
 	if r.typeDescription == "" {
@@ -213,7 +216,7 @@ func (r *ClusterRefactor) Run(stopCh <-chan struct{}) {
 	r.attachInternalStopCh(stopCh)
 	klog.V(3).Infof("Starting reflector %s from %s", r.typeDescription, r.name)
 	wait.BackoffUntil(func() {
-		r.IsRunning = true
+		r.IsRunning.Store(true)
 		if err := r.ListAndWatch(r.internalStopCh); err != nil {
 			r.watchErrorHandler(r, err)
 		}
@@ -230,6 +233,14 @@ func (r *ClusterRefactor) ListAndWatch(stopCh <-chan struct{}) error {
 	var w watch.Interface
 	fallbackToList := !r.UseWatchList
 
+	if resourceVersion := r.resumeResourceVersion; resourceVersion != "" {
+		// only the first watch is resumed, the reflector relists once the watch is ended, e.g. by the 410 Expired
+		r.resumeResourceVersion = ""
+		klog.V(3).Infof("Resuming watching %v from %s at %q", r.typeDescription, r.name, resourceVersion)
+		r.setLastSyncResourceVersion(resourceVersion)
+		return r.watch(nil, stopCh)
+	}
+
 	if r.UseWatchList {
 		w, err = r.watchList(stopCh)
 		if w == nil && err == nil {
@@ -611,6 +622,12 @@ func (r *ClusterRefactor) watch(w watch.Interface, stopCh <-chan struct{}) error
 	}
 }
 
+// ResumeFrom makes the reflector resume watching from the resourceVersion instead of listing, it should be invoked
+// before the reflector is running
+func (r *ClusterRefactor) ResumeFrom(resourceVersion string) {
+	r.resumeResourceVersion = resourceVersion
+}
+
 // LastSyncResourceVersion is the resource version observed when last sync with the underlying store
 // The value returned is not synchronized with access to the underlying store and is not thread-safe
 func (r *ClusterRefactor) LastSyncResourceVersion() string {
@@ -621,23 +638,17 @@ func (r *ClusterRefactor) LastSyncResourceVersion() string {
 
 func (r *ClusterRefactor) attachInternalStopCh(stopChan <-chan struct{}) {
 	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-stopChan:
-				r.internalStopCh <- struct{}{}
-			case <-ticker.C:
-				if r.latestSyncTime.Add(r.timeout).Before(time.Now()) {
-					klog.Warningf("Stopping refactor %s due to timeout %s", r.name, r.timeout.String())
-					r.internalStopCh <- struct{}{}
-				}
-			}
+		select {
+		case <-stopChan:
+			r.StopRefactor()
+		case <-r.internalStopCh:
 		}
 	}()
 }
 
 func (r *ClusterRefactor) StopRefactor() {
-	r.IsRunning = false
-	r.internalStopCh <- struct{}{}
+	r.IsRunning.Store(false)
+	r.stopOnce.Do(func() {
+		close(r.internalStopCh)
+	})
 }
@@ -11,6 +11,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/clock"
 )
@@ -93,14 +94,13 @@ loop:
 						*exitOnInitialEventsEndBookmark = true
 					}
 				}
+				if rvu, ok := store.(cache.ResourceVersionUpdater); ok {
+					rvu.UpdateResourceVersion(resourceVersion)
+				}
 			default:
 				utilruntime.HandleError(fmt.Errorf("%s: unable to understand watch event %#v", name, event))
 			}
 			setLastSyncResourceVersion(resourceVersion)
-			// TODO: update the resource version to store
-			// if rvu, ok := store.(ResourceVersionUpdater); ok {
-			// 	rvu.UpdateResourceVersion(resourceVersion)
-			// }
 			eventCount++
 			if exitOnInitialEventsEndBookmark != nil && *exitOnInitialEventsEndBookmark {
 				watchDuration := clock.Since(start)
@@ -19,6 +19,7 @@ package reflector
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -117,6 +118,11 @@ type DeltaFIFO struct {
 	populated bool
 	// initialPopulationCount is the number of items inserted by the first call of Replace()
 	initialPopulationCount int
+	// populatedClusters are the clusters whose first batch of items has been inserted by ReplaceCluster()
+	populatedClusters sets.String
+	// clusterInitialKeys are the keys inserted by the first ReplaceCluster() of each cluster and not popped yet, they're
+	// popped as the initial list of the cluster
+	clusterInitialKeys sets.String
 
 	// keyFunc is used to make the key used for queued item
 	// insertion and retrieval, and should be deterministic.
@@ -139,6 +145,19 @@ type DeltaFIFO struct {
 
 	// retryOnError is whether to retry processing an object if the
 	RetryOnError bool
+
+	// popped is the number of the keys popped from the queue
+	popped int
+	// barriers are the functions invoked once the keys queued before them are popped, in the order they're added
+	barriers []deltaBarrier
+	// barrierMutex serializes the functions of the barriers, it's acquired before the lock is released
+	barrierMutex sync.Mutex
+}
+
+// deltaBarrier is the function invoked once the popped count reaches the target
+type deltaBarrier struct {
+	target int
+	f      func()
 }
 
 // TransformFunc allows for transforming an object before it will be processed.
@@ -257,6 +276,9 @@ func NewDeltaFIFOWithOptions(opts DeltaFIFOOptions) *DeltaFIFO {
 		keyFunc:      opts.KeyFunction,
 		knownObjects: opts.KnownObjects,
 
+		populatedClusters:  sets.String{},
+		clusterInitialKeys: sets.String{},
+
 		emitDeltaTypeReplaced: opts.EmitDeltaTypeReplaced,
 		transformer:           opts.Transformer,
 	}
@@ -560,6 +582,17 @@ func (f *DeltaFIFO) IsClosed() bool {
 // Pop returns a 'Deltas', which has a complete list of all the things
 // that happened to the object (deltas) while it was sitting in the queue.
 func (f *DeltaFIFO) Pop(process cache.PopProcessFunc) (interface{}, error) {
+	var due []func()
+	defer func() {
+		// the barriers are invoked once the lock is released, barrierMutex keeps them in order
+		if len(due) == 0 {
+			return
+		}
+		defer f.barrierMutex.Unlock()
+		for _, barrier := range due {
+			barrier()
+		}
+	}()
 	f.lock.Lock()
 	defer f.lock.Unlock()
 	for {
@@ -573,8 +606,9 @@ func (f *DeltaFIFO) Pop(process cache.PopProcessFunc) (interface{}, error) {
 
 			f.cond.Wait()
 		}
-		isInInitialList := !f.hasSynced_locked()
 		id := f.queue[0]
+		isInInitialList := !f.hasSynced_locked() || f.clusterInitialKeys.Has(id)
+		f.clusterInitialKeys.Delete(id)
 		f.queue = f.queue[1:]
 		depth := len(f.queue)
 		if f.initialPopulationCount > 0 {
@@ -604,12 +638,36 @@ func (f *DeltaFIFO) Pop(process cache.PopProcessFunc) (interface{}, error) {
 			f.addIfNotPresent(id, item)
 			err = e.Err
 		}
+		f.popped++
+		for len(f.barriers) > 0 && f.barriers[0].target <= f.popped {
+			due = append(due, f.barriers[0].f)
+			f.barriers = f.barriers[1:]
+		}
+		if len(due) > 0 {
+			f.barrierMutex.Lock()
+		}
 		// Don't need to copyDeltas here, because we're transferring
 		// ownership to the caller.
 		return item, err
 	}
 }
 
+// AfterQueued invokes the function once the keys in the queue are popped, or right away if the queue is empty, e.g.
+// the resourceVersion of a relist is persisted once the objects of the relist are processed. The functions are invoked
+// in the order they're added, without holding the lock of the queue.
+func (f *DeltaFIFO) AfterQueued(barrier func()) {
+	f.lock.Lock()
+	if len(f.queue) > 0 {
+		f.barriers = append(f.barriers, deltaBarrier{target: f.popped + len(f.queue), f: barrier})
+		f.lock.Unlock()
+		return
+	}
+	f.barrierMutex.Lock()
+	f.lock.Unlock()
+	defer f.barrierMutex.Unlock()
+	barrier()
+}
+
 // Replace atomically does two things: (1) it adds the given objects
 // using the Sync or Replace DeltaType and then (2) it does some deletions.
 // In particular: for every pre-existing key K that is not the key of
@@ -622,6 +680,40 @@ func (f *DeltaFIFO) Pop(process cache.PopProcessFunc) (interface{}, error) {
 func (f *DeltaFIFO) Replace(list []interface{}, _ string) error {
 	f.lock.Lock()
 	defer f.lock.Unlock()
+	return f.replaceLocked(list, func(string) bool { return true })
+}
+
+// ReplaceCluster is the Replace scoped to the objects of the cluster, which are keyed by the
+// ClusterMetaNamespaceKeyFunc, so that the objects of the other clusters sharing the queue are kept.
+func (f *DeltaFIFO) ReplaceCluster(cluster string, list []interface{}, _ string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.replaceLocked(list, func(key string) bool { return strings.HasPrefix(key, cluster+"#") }); err != nil {
+		return err
+	}
+
+	if !f.populatedClusters.Has(cluster) {
+		f.populatedClusters.Insert(cluster)
+		for _, item := range list {
+			if key, err := f.KeyOf(item); err == nil {
+				f.clusterInitialKeys.Insert(key)
+			}
+		}
+	}
+	return nil
+}
+
+// ResumeCluster marks the objects of the cluster as populated without its first ReplaceCluster, since they're
+// restored from a persistent store and the cluster is resumed from its last resourceVersion
+func (f *DeltaFIFO) ResumeCluster(cluster string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.populated = true
+	f.populatedClusters.Insert(cluster)
+}
+
+// replaceLocked replaces the objects whose keys are in the scope with the list
+func (f *DeltaFIFO) replaceLocked(list []interface{}, inScope func(key string) bool) error {
 	keys := make(sets.String, len(list))
 
 	// keep backwards compat for old clients
@@ -645,7 +737,7 @@ func (f *DeltaFIFO) Replace(list []interface{}, _ string) error {
 	// Do deletion detection against objects in the queue
 	queuedDeletions := 0
 	for k, oldItem := range f.items {
-		if keys.Has(k) {
+		if keys.Has(k) || !inScope(k) {
 			continue
 		}
 		// Delete pre-existing items not in the new list.
@@ -670,7 +762,7 @@ func (f *DeltaFIFO) Replace(list []interface{}, _ string) error {
 		// Detect deletions for objects not present in the queue, but present in KnownObjects
 		knownKeys := f.knownObjects.ListKeys()
 		for _, k := range knownKeys {
-			if keys.Has(k) {
+			if keys.Has(k) || !inScope(k) {
 				continue
 			}
 			if len(f.items[k]) > 0 {
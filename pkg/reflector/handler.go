@@ -0,0 +1,126 @@
+package reflector
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/utils/clock"
+
+	"github.com/yanmxa/straw/pkg/utils"
+)
+
+// ClusterEventHandler is the cache.ResourceEventHandler of the objects reflected from the clusters, which are notified
+// with the cluster of the objects. The isInInitialList is true for the objects added by the first list of a cluster.
+// The handlers are invoked in the order of the deltas, so they shouldn't block.
+type ClusterEventHandler interface {
+	OnAdd(cluster string, obj interface{}, isInInitialList bool)
+	OnUpdate(cluster string, oldObj, newObj interface{})
+	OnDelete(cluster string, obj interface{})
+}
+
+// ClusterEventHandlerFuncs is an adaptor to let you easily specify as many or as few of the notification functions as
+// you want while still implementing ClusterEventHandler.
+type ClusterEventHandlerFuncs struct {
+	AddFunc    func(cluster string, obj interface{}, isInInitialList bool)
+	UpdateFunc func(cluster string, oldObj, newObj interface{})
+	DeleteFunc func(cluster string, obj interface{})
+}
+
+func (f ClusterEventHandlerFuncs) OnAdd(cluster string, obj interface{}, isInInitialList bool) {
+	if f.AddFunc != nil {
+		f.AddFunc(cluster, obj, isInInitialList)
+	}
+}
+
+func (f ClusterEventHandlerFuncs) OnUpdate(cluster string, oldObj, newObj interface{}) {
+	if f.UpdateFunc != nil {
+		f.UpdateFunc(cluster, oldObj, newObj)
+	}
+}
+
+func (f ClusterEventHandlerFuncs) OnDelete(cluster string, obj interface{}) {
+	if f.DeleteFunc != nil {
+		f.DeleteFunc(cluster, obj)
+	}
+}
+
+// clusterListener is a registered handler, which is resynced every resyncPeriod if it isn't zero
+type clusterListener struct {
+	handler      ClusterEventHandler
+	resyncPeriod time.Duration
+	nextResync   time.Time
+}
+
+// clusterProcessor distributes the notifications to the listeners, the Sync notifications of a resync are only
+// distributed to the listeners due to resync, like the sharedProcessor of the client-go
+type clusterProcessor struct {
+	mutex            sync.RWMutex
+	listeners        []*clusterListener
+	syncingListeners []*clusterListener
+	clock            clock.Clock
+}
+
+func (p *clusterProcessor) addListener(listener *clusterListener) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.listeners = append(p.listeners, listener)
+	if listener.resyncPeriod > 0 {
+		listener.nextResync = p.clock.Now().Add(listener.resyncPeriod)
+	}
+}
+
+// shouldResync returns whether any listener is due to resync, and chooses them as the syncing listeners
+func (p *clusterProcessor) shouldResync() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.syncingListeners = []*clusterListener{}
+	now := p.clock.Now()
+	for _, listener := range p.listeners {
+		if listener.resyncPeriod > 0 && !now.Before(listener.nextResync) {
+			p.syncingListeners = append(p.syncingListeners, listener)
+			listener.nextResync = now.Add(listener.resyncPeriod)
+		}
+	}
+	return len(p.syncingListeners) > 0
+}
+
+func (p *clusterProcessor) onAdd(obj interface{}, isInInitialList bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	cluster := clusterOf(obj)
+	for _, listener := range p.listeners {
+		listener.handler.OnAdd(cluster, obj, isInInitialList)
+	}
+}
+
+func (p *clusterProcessor) onUpdate(oldObj, newObj interface{}, isSync bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	listeners := p.listeners
+	if isSync {
+		listeners = p.syncingListeners
+	}
+	cluster := clusterOf(newObj)
+	for _, listener := range listeners {
+		listener.handler.OnUpdate(cluster, oldObj, newObj)
+	}
+}
+
+func (p *clusterProcessor) onDelete(obj interface{}) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	cluster := clusterOf(obj)
+	for _, listener := range p.listeners {
+		listener.handler.OnDelete(cluster, obj)
+	}
+}
+
+// clusterOf returns the cluster of the object by its cluster label
+func clusterOf(obj interface{}) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetLabels()[utils.ClusterLabelKey]
+}
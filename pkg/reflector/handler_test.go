@@ -0,0 +1,108 @@
+package reflector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// recorder records the notifications in the format of <type>:<cluster>#<namespace>/<name>
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) handler() ClusterEventHandler {
+	record := func(eventType, cluster string, obj interface{}) {
+		key, _ := ClusterMetaNamespaceKeyFunc(obj)
+		if cluster+"#" != key[:len(cluster)+1] {
+			key = "unexpected cluster " + cluster + " of " + key
+		}
+		r.events = append(r.events, eventType+":"+key)
+	}
+	return ClusterEventHandlerFuncs{
+		AddFunc: func(cluster string, obj interface{}, isInInitialList bool) {
+			record(fmt.Sprintf("add(%v)", isInInitialList), cluster, obj)
+		},
+		UpdateFunc: func(cluster string, oldObj, newObj interface{}) { record("update", cluster, newObj) },
+		DeleteFunc: func(cluster string, obj interface{}) { record("delete", cluster, obj) },
+	}
+}
+
+// expect checks the recorded events regardless of their order, since the deletions of a relist aren't ordered
+func (r *recorder) expect(t *testing.T, expected ...string) {
+	t.Helper()
+	sort.Strings(r.events)
+	sort.Strings(expected)
+	if fmt.Sprint(r.events) != fmt.Sprint(expected) {
+		t.Fatalf("expected the events %v, got %v", expected, r.events)
+	}
+	r.events = nil
+}
+
+func TestEventHandler(t *testing.T) {
+	r := NewReflectorFactory(context.Background(), schema.GroupVersionResource{Version: "v1", Resource: "secrets"},
+		nil, time.Minute)
+	clock := testingclock.NewFakeClock(time.Now())
+	r.processor.clock = clock
+	pop := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := r.deltaQueue.Pop(r.handleDeltas); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	first := &recorder{}
+	r.AddEventHandler(first.handler())
+
+	// the first list of each cluster is the initial list
+	if err := r.deltaQueue.ReplaceCluster("cluster1", []interface{}{newSecret("cluster1", "foo")}, ""); err != nil {
+		t.Fatal(err)
+	}
+	pop(1)
+	if err := r.deltaQueue.Add(newSecret("cluster1", "bar")); err != nil {
+		t.Fatal(err)
+	}
+	pop(1)
+	if err := r.deltaQueue.ReplaceCluster("cluster2", []interface{}{newSecret("cluster2", "foo")}, ""); err != nil {
+		t.Fatal(err)
+	}
+	pop(1)
+	first.expect(t, "add(true):cluster1#default/foo", "add(false):cluster1#default/bar",
+		"add(true):cluster2#default/foo")
+
+	// the handler added later is notified of the reflected objects as the initial list, and resynced periodically
+	second := &recorder{}
+	r.AddEventHandlerWithResyncPeriod(second.handler(), time.Minute)
+	second.expect(t, "add(true):cluster1#default/foo", "add(true):cluster1#default/bar",
+		"add(true):cluster2#default/foo")
+
+	r.resync()
+	if keys := r.deltaQueue.ListKeys(); len(keys) != 0 {
+		t.Fatalf("expected no resync before the period, got %v", keys)
+	}
+	clock.Step(time.Minute)
+	r.resync()
+	pop(3)
+	first.expect(t)
+	second.expect(t, "update:cluster1#default/foo", "update:cluster1#default/bar", "update:cluster2#default/foo")
+
+	// the relist of a cluster isn't the initial list, and the removed objects are deleted
+	if err := r.deltaQueue.ReplaceCluster("cluster1", []interface{}{newSecret("cluster1", "baz")}, ""); err != nil {
+		t.Fatal(err)
+	}
+	pop(3)
+	first.expect(t, "add(false):cluster1#default/baz", "delete:cluster1#default/bar", "delete:cluster1#default/foo")
+	second.events = nil
+
+	// the stale objects of the unavailable cluster are notified as updated
+	r.markStale("cluster2")
+	first.expect(t, "update:cluster2#default/foo")
+	second.expect(t, "update:cluster2#default/foo")
+}
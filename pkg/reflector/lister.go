@@ -0,0 +1,157 @@
+package reflector
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/yanmxa/straw/pkg/utils"
+)
+
+const (
+	// ClusterIndex indexes the cached objects by the cluster they're reflected from
+	ClusterIndex = "cluster"
+	// ClusterNamespaceIndex indexes the cached objects by <cluster>/<namespace>
+	ClusterNamespaceIndex = "cluster-namespace"
+	// LabelIndex indexes the cached objects by each of their labels in <key>=<value>
+	LabelIndex = "label"
+)
+
+// ClusterIndexFunc indexes the objects by the cluster label
+func ClusterIndexFunc(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return []string{accessor.GetLabels()[utils.ClusterLabelKey]}, nil
+}
+
+// ClusterNamespaceIndexFunc indexes the objects by the cluster label and the namespace
+func ClusterNamespaceIndexFunc(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return []string{clusterNamespace(accessor.GetLabels()[utils.ClusterLabelKey], accessor.GetNamespace())}, nil
+}
+
+// LabelIndexFunc indexes the objects by their labels
+func LabelIndexFunc(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(accessor.GetLabels()))
+	for key, value := range accessor.GetLabels() {
+		values = append(values, key+"="+value)
+	}
+	return values, nil
+}
+
+// NewClusterIndexer creates the store keyed by the ClusterMetaNamespaceKeyFunc with the cluster, namespace and label
+// indexes
+func NewClusterIndexer() cache.Indexer {
+	return cache.NewIndexer(ClusterMetaNamespaceKeyFunc, cache.Indexers{
+		ClusterIndex:          ClusterIndexFunc,
+		ClusterNamespaceIndex: ClusterNamespaceIndexFunc,
+		LabelIndex:            LabelIndexFunc,
+	})
+}
+
+func clusterNamespace(cluster, namespace string) string {
+	return cluster + "/" + namespace
+}
+
+// ClusterLister lists the latest objects reflected from the clusters by the indexes of NewClusterIndexer
+type ClusterLister struct {
+	indexer cache.Indexer
+}
+
+func NewClusterLister(indexer cache.Indexer) *ClusterLister {
+	return &ClusterLister{indexer: indexer}
+}
+
+// List lists the objects of all the clusters matching the selector. The candidates are narrowed by the label index if
+// the selector requires a label to equal some values.
+func (l *ClusterLister) List(selector labels.Selector) ([]runtime.Object, error) {
+	candidates, err := l.candidates(selector)
+	if err != nil {
+		return nil, err
+	}
+	return filter(candidates, selector)
+}
+
+// ByCluster lists the objects of the cluster
+func (l *ClusterLister) ByCluster(cluster string) ([]runtime.Object, error) {
+	objs, err := l.indexer.ByIndex(ClusterIndex, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return filter(objs, labels.Everything())
+}
+
+// ByNamespace lists the objects in the namespace of the cluster
+func (l *ClusterLister) ByNamespace(cluster, namespace string) ([]runtime.Object, error) {
+	objs, err := l.indexer.ByIndex(ClusterNamespaceIndex, clusterNamespace(cluster, namespace))
+	if err != nil {
+		return nil, err
+	}
+	return filter(objs, labels.Everything())
+}
+
+// Get gets the object of the cluster by the namespace and name
+func (l *ClusterLister) Get(cluster, namespace, name string) (runtime.Object, bool, error) {
+	key := cluster + "#" + name
+	if namespace != "" {
+		key = cluster + "#" + namespace + "/" + name
+	}
+	obj, exists, err := l.indexer.GetByKey(key)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	runtimeObj, ok := obj.(runtime.Object)
+	return runtimeObj, ok, nil
+}
+
+func (l *ClusterLister) candidates(selector labels.Selector) ([]interface{}, error) {
+	requirements, selectable := selector.Requirements()
+	if !selectable {
+		return nil, nil
+	}
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+		default:
+			continue
+		}
+		candidates := []interface{}{}
+		for _, value := range requirement.Values().List() {
+			objs, err := l.indexer.ByIndex(LabelIndex, requirement.Key()+"="+value)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, objs...)
+		}
+		return candidates, nil
+	}
+	return l.indexer.List(), nil
+}
+
+func filter(objs []interface{}, selector labels.Selector) ([]runtime.Object, error) {
+	ret := make([]runtime.Object, 0, len(objs))
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		if !selector.Matches(labels.Set(accessor.GetLabels())) {
+			continue
+		}
+		if runtimeObj, ok := obj.(runtime.Object); ok {
+			ret = append(ret, runtimeObj)
+		}
+	}
+	return ret, nil
+}
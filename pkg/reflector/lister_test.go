@@ -0,0 +1,127 @@
+package reflector
+
+import (
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func keys(t *testing.T, objs []runtime.Object, err error) []string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := []string{}
+	for _, obj := range objs {
+		key, err := ClusterMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func setLabel(obj *unstructured.Unstructured, key, value string) {
+	labels := obj.GetLabels()
+	labels[key] = value
+	obj.SetLabels(labels)
+}
+
+func TestClusterLister(t *testing.T) {
+	store := NewClusterIndexer()
+	objs := []*unstructured.Unstructured{
+		newSecret("cluster1", "foo"), newSecret("cluster1", "bar"), newSecret("cluster2", "foo"),
+	}
+	objs[1].SetNamespace("kube-system")
+	for i, app := range []string{"web", "db", "web"} {
+		setLabel(objs[i], "app", app)
+		if err := store.Add(objs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lister := NewClusterLister(store)
+
+	cases := []struct {
+		name     string
+		list     func() ([]runtime.Object, error)
+		expected []string
+	}{
+		{
+			name:     "by cluster",
+			list:     func() ([]runtime.Object, error) { return lister.ByCluster("cluster1") },
+			expected: []string{"cluster1#default/foo", "cluster1#kube-system/bar"},
+		},
+		{
+			name:     "by namespace",
+			list:     func() ([]runtime.Object, error) { return lister.ByNamespace("cluster1", "default") },
+			expected: []string{"cluster1#default/foo"},
+		},
+		{
+			name:     "everything",
+			list:     func() ([]runtime.Object, error) { return lister.List(labels.Everything()) },
+			expected: []string{"cluster1#default/foo", "cluster1#kube-system/bar", "cluster2#default/foo"},
+		},
+		{
+			name: "indexed label",
+			list: func() ([]runtime.Object, error) {
+				return lister.List(labels.SelectorFromSet(labels.Set{"app": "web"}))
+			},
+			expected: []string{"cluster1#default/foo", "cluster2#default/foo"},
+		},
+		{
+			name: "label without index",
+			list: func() ([]runtime.Object, error) {
+				selector, err := labels.Parse("app!=web")
+				if err != nil {
+					return nil, err
+				}
+				return lister.List(selector)
+			},
+			expected: []string{"cluster1#kube-system/bar"},
+		},
+		{
+			name:     "nothing",
+			list:     func() ([]runtime.Object, error) { return lister.List(labels.Nothing()) },
+			expected: []string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objs, err := c.list()
+			actual := keys(t, objs, err)
+			if len(actual) != len(c.expected) {
+				t.Fatalf("expected %v, got %v", c.expected, actual)
+			}
+			for i := range actual {
+				if actual[i] != c.expected[i] {
+					t.Fatalf("expected %v, got %v", c.expected, actual)
+				}
+			}
+		})
+	}
+
+	// the latest object of the cluster is listed after the update
+	updated := objs[2].DeepCopy()
+	setLabel(updated, "app", "db")
+	if err := store.Update(updated); err != nil {
+		t.Fatal(err)
+	}
+	dbs, err := lister.List(labels.SelectorFromSet(labels.Set{"app": "db"}))
+	actual := keys(t, dbs, err)
+	if len(actual) != 2 || actual[1] != "cluster2#default/foo" {
+		t.Fatalf("expected the updated object of cluster2, got %v", actual)
+	}
+	obj, exists, err := lister.Get("cluster2", "default", "foo")
+	if err != nil || !exists {
+		t.Fatalf("expected the object of cluster2, got %v, %v", exists, err)
+	}
+	if accessor, _ := meta.Accessor(obj); accessor.GetLabels()["app"] != "db" {
+		t.Fatalf("expected the updated label, got %v", accessor.GetLabels())
+	}
+}
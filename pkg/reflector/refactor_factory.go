@@ -19,43 +19,125 @@ package reflector
 import (
 	"context"
 	"errors"
-	"fmt"
 	"sync"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 
 	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/cluster"
+	"github.com/yanmxa/straw/pkg/utils"
 )
 
 // Reflector watches a specified resource and causes all changes to be reflected in the given store.
 type ReflectorFactory struct {
-	ctx        context.Context
-	clusters   map[string]*ClusterRefactor
-	deltaQueue cache.Queue
-	mutex      sync.RWMutex
-	transport  cloudevents.Client
-	gvr        schema.GroupVersionResource
+	ctx      context.Context
+	clusters map[string]*ClusterRefactor
+	// listWatchers receive the responses of the providers, keyed by the cluster
+	listWatchers map[string]*clusterListerWatcher
+	deltaQueue   *DeltaFIFO
+	// store caches the objects of all the clusters, it's also the known objects of the delta queue
+	store     cache.Indexer
+	registry  *cluster.Registry
+	mutex     sync.RWMutex
+	transport cloudevents.Client
+	gvr       schema.GroupVersionResource
+	// stopCh is set once the factory is running, so that the registered reflectors are started right away
+	stopCh <-chan struct{}
+	// processor notifies the handlers of the changes to the store, the processMutex serializes the changes with the
+	// registration of the handlers
+	processor    *clusterProcessor
+	processMutex sync.Mutex
+	// resourceVersionMutex serializes the resourceVersions persisted by the reflectors and the reset of the stale
+	// clusters
+	resourceVersionMutex sync.Mutex
 }
 
-func NewReflectorFactory(ctx context.Context, gvr schema.GroupVersionResource, t cloudevents.Client) *ReflectorFactory {
+// NewReflectorFactory creates the factory reflecting the resource from the clusters registered by the heartbeats, a
+// cluster is unregistered once its lease isn't renewed in the leaseDuration.
+func NewReflectorFactory(ctx context.Context, gvr schema.GroupVersionResource, t cloudevents.Client,
+	leaseDuration time.Duration,
+) *ReflectorFactory {
+	return NewReflectorFactoryWithStore(ctx, gvr, t, leaseDuration, NewClusterIndexer())
+}
+
+// NewReflectorFactoryWithStore creates the factory caching the objects in the store, which should be indexed like the
+// NewClusterIndexer. If it's a ResumableStore(e.g. the BoltStore), the clusters are resumed from their persisted
+// resourceVersions instead of relisting.
+func NewReflectorFactoryWithStore(ctx context.Context, gvr schema.GroupVersionResource, t cloudevents.Client,
+	leaseDuration time.Duration, store cache.Indexer,
+) *ReflectorFactory {
 	fifo := NewDeltaFIFOWithOptions(DeltaFIFOOptions{
-		KnownObjects:          cache.NewIndexer(cache.DeletionHandlingMetaNamespaceKeyFunc, nil),
+		KnownObjects:          store,
 		EmitDeltaTypeReplaced: true,
 		KeyFunction:           ClusterMetaNamespaceKeyFunc,
 		// Transformer:           s.transform,
 	})
-	return &ReflectorFactory{
-		ctx:        ctx,
-		clusters:   map[string]*ClusterRefactor{},
-		transport:  t,
-		gvr:        gvr,
-		deltaQueue: fifo,
+	r := &ReflectorFactory{
+		ctx:          ctx,
+		clusters:     map[string]*ClusterRefactor{},
+		listWatchers: map[string]*clusterListerWatcher{},
+		transport:    t,
+		gvr:          gvr,
+		deltaQueue:   fifo,
+		store:        store,
+		processor:    &clusterProcessor{clock: clock.RealClock{}},
+	}
+	r.registry = cluster.NewRegistry(leaseDuration, r.RegisterRefactor, func(name string) {
+		r.UnregisterRefactor(name)
+		r.markStale(name)
+	})
+	return r
+}
+
+// AddEventHandler adds the handler of the objects reflected from the clusters, the objects already reflected are
+// notified to the handler as the initial list.
+func (r *ReflectorFactory) AddEventHandler(handler ClusterEventHandler) {
+	r.AddEventHandlerWithResyncPeriod(handler, 0)
+}
+
+// AddEventHandlerWithResyncPeriod adds the handler like AddEventHandler, and the handler is notified of all the objects
+// by OnUpdate every resyncPeriod. The zero resyncPeriod means no resync.
+func (r *ReflectorFactory) AddEventHandlerWithResyncPeriod(handler ClusterEventHandler, resyncPeriod time.Duration) {
+	r.processMutex.Lock()
+	defer r.processMutex.Unlock()
+	for _, obj := range r.store.List() {
+		handler.OnAdd(clusterOf(obj), obj, true)
+	}
+	r.processor.addListener(&clusterListener{handler: handler, resyncPeriod: resyncPeriod})
+}
+
+// resync queues all the objects as Sync deltas if any handler is due to resync
+func (r *ReflectorFactory) resync() {
+	if !r.processor.shouldResync() {
+		return
 	}
+	if err := r.deltaQueue.Resync(); err != nil {
+		klog.Errorf("failed to resync %s: %v", apis.ToGVRString(r.gvr), err)
+	}
+}
+
+// Lister returns the lister of the objects reflected from the clusters
+func (r *ReflectorFactory) Lister() *ClusterLister {
+	return NewClusterLister(r.store)
+}
+
+// Registry returns the liveness of the clusters
+func (r *ReflectorFactory) Registry() *cluster.Registry {
+	return r.registry
+}
+
+// GetStore returns the objects reflected from the clusters, the ones of the unavailable clusters are annotated with
+// the utils.StaleAnnotationKey
+func (r *ReflectorFactory) GetStore() cache.Indexer {
+	return r.store
 }
 
 // Run repeatedly uses the reflector's ListAndWatch to fetch all the
@@ -63,17 +145,31 @@ func NewReflectorFactory(ctx context.Context, gvr schema.GroupVersionResource, t
 // Run will exit when stopCh is closed.
 func (r *ReflectorFactory) Run(stopCh <-chan struct{}) {
 	klog.V(3).Infof("Starting ReflectorFactory %s", apis.ToGVRString(r.gvr))
+	r.mutex.Lock()
+	r.stopCh = stopCh
+	r.mutex.Unlock()
 	// start a goroutine to manage the cluster reflector
 	// this goroutine will receive the register and unregister event
 	go func() {
 		r.transport.StartReceiver(r.ctx, func(event cloudevents.Event) error {
 			switch event.Type() {
 			case string(apis.ModeRegister):
-				klog.Infof("Registering reflector %s", event.Source())
-				r.RegisterRefactor(event.Source())
+				klog.V(4).Infof("Received the heartbeat of %s", event.Source())
+				r.registry.Heartbeat(event.Source())
 			case string(apis.ModeUnregister):
-				klog.Infof("Unregistering reflector %s", event.Source())
-				r.UnregisterRefactor(event.Source())
+				r.registry.Unregister(event.Source())
+			default:
+				// the list and watch responses of the provider
+				r.mutex.RLock()
+				lw, ok := r.listWatchers[event.Source()]
+				r.mutex.RUnlock()
+				if !ok {
+					klog.V(4).Infof("Dropping the event %s of the unregistered cluster %s", event.Type(), event.Source())
+					return nil
+				}
+				if err := lw.Handle(event); err != nil {
+					klog.Errorf("failed to handle the event %s of %s: %v", event.Type(), event.Source(), err)
+				}
 			}
 			return nil
 		})
@@ -85,11 +181,15 @@ func (r *ReflectorFactory) Run(stopCh <-chan struct{}) {
 		r.deltaQueue.Close()
 	}()
 	go wait.Until(r.processLoop, time.Second, stopCh)
+	go wait.Until(r.resync, time.Second, stopCh)
+	go r.registry.Run(stopCh)
 
 	// refactor resource from cluster
 	wait.Until(func() {
+		r.mutex.RLock()
+		defer r.mutex.RUnlock()
 		for _, clusterRefactor := range r.clusters {
-			if !clusterRefactor.IsRunning {
+			if clusterRefactor.IsRunning.CompareAndSwap(false, true) {
 				klog.V(3).Infof("Starting reflector %s", clusterRefactor.name)
 				go clusterRefactor.Run(stopCh)
 			}
@@ -102,14 +202,96 @@ func (r *ReflectorFactory) Run(stopCh <-chan struct{}) {
 func (r *ReflectorFactory) RegisterRefactor(cluster string) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	r.clusters[cluster] = NewClusterReflector(cluster, "", nil, nil, r.deltaQueue)
+	klog.Infof("Registering reflector %s", cluster)
+	if clusterRefactor, ok := r.clusters[cluster]; ok {
+		clusterRefactor.StopRefactor()
+	}
+	lw := newClusterListerWatcher(r.ctx, r.transport, r.gvr, cluster)
+	r.listWatchers[cluster] = lw
+	queue := &clusterQueue{DeltaFIFO: r.deltaQueue, cluster: cluster}
+	clusterRefactor := NewClusterReflector(cluster, apis.ToGVRString(r.gvr), lw, nil, queue)
+	if store, ok := r.store.(ResumableStore); ok {
+		if resourceVersion := store.ResourceVersion(cluster); resourceVersion != "" {
+			r.deltaQueue.ResumeCluster(cluster)
+			clusterRefactor.ResumeFrom(resourceVersion)
+		}
+		queue.synced = func(resourceVersion string) {
+			r.resourceVersionMutex.Lock()
+			defer r.resourceVersionMutex.Unlock()
+			select {
+			case <-clusterRefactor.internalStopCh:
+				// the stopped reflector doesn't override the reset of the stale cluster
+				return
+			default:
+			}
+			if err := store.SetResourceVersion(cluster, resourceVersion); err != nil {
+				klog.Errorf("failed to persist the resourceVersion %s of %s: %v", resourceVersion, cluster, err)
+			}
+		}
+	}
+	r.clusters[cluster] = clusterRefactor
+	if r.stopCh != nil {
+		clusterRefactor.IsRunning.Store(true)
+		go clusterRefactor.Run(r.stopCh)
+	}
 }
 
 func (r *ReflectorFactory) UnregisterRefactor(cluster string) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	r.clusters[cluster].StopRefactor()
+	clusterRefactor, ok := r.clusters[cluster]
+	if !ok {
+		return
+	}
+	klog.Infof("Unregistering reflector %s", cluster)
+	clusterRefactor.StopRefactor()
 	delete(r.clusters, cluster)
+	delete(r.listWatchers, cluster)
+}
+
+// markStale annotates the cached objects of the cluster as stale, they're refreshed once the cluster is registered
+// and listed again
+func (r *ReflectorFactory) markStale(cluster string) {
+	r.processMutex.Lock()
+	defer r.processMutex.Unlock()
+	objs, err := r.store.ByIndex(ClusterIndex, cluster)
+	if err != nil {
+		klog.Errorf("failed to list the objects of %s: %v", cluster, err)
+		return
+	}
+	for _, obj := range objs {
+		runtimeObj, ok := obj.(runtime.Object)
+		if !ok {
+			continue
+		}
+		stale := runtimeObj.DeepCopyObject()
+		accessor, err := meta.Accessor(stale)
+		if err != nil {
+			continue
+		}
+		annotations := accessor.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[utils.StaleAnnotationKey] = "true"
+		accessor.SetAnnotations(annotations)
+		if err := r.store.Update(stale); err != nil {
+			klog.Errorf("failed to mark %s/%s of %s as stale: %v", accessor.GetNamespace(), accessor.GetName(),
+				cluster, err)
+			continue
+		}
+		r.processor.onUpdate(obj, stale, false)
+	}
+	klog.Infof("marked %d objects of %s as stale", len(objs), cluster)
+
+	// the stale objects are refreshed by relisting the cluster
+	if store, ok := r.store.(ResumableStore); ok {
+		r.resourceVersionMutex.Lock()
+		defer r.resourceVersionMutex.Unlock()
+		if err := store.ResetResourceVersion(cluster); err != nil {
+			klog.Errorf("failed to reset the resourceVersion of %s: %v", cluster, err)
+		}
+	}
 }
 
 // processLoop drains the work queue.
@@ -140,7 +322,9 @@ func (r *ReflectorFactory) processLoop() {
 
 func (r *ReflectorFactory) handleDeltas(obj interface{}, isInInitialList bool) error {
 	if deltas, ok := obj.(Deltas); ok {
-		return processDeltas(nil, nil, deltas, isInInitialList)
+		r.processMutex.Lock()
+		defer r.processMutex.Unlock()
+		return processDeltas(r.processor, r.store, deltas, isInInitialList)
 	}
 	return errors.New("object given as Process argument is not Deltas")
 }
@@ -151,7 +335,7 @@ var RetryOnError bool
 // a given handler of events OnUpdate, OnAdd, OnDelete
 func processDeltas(
 	// Object which receives event notifications from the given deltas
-	handler cache.ResourceEventHandler,
+	handler *clusterProcessor,
 	clientState Store,
 	deltas Deltas,
 	isInInitialList bool,
@@ -166,21 +350,38 @@ func processDeltas(
 				if err := clientState.Update(obj); err != nil {
 					return err
 				}
-				// handler.OnUpdate(old, obj)
-				fmt.Println("OnUpdate", "old", old, "new", obj)
+				isSync := false
+				switch {
+				case d.Type == Sync:
+					// Sync events are only propagated to listeners that requested resync
+					isSync = true
+				case d.Type == Replaced:
+					if accessor, err := meta.Accessor(obj); err == nil {
+						if oldAccessor, err := meta.Accessor(old); err == nil {
+							// Replaced events that didn't change resourceVersion are treated as resync events
+							// and only propagated to listeners that requested resync, unless the relist refreshes
+							// the object annotated by the markStale
+							isSync = accessor.GetResourceVersion() == oldAccessor.GetResourceVersion() &&
+								accessor.GetAnnotations()[utils.StaleAnnotationKey] ==
+									oldAccessor.GetAnnotations()[utils.StaleAnnotationKey]
+						}
+					}
+				}
+				handler.onUpdate(old, obj, isSync)
 			} else {
 				if err := clientState.Add(obj); err != nil {
 					return err
 				}
-				// handler.OnAdd(obj, isInInitialList)
-				fmt.Println("OnAdd", "new", obj)
+				handler.onAdd(obj, isInInitialList)
 			}
 		case Deleted:
+			if tombstone, ok := obj.(DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
 			if err := clientState.Delete(obj); err != nil {
 				return err
 			}
-			// handler.OnDelete(obj)
-			fmt.Println("OnDelete", "old", obj)
+			handler.onDelete(obj)
 		}
 	}
 	return nil
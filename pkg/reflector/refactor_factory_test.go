@@ -0,0 +1,89 @@
+package reflector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/yanmxa/straw/pkg/utils"
+)
+
+func newSecret(cluster, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Secret")
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{utils.ClusterLabelKey: cluster})
+	return obj
+}
+
+func TestUnregisterMarksStale(t *testing.T) {
+	r := NewReflectorFactory(context.Background(), schema.GroupVersionResource{Version: "v1", Resource: "secrets"},
+		nil, time.Minute)
+	for _, obj := range []*unstructured.Unstructured{newSecret("cluster1", "foo"), newSecret("cluster2", "foo")} {
+		if err := r.GetStore().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.Registry().Heartbeat("cluster1")
+	if _, ok := r.clusters["cluster1"]; !ok {
+		t.Fatal("expected the reflector of cluster1 to be registered")
+	}
+	r.Registry().Unregister("cluster1")
+	if _, ok := r.clusters["cluster1"]; ok {
+		t.Fatal("expected the reflector of cluster1 to be unregistered")
+	}
+
+	for cluster, stale := range map[string]bool{"cluster1": true, "cluster2": false} {
+		objs, err := r.GetStore().ByIndex(ClusterIndex, cluster)
+		if err != nil || len(objs) != 1 {
+			t.Fatalf("expected the object of %s, got %v, %v", cluster, objs, err)
+		}
+		annotations := objs[0].(*unstructured.Unstructured).GetAnnotations()
+		if (annotations[utils.StaleAnnotationKey] == "true") != stale {
+			t.Fatalf("expected the object of %s stale %v, got %v", cluster, stale, annotations)
+		}
+	}
+}
+
+func TestRelistRefreshesStale(t *testing.T) {
+	r := NewReflectorFactory(context.Background(), schema.GroupVersionResource{Version: "v1", Resource: "secrets"},
+		nil, time.Minute)
+	obj := newSecret("cluster1", "foo")
+	obj.SetResourceVersion("1")
+	if err := r.GetStore().Add(obj); err != nil {
+		t.Fatal(err)
+	}
+	updates := []*unstructured.Unstructured{}
+	r.AddEventHandler(ClusterEventHandlerFuncs{
+		UpdateFunc: func(cluster string, oldObj, newObj interface{}) {
+			updates = append(updates, newObj.(*unstructured.Unstructured))
+		},
+	})
+
+	r.markStale("cluster1")
+	if len(updates) != 1 || updates[0].GetAnnotations()[utils.StaleAnnotationKey] != "true" {
+		t.Fatalf("expected the update of the stale object, got %v", updates)
+	}
+
+	// the relist replaces the stale object with the same resourceVersion, which is notified as an update
+	if err := processDeltas(r.processor, r.store, Deltas{{Type: Replaced, Object: obj.DeepCopy()}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || updates[1].GetAnnotations()[utils.StaleAnnotationKey] != "" {
+		t.Fatalf("expected the update of the refreshed object, got %v", updates)
+	}
+
+	// the unchanged object is a resync, which isn't notified to the handler without the resync period
+	if err := processDeltas(r.processor, r.store, Deltas{{Type: Replaced, Object: obj.DeepCopy()}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("expected no update of the unchanged object, got %d updates", len(updates))
+	}
+}
@@ -0,0 +1,210 @@
+package reflector
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/utils"
+)
+
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS straw_objects (
+		cluster TEXT NOT NULL,
+		gvr TEXT NOT NULL,
+		namespace TEXT NOT NULL,
+		name TEXT NOT NULL,
+		resource_version TEXT NOT NULL,
+		deleted INTEGER NOT NULL,
+		object TEXT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		PRIMARY KEY (cluster, gvr, namespace, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS straw_resource_versions (
+		cluster TEXT NOT NULL,
+		gvr TEXT NOT NULL,
+		resource_version TEXT NOT NULL,
+		PRIMARY KEY (cluster, gvr)
+	)`,
+}
+
+// SQLStore is the store of the reflected objects writing through to the SQL tables, so that the objects of the fleet
+// can be queried from the database. Each object is a row of the straw_objects keyed by the cluster, gvr, namespace and
+// name, with the JSON object in the object column. The deleted objects are kept as the tombstones with deleted = 1
+// and their last known object. The resourceVersion each cluster is synced to is in the straw_resource_versions.
+//
+// The statements are in the dialect of SQLite(the driver "sqlite" or "sqlite3"), the other drivers are rejected, e.g.
+// MySQL can't parse the ON CONFLICT of the upserts.
+type SQLStore struct {
+	cache.Indexer
+	db  *sql.DB
+	gvr string
+}
+
+var _ ResumableStore = &SQLStore{}
+
+// NewSQLStore creates the tables if they're missing, and loads the objects of the resource into the indexer of
+// NewClusterIndexer
+func NewSQLStore(db *sql.DB, driverName string, gvr schema.GroupVersionResource) (*SQLStore, error) {
+	s := &SQLStore{
+		Indexer: NewClusterIndexer(),
+		db:      db,
+		gvr:     apis.ToGVRString(gvr),
+	}
+	switch driverName {
+	case "sqlite", "sqlite3":
+	default:
+		return nil, fmt.Errorf("the sql driver %q isn't supported, it should be sqlite or sqlite3", driverName)
+	}
+	for _, statement := range sqlSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create the table: %v", err)
+		}
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLStore) load() error {
+	rows, err := s.db.Query(`SELECT object FROM straw_objects WHERE gvr = ? AND deleted = 0`, s.gvr)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON([]byte(value)); err != nil {
+			return fmt.Errorf("failed to decode the object: %v", err)
+		}
+		if err := s.Indexer.Add(obj); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) Add(obj interface{}) error {
+	if err := s.write(func(tx *sql.Tx) error { return s.upsert(tx, obj, false) }); err != nil {
+		return err
+	}
+	return s.Indexer.Add(obj)
+}
+
+func (s *SQLStore) Update(obj interface{}) error {
+	if err := s.write(func(tx *sql.Tx) error { return s.upsert(tx, obj, false) }); err != nil {
+		return err
+	}
+	return s.Indexer.Update(obj)
+}
+
+// Delete keeps the object as a tombstone
+func (s *SQLStore) Delete(obj interface{}) error {
+	if err := s.write(func(tx *sql.Tx) error { return s.upsert(tx, obj, true) }); err != nil {
+		return err
+	}
+	return s.Indexer.Delete(obj)
+}
+
+// Replace replaces the objects of the clusters in the list by the ReplaceCluster, the objects of the other clusters are
+// kept
+func (s *SQLStore) Replace(list []interface{}, resourceVersion string) error {
+	return replaceClusters(s, list, resourceVersion)
+}
+
+// ReplaceCluster turns the objects of the cluster missing from the list into the tombstones
+func (s *SQLStore) ReplaceCluster(cluster string, list []interface{}, resourceVersion string) error {
+	err := s.write(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE straw_objects SET deleted = 1, updated_at = ?
+			WHERE cluster = ? AND gvr = ? AND deleted = 0`, time.Now().Unix(), cluster, s.gvr); err != nil {
+			return err
+		}
+		for _, obj := range list {
+			if err := s.upsert(tx, obj, false); err != nil {
+				return err
+			}
+		}
+		if resourceVersion == "" {
+			return nil
+		}
+		return setResourceVersion(tx, cluster, s.gvr, resourceVersion)
+	})
+	if err != nil {
+		return err
+	}
+	return replaceIndexedCluster(s.Indexer, cluster, list)
+}
+
+func (s *SQLStore) ResourceVersion(cluster string) string {
+	resourceVersion := ""
+	err := s.db.QueryRow(`SELECT resource_version FROM straw_resource_versions WHERE cluster = ? AND gvr = ?`,
+		cluster, s.gvr).Scan(&resourceVersion)
+	if err != nil {
+		// the cluster is relisted
+		return ""
+	}
+	return resourceVersion
+}
+
+func (s *SQLStore) SetResourceVersion(cluster, resourceVersion string) error {
+	return s.write(func(tx *sql.Tx) error { return setResourceVersion(tx, cluster, s.gvr, resourceVersion) })
+}
+
+func (s *SQLStore) ResetResourceVersion(cluster string) error {
+	_, err := s.db.Exec(`DELETE FROM straw_resource_versions WHERE cluster = ? AND gvr = ?`, cluster, s.gvr)
+	return err
+}
+
+func (s *SQLStore) write(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// upsert writes the object
+func (s *SQLStore) upsert(tx *sql.Tx, obj interface{}, deleted bool) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return KeyError{obj, err}
+	}
+	value, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	deletedValue := 0
+	if deleted {
+		deletedValue = 1
+	}
+	_, err = tx.Exec(`INSERT INTO straw_objects
+		(cluster, gvr, namespace, name, resource_version, deleted, object, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (cluster, gvr, namespace, name) DO UPDATE SET resource_version = excluded.resource_version,
+		deleted = excluded.deleted, object = excluded.object, updated_at = excluded.updated_at`,
+		accessor.GetLabels()[utils.ClusterLabelKey], s.gvr, accessor.GetNamespace(), accessor.GetName(),
+		accessor.GetResourceVersion(), deletedValue, string(value), time.Now().Unix())
+	return err
+}
+
+func setResourceVersion(tx *sql.Tx, cluster, gvr, resourceVersion string) error {
+	_, err := tx.Exec(`INSERT INTO straw_resource_versions (cluster, gvr, resource_version) VALUES (?, ?, ?)
+		ON CONFLICT (cluster, gvr) DO UPDATE SET resource_version = excluded.resource_version`,
+		cluster, gvr, resourceVersion)
+	return err
+}
@@ -0,0 +1,150 @@
+package reflector
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "reflector.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	store, err := NewSQLStore(db, "sqlite", gvr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deltas of the factory are written into the tables
+	r := NewReflectorFactoryWithStore(context.Background(), gvr, nil, time.Minute, store)
+	r.RegisterRefactor("cluster1")
+	r.RegisterRefactor("cluster2")
+	foo, bar := newSecret("cluster1", "foo"), newSecret("cluster2", "bar")
+	foo.SetResourceVersion("10")
+	bar.SetResourceVersion("20")
+	if err := r.clusters["cluster1"].store.Replace([]interface{}{foo}, "15"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.clusters["cluster2"].store.Replace([]interface{}{bar}, "25"); err != nil {
+		t.Fatal(err)
+	}
+	deleted := foo.DeepCopy()
+	deleted.SetResourceVersion("11")
+	if err := r.deltaQueue.Delete(deleted); err != nil {
+		t.Fatal(err)
+	}
+	r.clusters["cluster1"].store.(cache.ResourceVersionUpdater).UpdateResourceVersion("30")
+	// the resourceVersion of the relist is persisted once its objects are processed
+	if rv := store.ResourceVersion("cluster1"); rv != "" {
+		t.Fatalf("expected the resourceVersion of cluster1 persisted after its objects, got %q", rv)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := r.deltaQueue.Pop(r.handleDeltas); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := map[string]int{}
+	result, err := db.Query(`SELECT cluster, namespace, name, deleted FROM straw_objects WHERE gvr = 'v1.secrets.'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for result.Next() {
+		var cluster, namespace, name string
+		var deleted int
+		if err := result.Scan(&cluster, &namespace, &name, &deleted); err != nil {
+			t.Fatal(err)
+		}
+		rows[cluster+"#"+namespace+"/"+name] = deleted
+	}
+	result.Close()
+	if len(rows) != 2 || rows["cluster1#default/foo"] != 1 || rows["cluster2#default/bar"] != 0 {
+		t.Fatalf("expected the tombstone of cluster1 and the object of cluster2, got %v", rows)
+	}
+
+	// the live objects and the resourceVersions are restored from the tables
+	restored, err := NewSQLStore(db, "sqlite", gvr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := restored.ListKeys(); len(keys) != 1 || keys[0] != "cluster2#default/bar" {
+		t.Fatalf("expected the object of cluster2 restored, got %v", keys)
+	}
+	obj, _, _ := restored.GetByKey("cluster2#default/bar")
+	if obj.(*unstructured.Unstructured).GetResourceVersion() != "20" {
+		t.Fatalf("expected the restored object at 20, got %v", obj)
+	}
+	for cluster, expected := range map[string]string{"cluster1": "30", "cluster2": "25"} {
+		if rv := restored.ResourceVersion(cluster); rv != expected {
+			t.Fatalf("expected the resourceVersion %q of %s, got %q", expected, cluster, rv)
+		}
+	}
+	if err := restored.ResetResourceVersion("cluster1"); err != nil {
+		t.Fatal(err)
+	}
+	if rv := restored.ResourceVersion("cluster1"); rv != "" {
+		t.Fatalf("expected the resourceVersion of cluster1 reset, got %q", rv)
+	}
+
+	// the dialect of MySQL can't parse the upserts
+	if _, err := NewSQLStore(db, "mysql", gvr); err == nil {
+		t.Fatal("expected the mysql driver to be rejected")
+	}
+}
+
+func TestSQLStoreReplace(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "reflector.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	store, err := NewSQLStore(db, "sqlite", gvr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []*unstructured.Unstructured{
+		newSecret("cluster1", "foo"), newSecret("cluster1", "bar"), newSecret("cluster2", "foo"),
+	} {
+		if err := store.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the relist of cluster1 only tombstones the missing objects of cluster1
+	if err := store.Replace([]interface{}{newSecret("cluster1", "foo")}, ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"cluster1#default/foo", "cluster2#default/foo"}
+	if keys := store.ListKeys(); !sets.NewString(keys...).Equal(sets.NewString(expected...)) {
+		t.Fatalf("expected %v, got %v", expected, keys)
+	}
+	rows := map[string]int{}
+	result, err := db.Query(`SELECT cluster, name, deleted FROM straw_objects`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for result.Next() {
+		var cluster, name string
+		var deleted int
+		if err := result.Scan(&cluster, &name, &deleted); err != nil {
+			t.Fatal(err)
+		}
+		rows[cluster+"/"+name] = deleted
+	}
+	result.Close()
+	if len(rows) != 3 || rows["cluster1/bar"] != 1 || rows["cluster1/foo"] != 0 || rows["cluster2/foo"] != 0 {
+		t.Fatalf("expected only the tombstone of cluster1/bar, got %v", rows)
+	}
+}
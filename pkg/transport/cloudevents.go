@@ -1,16 +1,15 @@
 package transport
 
 import (
-	"context"
-	"crypto/tls"
-	"net"
+	"strings"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
-	"github.com/eclipse/paho.golang/paho"
+	"github.com/nats-io/nats.go"
 	"github.com/yanmxa/straw/pkg/option"
-	"github.com/yanmxa/straw/pkg/utils"
 
-	cemqtt "github.com/cloudevents/sdk-go/protocol/mqtt_paho/v2"
+	"github.com/cloudevents/sdk-go/protocol/kafka_sarama/v2"
+	cenats "github.com/cloudevents/sdk-go/protocol/nats/v2"
+	cejetstream "github.com/cloudevents/sdk-go/protocol/nats_jetstream/v2"
 )
 
 // define an enum with informer, provider and both values
@@ -21,36 +20,62 @@ const (
 	Provider
 )
 
-func CloudeventsClient(ctx context.Context, opt *option.Options) (
-	client cloudevents.Client, err error,
-) {
-	var conn net.Conn
-	if opt.EnableTLS {
-		tlsConfig := utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)
-		conn, err = tls.Dial("tcp", opt.Broker, tlsConfig)
-	} else {
-		conn, err = net.Dial("tcp", opt.Broker)
+func kafkaCloudeventsClient(opt *option.Options) (cloudevents.Client, error) {
+	sendTopic, err := kafkaTopic(opt.SendTopic)
+	if err != nil {
+		return nil, err
 	}
+	receiveTopic, err := kafkaTopic(opt.ReceiveTopic)
 	if err != nil {
-		return client, err
-	}
-
-	p, err := cemqtt.New(ctx,
-		&paho.ClientConfig{
-			ClientID: opt.ClientID,
-			Conn:     conn,
-		},
-		cemqtt.WithPublish(&paho.Publish{
-			Topic: opt.SendTopic,
-		}),
-		cemqtt.WithSubscribe(&paho.Subscribe{
-			Subscriptions: map[string]paho.SubscribeOptions{
-				opt.ReceiveTopic: {QoS: 0},
-			},
-		}),
-	)
+		return nil, err
+	}
+	p, err := kafka_sarama.NewProtocol(strings.Split(opt.Broker, ","), newSaramaConfig(opt), sendTopic,
+		receiveTopic, kafka_sarama.WithReceiverGroupId(kafkaGroupID(opt)))
 	if err != nil {
-		return client, err
+		return nil, err
 	}
 	return cloudevents.NewClient(p, cloudevents.WithTimeNow(), cloudevents.WithUUIDs())
 }
+
+// natsCloudeventsClient receives the events by the durable consumer of the receive topic if the stream is specified,
+// which resumes from the unacked events once the client is recreated
+func natsCloudeventsClient(opt *option.Options) (cloudevents.Client, error) {
+	natsOpt := natsOptions(opt)
+	conn, err := natsConnect(opt)
+	if err != nil {
+		return nil, err
+	}
+	sendSubject := natsSubject(natsOpt.Stream, opt.SendTopic)
+	receiveSubject := natsSubject(natsOpt.Stream, opt.ReceiveTopic)
+
+	var p interface{}
+	if natsOpt.Stream == "" {
+		p, err = cenats.NewProtocolFromConn(conn, sendSubject, receiveSubject)
+	} else {
+		p, err = natsJetStreamProtocol(conn, natsOpt, sendSubject, receiveSubject)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return cloudevents.NewClient(p, cloudevents.WithTimeNow(), cloudevents.WithUUIDs())
+}
+
+func natsJetStreamProtocol(conn *nats.Conn, natsOpt NatsOptions, sendSubject, receiveSubject string) (
+	*cejetstream.Protocol, error,
+) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureStream(js, natsOpt.Stream); err != nil {
+		return nil, err
+	}
+	durable := natsDurableName(natsOpt.Durable, receiveSubject)
+	if err := ensureDurableConsumer(js, natsOpt.Stream, durable, receiveSubject); err != nil {
+		return nil, err
+	}
+	// the event is acked once the callback returns, which is after it's received by the client
+	return cejetstream.NewProtocolFromConn(conn, natsOpt.Stream, sendSubject, receiveSubject, nil,
+		[]nats.SubOpt{nats.Bind(natsOpt.Stream, durable)})
+}
@@ -1,10 +1,16 @@
 package transport
 
 import (
+	"context"
+	"sync"
+
 	"github.com/yanmxa/straw/pkg/apis"
 )
 
-var _ Receiver = (*defaultReceiver)(nil)
+var (
+	_ Receiver    = (*defaultReceiver)(nil)
+	_ AckReceiver = (*ackReceiver)(nil)
+)
 
 type defaultReceiver struct {
 	msgChan chan apis.TransportMessage
@@ -23,3 +29,72 @@ func (r *defaultReceiver) Stop() {
 func (r *defaultReceiver) MessageChan() <-chan apis.TransportMessage {
 	return r.msgChan
 }
+
+// ackReceiver delivers the messages one by one, each deliver waits until the message is acked by the consumer
+type ackReceiver struct {
+	msgChan chan apis.TransportMessage
+	acked   chan struct{}
+	done    chan struct{}
+
+	// deliverMutex keeps a single message in flight, and the msgChan is closed only if no message is delivering
+	deliverMutex sync.Mutex
+	stopOnce     sync.Once
+}
+
+func newAckReceiver() *ackReceiver {
+	return &ackReceiver{
+		msgChan: make(chan apis.TransportMessage),
+		acked:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// deliver hands over the message and waits for the ack, it returns false if the message isn't acked before the ctx
+// is done or the receiver is stopped
+func (r *ackReceiver) deliver(ctx context.Context, msg apis.TransportMessage) bool {
+	r.deliverMutex.Lock()
+	defer r.deliverMutex.Unlock()
+	select {
+	case <-r.done:
+		return false
+	case <-r.acked:
+		// drop the ack of the message which isn't acked in time
+	default:
+	}
+
+	select {
+	case r.msgChan <- msg:
+	case <-ctx.Done():
+		return false
+	case <-r.done:
+		return false
+	}
+	select {
+	case <-r.acked:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-r.done:
+		return false
+	}
+}
+
+func (r *ackReceiver) Ack() {
+	select {
+	case r.acked <- struct{}{}:
+	default:
+	}
+}
+
+func (r *ackReceiver) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		r.deliverMutex.Lock()
+		defer r.deliverMutex.Unlock()
+		close(r.msgChan)
+	})
+}
+
+func (r *ackReceiver) MessageChan() <-chan apis.TransportMessage {
+	return r.msgChan
+}
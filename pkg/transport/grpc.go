@@ -0,0 +1,352 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"github.com/yanmxa/straw/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+var (
+	_ Transport          = (*grpcTransport)(nil)
+	_ ConnectionNotifier = (*grpcTransport)(nil)
+)
+
+const (
+	grpcConnectMethod = "/straw.transport.Transport/Connect"
+	grpcKeepAlive     = 30 * time.Second
+)
+
+// GrpcOptions are the options of the gRPC transport, they're kept in the option.Options.TransportOptions["grpc"]
+type GrpcOptions struct {
+	// SessionBuffer is the max number of the unacked messages the hub keeps for each client
+	SessionBuffer int
+	// SessionExpiry is how long the hub keeps the session of a disconnected client
+	SessionExpiry time.Duration
+}
+
+func init() {
+	option.AddTransportFlags("grpc", func(fs *flag.FlagSet) interface{} {
+		o := &GrpcOptions{}
+		fs.IntVarP(&o.SessionBuffer, "grpc-session-buffer", "", 10000,
+			"the max number of the unacked messages the gRPC hub keeps for each client to resume")
+		fs.DurationVarP(&o.SessionExpiry, "grpc-session-expiry", "", 10*time.Minute,
+			"how long the gRPC hub keeps the session of a disconnected client, it's kept forever if it isn't positive")
+		return o
+	})
+	// the agents dial the hub by grpc://hub:9443, and the hub listens on grpc-hub://0.0.0.0:9443
+	RegisterTransport("grpc", func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+		return NewGrpcTransport(ctx, withBrokerAddress(opt, broker.Host, false))
+	})
+	RegisterTransport("grpc-hub", func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+		return NewGrpcHubTransport(ctx, withBrokerAddress(opt, broker.Host, false))
+	})
+}
+
+// grpcOptions returns the gRPC options of the opt, or the defaults if they're not set
+func grpcOptions(opt *option.Options) *GrpcOptions {
+	if o, ok := opt.TransportOptions["grpc"].(*GrpcOptions); ok {
+		return o
+	}
+	return &GrpcOptions{SessionBuffer: 10000, SessionExpiry: 10 * time.Minute}
+}
+
+type grpcFrameType string
+
+const (
+	// grpcHello is the first frame of the stream: the client tells its id, the session to resume and the seq of the
+	// last message it has received, and the hub answers the session and whether it's resumed
+	grpcHello grpcFrameType = "hello"
+	// grpcSubscribe subscribes the topic filter for the client
+	grpcSubscribe grpcFrameType = "subscribe"
+	// grpcPublish carries a message of the topic, the messages from the hub are numbered by the seq of the session
+	grpcPublish grpcFrameType = "publish"
+	// grpcAck acks the messages of the session up to the seq
+	grpcAck grpcFrameType = "ack"
+)
+
+// grpcFrame is the frame of the bidi stream between the client and the hub
+type grpcFrame struct {
+	Type     grpcFrameType          `json:"type"`
+	ClientID string                 `json:"clientID,omitempty"`
+	Session  string                 `json:"session,omitempty"`
+	Resumed  bool                   `json:"resumed,omitempty"`
+	Topic    string                 `json:"topic,omitempty"`
+	Seq      uint64                 `json:"seq,omitempty"`
+	Message  *apis.TransportMessage `json:"message,omitempty"`
+}
+
+// grpcCodec encodes the frames by JSON, so that the service needn't the generated protobuf code
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (grpcCodec) Name() string                               { return "straw-json" }
+
+type grpcConnectHandler interface {
+	connect(stream grpc.ServerStream) error
+}
+
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: "straw.transport.Transport",
+	HandlerType: (*grpcConnectHandler)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Connect",
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(grpcConnectHandler).connect(stream)
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+// grpcTransport is the client of the hub(grpcHubTransport), it dials out a long-lived bidi stream to the hub, so
+// that there is no broker between the hub and the agents. It reconnects with the backoff once the stream is broken,
+// and resumes the session kept by the hub: the messages sent to it during the outage are redelivered, and the ones
+// have been received are skipped. The connection state handlers are notified only if the session isn't resumed, e.g.
+// the hub is restarted, since the messages might be lost.
+type grpcTransport struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	address  string
+	clientID string
+	conn     *grpc.ClientConn
+	backoff  wait.Backoff
+
+	mutex         sync.RWMutex
+	stream        grpc.ClientStream // nil when the stream is broken
+	receivers     map[string][]*defaultReceiver
+	stateHandlers []func(state ConnectionState)
+	// sendMutex serializes the frames sent to the stream
+	sendMutex sync.Mutex
+
+	// session and received are only accessed by the run goroutine
+	session  string
+	received uint64
+}
+
+// NewGrpcTransport dials the hub(opt.Broker) in the background, the receivers can be added before the stream is up,
+// and the sending fails until it's connected. The mTLS is enabled by the TLS options.
+func NewGrpcTransport(ctx context.Context, opt *option.Options) (*grpcTransport, error) {
+	creds := insecure.NewCredentials()
+	if opt.EnableTLS {
+		creds = credentials.NewTLS(utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey))
+	}
+	conn, err := grpc.Dial(opt.Broker, grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: grpcKeepAlive, PermitWithoutStream: true}))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t := &grpcTransport{
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		address:  opt.Broker,
+		clientID: opt.ClientID,
+		conn:     conn,
+		backoff: wait.Backoff{
+			Duration: opt.ReconnectMinBackoff,
+			Cap:      opt.ReconnectMaxBackoff,
+			Factor:   2.0,
+			Jitter:   0.1,
+			Steps:    math.MaxInt32,
+		},
+		receivers: map[string][]*defaultReceiver{},
+	}
+	go t.run()
+	return t, nil
+}
+
+// AddConnectionStateHandler registers the handler invoked when the connection is up or down
+func (t *grpcTransport) AddConnectionStateHandler(handler func(state ConnectionState)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stateHandlers = append(t.stateHandlers, handler)
+}
+
+// run keeps the stream until the context is done
+func (t *grpcTransport) run() {
+	defer close(t.done)
+
+	backoff := t.backoff
+	for {
+		err := t.connect(func() { backoff = t.backoff })
+		t.setStream(nil)
+		if t.ctx.Err() != nil {
+			return
+		}
+		delay := backoff.Step()
+		klog.Errorf("stream to the hub %s is broken, reconnect after %s: %v", t.address, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// connect opens the stream and resumes the session, then receives the messages until the stream is broken
+func (t *grpcTransport) connect(connected func()) error {
+	ctx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+	stream, err := t.conn.NewStream(ctx, &grpcServiceDesc.Streams[0], grpcConnectMethod, grpc.ForceCodec(grpcCodec{}))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&grpcFrame{
+		Type: grpcHello, ClientID: t.clientID, Session: t.session, Seq: t.received,
+	}); err != nil {
+		return err
+	}
+	hello := &grpcFrame{}
+	if err := stream.RecvMsg(hello); err != nil {
+		return err
+	}
+	if hello.Type != grpcHello {
+		return fmt.Errorf("expected the hello from the hub, got %s", hello.Type)
+	}
+
+	// the topics are subscribed even if the session is resumed, it's idempotent on the hub
+	t.mutex.Lock()
+	for topic := range t.receivers {
+		if err := stream.SendMsg(&grpcFrame{Type: grpcSubscribe, Topic: topic}); err != nil {
+			t.mutex.Unlock()
+			return err
+		}
+	}
+	t.stream = stream
+	t.mutex.Unlock()
+	connected()
+
+	reconnected := t.session != ""
+	if !hello.Resumed {
+		t.received = 0
+	}
+	t.session = hello.Session
+	klog.Infof("Connected to the hub %s, session %s(resumed: %v)", t.address, hello.Session, hello.Resumed)
+	switch {
+	case !reconnected:
+		t.notify(Connected)
+	case !hello.Resumed:
+		t.notify(Disconnected)
+		t.notify(Connected)
+	}
+
+	for {
+		frame := &grpcFrame{}
+		if err := stream.RecvMsg(frame); err != nil {
+			return err
+		}
+		if frame.Type != grpcPublish || frame.Message == nil {
+			continue
+		}
+		// skip the message has been received before the stream is resumed
+		if frame.Seq <= t.received {
+			continue
+		}
+		for _, receiver := range t.topicReceivers(frame.Topic) {
+			select {
+			case receiver.msgChan <- *frame.Message:
+			case <-t.ctx.Done():
+				return t.ctx.Err()
+			}
+		}
+		t.received = frame.Seq
+		if err := t.send(stream, &grpcFrame{Type: grpcAck, Seq: frame.Seq}); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *grpcTransport) send(stream grpc.ClientStream, frame *grpcFrame) error {
+	t.sendMutex.Lock()
+	defer t.sendMutex.Unlock()
+	return stream.SendMsg(frame)
+}
+
+func (t *grpcTransport) setStream(stream grpc.ClientStream) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stream = stream
+}
+
+func (t *grpcTransport) topicReceivers(topic string) []*defaultReceiver {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.receivers[topic]
+}
+
+func (t *grpcTransport) notify(state ConnectionState) {
+	t.mutex.RLock()
+	handlers := append([]func(ConnectionState){}, t.stateHandlers...)
+	t.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(state)
+	}
+}
+
+func (t *grpcTransport) Send(topic string, msg apis.TransportMessage) error {
+	t.mutex.RLock()
+	stream := t.stream
+	t.mutex.RUnlock()
+	if stream == nil {
+		return fmt.Errorf("the transport is disconnected from the hub %s", t.address)
+	}
+	return t.send(stream, &grpcFrame{Type: grpcPublish, Topic: topic, Message: &msg})
+}
+
+// Receive subscribes the topic filter on the hub, each receiver of the same topic gets all the messages
+func (t *grpcTransport) Receive(topic string) (Receiver, error) {
+	receiver := NewDefaultReceiver(make(chan apis.TransportMessage))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	subscribed := len(t.receivers[topic]) > 0
+	t.receivers[topic] = append(t.receivers[topic], receiver)
+	// the topic is subscribed once the stream is up if it's disconnected now
+	if subscribed || t.stream == nil {
+		return receiver, nil
+	}
+	// the broken stream is reconnected, and the topics are resubscribed on the new one
+	if err := t.send(t.stream, &grpcFrame{Type: grpcSubscribe, Topic: topic}); err != nil {
+		klog.Warningf("failed to subscribe the topic %s, it's subscribed after reconnecting: %v", topic, err)
+	}
+	return receiver, nil
+}
+
+func (t *grpcTransport) Stop() {
+	t.cancel()
+	<-t.done
+
+	t.mutex.Lock()
+	for topic, receivers := range t.receivers {
+		for _, receiver := range receivers {
+			receiver.Stop()
+		}
+		klog.Infof("transport receiver(%s) stopped!", topic)
+	}
+	t.receivers = map[string][]*defaultReceiver{}
+	t.mutex.Unlock()
+
+	if err := t.conn.Close(); err != nil {
+		klog.Error(err)
+	}
+	klog.Info("transport is disconnected!")
+}
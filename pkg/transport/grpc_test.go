@@ -0,0 +1,262 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func newGrpcTestTransports(t *testing.T, clusters ...string) (*grpcHubTransport, map[string]*grpcTransport) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hub, err := NewGrpcHubTransport(ctx, &option.Options{TLSConfig: &option.TLSConfig{}, Broker: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(hub.Stop)
+
+	clients := map[string]*grpcTransport{}
+	for _, cluster := range clusters {
+		client, err := NewGrpcTransport(ctx, &option.Options{
+			TLSConfig:           &option.TLSConfig{},
+			Broker:              hub.Addr().String(),
+			ClientID:            cluster,
+			ReconnectMinBackoff: 10 * time.Millisecond,
+			ReconnectMaxBackoff: 100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Stop)
+		clients[cluster] = client
+	}
+	return hub, clients
+}
+
+// waitForSubscriptions waits until the hub broker has the number of the subscriptions
+func waitForSubscriptions(t *testing.T, broker *MemoryBroker, count int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		broker.mutex.RLock()
+		subscriptions := len(broker.receivers)
+		broker.mutex.RUnlock()
+		if subscriptions >= count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d subscriptions, got %d", count, subscriptions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGrpcTransport(t *testing.T) {
+	hub, clients := newGrpcTestTransports(t, "cluster1", "cluster2")
+
+	hubReceiver, err := hub.Receive("straw/+/response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receivers := map[string]Receiver{}
+	for cluster, client := range clients {
+		if receivers[cluster], err = client.Receive("straw/" + cluster + "/request"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForSubscriptions(t, hub.broker, 3)
+
+	// the request of the hub is routed to the cluster1 only
+	if err := hub.Send("straw/cluster1/request", apis.TransportMessage{ID: "request"}); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, receivers["cluster1"], "request")
+	select {
+	case msg := <-receivers["cluster2"].MessageChan():
+		t.Fatalf("expected no message is routed to cluster2, got %s", msg.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := clients["cluster1"].Send("straw/cluster1/response", apis.TransportMessage{ID: "response"}); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, hubReceiver, "response")
+}
+
+func TestGrpcTransportResume(t *testing.T) {
+	hub, clients := newGrpcTestTransports(t, "cluster1")
+	client := clients["cluster1"]
+
+	var mutex sync.Mutex
+	states := []ConnectionState{}
+	client.AddConnectionStateHandler(func(state ConnectionState) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		states = append(states, state)
+	})
+	receiver, err := client.Receive("straw/cluster1/request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscriptions(t, hub.broker, 1)
+	if err := hub.Send("straw/cluster1/request", apis.TransportMessage{ID: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, receiver, "first")
+
+	// break the stream, the message sent during the outage is redelivered once the session is resumed
+	hub.mutex.Lock()
+	session := hub.sessions["cluster1"]
+	hub.mutex.Unlock()
+	session.mutex.Lock()
+	session.detach()
+	session.mutex.Unlock()
+	if err := hub.Send("straw/cluster1/request", apis.TransportMessage{ID: "missed"}); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, receiver, "missed")
+
+	hub.mutex.Lock()
+	resumed := hub.sessions["cluster1"] == session
+	hub.mutex.Unlock()
+	if !resumed {
+		t.Fatal("expected the session is resumed")
+	}
+	select {
+	case msg := <-receiver.MessageChan():
+		t.Fatalf("expected the received message isn't redelivered, got %s", msg.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// the informers needn't relist since no message is lost
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, state := range states {
+		if state == Disconnected {
+			t.Fatalf("expected the resumed session isn't notified, got %v", states)
+		}
+	}
+}
+
+func TestGrpcHubSessionExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub, err := NewGrpcHubTransport(ctx, &option.Options{
+		TLSConfig: &option.TLSConfig{},
+		Broker:    "127.0.0.1:0",
+		TransportOptions: map[string]interface{}{
+			"grpc": &GrpcOptions{SessionBuffer: 100, SessionExpiry: 100 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Stop()
+	client, err := NewGrpcTransport(ctx, &option.Options{
+		TLSConfig:           &option.TLSConfig{},
+		Broker:              hub.Addr().String(),
+		ClientID:            "cluster1",
+		ReconnectMinBackoff: 10 * time.Millisecond,
+		ReconnectMaxBackoff: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Receive("straw/cluster1/request"); err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscriptions(t, hub.broker, 1)
+
+	hasSession := func() bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		_, ok := hub.sessions["cluster1"]
+		return ok
+	}
+	// the session of the connected client is kept
+	time.Sleep(300 * time.Millisecond)
+	if !hasSession() {
+		t.Fatal("expected the session of the connected client is kept")
+	}
+
+	// the session is expired once the client is disconnected for the expiry, and its subscriptions are released
+	client.Stop()
+	deadline := time.Now().Add(10 * time.Second)
+	for hasSession() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the session to expire")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	hub.broker.mutex.RLock()
+	defer hub.broker.mutex.RUnlock()
+	if len(hub.broker.receivers) != 0 {
+		t.Fatalf("expected the subscriptions of the expired session are released, got %d", len(hub.broker.receivers))
+	}
+}
+
+// grpcTestStream is the server stream of a client, which sends the hello and then is broken
+type grpcTestStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	hello *grpcFrame
+}
+
+func (s *grpcTestStream) Context() context.Context    { return s.ctx }
+func (s *grpcTestStream) SendMsg(m interface{}) error { return nil }
+
+func (s *grpcTestStream) RecvMsg(m interface{}) error {
+	if s.hello == nil {
+		return io.EOF
+	}
+	*m.(*grpcFrame) = *s.hello
+	s.hello = nil
+	return nil
+}
+
+func TestGrpcHubVerifyClientID(t *testing.T) {
+	hub, _ := newGrpcTestTransports(t)
+	connect := func(clientID string, cert *x509.Certificate) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		return hub.connect(&grpcTestStream{ctx: ctx, hello: &grpcFrame{Type: grpcHello, ClientID: clientID}})
+	}
+	hasSession := func(clientID string) bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		_, ok := hub.sessions[clientID]
+		return ok
+	}
+
+	// the client id is the common name or a DNS name of the cert
+	if err := connect("cluster1", &x509.Certificate{Subject: pkix.Name{CommonName: "cluster1"}}); err != io.EOF {
+		t.Fatalf("expected the stream of cluster1 is served until it's broken, got %v", err)
+	}
+	if err := connect("cluster2", &x509.Certificate{DNSNames: []string{"cluster2"}}); err != io.EOF {
+		t.Fatalf("expected the stream of cluster2 is served until it's broken, got %v", err)
+	}
+	if !hasSession("cluster1") || !hasSession("cluster2") {
+		t.Fatal("expected the sessions of cluster1 and cluster2")
+	}
+
+	// the client can't take over the session of another cluster
+	err := connect("cluster3", &x509.Certificate{Subject: pkix.Name{CommonName: "cluster1"}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected the client id mismatching the cert is denied, got %v", err)
+	}
+	if hasSession("cluster3") {
+		t.Fatal("expected no session of the denied client")
+	}
+}
@@ -0,0 +1,272 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"github.com/yanmxa/straw/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+var _ Transport = (*grpcHubTransport)(nil)
+
+// grpcHubTransport is the hub of the gRPC transport, it serves the bidi streams dialed by the clients(the agents),
+// and routes the messages by the topics like a broker: the messages of the hub and the clients are delivered to all the
+// receivers subscribed to the matched topics(the MQTT semantics of the MemoryBroker) except the publisher itself,
+// including the receivers of the hub and the ones of the other clients.
+//
+// The hub keeps a session for each client(the client id, e.g. the cluster), which is bound to the common name or the DNS
+// names of the client cert if the TLS is enabled, so that the subscriptions and the unacked
+// messages(up to the GrpcOptions.SessionBuffer) survive the reconnection of the client, the session is expired once
+// the client is disconnected for the GrpcOptions.SessionExpiry.
+type grpcHubTransport struct {
+	broker        *MemoryBroker
+	local         *memoryTransport
+	server        *grpc.Server
+	listener      net.Listener
+	sessionBuffer int
+	sessionExpiry time.Duration
+	done          chan struct{}
+	stopExpiry    chan struct{}
+
+	mutex    sync.Mutex
+	sessions map[string]*hubSession
+}
+
+// NewGrpcHubTransport listens on the address(opt.Broker) for the clients, the clients are verified by the CA of the
+// TLS options if the TLS is enabled(mTLS).
+func NewGrpcHubTransport(ctx context.Context, opt *option.Options) (*grpcHubTransport, error) {
+	serverOptions := []grpc.ServerOption{
+		grpc.ForceServerCodec(grpcCodec{}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             grpcKeepAlive / 2,
+			PermitWithoutStream: true,
+		}),
+	}
+	if opt.EnableTLS {
+		// the client cert of the options is the cert of the hub
+		tlsConfig := utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)
+		tlsConfig.ClientCAs = tlsConfig.RootCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	listener, err := net.Listen("tcp", opt.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	grpcOpt := grpcOptions(opt)
+	broker := NewMemoryBroker()
+	t := &grpcHubTransport{
+		broker:        broker,
+		local:         NewMemoryTransport(broker),
+		server:        grpc.NewServer(serverOptions...),
+		listener:      listener,
+		sessionBuffer: grpcOpt.SessionBuffer,
+		sessionExpiry: grpcOpt.SessionExpiry,
+		done:          make(chan struct{}),
+		stopExpiry:    make(chan struct{}),
+		sessions:      map[string]*hubSession{},
+	}
+	t.server.RegisterService(&grpcServiceDesc, t)
+	go func() {
+		defer close(t.done)
+		if err := t.server.Serve(listener); err != nil {
+			klog.Errorf("the hub stops serving on %s: %v", opt.Broker, err)
+		}
+	}()
+	go t.expireSessions()
+	klog.Infof("the hub is listening on %s", listener.Addr())
+	return t, nil
+}
+
+// Addr returns the address the hub is listening on
+func (t *grpcHubTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+func (t *grpcHubTransport) Send(topic string, msg apis.TransportMessage) error {
+	return t.local.Send(topic, msg)
+}
+
+// Receive subscribes the topic filter, each receiver gets the messages of the hub's own clients and the other
+// receivers of the hub
+func (t *grpcHubTransport) Receive(topic string) (Receiver, error) {
+	return t.local.Receive(topic)
+}
+
+func (t *grpcHubTransport) Stop() {
+	close(t.stopExpiry)
+	t.server.Stop()
+	<-t.done
+
+	t.mutex.Lock()
+	for _, session := range t.sessions {
+		session.close()
+	}
+	t.sessions = map[string]*hubSession{}
+	t.mutex.Unlock()
+
+	t.local.Stop()
+	klog.Info("transport is disconnected!")
+}
+
+// expireSessions closes the sessions whose clients have been disconnected for the expiry, they're kept if the expiry
+// isn't positive
+func (t *grpcHubTransport) expireSessions() {
+	if t.sessionExpiry <= 0 {
+		return
+	}
+	ticker := time.NewTicker(t.sessionExpiry / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.stopExpiry:
+			return
+		}
+		t.mutex.Lock()
+		for clientID, session := range t.sessions {
+			if session.idle() > t.sessionExpiry {
+				klog.Infof("the session %s of client %s is expired", session.id, clientID)
+				session.close()
+				delete(t.sessions, clientID)
+			}
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// session returns the session of the client, it's resumed if the hello asks for the current session of the client.
+// Otherwise, a new session is started for the client, e.g. the client is restarted.
+func (t *grpcHubTransport) session(hello *grpcFrame) (*hubSession, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if session, ok := t.sessions[hello.ClientID]; ok {
+		if session.id == hello.Session {
+			return session, session.resume(hello.Seq)
+		}
+		session.close()
+	}
+	session := newHubSession(hello.ClientID, NewMemoryTransport(t.broker), t.sessionBuffer)
+	t.sessions[hello.ClientID] = session
+	return session, false
+}
+
+// connect serves the stream of a client until it's broken or replaced by a new stream of the client
+func (t *grpcHubTransport) connect(stream grpc.ServerStream) error {
+	hello := &grpcFrame{}
+	if err := stream.RecvMsg(hello); err != nil {
+		return err
+	}
+	if hello.Type != grpcHello || hello.ClientID == "" {
+		return fmt.Errorf("expected the hello with the client id, got %s", hello.Type)
+	}
+	if err := verifyClientID(peerCertificates(stream.Context()), hello.ClientID); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	session, resumed := t.session(hello)
+	ctx, detach := session.attach(stream.Context())
+	defer detach()
+	if err := stream.SendMsg(&grpcFrame{Type: grpcHello, Session: session.id, Resumed: resumed}); err != nil {
+		return err
+	}
+	klog.Infof("client %s is connected, session %s(resumed: %v)", hello.ClientID, session.id, resumed)
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- session.deliver(ctx, stream)
+	}()
+	received := make(chan error, 1)
+	go func() {
+		received <- session.receive(stream)
+	}()
+
+	var err error
+	delivered := false
+	select {
+	case err = <-received:
+	case err = <-sent:
+		delivered = true
+	case <-ctx.Done():
+		// the session is replaced by a new stream of the client, or the hub is stopped
+	}
+	// the stream can't be sent once the handler returns
+	detach()
+	if !delivered {
+		<-sent
+	}
+	klog.Infof("client %s is disconnected: %v", hello.ClientID, err)
+	return err
+}
+
+// peerCertificates returns the verified certs of the client, or nil if the TLS is disabled
+func peerCertificates(ctx context.Context) []*x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return tlsInfo.State.PeerCertificates
+}
+
+// deliver sends the queued messages to the stream, starting from the ones haven't been acked
+func (s *hubSession) deliver(ctx context.Context, stream grpc.ServerStream) error {
+	var sent uint64
+	for {
+		for _, msg := range s.pending(sent) {
+			frame := &grpcFrame{Type: grpcPublish, Topic: msg.Topic, Seq: msg.Seq, Message: &msg.Message}
+			if err := stream.SendMsg(frame); err != nil {
+				return err
+			}
+			sent = msg.Seq
+		}
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return nil
+		case <-time.After(grpcKeepAlive):
+			// recheck in case the notification is consumed by the previous stream
+		}
+	}
+}
+
+// receive handles the frames of the client until the stream is broken
+func (s *hubSession) receive(stream grpc.ServerStream) error {
+	for {
+		frame := &grpcFrame{}
+		if err := stream.RecvMsg(frame); err != nil {
+			return err
+		}
+		switch frame.Type {
+		case grpcSubscribe:
+			if err := s.subscribe(frame.Topic); err != nil {
+				return err
+			}
+		case grpcPublish:
+			if frame.Message != nil {
+				if err := s.transport.Send(frame.Topic, *frame.Message); err != nil {
+					return err
+				}
+			}
+		case grpcAck:
+			s.ack(frame.Seq)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/protocol"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	flag "github.com/spf13/pflag"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+var (
+	_ Transport          = (*httpTransport)(nil)
+	_ ConnectionNotifier = (*httpTransport)(nil)
+	_ protocol.Sender    = (*httpProtocol)(nil)
+	_ protocol.Receiver  = (*httpProtocol)(nil)
+	_ protocol.Closer    = (*httpProtocol)(nil)
+)
+
+const (
+	// httpEventsPath is the endpoint of the hub: the clients POST the events to it, and long-poll it(GET) for the
+	// events of the subscribed topics
+	httpEventsPath = "/events"
+
+	httpClientIDHeader = "Straw-Client-Id"
+	httpSessionHeader  = "Straw-Session"
+	httpResumedHeader  = "Straw-Resumed"
+
+	// the extensions of the polled events, they're removed before the events are handed over to the receivers
+	httpTopicExtension = "strawtopic"
+	httpSeqExtension   = "strawseq"
+
+	httpBatchContentType = "application/cloudevents-batch+json"
+	// httpRequestTimeout is the timeout of the POST, and the grace period of the poll besides its timeout
+	httpRequestTimeout = 30 * time.Second
+)
+
+// HttpOptions are the options of the HTTP transport, they're kept in the option.Options.TransportOptions["http"]
+type HttpOptions struct {
+	// PollTimeout is how long a poll is held by the hub if there is no event
+	PollTimeout time.Duration
+	// SessionBuffer is the max number of the unacked events the hub keeps for each client
+	SessionBuffer int
+	// SessionExpiry is how long the hub keeps the session of a client which stops polling
+	SessionExpiry time.Duration
+}
+
+func init() {
+	option.AddTransportFlags("http", func(fs *flag.FlagSet) interface{} {
+		o := &HttpOptions{}
+		fs.DurationVarP(&o.PollTimeout, "http-poll-timeout", "", 30*time.Second,
+			"how long the HTTP hub holds a poll if there is no event, it should be shorter than the idle timeout of "+
+				"the proxies between the agents and the hub")
+		fs.IntVarP(&o.SessionBuffer, "http-session-buffer", "", 10000,
+			"the max number of the unacked events the HTTP hub keeps for each client to resume")
+		fs.DurationVarP(&o.SessionExpiry, "http-session-expiry", "", 10*time.Minute,
+			"how long the HTTP hub keeps the session of a client which stops polling")
+		return o
+	})
+	// the agents call the hub by http(s)://hub.example.com/prefix, and the hub listens on http-hub://0.0.0.0:8080
+	for _, scheme := range []string{"http", "https"} {
+		RegisterTransport(scheme, func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+			return NewHttpTransport(ctx, withBrokerAddress(opt, broker.String(), false))
+		})
+		RegisterCloudeventsClient(scheme, func(ctx context.Context, broker *url.URL, opt *option.Options) (
+			cloudevents.Client, error,
+		) {
+			return httpCloudeventsClient(ctx, withBrokerAddress(opt, broker.String(), false))
+		})
+	}
+	RegisterTransport("http-hub", func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+		return NewHttpHubTransport(ctx, withBrokerAddress(opt, broker.Host, false))
+	})
+	// the hub is kept until the process exits, since the cloudevents client can't stop it
+	RegisterCloudeventsClient("http-hub", func(ctx context.Context, broker *url.URL, opt *option.Options) (
+		cloudevents.Client, error,
+	) {
+		hub, err := NewHttpHubTransport(ctx, withBrokerAddress(opt, broker.Host, false))
+		if err != nil {
+			return nil, err
+		}
+		return MemoryCloudeventsClient(hub.broker, opt.SendTopic, opt.ReceiveTopic)
+	})
+}
+
+// httpOptions returns the HTTP options of the opt, or the defaults if they're not set
+func httpOptions(opt *option.Options) *HttpOptions {
+	if o, ok := opt.TransportOptions["http"].(*HttpOptions); ok {
+		return o
+	}
+	return &HttpOptions{PollTimeout: 30 * time.Second, SessionBuffer: 10000, SessionExpiry: 10 * time.Minute}
+}
+
+// messageEvent maps the transport message to the event sent by the HTTP transport, the payload is the data
+func messageEvent(msg apis.TransportMessage) cloudevents.Event {
+	evt := cloudevents.NewEvent()
+	evt.SetID(msg.ID)
+	evt.SetType(msg.Type)
+	evt.SetSource(msg.Source)
+	contentType := cloudevents.ApplicationJSON
+	if !json.Valid(msg.Payload) {
+		contentType = "application/octet-stream"
+	}
+	_ = evt.SetData(contentType, msg.Payload)
+	return evt
+}
+
+// eventMessage maps the event back to the transport message
+func eventMessage(evt cloudevents.Event) apis.TransportMessage {
+	return apis.TransportMessage{
+		Type:    evt.Type(),
+		ID:      evt.ID(),
+		Source:  evt.Source(),
+		Payload: evt.Data(),
+	}
+}
+
+// httpClient is the client side of the HTTP hub(httpHubTransport), all the requests are the outbound HTTP(S) calls,
+// so that it works through the proxies(the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environments) where only them are allowed.
+// The events are POSTed in the binary mode of the CloudEvents HTTP binding, and the events of the subscribed topics are
+// long-polled in the batched mode. Each poll acks the events received by the previous ones, so that the events are
+// redelivered if a poll is broken, and the session kept by the hub is resumed once the hub is reachable again. The
+// connection state handlers are notified only if the session isn't resumed, like the gRPC transport.
+type httpClient struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	url         string
+	clientID    string
+	client      *http.Client
+	pollTimeout time.Duration
+	backoff     wait.Backoff
+	// handler hands over the polled event of the topic, it's invoked by the run goroutine
+	handler func(topic string, evt cloudevents.Event) error
+
+	mutex  sync.RWMutex
+	topics map[string]bool
+	// repoll cancels the current poll, so that the topic subscribed later is polled
+	repoll        context.CancelFunc
+	stateHandlers []func(state ConnectionState)
+
+	// session and received are only accessed by the run goroutine
+	session  string
+	received uint64
+}
+
+func newHttpClient(ctx context.Context, opt *option.Options,
+	handler func(topic string, evt cloudevents.Event) error,
+) (*httpClient, error) {
+	base, err := url.Parse(opt.Broker)
+	if err != nil {
+		return nil, err
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + httpEventsPath
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opt.EnableTLS {
+		transport.TLSClientConfig = utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := &httpClient{
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		url:         base.String(),
+		clientID:    opt.ClientID,
+		client:      &http.Client{Transport: transport},
+		pollTimeout: httpOptions(opt).PollTimeout,
+		backoff: wait.Backoff{
+			Duration: opt.ReconnectMinBackoff,
+			Cap:      opt.ReconnectMaxBackoff,
+			Factor:   2.0,
+			Jitter:   0.1,
+			Steps:    math.MaxInt32,
+		},
+		handler: handler,
+		topics:  map[string]bool{},
+	}
+	go c.run()
+	return c, nil
+}
+
+// AddConnectionStateHandler registers the handler invoked when the connection is up or down
+func (c *httpClient) AddConnectionStateHandler(handler func(state ConnectionState)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stateHandlers = append(c.stateHandlers, handler)
+}
+
+func (c *httpClient) notify(state ConnectionState) {
+	c.mutex.RLock()
+	handlers := append([]func(ConnectionState){}, c.stateHandlers...)
+	c.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(state)
+	}
+}
+
+// subscribe adds the topic filter to the polls, it's idempotent
+func (c *httpClient) subscribe(topic string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.topics[topic] {
+		return
+	}
+	c.topics[topic] = true
+	if c.repoll != nil {
+		c.repoll()
+	}
+}
+
+// pollTopics returns the subscribed topics, and the context of the poll which is canceled once a topic is added
+func (c *httpClient) pollTopics() ([]string, context.Context, context.CancelFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.repoll = cancel
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, ctx, cancel
+}
+
+// run polls the hub until the context is done
+func (c *httpClient) run() {
+	defer close(c.done)
+
+	backoff := c.backoff
+	for {
+		topics, ctx, cancel := c.pollTopics()
+		var err error
+		if len(topics) == 0 {
+			// wait for the first topic
+			<-ctx.Done()
+		} else {
+			err = c.poll(ctx, topics)
+		}
+		repolled := ctx.Err() != nil
+		cancel()
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err == nil || repolled {
+			backoff = c.backoff
+			continue
+		}
+		delay := backoff.Step()
+		klog.Errorf("failed to poll the hub %s, retry after %s: %v", c.url, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// poll acks the events have been received, and hands over the events of the topics returned by the hub
+func (c *httpClient) poll(ctx context.Context, topics []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.pollTimeout+httpRequestTimeout)
+	defer cancel()
+
+	query := url.Values{"topic": topics}
+	query.Set("after", strconv.FormatUint(c.received, 10))
+	query.Set("timeout", c.pollTimeout.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", httpBatchContentType)
+	req.Header.Set(httpClientIDHeader, c.clientID)
+	if c.session != "" {
+		req.Header.Set(httpSessionHeader, c.session)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpStatusError(resp)
+	}
+	events, err := cehttp.NewEventsFromHTTPResponse(resp)
+	if err != nil {
+		return err
+	}
+	c.connected(resp.Header.Get(httpSessionHeader), resp.Header.Get(httpResumedHeader) == "true")
+
+	for _, evt := range events {
+		topic, _ := evt.Extensions()[httpTopicExtension].(string)
+		seqValue, _ := evt.Extensions()[httpSeqExtension].(string)
+		seq, err := strconv.ParseUint(seqValue, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid seq %q of the event %s: %v", seqValue, evt.ID(), err)
+		}
+		// skip the event has been received before the session is resumed
+		if seq <= c.received {
+			continue
+		}
+		evt.SetExtension(httpTopicExtension, nil)
+		evt.SetExtension(httpSeqExtension, nil)
+		if err := c.handler(topic, evt); err != nil {
+			return err
+		}
+		c.received = seq
+	}
+	return nil
+}
+
+// connected records the session answered by the hub, the handlers are notified if the session isn't resumed
+func (c *httpClient) connected(session string, resumed bool) {
+	if session == c.session && resumed {
+		return
+	}
+	reconnected := c.session != ""
+	if !resumed {
+		c.received = 0
+	}
+	c.session = session
+	klog.Infof("Connected to the hub %s, session %s(resumed: %v)", c.url, session, resumed)
+	switch {
+	case !reconnected:
+		c.notify(Connected)
+	case !resumed:
+		c.notify(Disconnected)
+		c.notify(Connected)
+	}
+}
+
+// publish POSTs the event to the topic in the binary mode
+func (c *httpClient) publish(ctx context.Context, topic string, evt cloudevents.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, httpRequestTimeout)
+	defer cancel()
+
+	query := url.Values{"topic": {topic}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if err := cehttp.WriteRequest(ctx, binding.ToMessage(&evt), req); err != nil {
+		return err
+	}
+	req.Header.Set(httpClientIDHeader, c.clientID)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return httpStatusError(resp)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (c *httpClient) stop() {
+	c.cancel()
+	<-c.done
+	c.client.CloseIdleConnections()
+}
+
+func httpStatusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("the hub responds %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// httpTransport sends and receives the transport messages through the HTTP hub, the messages are mapped to the events
+// whose data is the payload.
+type httpTransport struct {
+	client *httpClient
+
+	mutex     sync.RWMutex
+	receivers map[string][]*defaultReceiver
+}
+
+// NewHttpTransport polls the hub(the URL of opt.Broker) in the background once a topic is received, and the sending
+// fails if the hub isn't reachable. The client cert is presented to the hub if the TLS is enabled.
+func NewHttpTransport(ctx context.Context, opt *option.Options) (*httpTransport, error) {
+	t := &httpTransport{receivers: map[string][]*defaultReceiver{}}
+	client, err := newHttpClient(ctx, opt, t.dispatch)
+	if err != nil {
+		return nil, err
+	}
+	t.client = client
+	return t, nil
+}
+
+// AddConnectionStateHandler registers the handler invoked when the connection is up or down
+func (t *httpTransport) AddConnectionStateHandler(handler func(state ConnectionState)) {
+	t.client.AddConnectionStateHandler(handler)
+}
+
+func (t *httpTransport) Send(topic string, msg apis.TransportMessage) error {
+	return t.client.publish(t.client.ctx, topic, messageEvent(msg))
+}
+
+// Receive subscribes the topic filter on the hub, each receiver of the same topic gets all the messages
+func (t *httpTransport) Receive(topic string) (Receiver, error) {
+	receiver := NewDefaultReceiver(make(chan apis.TransportMessage))
+	t.mutex.Lock()
+	t.receivers[topic] = append(t.receivers[topic], receiver)
+	t.mutex.Unlock()
+	t.client.subscribe(topic)
+	return receiver, nil
+}
+
+func (t *httpTransport) dispatch(topic string, evt cloudevents.Event) error {
+	t.mutex.RLock()
+	receivers := t.receivers[topic]
+	t.mutex.RUnlock()
+	msg := eventMessage(evt)
+	for _, receiver := range receivers {
+		select {
+		case receiver.msgChan <- msg:
+		case <-t.client.ctx.Done():
+			return t.client.ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (t *httpTransport) Stop() {
+	t.client.stop()
+
+	t.mutex.Lock()
+	for topic, receivers := range t.receivers {
+		for _, receiver := range receivers {
+			receiver.Stop()
+		}
+		klog.Infof("transport receiver(%s) stopped!", topic)
+	}
+	t.receivers = map[string][]*defaultReceiver{}
+	t.mutex.Unlock()
+	klog.Info("transport is disconnected!")
+}
+
+// httpProtocol is the cloudevents protocol of the HTTP hub, the events are sent to the opt.SendTopic and polled from
+// the opt.ReceiveTopic as they are, so that the consumers of the cloudevents client work unchanged.
+type httpProtocol struct {
+	client    *httpClient
+	sendTopic string
+	incoming  chan cloudevents.Event
+}
+
+func newHttpProtocol(ctx context.Context, opt *option.Options) (*httpProtocol, error) {
+	p := &httpProtocol{
+		sendTopic: opt.SendTopic,
+		incoming:  make(chan cloudevents.Event),
+	}
+	client, err := newHttpClient(ctx, opt, p.handle)
+	if err != nil {
+		return nil, err
+	}
+	p.client = client
+	client.subscribe(opt.ReceiveTopic)
+	return p, nil
+}
+
+func httpCloudeventsClient(ctx context.Context, opt *option.Options) (cloudevents.Client, error) {
+	p, err := newHttpProtocol(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	// a single poll goroutine and the blocking callback keep the events in order, like the memory protocol
+	return cloudevents.NewClient(p, cloudevents.WithTimeNow(), cloudevents.WithUUIDs(),
+		client.WithPollGoroutines(1), client.WithBlockingCallback())
+}
+
+func (p *httpProtocol) handle(_ string, evt cloudevents.Event) error {
+	select {
+	case p.incoming <- evt:
+		return nil
+	case <-p.client.ctx.Done():
+		return p.client.ctx.Err()
+	}
+}
+
+func (p *httpProtocol) Send(ctx context.Context, m binding.Message, transformers ...binding.Transformer) (err error) {
+	defer func() {
+		if finishErr := m.Finish(err); err == nil {
+			err = finishErr
+		}
+	}()
+
+	evt, err := binding.ToEvent(ctx, m, transformers...)
+	if err != nil {
+		return err
+	}
+	return p.client.publish(ctx, p.sendTopic, *evt)
+}
+
+func (p *httpProtocol) Receive(ctx context.Context) (binding.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, io.EOF
+	case <-p.client.ctx.Done():
+		return nil, io.EOF
+	case evt := <-p.incoming:
+		return binding.ToMessage(&evt), nil
+	}
+}
+
+func (p *httpProtocol) Close(ctx context.Context) error {
+	p.client.stop()
+	return nil
+}
@@ -0,0 +1,318 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+)
+
+func newHttpTestHub(t *testing.T) (*httpHubTransport, string) {
+	hub, err := NewHttpHubTransport(context.Background(), &option.Options{
+		TLSConfig: &option.TLSConfig{},
+		Broker:    "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(hub.Stop)
+	return hub, "http://" + hub.Addr().String()
+}
+
+func httpTestOptions(hubURL, clientID string) *option.Options {
+	return &option.Options{
+		TLSConfig:           &option.TLSConfig{},
+		Broker:              hubURL,
+		ClientID:            clientID,
+		ReconnectMinBackoff: 10 * time.Millisecond,
+		ReconnectMaxBackoff: 100 * time.Millisecond,
+		TransportOptions: map[string]interface{}{
+			"http": &HttpOptions{PollTimeout: time.Second, SessionBuffer: 100, SessionExpiry: time.Minute},
+		},
+	}
+}
+
+// pollEvents polls the topic of the hub as the client, and returns the events, the session and whether it's resumed
+func pollEvents(t *testing.T, hubURL, clientID, session string, after uint64, topic string) (
+	[]cloudevents.Event, string, bool,
+) {
+	query := url.Values{"topic": {topic}, "after": {strconv.FormatUint(after, 10)}, "timeout": {"100ms"}}
+	req, err := http.NewRequest(http.MethodGet, hubURL+httpEventsPath+"?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(httpClientIDHeader, clientID)
+	req.Header.Set(httpSessionHeader, session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(httpStatusError(resp))
+	}
+	events, err := cehttp.NewEventsFromHTTPResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events, resp.Header.Get(httpSessionHeader), resp.Header.Get(httpResumedHeader) == "true"
+}
+
+func expectEvents(t *testing.T, events []cloudevents.Event, ids ...string) {
+	if len(events) != len(ids) {
+		t.Fatalf("expected the events %v, got %d events", ids, len(events))
+	}
+	for i, evt := range events {
+		if evt.ID() != ids[i] {
+			t.Fatalf("expected the event %s, got %s", ids[i], evt.ID())
+		}
+		if seq := evt.Extensions()[httpSeqExtension]; seq != strconv.Itoa(i+1) {
+			t.Fatalf("expected the seq %d of the event %s, got %v", i+1, evt.ID(), seq)
+		}
+	}
+}
+
+func TestHttpTransport(t *testing.T) {
+	hub, hubURL := newHttpTestHub(t)
+
+	hubReceiver, err := hub.Receive("straw/+/response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := map[string]*httpTransport{}
+	receivers := map[string]Receiver{}
+	for _, cluster := range []string{"cluster1", "cluster2"} {
+		client, err := NewHttpTransport(context.Background(), httpTestOptions(hubURL, cluster))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Stop)
+		clients[cluster] = client
+		if receivers[cluster], err = client.Receive("straw/" + cluster + "/request"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForSubscriptions(t, hub.broker, 3)
+
+	// the request of the hub is routed to the cluster1 only, and the payload is kept
+	request := apis.TransportMessage{ID: "request", Type: "watch.v1.secrets.", Source: "hub", Payload: []byte(`{}`)}
+	if err := hub.Send("straw/cluster1/request", request); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-receivers["cluster1"].MessageChan():
+		if msg.ID != request.ID || msg.Type != request.Type || msg.Source != request.Source ||
+			!bytes.Equal(msg.Payload, request.Payload) {
+			t.Fatalf("expected the message %v, got %v", request, msg)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the request")
+	}
+	select {
+	case msg := <-receivers["cluster2"].MessageChan():
+		t.Fatalf("expected no message is routed to cluster2, got %s", msg.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the response of the cluster2 is routed to the hub
+	if err := clients["cluster2"].Send("straw/cluster2/response", apis.TransportMessage{
+		ID: "response", Type: "watch.v1.secrets.", Source: "cluster2", Payload: []byte("not json"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, hubReceiver, "response")
+}
+
+func TestHttpHubBinding(t *testing.T) {
+	hub, hubURL := newHttpTestHub(t)
+	topic := "straw/cluster1/request"
+
+	// the first poll starts the session of the client
+	events, session, resumed := pollEvents(t, hubURL, "cluster1", "", 0, topic)
+	if session == "" || resumed || len(events) != 0 {
+		t.Fatalf("expected a new session without events, got %q(resumed: %v) with %d events", session, resumed,
+			len(events))
+	}
+	waitForSubscriptions(t, hub.broker, 1)
+
+	// POST the events in the binary mode and the structured mode
+	target := hubURL + httpEventsPath + "?" + url.Values{"topic": {topic}}.Encode()
+	ceClient, err := cloudevents.NewClientHTTP(cloudevents.WithTarget(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := cloudevents.NewEvent()
+	binary.SetID("binary")
+	binary.SetType("watch.v1.secrets.")
+	binary.SetSource("hub")
+	if result := ceClient.Send(context.Background(), binary); !cloudevents.IsACK(result) {
+		t.Fatal(result)
+	}
+
+	structured := binary.Clone()
+	structured.SetID("structured")
+	body, err := json.Marshal(structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(target, "application/cloudevents+json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected the structured event is accepted, got %s", resp.Status)
+	}
+
+	resp, err = http.Post(target, "application/json", bytes.NewReader([]byte(`{}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the request without the event is rejected, got %s", resp.Status)
+	}
+
+	// the events are redelivered until they're acked by the next poll
+	events, _, resumed = pollEvents(t, hubURL, "cluster1", session, 0, topic)
+	if !resumed {
+		t.Fatal("expected the session is resumed")
+	}
+	expectEvents(t, events, "binary", "structured")
+	if events[0].Extensions()[httpTopicExtension] != topic {
+		t.Fatalf("expected the topic %s, got %v", topic, events[0].Extensions()[httpTopicExtension])
+	}
+	events, _, _ = pollEvents(t, hubURL, "cluster1", session, 1, topic)
+	if len(events) != 1 || events[0].ID() != "structured" {
+		t.Fatalf("expected the unacked event structured, got %d events", len(events))
+	}
+	events, _, resumed = pollEvents(t, hubURL, "cluster1", session, 2, topic)
+	if !resumed || len(events) != 0 {
+		t.Fatalf("expected no event once they're acked, got %d events(resumed: %v)", len(events), resumed)
+	}
+
+	// the client with an unknown session, e.g. it's restarted, gets a new one
+	_, newSession, resumed := pollEvents(t, hubURL, "cluster1", "unknown", 2, topic)
+	if resumed || newSession == session {
+		t.Fatalf("expected a new session, got %q(resumed: %v)", newSession, resumed)
+	}
+}
+
+func TestHttpCloudeventsClient(t *testing.T) {
+	hub, hubURL := newHttpTestHub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubClient, err := MemoryCloudeventsClient(hub.broker, "straw/cluster1/request", "straw/cluster1/response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := make(chan cloudevents.Event, 1)
+	go func() {
+		_ = hubClient.StartReceiver(ctx, func(evt cloudevents.Event) { responses <- evt })
+	}()
+
+	opt := httpTestOptions(hubURL, "cluster1")
+	opt.SendTopic = "straw/cluster1/response"
+	opt.ReceiveTopic = "straw/cluster1/request"
+	agentClient, err := httpCloudeventsClient(ctx, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan cloudevents.Event, 1)
+	go func() {
+		_ = agentClient.StartReceiver(ctx, func(evt cloudevents.Event) { requests <- evt })
+	}()
+	waitForSubscriptions(t, hub.broker, 2)
+
+	request := cloudevents.NewEvent()
+	request.SetType("watch.v1.secrets.")
+	request.SetSource("hub")
+	request.SetSubject("cluster1")
+	if result := hubClient.Send(ctx, request); cloudevents.IsUndelivered(result) {
+		t.Fatal(result)
+	}
+	select {
+	case evt := <-requests:
+		if evt.Subject() != "cluster1" || len(evt.Extensions()) != 0 {
+			t.Fatalf("expected the request to cluster1 without the extensions, got %s", evt)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the request")
+	}
+
+	response := cloudevents.NewEvent()
+	response.SetType("watch.v1.secrets.")
+	response.SetSource("cluster1")
+	if result := agentClient.Send(ctx, response); cloudevents.IsUndelivered(result) {
+		t.Fatal(result)
+	}
+	select {
+	case evt := <-responses:
+		if evt.Source() != "cluster1" {
+			t.Fatalf("expected the response of cluster1, got %s", evt)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the response")
+	}
+}
+
+func TestHttpHubVerifyClientID(t *testing.T) {
+	hub, _ := newHttpTestHub(t)
+	request := func(method, clientID, commonName string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, httpEventsPath+"?topic=straw/cluster1/request&timeout=10ms", nil)
+		if method == http.MethodPost {
+			req.Header.Set("Ce-Specversion", "1.0")
+			req.Header.Set("Ce-Id", "1")
+			req.Header.Set("Ce-Source", commonName)
+			req.Header.Set("Ce-Type", "test")
+		}
+		if clientID != "" {
+			req.Header.Set(httpClientIDHeader, clientID)
+		}
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: commonName}}},
+		}
+		w := httptest.NewRecorder()
+		hub.serveEvents(w, req)
+		return w
+	}
+	hasSession := func(clientID string) bool {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		_, ok := hub.sessions[clientID]
+		return ok
+	}
+
+	// the client is identified by its cert, the header is optional
+	if w := request(http.MethodGet, "cluster1", "cluster1"); w.Code != http.StatusOK {
+		t.Fatalf("expected the poll of cluster1 succeeds, got %d: %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodGet, "", "cluster2"); w.Code != http.StatusOK || !hasSession("cluster2") {
+		t.Fatalf("expected the poll of cluster2 identified by its cert, got %d: %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodPost, "cluster1", "cluster1"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected the event of cluster1 is accepted, got %d: %s", w.Code, w.Body)
+	}
+
+	// the client can't poll or publish as another cluster
+	if w := request(http.MethodGet, "cluster3", "cluster1"); w.Code != http.StatusForbidden || hasSession("cluster3") {
+		t.Fatalf("expected the poll mismatching the cert is forbidden, got %d: %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodPost, "cluster2", "cluster1"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected the event mismatching the cert is forbidden, got %d: %s", w.Code, w.Body)
+	}
+}
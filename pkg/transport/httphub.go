@@ -0,0 +1,373 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/klog/v2"
+)
+
+var _ Transport = (*httpHubTransport)(nil)
+
+// httpMaxPollTimeout caps the timeout of the polls asked by the clients
+const httpMaxPollTimeout = 5 * time.Minute
+
+// httpHubTransport is the hub of the HTTP transport, it serves the CloudEvents POSTed by the clients(the agents) in
+// the structured or binary mode, and routes them by the topics like the gRPC hub. The events of the topics polled by a
+// client are queued in its session(up to the HttpOptions.SessionBuffer) until they're acked by the next poll, and the
+// session is expired once the client stops polling for the HttpOptions.SessionExpiry. If the TLS is enabled, the client
+// is identified by its cert, and the client id header must be the common name or a DNS name of the cert.
+//
+// The events are wrapped into the messages of the internal broker(the same as the memory protocol), so that the
+// cloudevents clients of the hub are the memory ones on the broker, and the transport messages of the hub are mapped
+// to the events like the clients.
+type httpHubTransport struct {
+	broker *MemoryBroker
+	local  *memoryTransport
+	// anonymous publishes the events of the clients without the session
+	anonymous     *memoryTransport
+	server        *http.Server
+	listener      net.Listener
+	pollTimeout   time.Duration
+	sessionBuffer int
+	sessionExpiry time.Duration
+	done          chan struct{}
+	stopExpiry    chan struct{}
+
+	mutex    sync.Mutex
+	sessions map[string]*hubSession
+}
+
+// NewHttpHubTransport listens on the address(opt.Broker) for the clients, the clients are verified by the CA of the
+// TLS options if the TLS is enabled(mTLS).
+func NewHttpHubTransport(ctx context.Context, opt *option.Options) (*httpHubTransport, error) {
+	listener, err := net.Listen("tcp", opt.Broker)
+	if err != nil {
+		return nil, err
+	}
+	if opt.EnableTLS {
+		// the client cert of the options is the cert of the hub
+		tlsConfig := utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)
+		tlsConfig.ClientCAs = tlsConfig.RootCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	httpOpt := httpOptions(opt)
+	broker := NewMemoryBroker()
+	t := &httpHubTransport{
+		broker:        broker,
+		local:         NewMemoryTransport(broker),
+		anonymous:     NewMemoryTransport(broker),
+		listener:      listener,
+		pollTimeout:   httpOpt.PollTimeout,
+		sessionBuffer: httpOpt.SessionBuffer,
+		sessionExpiry: httpOpt.SessionExpiry,
+		done:          make(chan struct{}),
+		stopExpiry:    make(chan struct{}),
+		sessions:      map[string]*hubSession{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpEventsPath, t.serveEvents)
+	t.server = &http.Server{Handler: mux, ReadHeaderTimeout: httpRequestTimeout}
+
+	go func() {
+		defer close(t.done)
+		if err := t.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("the hub stops serving on %s: %v", opt.Broker, err)
+		}
+	}()
+	go t.expireSessions()
+	klog.Infof("the hub is listening on %s", listener.Addr())
+	return t, nil
+}
+
+// Addr returns the address the hub is listening on
+func (t *httpHubTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+func (t *httpHubTransport) Send(topic string, msg apis.TransportMessage) error {
+	wrapped, err := wrapEvent(messageEvent(msg))
+	if err != nil {
+		return err
+	}
+	return t.local.Send(topic, wrapped)
+}
+
+// Receive subscribes the topic filter, each receiver gets the messages of the hub's own clients and the other
+// receivers of the hub
+func (t *httpHubTransport) Receive(topic string) (Receiver, error) {
+	receiver, err := t.local.Receive(topic)
+	if err != nil {
+		return nil, err
+	}
+	return newHttpHubReceiver(receiver), nil
+}
+
+func (t *httpHubTransport) Stop() {
+	close(t.stopExpiry)
+	if err := t.server.Close(); err != nil {
+		klog.Error(err)
+	}
+	<-t.done
+
+	t.mutex.Lock()
+	for _, session := range t.sessions {
+		session.close()
+	}
+	t.sessions = map[string]*hubSession{}
+	t.mutex.Unlock()
+
+	t.local.Stop()
+	t.anonymous.Stop()
+	klog.Info("transport is disconnected!")
+}
+
+// expireSessions closes the sessions whose clients have stopped polling, they're kept if the expiry isn't positive
+func (t *httpHubTransport) expireSessions() {
+	if t.sessionExpiry <= 0 {
+		return
+	}
+	ticker := time.NewTicker(t.sessionExpiry / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.stopExpiry:
+			return
+		}
+		t.mutex.Lock()
+		for clientID, session := range t.sessions {
+			if session.idle() > t.sessionExpiry {
+				klog.Infof("the session %s of client %s is expired", session.id, clientID)
+				session.close()
+				delete(t.sessions, clientID)
+			}
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// session returns the session of the client, it's resumed if the poll asks for the current session of the client.
+// Otherwise, a new session is started for the client, e.g. the client is restarted.
+func (t *httpHubTransport) session(clientID, sessionID string, received uint64) (*hubSession, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if session, ok := t.sessions[clientID]; ok {
+		if session.id == sessionID {
+			return session, session.resume(received)
+		}
+		session.close()
+	}
+	session := newHubSession(clientID, NewMemoryTransport(t.broker), t.sessionBuffer)
+	t.sessions[clientID] = session
+	return session, false
+}
+
+// publisher returns the transport publishing the events of the client, the events aren't routed back to the client
+// if it has the session
+func (t *httpHubTransport) publisher(clientID string) *memoryTransport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if session, ok := t.sessions[clientID]; ok {
+		return session.transport
+	}
+	return t.anonymous
+}
+
+// requestClientID returns the client id of the request. It's the common name of the client cert if the TLS is enabled,
+// and the client id header mismatching the cert is rejected. Otherwise, it's the client id header.
+func requestClientID(r *http.Request) (string, error) {
+	clientID := r.Header.Get(httpClientIDHeader)
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return clientID, nil
+	}
+	if clientID == "" {
+		return r.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+	if err := verifyClientID(r.TLS.PeerCertificates, clientID); err != nil {
+		return "", err
+	}
+	return clientID, nil
+}
+
+func (t *httpHubTransport) serveEvents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		t.publish(w, r)
+	case http.MethodGet:
+		t.poll(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// publish routes the event POSTed to the topic
+func (t *httpHubTransport) publish(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		http.Error(w, "the topic is required", http.StatusBadRequest)
+		return
+	}
+	evt, err := cehttp.NewEventFromHTTPRequest(r)
+	if err == nil {
+		err = evt.Validate()
+	}
+	if err != nil {
+		http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, err := requestClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	msg, err := wrapEvent(*evt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := t.publisher(clientID).Send(topic, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// poll acks the events up to the "after" seq, subscribes the topics for the client, and then responds the queued
+// events in the batched mode once there are any or the poll is timed out
+func (t *httpHubTransport) poll(w http.ResponseWriter, r *http.Request) {
+	clientID, err := requestClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if clientID == "" {
+		http.Error(w, "the client id is required", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	var received uint64
+	if after := query.Get("after"); after != "" {
+		var err error
+		if received, err = strconv.ParseUint(after, 10, 64); err != nil {
+			http.Error(w, "invalid after: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	timeout := t.pollTimeout
+	if value := query.Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil {
+			http.Error(w, "invalid timeout: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if timeout > httpMaxPollTimeout {
+		timeout = httpMaxPollTimeout
+	}
+
+	session, resumed := t.session(clientID, r.Header.Get(httpSessionHeader), received)
+	// the previous poll of the client is canceled, e.g. it's abandoned by the client
+	ctx, detach := session.attach(r.Context())
+	defer detach()
+	for _, topic := range query["topic"] {
+		if err := session.subscribe(topic); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if !resumed {
+		received = 0
+	}
+
+	messages := session.pending(received)
+	if len(messages) == 0 {
+		select {
+		case <-session.notify:
+		case <-ctx.Done():
+		case <-time.After(timeout):
+		}
+		messages = session.pending(received)
+	}
+
+	events := make([]cloudevents.Event, 0, len(messages))
+	for _, msg := range messages {
+		evt, err := unwrapEvent(msg.Message)
+		if err != nil {
+			klog.Errorf("failed to unwrap the event %s of the topic %s: %v", msg.Message.ID, msg.Topic, err)
+			continue
+		}
+		evt.SetExtension(httpTopicExtension, msg.Topic)
+		evt.SetExtension(httpSeqExtension, strconv.FormatUint(msg.Seq, 10))
+		events = append(events, evt)
+	}
+	body, err := json.Marshal(events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", httpBatchContentType)
+	w.Header().Set(httpSessionHeader, session.id)
+	w.Header().Set(httpResumedHeader, strconv.FormatBool(resumed))
+	if _, err := w.Write(body); err != nil {
+		klog.Warningf("failed to respond the poll of client %s: %v", clientID, err)
+	}
+}
+
+// httpHubReceiver maps the events of the hub receiver back to the transport messages
+type httpHubReceiver struct {
+	receiver Receiver
+	msgChan  chan apis.TransportMessage
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newHttpHubReceiver(receiver Receiver) *httpHubReceiver {
+	r := &httpHubReceiver{
+		receiver: receiver,
+		msgChan:  make(chan apis.TransportMessage),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(r.msgChan)
+		for msg := range receiver.MessageChan() {
+			evt, err := unwrapEvent(msg)
+			if err != nil {
+				klog.Errorf("failed to unwrap the event %s: %v", msg.ID, err)
+				continue
+			}
+			select {
+			case r.msgChan <- eventMessage(evt):
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+func (r *httpHubReceiver) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		r.receiver.Stop()
+	})
+}
+
+func (r *httpHubReceiver) MessageChan() <-chan apis.TransportMessage {
+	return r.msgChan
+}
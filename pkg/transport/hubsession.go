@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yanmxa/straw/pkg/apis"
+	"k8s.io/klog/v2"
+)
+
+// verifyClientID checks the client id declared by the client against its verified cert(the first of the certs), the
+// client id should be the common name or one of the DNS names of the cert. The client without the cert isn't verified,
+// e.g. the TLS is disabled, the hubs require the cert of the clients once it's enabled.
+func verifyClientID(certs []*x509.Certificate, clientID string) error {
+	if len(certs) == 0 {
+		return nil
+	}
+	cert := certs[0]
+	if cert.Subject.CommonName == clientID {
+		return nil
+	}
+	for _, name := range cert.DNSNames {
+		if name == clientID {
+			return nil
+		}
+	}
+	return fmt.Errorf("the client id %q doesn't match the cert %q", clientID, cert.Subject.CommonName)
+}
+
+// sessionMessage is a message of the subscribed topic queued by the session
+type sessionMessage struct {
+	Topic   string
+	Seq     uint64
+	Message apis.TransportMessage
+}
+
+// hubSession is the state of a client kept by the hubs of the brokerless transports, e.g. the gRPC and HTTP hubs.
+// The messages of the subscribed topics are numbered by the seq and queued until they're acked by the client, the
+// oldest ones are dropped once the queue is full.
+type hubSession struct {
+	id        string
+	clientID  string
+	transport *memoryTransport
+	limit     int
+
+	mutex   sync.Mutex
+	topics  map[string]bool
+	queue   []*sessionMessage
+	seq     uint64
+	dropped uint64 // the seq of the last dropped message
+	// notify is signaled once a message is queued
+	notify chan struct{}
+	// detach cancels the current connection of the session
+	detach context.CancelFunc
+	// attached is the context of the current connection, lastSeen is the time it's attached or detached
+	attached context.Context
+	lastSeen time.Time
+}
+
+func newHubSession(clientID string, transport *memoryTransport, limit int) *hubSession {
+	return &hubSession{
+		id:        uuid.NewString(),
+		clientID:  clientID,
+		transport: transport,
+		limit:     limit,
+		topics:    map[string]bool{},
+		notify:    make(chan struct{}, 1),
+		lastSeen:  time.Now(),
+	}
+}
+
+// resume acks the messages received by the client, it returns false if any of the others has been dropped
+func (s *hubSession) resume(received uint64) bool {
+	s.ack(received)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dropped <= received
+}
+
+// attach makes the connection(e.g. the stream) the current one of the session, the previous one is detached
+func (s *hubSession) attach(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.detach != nil {
+		s.detach()
+	}
+	s.detach = cancel
+	s.attached = ctx
+	s.lastSeen = time.Now()
+	return ctx, func() {
+		cancel()
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.lastSeen = time.Now()
+	}
+}
+
+// idle returns how long the session has been detached, it's zero if a connection is attached
+func (s *hubSession) idle() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.attached != nil && s.attached.Err() == nil {
+		return 0
+	}
+	return time.Since(s.lastSeen)
+}
+
+// subscribe forwards the messages of the topic filter to the queue of the session, it's idempotent
+func (s *hubSession) subscribe(topic string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.topics[topic] {
+		return nil
+	}
+	receiver, err := s.transport.Receive(topic)
+	if err != nil {
+		return err
+	}
+	s.topics[topic] = true
+	go func() {
+		for msg := range receiver.MessageChan() {
+			s.enqueue(topic, msg)
+		}
+	}()
+	return nil
+}
+
+func (s *hubSession) enqueue(topic string, msg apis.TransportMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq++
+	s.queue = append(s.queue, &sessionMessage{Topic: topic, Seq: s.seq, Message: msg})
+	if len(s.queue) > s.limit {
+		s.dropped = s.queue[0].Seq
+		s.queue = s.queue[1:]
+		klog.Warningf("the session of client %s is full, drop the message %d", s.clientID, s.dropped)
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ack removes the messages acked by the client from the queue
+func (s *hubSession) ack(seq uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i := 0
+	for i < len(s.queue) && s.queue[i].Seq <= seq {
+		i++
+	}
+	s.queue = s.queue[i:]
+}
+
+// pending returns the queued messages after the seq
+func (s *hubSession) pending(seq uint64) []*sessionMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	messages := []*sessionMessage{}
+	for _, msg := range s.queue {
+		if msg.Seq > seq {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// close stops the subscriptions and the connection of the session
+func (s *hubSession) close() {
+	s.mutex.Lock()
+	if s.detach != nil {
+		s.detach()
+	}
+	s.mutex.Unlock()
+	s.transport.Stop()
+}
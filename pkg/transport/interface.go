@@ -22,3 +22,47 @@ type Receiver interface {
 	// release any resources used by the watch.
 	MessageChan() <-chan apis.TransportMessage
 }
+
+// AckReceiver is implemented by the receivers of the transports which commit the messages to the broker only after
+// they're processed, e.g. the Kafka offsets and the AMQP deliveries. Each message received from the MessageChan should
+// be acked once it's processed, and the next message isn't delivered until then. The unacked messages are redelivered
+// after the transport is restarted.
+type AckReceiver interface {
+	Receiver
+
+	// Ack acks the last message received from the MessageChan
+	Ack()
+}
+
+// Ack acks the last message received from the receiver once it's processed, nothing happens if the receiver doesn't
+// ack the messages
+func Ack(receiver Receiver) {
+	if r, ok := receiver.(AckReceiver); ok {
+		r.Ack()
+	}
+}
+
+// ConnectionState is the state of the connection between the transport and the broker
+type ConnectionState int
+
+const (
+	Connected ConnectionState = iota
+	Disconnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "Connected"
+	case Disconnected:
+		return "Disconnected"
+	}
+	return "Unknown"
+}
+
+// ConnectionNotifier is implemented by the transports which reconnect to the broker automatically. The messages might
+// be lost during the outage, so that the informers should relist once the transport is reconnected.
+type ConnectionNotifier interface {
+	// AddConnectionStateHandler registers the handler invoked when the connection is up or down
+	AddConnectionStateHandler(handler func(state ConnectionState))
+}
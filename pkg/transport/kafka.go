@@ -0,0 +1,293 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	flag "github.com/spf13/pflag"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/klog/v2"
+)
+
+var _ Transport = (*kafkaTransport)(nil)
+
+// KafkaOptions are the options of the Kafka transport, they're kept in the option.Options.TransportOptions["kafka"]
+type KafkaOptions struct {
+	// GroupID is the consumer group of the receivers, the client id is used if it's empty
+	GroupID string
+}
+
+func init() {
+	option.AddTransportFlags("kafka", func(fs *flag.FlagSet) interface{} {
+		o := &KafkaOptions{}
+		fs.StringVarP(&o.GroupID, "group-id", "", "", "the Kafka consumer group, the client id is used if it's empty")
+		return o
+	})
+	// the brokers are separated by the comma, e.g. kafka://broker1:9092,broker2:9092
+	RegisterTransport("kafka", func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+		t, err := NewKafkaTransport(ctx, withBrokerAddress(opt, broker.Host, false))
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	})
+	RegisterCloudeventsClient("kafka", func(ctx context.Context, broker *url.URL, opt *option.Options) (
+		cloudevents.Client, error,
+	) {
+		return kafkaCloudeventsClient(withBrokerAddress(opt, broker.Host, false))
+	})
+}
+
+// kafkaGroupID returns the consumer group of the opt, which is the client id if it isn't specified
+func kafkaGroupID(opt *option.Options) string {
+	if o, ok := opt.TransportOptions["kafka"].(*KafkaOptions); ok && o.GroupID != "" {
+		return o.GroupID
+	}
+	return opt.ClientID
+}
+
+var invalidKafkaTopicChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// kafkaTopic maps the MQTT-style topic to the Kafka topic by the topicLevels joined with the ".", since the "/" is
+// illegal in the Kafka topic names, e.g. /informer/signal to informer.signal. The Kafka topics have no wildcards.
+func kafkaTopic(topic string) (string, error) {
+	levels := topicLevels(topic, "+", "#")
+	for _, level := range levels {
+		if level == "+" || level == "#" {
+			return "", fmt.Errorf("the Kafka topic doesn't support the wildcard: %s", topic)
+		}
+	}
+	name := invalidKafkaTopicChars.ReplaceAllString(strings.Join(levels, "."), "_")
+	if name == "" || len(name) > 249 {
+		return "", fmt.Errorf("invalid Kafka topic %q of the topic %s", name, topic)
+	}
+	return name, nil
+}
+
+// kafkaTransport sends the messages by a sync producer and receives them by a single consumer group of the topics of
+// all the receivers. The message is keyed by its ID, so that the responses of a list/watch session are kept in order
+// within a partition.
+type kafkaTransport struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	brokers  []string
+	groupID  string
+	config   *sarama.Config
+	producer sarama.SyncProducer
+
+	mutex sync.RWMutex
+	// receivers are keyed by the Kafka topics
+	receivers map[string][]*ackReceiver
+	consumer  sarama.ConsumerGroup
+	// rejoin cancels the current session of the consumer, so that it rejoins the group with the new topics
+	rejoin context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewKafkaTransport connects the brokers(the comma separated opt.Broker), the receivers join the consumer group
+// of the KafkaOptions, or the opt.ClientID if it's empty.
+func NewKafkaTransport(ctx context.Context, opt *option.Options) (*kafkaTransport, error) {
+	config := newSaramaConfig(opt)
+	brokers := strings.Split(opt.Broker, ",")
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &kafkaTransport{
+		ctx:       ctx,
+		cancel:    cancel,
+		brokers:   brokers,
+		groupID:   kafkaGroupID(opt),
+		config:    config,
+		producer:  producer,
+		receivers: map[string][]*ackReceiver{},
+	}, nil
+}
+
+func newSaramaConfig(opt *option.Options) *sarama.Config {
+	config := sarama.NewConfig()
+	// the consumer group requires the version 0.10.2 at least
+	config.Version = sarama.V2_0_0_0
+	config.ClientID = opt.ClientID
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	// the committed offset is used if it exists, otherwise the requests sent before the consumer starts are skipped
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	config.Consumer.Return.Errors = true
+	if opt.EnableTLS {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)
+	}
+	return config
+}
+
+func (t *kafkaTransport) Send(topic string, msg apis.TransportMessage) error {
+	kafkaTopic, err := kafkaTopic(topic)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, _, err = t.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: kafkaTopic,
+		Key:   sarama.StringEncoder(msg.ID),
+		Value: sarama.ByteEncoder(payload),
+	})
+	return err
+}
+
+// Receive subscribes the topic by the consumer group of the transport, each receiver of the same topic gets all the
+// messages. The offset of a message is committed after it's acked by all the receivers of its topic.
+func (t *kafkaTransport) Receive(topic string) (Receiver, error) {
+	kafkaTopic, err := kafkaTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	receiver := newAckReceiver()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	subscribed := len(t.receivers[kafkaTopic]) > 0
+	t.receivers[kafkaTopic] = append(t.receivers[kafkaTopic], receiver)
+	if subscribed {
+		return receiver, nil
+	}
+	if t.consumer != nil {
+		// the consumer rejoins the group with the new topic
+		t.rejoin()
+		return receiver, nil
+	}
+
+	consumer, err := sarama.NewConsumerGroup(t.brokers, t.groupID, t.config)
+	if err != nil {
+		delete(t.receivers, kafkaTopic)
+		return nil, err
+	}
+	t.consumer = consumer
+	t.rejoin = func() {}
+
+	t.wg.Add(2)
+	go func() {
+		defer t.wg.Done()
+		for err := range consumer.Errors() {
+			klog.Errorf("failed to consume the topics of the group %s: %v", t.groupID, err)
+		}
+	}()
+	go func() {
+		defer t.wg.Done()
+		t.consume(consumer)
+	}()
+	return receiver, nil
+}
+
+// consume joins the group with the topics of the receivers until the transport is stopped. The Consume returns when
+// the group is rebalanced or the topics are changed, it's recalled to rejoin the group.
+func (t *kafkaTransport) consume(consumer sarama.ConsumerGroup) {
+	handler := &kafkaConsumerHandler{transport: t}
+	for {
+		ctx, cancel := context.WithCancel(t.ctx)
+		t.mutex.Lock()
+		t.rejoin = cancel
+		topics := make([]string, 0, len(t.receivers))
+		for topic := range t.receivers {
+			topics = append(topics, topic)
+		}
+		t.mutex.Unlock()
+
+		if err := consumer.Consume(ctx, topics, handler); err != nil {
+			klog.Errorf("consumer of the topics %v is stopped: %v", topics, err)
+		}
+		cancel()
+		if t.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (t *kafkaTransport) topicReceivers(kafkaTopic string) []*ackReceiver {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.receivers[kafkaTopic]
+}
+
+func (t *kafkaTransport) Stop() {
+	t.cancel()
+	t.mutex.Lock()
+	consumer := t.consumer
+	t.mutex.Unlock()
+	if consumer != nil {
+		if err := consumer.Close(); err != nil {
+			klog.Error(err)
+		}
+	}
+	t.wg.Wait()
+
+	t.mutex.Lock()
+	for topic, receivers := range t.receivers {
+		for _, receiver := range receivers {
+			receiver.Stop()
+		}
+		klog.Infof("transport receiver(%s) stopped!", topic)
+	}
+	t.receivers = map[string][]*ackReceiver{}
+	t.mutex.Unlock()
+
+	if err := t.producer.Close(); err != nil {
+		klog.Error(err)
+	}
+	klog.Info("transport is disconnected!")
+}
+
+type kafkaConsumerHandler struct {
+	transport *kafkaTransport
+}
+
+func (h *kafkaConsumerHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *kafkaConsumerHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim marks the offset of the message after it's acked by all the receivers, so that the committed offset
+// never passes the messages haven't been processed. The message is redelivered if the session ends before that.
+func (h *kafkaConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim,
+) error {
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			transportMsg := apis.TransportMessage{}
+			if err := json.Unmarshal(msg.Value, &transportMsg); err != nil {
+				// skip the message can never be processed
+				klog.Errorf("failed to unmarshal message from %s/%d: %v", msg.Topic, msg.Partition, err)
+				session.MarkMessage(msg, "")
+				continue
+			}
+			for _, receiver := range h.transport.topicReceivers(msg.Topic) {
+				if !receiver.deliver(session.Context(), transportMsg) {
+					if session.Context().Err() != nil {
+						return nil
+					}
+					// the receiver is stopped
+					continue
+				}
+			}
+			session.MarkMessage(msg, "")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+)
+
+func TestKafkaTopic(t *testing.T) {
+	cases := []struct {
+		topic    string
+		expected string
+		invalid  bool
+	}{
+		{topic: "/event/payload", expected: "event.payload"},
+		{topic: "/event/signal", expected: "event.signal"},
+		{topic: "/informer/signal", expected: "informer.signal"},
+		{topic: "straw/cluster1/request/v1.secrets.", expected: "straw.cluster1.request.v1_secrets_"},
+		{topic: "straw-test", expected: "straw-test"},
+		{topic: "straw/cluster:1/request", expected: "straw.cluster_1.request"},
+		{topic: "straw/+/response", invalid: true},
+		{topic: "straw/#", invalid: true},
+		{topic: "/", invalid: true},
+	}
+	for _, c := range cases {
+		name, err := kafkaTopic(c.topic)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("expected the topic %s to be rejected, got %s", c.topic, name)
+			}
+			continue
+		}
+		if err != nil || name != c.expected {
+			t.Errorf("expected the topic %s to be mapped to %s, got %s(%v)", c.topic, c.expected, name, err)
+		}
+	}
+}
+
+func TestKafkaTransport(t *testing.T) {
+	const (
+		// the default topic of the provider, which is sent to the Kafka topic event.payload
+		strawTopic = "/event/payload"
+		topic      = "event.payload"
+		groupID    = "straw-group"
+	)
+
+	payload, err := json.Marshal(apis.TransportMessage{ID: "received", Type: "watch.v1.secrets."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	// the consumer starts from the committed offset 5, and the message at the offset 5 is fetched
+	offsetFetchResponse := &sarama.OffsetFetchResponse{Version: 1, Err: sarama.ErrNoError}
+	offsetFetchResponse.AddBlock(topic, 0, &sarama.OffsetFetchResponseBlock{Offset: 5, Err: sarama.ErrNoError})
+	assignment := sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+		Topics: map[string][]int32{topic: {0}},
+	})
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, groupID, broker),
+		"JoinGroupRequest":   sarama.NewMockJoinGroupResponse(t).SetGroupProtocol(sarama.RangeBalanceStrategyName),
+		"SyncGroupRequest":   assignment,
+		"HeartbeatRequest":   sarama.NewMockHeartbeatResponse(t),
+		"OffsetFetchRequest": sarama.NewMockSequence(offsetFetchResponse),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 6),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage(topic, 0, 5, sarama.ByteEncoder(payload)),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt := &option.Options{
+		TLSConfig: &option.TLSConfig{},
+		Broker:    broker.Addr(),
+		ClientID:  "straw",
+		TransportOptions: map[string]interface{}{
+			"kafka": &KafkaOptions{GroupID: groupID},
+		},
+	}
+	transport, err := NewKafkaTransport(ctx, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// shorten the interval to commit the marked offsets
+	transport.config.Consumer.Offsets.AutoCommit.Interval = 10 * time.Millisecond
+	defer transport.Stop()
+
+	if err := transport.Send(strawTopic, apis.TransportMessage{ID: "sent"}); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := transport.Receive(strawTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-receiver.MessageChan():
+		if msg.ID != "received" {
+			t.Fatalf("expected the message received, got %s", msg.ID)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+
+	committed := func() bool {
+		for _, rr := range broker.History() {
+			req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+			if !ok {
+				continue
+			}
+			if offset, _, err := req.Offset(topic, 0); err == nil && offset == 6 {
+				return true
+			}
+		}
+		return false
+	}
+	// the offset isn't committed until the message is processed
+	time.Sleep(200 * time.Millisecond)
+	if committed() {
+		t.Fatal("expected the offset isn't committed before the message is acked")
+	}
+
+	// the next offset is committed after the message is acked
+	Ack(receiver)
+	deadline := time.Now().Add(10 * time.Second)
+	for !committed() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the offset to be committed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
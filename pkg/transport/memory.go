@@ -0,0 +1,215 @@
+package transport
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"k8s.io/klog/v2"
+)
+
+var _ Transport = (*memoryTransport)(nil)
+
+var (
+	memoryBrokersMutex sync.Mutex
+	memoryBrokers      = map[string]*MemoryBroker{}
+)
+
+// the in-process brokers are named by the host of the URL, e.g. the transports of mem://hub share the broker "hub"
+func init() {
+	RegisterTransport("mem", func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+		return NewMemoryTransport(namedMemoryBroker(broker.Host)), nil
+	})
+	RegisterCloudeventsClient("mem", func(ctx context.Context, broker *url.URL, opt *option.Options) (
+		cloudevents.Client, error,
+	) {
+		return MemoryCloudeventsClient(namedMemoryBroker(broker.Host), opt.SendTopic, opt.ReceiveTopic)
+	})
+}
+
+func namedMemoryBroker(name string) *MemoryBroker {
+	memoryBrokersMutex.Lock()
+	defer memoryBrokersMutex.Unlock()
+	broker, ok := memoryBrokers[name]
+	if !ok {
+		broker = NewMemoryBroker()
+		memoryBrokers[name] = broker
+	}
+	return broker
+}
+
+// MemoryBroker routes the messages between the in-process transports. The topic filters follow the MQTT
+// semantics: "+" matches a single topic level and "#" matches any number of the remaining levels.
+type MemoryBroker struct {
+	mutex     sync.RWMutex
+	receivers map[*memoryReceiver]struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		receivers: map[*memoryReceiver]struct{}{},
+	}
+}
+
+// publish delivers the message to all the receivers matched with the topic, except the ones belong to the
+// publisher itself(like the NoLocal option of the MQTT subscription).
+func (b *MemoryBroker) publish(publisher *memoryTransport, topic string, msg apis.TransportMessage) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for receiver := range b.receivers {
+		if receiver.owner == publisher || !MatchTopic(receiver.filter, topic) {
+			continue
+		}
+		receiver.enqueue(msg)
+	}
+}
+
+func (b *MemoryBroker) subscribe(receiver *memoryReceiver) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.receivers[receiver] = struct{}{}
+}
+
+func (b *MemoryBroker) unsubscribe(receiver *memoryReceiver) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.receivers, receiver)
+}
+
+// MatchTopic reports whether the topic matches the filter, the filter may contain the MQTT wildcards.
+func MatchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// memoryTransport is an in-process transport, it's used to connect the informer and provider in the tests or
+// the single process setups without a broker.
+type memoryTransport struct {
+	broker    *MemoryBroker
+	mutex     sync.Mutex
+	receivers []*memoryReceiver
+}
+
+func NewMemoryTransport(broker *MemoryBroker) *memoryTransport {
+	return &memoryTransport{
+		broker: broker,
+	}
+}
+
+func (t *memoryTransport) Send(topic string, msg apis.TransportMessage) error {
+	t.broker.publish(t, topic, msg)
+	return nil
+}
+
+// Receive subscribes the topic filter, each receiver gets all the messages matched with the filter.
+func (t *memoryTransport) Receive(topic string) (Receiver, error) {
+	receiver := newMemoryReceiver(t, topic)
+	t.mutex.Lock()
+	t.receivers = append(t.receivers, receiver)
+	t.mutex.Unlock()
+	t.broker.subscribe(receiver)
+	return receiver, nil
+}
+
+func (t *memoryTransport) Stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, receiver := range t.receivers {
+		receiver.Stop()
+		klog.Infof("transport receiver(%s) stopped!", receiver.filter)
+	}
+	t.receivers = nil
+}
+
+var _ Receiver = (*memoryReceiver)(nil)
+
+// memoryReceiver buffers the messages in an unbounded queue, so that the sender never blocks on a slow receiver.
+// The messages are delivered to the channel in the order they're published.
+type memoryReceiver struct {
+	owner   *memoryTransport
+	filter  string
+	msgChan chan apis.TransportMessage
+
+	cond     *sync.Cond
+	queue    []apis.TransportMessage
+	stopped  bool
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newMemoryReceiver(owner *memoryTransport, filter string) *memoryReceiver {
+	r := &memoryReceiver{
+		owner:   owner,
+		filter:  filter,
+		msgChan: make(chan apis.TransportMessage),
+		cond:    sync.NewCond(&sync.Mutex{}),
+		done:    make(chan struct{}),
+	}
+	go r.deliver()
+	return r
+}
+
+func (r *memoryReceiver) enqueue(msg apis.TransportMessage) {
+	r.cond.L.Lock()
+	defer r.cond.L.Unlock()
+	if r.stopped {
+		return
+	}
+	r.queue = append(r.queue, msg)
+	r.cond.Signal()
+}
+
+func (r *memoryReceiver) deliver() {
+	defer close(r.msgChan)
+	for {
+		r.cond.L.Lock()
+		for len(r.queue) == 0 && !r.stopped {
+			r.cond.Wait()
+		}
+		if r.stopped {
+			r.cond.L.Unlock()
+			return
+		}
+		msg := r.queue[0]
+		r.queue = r.queue[1:]
+		r.cond.L.Unlock()
+
+		select {
+		case r.msgChan <- msg:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *memoryReceiver) Stop() {
+	r.stopOnce.Do(func() {
+		r.owner.broker.unsubscribe(r)
+		r.cond.L.Lock()
+		r.stopped = true
+		r.queue = nil
+		r.cond.Broadcast()
+		r.cond.L.Unlock()
+		close(r.done)
+	})
+}
+
+func (r *memoryReceiver) MessageChan() <-chan apis.TransportMessage {
+	return r.msgChan
+}
@@ -0,0 +1,35 @@
+package transport
+
+import "testing"
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		filter   string
+		topic    string
+		expected bool
+	}{
+		{filter: "straw/cluster1/request", topic: "straw/cluster1/request", expected: true},
+		{filter: "straw/cluster1/request", topic: "straw/cluster2/request"},
+		{filter: "straw/cluster1/request", topic: "straw/cluster1"},
+		{filter: "straw/cluster1", topic: "straw/cluster1/request"},
+		{filter: "straw/+/response", topic: "straw/cluster1/response", expected: true},
+		{filter: "straw/+/response", topic: "straw/cluster1/request"},
+		{filter: "straw/+/response", topic: "straw/response"},
+		{filter: "straw/+", topic: "straw/cluster1/response"},
+		{filter: "straw/#", topic: "straw/cluster1/response/v1.secrets.", expected: true},
+		{filter: "straw/+/#", topic: "straw/cluster1/response", expected: true},
+		// the # matches the parent level as well
+		{filter: "straw/#", topic: "straw", expected: true},
+		{filter: "straw/cluster1/#", topic: "straw/cluster1", expected: true},
+		{filter: "straw/#", topic: "other/cluster1"},
+		{filter: "#", topic: "straw/cluster1/request", expected: true},
+		{filter: "/event/payload", topic: "/event/payload", expected: true},
+		{filter: "/event/payload", topic: "event/payload"},
+	}
+	for _, c := range cases {
+		if matched := MatchTopic(c.filter, c.topic); matched != c.expected {
+			t.Errorf("expected the filter %s matching the topic %s to be %v, got %v", c.filter, c.topic, c.expected,
+				matched)
+		}
+	}
+}
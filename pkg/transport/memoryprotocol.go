@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/protocol"
+	"github.com/yanmxa/straw/pkg/apis"
+)
+
+var (
+	_ protocol.Sender   = (*memoryProtocol)(nil)
+	_ protocol.Receiver = (*memoryProtocol)(nil)
+	_ protocol.Closer   = (*memoryProtocol)(nil)
+)
+
+// memoryProtocol is the cloudevents protocol binding of the memory transport. The events are wrapped into the
+// TransportMessage, so they're routed by the same topic semantics as the memory transport.
+type memoryProtocol struct {
+	transport *memoryTransport
+	sendTopic string
+	receiver  Receiver
+}
+
+func NewMemoryProtocol(broker *MemoryBroker, sendTopic, receiveTopic string) (*memoryProtocol, error) {
+	t := NewMemoryTransport(broker)
+	receiver, err := t.Receive(receiveTopic)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryProtocol{
+		transport: t,
+		sendTopic: sendTopic,
+		receiver:  receiver,
+	}, nil
+}
+
+// MemoryCloudeventsClient returns a cloudevents client which sends and receives the events through the broker.
+func MemoryCloudeventsClient(broker *MemoryBroker, sendTopic, receiveTopic string) (cloudevents.Client, error) {
+	p, err := NewMemoryProtocol(broker, sendTopic, receiveTopic)
+	if err != nil {
+		return nil, err
+	}
+	// a single poll goroutine and the blocking callback keep the events in order, like the watch events
+	return cloudevents.NewClient(p, cloudevents.WithTimeNow(), cloudevents.WithUUIDs(),
+		client.WithPollGoroutines(1), client.WithBlockingCallback())
+}
+
+func (p *memoryProtocol) Send(ctx context.Context, m binding.Message, transformers ...binding.Transformer) (err error) {
+	defer func() {
+		if finishErr := m.Finish(err); err == nil {
+			err = finishErr
+		}
+	}()
+
+	evt, err := binding.ToEvent(ctx, m, transformers...)
+	if err != nil {
+		return err
+	}
+	msg, err := wrapEvent(*evt)
+	if err != nil {
+		return err
+	}
+	return p.transport.Send(p.sendTopic, msg)
+}
+
+func (p *memoryProtocol) Receive(ctx context.Context) (binding.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, io.EOF
+	case msg, ok := <-p.receiver.MessageChan():
+		if !ok {
+			return nil, io.EOF
+		}
+		evt, err := unwrapEvent(msg)
+		if err != nil {
+			return nil, err
+		}
+		return binding.ToMessage(&evt), nil
+	}
+}
+
+func (p *memoryProtocol) Close(ctx context.Context) error {
+	p.transport.Stop()
+	return nil
+}
+
+// wrapEvent wraps the event into the payload of the TransportMessage, the attributes of the message are the ones of
+// the event
+func wrapEvent(evt cloudevents.Event) (apis.TransportMessage, error) {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return apis.TransportMessage{}, err
+	}
+	return apis.TransportMessage{
+		Type:    evt.Type(),
+		ID:      evt.ID(),
+		Source:  evt.Source(),
+		Payload: payload,
+	}, nil
+}
+
+// unwrapEvent returns the event wrapped by the wrapEvent
+func unwrapEvent(msg apis.TransportMessage) (cloudevents.Event, error) {
+	evt := cloudevents.NewEvent()
+	err := json.Unmarshal(msg.Payload, &evt)
+	return evt, err
+}
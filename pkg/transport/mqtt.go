@@ -4,66 +4,288 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"net"
+	"net/url"
+	"sync"
+	"time"
 
+	cemqtt "github.com/cloudevents/sdk-go/protocol/mqtt_paho/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	"github.com/cloudevents/sdk-go/v2/protocol"
 	"github.com/eclipse/paho.golang/paho"
+	flag "github.com/spf13/pflag"
 	"github.com/yanmxa/straw/pkg/apis"
 	"github.com/yanmxa/straw/pkg/option"
 	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
-var _ Transport = (*mqttTransport)(nil)
+var (
+	_ Transport          = (*mqttTransport)(nil)
+	_ ConnectionNotifier = (*mqttTransport)(nil)
+	_ protocol.Sender    = (*mqttProtocol)(nil)
+	_ protocol.Receiver  = (*mqttProtocol)(nil)
+	_ protocol.Closer    = (*mqttProtocol)(nil)
+)
+
+const (
+	mqttKeepAlive      = 30
+	mqttConnectTimeout = 10 * time.Second
+)
+
+// MqttOptions are the options of the MQTT transport, they're kept in the option.Options.TransportOptions["mqtt"]
+type MqttOptions struct {
+	QoS      byte
+	Retained bool
+	// SessionExpiry is the seconds the broker keeps the session after the connection is lost
+	SessionExpiry uint32
+}
 
+func init() {
+	option.AddTransportFlags("mqtt", func(fs *flag.FlagSet) interface{} {
+		o := &MqttOptions{}
+		fs.Uint8VarP(&o.QoS, "QoS", "q", 0,
+			"the level of reliability and assurance of message delivery between an MQTT client and broker")
+		fs.BoolVarP(&o.Retained, "retained", "", false, "retain the MQTT message or not")
+		fs.Uint32VarP(&o.SessionExpiry, "session-expiry", "", 3600,
+			"the seconds the broker keeps the MQTT session after the connection is lost")
+		return o
+	})
+	for _, scheme := range []string{"mqtt", "mqtts"} {
+		RegisterTransport(scheme, func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+			return NewMqttTransport(ctx, withBrokerAddress(opt, broker.Host, broker.Scheme == "mqtts")), nil
+		})
+		RegisterCloudeventsClient(scheme, func(ctx context.Context, broker *url.URL, opt *option.Options) (
+			cloudevents.Client, error,
+		) {
+			return mqttCloudeventsClient(ctx, withBrokerAddress(opt, broker.Host, broker.Scheme == "mqtts"))
+		})
+	}
+}
+
+// mqttOptions returns the MQTT options of the opt, or the defaults if they're not set
+func mqttOptions(opt *option.Options) *MqttOptions {
+	if o, ok := opt.TransportOptions["mqtt"].(*MqttOptions); ok {
+		return o
+	}
+	return &MqttOptions{SessionExpiry: 3600}
+}
+
+// mqttTransport keeps the connection to the broker like the autopaho: it reconnects with the backoff once the
+// connection is lost, and resubscribes the topics of the receivers on the new connection. The session is kept by the
+// broker during the outage(CleanStart=false with the session expiry), so that the QoS 1/2 messages are not lost.
 type mqttTransport struct {
-	ctx       context.Context
-	client    *paho.Client
-	qos       byte
-	retained  bool
-	receivers map[string]Receiver
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+	broker        string
+	clientID      string
+	tlsConfig     *tls.Config
+	qos           byte
+	retained      bool
+	sessionExpiry uint32
+	backoff       wait.Backoff
+
+	// the router is shared by the clients of all the connections, so the handlers are kept after reconnecting
+	router *paho.StandardRouter
+
+	// deliverMutex is held by the handlers delivering the messages, so that the receivers are closed by the Stop only
+	// after the handlers have returned
+	deliverMutex sync.RWMutex
+
+	mutex         sync.RWMutex
+	client        *paho.Client // nil when the connection is down
+	receivers     map[string][]Receiver
+	stateHandlers []func(state ConnectionState)
 }
 
+// NewMqttTransport starts to connect the broker in the background, the receivers can be added before the
+// connection is up, and the sending fails until it's connected.
 func NewMqttTransport(ctx context.Context, opt *option.Options) *mqttTransport {
+	var tlsConfig *tls.Config
+	if opt.EnableTLS {
+		tlsConfig = utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)
+	}
+
+	mqttOpt := mqttOptions(opt)
+	ctx, cancel := context.WithCancel(ctx)
+	t := &mqttTransport{
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		broker:        opt.Broker,
+		clientID:      opt.ClientID,
+		tlsConfig:     tlsConfig,
+		qos:           mqttOpt.QoS,
+		retained:      mqttOpt.Retained,
+		sessionExpiry: mqttOpt.SessionExpiry,
+		backoff: wait.Backoff{
+			Duration: opt.ReconnectMinBackoff,
+			Cap:      opt.ReconnectMaxBackoff,
+			Factor:   2.0,
+			Jitter:   0.1,
+			Steps:    math.MaxInt32,
+		},
+		router:    paho.NewStandardRouter(),
+		receivers: make(map[string][]Receiver),
+	}
+	go t.run()
+	return t
+}
+
+// AddConnectionStateHandler registers the handler invoked when the connection is up or down
+func (t *mqttTransport) AddConnectionStateHandler(handler func(state ConnectionState)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stateHandlers = append(t.stateHandlers, handler)
+}
+
+// run keeps the connection until the context is done
+func (t *mqttTransport) run() {
+	defer close(t.done)
+
+	backoff := t.backoff
+	for {
+		client, connectionLost, err := t.connect()
+		if err != nil {
+			if t.ctx.Err() != nil {
+				return
+			}
+			delay := backoff.Step()
+			klog.Errorf("failed to connect to %s, retry after %s: %v", t.broker, delay, err)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-t.ctx.Done():
+				return
+			}
+		}
+		backoff = t.backoff
+		klog.Info("Connected to ", t.broker)
+
+		if err := t.resubscribe(client); err != nil {
+			klog.Errorf("failed to resubscribe the topics to %s: %v", t.broker, err)
+			t.setClient(nil)
+			_ = client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+			continue
+		}
+		t.notify(Connected)
+
+		select {
+		case err := <-connectionLost:
+			klog.Errorf("connection to %s is lost, reconnecting: %v", t.broker, err)
+			t.setClient(nil)
+			t.notify(Disconnected)
+		case <-t.ctx.Done():
+			t.setClient(nil)
+			if err := client.Disconnect(&paho.Disconnect{ReasonCode: 0}); err != nil {
+				klog.Error(err)
+			}
+			t.notify(Disconnected)
+			return
+		}
+	}
+}
+
+// connect returns the connected client and the chan receives the error once the connection is lost
+func (t *mqttTransport) connect() (*paho.Client, <-chan error, error) {
+	ctx, cancel := context.WithTimeout(t.ctx, mqttConnectTimeout)
+	defer cancel()
+
 	var conn net.Conn
 	var err error
-	if opt.EnableTLS {
-		tlsConfig := utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)
-		conn, err = tls.Dial("tcp", opt.Broker, tlsConfig)
+	if t.tlsConfig != nil {
+		conn, err = (&tls.Dialer{Config: t.tlsConfig}).DialContext(ctx, "tcp", t.broker)
 	} else {
-		conn, err = net.Dial("tcp", opt.Broker)
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", t.broker)
 	}
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	conf := paho.ClientConfig{
-		ClientID: opt.ClientID,
-		Conn:     conn,
-	}
-	client := paho.NewClient(conf)
-
-	cp := &paho.Connect{
-		KeepAlive:  30,
-		ClientID:   opt.ClientID,
-		CleanStart: true,
+	// the client might report more than one error for a broken connection
+	connectionLost := make(chan error, 1)
+	var lostOnce sync.Once
+	lost := func(err error) {
+		lostOnce.Do(func() { connectionLost <- err })
 	}
+	client := paho.NewClient(paho.ClientConfig{
+		ClientID:      t.clientID,
+		Conn:          conn,
+		Router:        t.router,
+		OnClientError: lost,
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			lost(fmt.Errorf("disconnected by the broker with reason code %d", d.ReasonCode))
+		},
+	})
 
-	connAck, err := client.Connect(ctx, cp)
+	sessionExpiry := t.sessionExpiry
+	connAck, err := client.Connect(ctx, &paho.Connect{
+		KeepAlive:  mqttKeepAlive,
+		ClientID:   t.clientID,
+		CleanStart: false,
+		Properties: &paho.ConnectProperties{
+			SessionExpiryInterval: &sessionExpiry,
+		},
+	})
 	if err != nil {
-		panic(err)
+		_ = conn.Close()
+		return nil, nil, err
 	}
 	if connAck.ReasonCode != 0 {
-		klog.Errorf("Failed to connect to %s: - %s", opt.Broker, connAck.Properties.ReasonString)
-		panic(err)
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to connect to %s: %d - %s", t.broker, connAck.ReasonCode,
+			connAck.Properties.ReasonString)
 	}
-	klog.Info("Connected to ", opt.Broker)
+	return client, connectionLost, nil
+}
+
+// resubscribe sets the client and subscribes the topics of all the receivers with it. The topics are subscribed even
+// if the session is present, since the broker might not keep the subscriptions, e.g. the session is expired.
+func (t *mqttTransport) resubscribe(client *paho.Client) error {
+	t.mutex.Lock()
+	t.client = client
+	topics := []string{}
+	for topic := range t.receivers {
+		topics = append(topics, topic)
+	}
+	t.mutex.Unlock()
+
+	for _, topic := range topics {
+		if err := t.subscribe(client, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *mqttTransport) subscribe(client *paho.Client, topic string) error {
+	_, err := client.Subscribe(t.ctx, &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{
+			topic: {QoS: t.qos, NoLocal: true, RetainAsPublished: t.retained},
+		},
+	})
+	return err
+}
+
+func (t *mqttTransport) setClient(client *paho.Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.client = client
+}
 
-	return &mqttTransport{
-		ctx:       ctx,
-		client:    client,
-		qos:       opt.QoS,
-		retained:  opt.Retained,
-		receivers: make(map[string]Receiver),
+func (t *mqttTransport) notify(state ConnectionState) {
+	t.mutex.RLock()
+	handlers := append([]func(ConnectionState){}, t.stateHandlers...)
+	t.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(state)
 	}
 }
 
@@ -72,29 +294,32 @@ func (t *mqttTransport) Send(topic string, msg apis.TransportMessage) error {
 	if err != nil {
 		return err
 	}
-	_, err = t.client.Publish(t.ctx, &paho.Publish{
+	return t.publish(t.ctx, &paho.Publish{
 		QoS:     t.qos,
 		Topic:   topic,
 		Payload: payload,
 		Retain:  t.retained,
 	})
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-// start a goroutine to receive message from subscribed topic
-func (t *mqttTransport) Receive(topic string) (Receiver, error) {
-	if t.receivers[topic] != nil {
-		klog.Info("provider(%s) already existed!", topic)
-		return t.receivers[topic], nil
+// publish sends the message with the current connection, it fails if the connection is down
+func (t *mqttTransport) publish(ctx context.Context, msg *paho.Publish) error {
+	t.mutex.RLock()
+	client := t.client
+	t.mutex.RUnlock()
+	if client == nil {
+		return fmt.Errorf("the transport is disconnected from %s", t.broker)
 	}
+	_, err := client.Publish(ctx, msg)
+	return err
+}
 
+// start a goroutine to receive message from subscribed topic, each receiver of the same topic gets all the messages
+func (t *mqttTransport) Receive(topic string) (Receiver, error) {
 	messageChan := make(chan apis.TransportMessage)
-	t.receivers[topic] = NewDefaultReceiver(messageChan)
+	receiver := NewDefaultReceiver(messageChan)
 
-	t.client.Router.RegisterHandler(topic, func(msg *paho.Publish) {
+	err := t.receive(topic, receiver, func(msg *paho.Publish) {
 		transportMsg := &apis.TransportMessage{}
 		err := json.Unmarshal(msg.Payload, transportMsg)
 		if err != nil {
@@ -102,43 +327,168 @@ func (t *mqttTransport) Receive(topic string) (Receiver, error) {
 			return
 		}
 		// klog.Infof("received message(%s): %s", transportMsg.ID, transportMsg.Type)
-		messageChan <- *transportMsg
+		t.deliver(messageChan, *transportMsg)
 	})
-
-	// klog.Infof("receiver subscribe topic: %s", topic)
-	if _, err := t.client.Subscribe(t.ctx, &paho.Subscribe{
-		Subscriptions: map[string]paho.SubscribeOptions{
-			topic: {QoS: t.qos, NoLocal: true, RetainAsPublished: t.retained},
-		},
-	}); err != nil {
+	if err != nil {
 		return nil, err
 	}
-
-	return t.receivers[topic], nil
+	return receiver, nil
 }
 
-// func (t *mqttTransport) waitUntilConnected() error {
-// 	if !t.client.IsConnected() {
-// 		if token := t.client.Connect(); token.Wait() && token.Error() != nil {
-// 			return token.Error()
-// 		}
-// 	}
-// 	return nil
-// }
+// receive registers the handler of the topic, and keeps the topic subscribed by the receiver across the connections
+func (t *mqttTransport) receive(topic string, receiver Receiver, handler func(msg *paho.Publish)) error {
+	t.router.RegisterHandler(topic, handler)
+
+	t.mutex.Lock()
+	subscribed := len(t.receivers[topic]) > 0
+	t.receivers[topic] = append(t.receivers[topic], receiver)
+	client := t.client
+	t.mutex.Unlock()
 
-func (t *mqttTransport) GetClient() *paho.Client {
-	return t.client
+	// the topic is subscribed once the connection is up if it's disconnected now
+	if subscribed || client == nil {
+		return nil
+	}
+	// klog.Infof("receiver subscribe topic: %s", topic)
+	return t.subscribe(client, topic)
+}
+
+// deliver hands over the message to the receiver until the transport is stopped
+func (t *mqttTransport) deliver(messageChan chan apis.TransportMessage, msg apis.TransportMessage) {
+	t.deliverMutex.RLock()
+	defer t.deliverMutex.RUnlock()
+	if t.ctx.Err() != nil {
+		return
+	}
+	select {
+	case messageChan <- msg:
+	case <-t.ctx.Done():
+	}
 }
 
+// Stop disconnects the broker and unregisters the handlers first, the receivers are closed once no handler is
+// delivering the messages to them
 func (t *mqttTransport) Stop() {
-	for topic, receiver := range t.receivers {
-		receiver.Stop()
+	t.cancel()
+	<-t.done
+
+	t.deliverMutex.Lock()
+	defer t.deliverMutex.Unlock()
+	t.mutex.Lock()
+	for topic, receivers := range t.receivers {
+		t.router.UnregisterHandler(topic)
+		for _, receiver := range receivers {
+			receiver.Stop()
+		}
 		klog.Infof("transport receiver(%s) stopped!", topic)
 	}
+	t.receivers = map[string][]Receiver{}
+	t.mutex.Unlock()
+	klog.Info("transport is disconnected!")
+}
+
+// mqttProtocol is the cloudevents protocol on the mqttTransport, so that the cloudevents client reconnects to the
+// broker with the backoff and resubscribes the opt.ReceiveTopic like the transport. The events are written and read
+// by the cloudevents MQTT binding, the same as the cloudevents paho protocol.
+type mqttProtocol struct {
+	transport *mqttTransport
+	sendTopic string
+	incoming  chan *paho.Publish
+}
 
-	err := t.client.Conn.Close()
+func newMqttProtocol(ctx context.Context, opt *option.Options) (*mqttProtocol, error) {
+	t := NewMqttTransport(ctx, opt)
+	p := &mqttProtocol{
+		transport: t,
+		sendTopic: opt.SendTopic,
+		incoming:  make(chan *paho.Publish),
+	}
+	if err := t.waitForConnection(mqttConnectTimeout); err != nil {
+		t.Stop()
+		return nil, err
+	}
+	if opt.ReceiveTopic != "" {
+		// the receiver only keeps the topic subscribed, the events are handed over by the handler
+		err := t.receive(opt.ReceiveTopic, NewDefaultReceiver(make(chan apis.TransportMessage)), p.deliver)
+		if err != nil {
+			t.Stop()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func mqttCloudeventsClient(ctx context.Context, opt *option.Options) (cloudevents.Client, error) {
+	p, err := newMqttProtocol(ctx, opt)
 	if err != nil {
-		klog.Error(err)
+		return nil, err
 	}
-	klog.Info("transport is disconnected!")
+	return cloudevents.NewClient(p, cloudevents.WithTimeNow(), cloudevents.WithUUIDs())
+}
+
+// waitForConnection waits for the first connection, so that the client of an unreachable broker fails at once
+func (t *mqttTransport) waitForConnection(timeout time.Duration) error {
+	connected := make(chan struct{}, 1)
+	t.AddConnectionStateHandler(func(state ConnectionState) {
+		if state == Connected {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		}
+	})
+	t.mutex.RLock()
+	client := t.client
+	t.mutex.RUnlock()
+	if client != nil {
+		return nil
+	}
+	select {
+	case <-connected:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("failed to connect to %s within %s", t.broker, timeout)
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+func (p *mqttProtocol) deliver(msg *paho.Publish) {
+	select {
+	case p.incoming <- msg:
+	case <-p.transport.ctx.Done():
+	}
+}
+
+func (p *mqttProtocol) Send(ctx context.Context, m binding.Message, transformers ...binding.Transformer) (err error) {
+	defer func() {
+		if finishErr := m.Finish(err); err == nil {
+			err = finishErr
+		}
+	}()
+
+	msg := &paho.Publish{QoS: p.transport.qos, Topic: p.sendTopic, Retain: p.transport.retained}
+	if topic := cecontext.TopicFrom(ctx); topic != "" {
+		msg.Topic = topic
+	}
+	if err := cemqtt.WritePubMessage(ctx, m, msg, transformers...); err != nil {
+		return err
+	}
+	return p.transport.publish(ctx, msg)
+}
+
+func (p *mqttProtocol) Receive(ctx context.Context) (binding.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, io.EOF
+	case <-p.transport.ctx.Done():
+		return nil, io.EOF
+	case msg := <-p.incoming:
+		return cemqtt.NewMessage(msg), nil
+	}
+}
+
+func (p *mqttProtocol) Close(ctx context.Context) error {
+	p.transport.Stop()
+	return nil
 }
@@ -0,0 +1,337 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+)
+
+// fakeBroker is a minimal MQTT v5 broker for the QoS 0 messages, it records the CONNECT and SUBSCRIBE packets
+type fakeBroker struct {
+	listener net.Listener
+
+	mutex         sync.Mutex
+	conns         map[net.Conn]map[string]bool // the subscriptions of the connections
+	connects      []*packets.Connect
+	subscriptions []string
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{listener: listener, conns: map[net.Conn]map[string]bool{}}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(packets.NewThreadSafeConn(conn))
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer func() {
+		b.mutex.Lock()
+		delete(b.conns, conn)
+		b.mutex.Unlock()
+		_ = conn.Close()
+	}()
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := cp.Content.(type) {
+		case *packets.Connect:
+			b.mutex.Lock()
+			b.connects = append(b.connects, p)
+			b.conns[conn] = map[string]bool{}
+			b.mutex.Unlock()
+			_, _ = packets.NewControlPacket(packets.CONNACK).WriteTo(conn)
+		case *packets.Subscribe:
+			ack := packets.NewControlPacket(packets.SUBACK)
+			ack.Content.(*packets.Suback).PacketID = p.PacketID
+			b.mutex.Lock()
+			for topic, opts := range p.Subscriptions {
+				b.conns[conn][topic] = true
+				b.subscriptions = append(b.subscriptions, topic)
+				ack.Content.(*packets.Suback).Reasons = append(ack.Content.(*packets.Suback).Reasons, opts.QoS)
+			}
+			b.mutex.Unlock()
+			_, _ = ack.WriteTo(conn)
+		case *packets.Publish:
+			b.publish(conn, p)
+		case *packets.Pingreq:
+			_, _ = packets.NewControlPacket(packets.PINGRESP).WriteTo(conn)
+		case *packets.Disconnect:
+			return
+		}
+	}
+}
+
+// publish delivers the message to the other connections(the NoLocal) subscribed the topic
+func (b *fakeBroker) publish(from net.Conn, p *packets.Publish) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for conn, topics := range b.conns {
+		if conn == from {
+			continue
+		}
+		for filter := range topics {
+			if MatchTopic(filter, p.Topic) {
+				cp := packets.NewControlPacket(packets.PUBLISH)
+				cp.Content.(*packets.Publish).Topic = p.Topic
+				cp.Content.(*packets.Publish).Payload = p.Payload
+				cp.Content.(*packets.Publish).Properties = p.Properties
+				_, _ = cp.WriteTo(conn)
+				break
+			}
+		}
+	}
+}
+
+// dropConnections closes all the connections like the broker is restarted
+func (b *fakeBroker) dropConnections() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for conn := range b.conns {
+		_ = conn.Close()
+	}
+}
+
+func (b *fakeBroker) subscriptionCount(topic string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	count := 0
+	for _, subscription := range b.subscriptions {
+		if subscription == topic {
+			count++
+		}
+	}
+	return count
+}
+
+func newTestMqttTransport(ctx context.Context, broker *fakeBroker, clientID string) *mqttTransport {
+	return NewMqttTransport(ctx, &option.Options{
+		TLSConfig: &option.TLSConfig{},
+		Broker:    broker.listener.Addr().String(),
+		ClientID:  clientID,
+		TransportOptions: map[string]interface{}{
+			"mqtt": &MqttOptions{SessionExpiry: 60},
+		},
+		ReconnectMinBackoff: 10 * time.Millisecond,
+		ReconnectMaxBackoff: 100 * time.Millisecond,
+	})
+}
+
+func TestMqttTransportReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := newFakeBroker(t)
+	sender := newTestMqttTransport(ctx, broker, "sender")
+	defer sender.Stop()
+	receiver := newTestMqttTransport(ctx, broker, "receiver")
+
+	states := make(chan ConnectionState, 10)
+	receiver.AddConnectionStateHandler(func(state ConnectionState) {
+		states <- state
+	})
+	expectState := func(expected ConnectionState) {
+		t.Helper()
+		select {
+		case state := <-states:
+			if state != expected {
+				t.Fatalf("expected the state %s, got %s", expected, state)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the state %s", expected)
+		}
+	}
+
+	r, err := receiver.Receive("straw/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Stop()
+	expectState(Connected)
+
+	// the messages are sent once the sender is connected and the receiver has subscribed the topic
+	sendAndReceive := func(id string) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			if err := sender.Send("straw/test", apis.TransportMessage{ID: id}); err == nil {
+				select {
+				case msg := <-r.MessageChan():
+					if msg.ID != id {
+						t.Fatalf("expected the message %s, got %s", id, msg.ID)
+					}
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("timed out waiting for the message %s", id)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	sendAndReceive("before")
+
+	broker.dropConnections()
+	expectState(Disconnected)
+	expectState(Connected)
+
+	// the topic is resubscribed after reconnecting
+	if n := broker.subscriptionCount("straw/test"); n != 2 {
+		t.Fatalf("expected the topic to be subscribed twice, got %d", n)
+	}
+	sendAndReceive("after")
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for _, connect := range broker.connects {
+		if connect.CleanStart || connect.Properties.SessionExpiryInterval == nil ||
+			*connect.Properties.SessionExpiryInterval != 60 {
+			t.Fatalf("expected the persistent session, got %s", connect)
+		}
+	}
+}
+
+func TestMqttTransportStopDelivering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := newFakeBroker(t)
+	transport := newTestMqttTransport(ctx, broker, "receiver")
+	r, err := transport.Receive("straw/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(apis.TransportMessage{ID: "blocked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := func() <-chan struct{} {
+		routed := make(chan struct{})
+		go func() {
+			defer close(routed)
+			transport.router.Route(&packets.Publish{Topic: "straw/test", Payload: payload,
+				Properties: &packets.Properties{}})
+		}()
+		return routed
+	}
+
+	// the handler is blocked since nobody reads the receiver, it returns once the transport is stopped instead of
+	// sending to the closed receiver
+	routed := route()
+	time.Sleep(100 * time.Millisecond)
+	transport.Stop()
+	select {
+	case <-routed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked handler isn't returned after the transport is stopped")
+	}
+	if _, ok := <-r.MessageChan(); ok {
+		t.Fatal("expected the receiver is closed")
+	}
+
+	// the handlers are unregistered
+	select {
+	case <-route():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message is routed after the transport is stopped")
+	}
+}
+
+func TestMqttCloudeventsClientReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := newFakeBroker(t)
+	newClient := func(clientID, sendTopic, receiveTopic string) cloudevents.Client {
+		client, err := mqttCloudeventsClient(ctx, &option.Options{
+			TLSConfig:           &option.TLSConfig{},
+			Broker:              broker.listener.Addr().String(),
+			ClientID:            clientID,
+			SendTopic:           sendTopic,
+			ReceiveTopic:        receiveTopic,
+			ReconnectMinBackoff: 10 * time.Millisecond,
+			ReconnectMaxBackoff: 100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	sender := newClient("sender", "straw/cluster1/request", "")
+	receiver := newClient("receiver", "", "straw/+/request")
+	events := make(chan cloudevents.Event, 10)
+	go func() {
+		_ = receiver.StartReceiver(ctx, func(evt cloudevents.Event) { events <- evt })
+	}()
+
+	// the events are sent once the sender is connected and the receiver has subscribed the topic
+	sendAndReceive := func(id string) {
+		t.Helper()
+		evt := cloudevents.NewEvent()
+		evt.SetID(id)
+		evt.SetType("watch.v1.secrets.")
+		evt.SetSource("hub")
+		deadline := time.After(5 * time.Second)
+		for {
+			if result := sender.Send(ctx, evt); !cloudevents.IsUndelivered(result) {
+				select {
+				case received := <-events:
+					if received.ID() != id || received.Source() != "hub" {
+						t.Fatalf("expected the event %s, got %s", id, received)
+					}
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("timed out waiting for the event %s", id)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	sendAndReceive("before")
+
+	// both the clients reconnect, and the receive topic is resubscribed
+	broker.dropConnections()
+	sendAndReceive("after")
+	if n := broker.subscriptionCount("straw/+/request"); n != 2 {
+		t.Fatalf("expected the topic to be subscribed twice, got %d", n)
+	}
+
+	unreachableCtx, cancelUnreachable := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancelUnreachable()
+	if _, err := mqttCloudeventsClient(unreachableCtx, &option.Options{
+		TLSConfig:           &option.TLSConfig{},
+		Broker:              "127.0.0.1:1",
+		ClientID:            "unreachable",
+		ReconnectMinBackoff: 10 * time.Millisecond,
+		ReconnectMaxBackoff: 100 * time.Millisecond,
+	}); err == nil {
+		t.Fatal("expected the client of the unreachable broker to fail")
+	}
+}
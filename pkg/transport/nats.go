@@ -0,0 +1,314 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	flag "github.com/spf13/pflag"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/klog/v2"
+)
+
+var (
+	_ Transport          = (*natsTransport)(nil)
+	_ ConnectionNotifier = (*natsTransport)(nil)
+)
+
+// NatsOptions are the options of the NATS transport, they're kept in the option.Options.TransportOptions["nats"]
+type NatsOptions struct {
+	// Stream is the JetStream stream of the messages, the core NATS is used if it's empty
+	Stream string
+	// Durable is the prefix of the durable consumers, the client id is used if it's empty
+	Durable string
+}
+
+func init() {
+	option.AddTransportFlags("nats", func(fs *flag.FlagSet) interface{} {
+		o := &NatsOptions{}
+		fs.StringVarP(&o.Stream, "nats-stream", "", "",
+			"the JetStream stream of the messages, which is created if it's missing. The core NATS is used if it's empty")
+		fs.StringVarP(&o.Durable, "nats-durable", "", "",
+			"the prefix of the JetStream durable consumers, the client id is used if it's empty")
+		return o
+	})
+	// the servers are separated by the comma, e.g. nats://server1:4222,server2:4222
+	RegisterTransport("nats", func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+		t, err := NewNatsTransport(ctx, withBrokerAddress(opt, broker.Host, false))
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	})
+	RegisterCloudeventsClient("nats", func(ctx context.Context, broker *url.URL, opt *option.Options) (
+		cloudevents.Client, error,
+	) {
+		return natsCloudeventsClient(withBrokerAddress(opt, broker.Host, false))
+	})
+}
+
+// natsOptions returns the NatsOptions of the opt, the durable is the client id if it isn't specified
+func natsOptions(opt *option.Options) NatsOptions {
+	o := NatsOptions{}
+	if natsOpt, ok := opt.TransportOptions["nats"].(*NatsOptions); ok {
+		o = *natsOpt
+	}
+	if o.Durable == "" {
+		o.Durable = opt.ClientID
+	}
+	return o
+}
+
+// natsConnect connects the comma separated servers of the opt.Broker
+func natsConnect(opt *option.Options, natsOpts ...nats.Option) (*nats.Conn, error) {
+	servers := []string{}
+	for _, server := range strings.Split(opt.Broker, ",") {
+		servers = append(servers, "nats://"+server)
+	}
+	natsOpts = append([]nats.Option{nats.Name(opt.ClientID), nats.MaxReconnects(-1)}, natsOpts...)
+	if opt.EnableTLS {
+		natsOpts = append(natsOpts, nats.Secure(utils.NewTLSConfig(opt.CACert, opt.ClientCert, opt.ClientKey)))
+	}
+	return nats.Connect(strings.Join(servers, ","), natsOpts...)
+}
+
+var invalidDurableChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// natsSubject maps the MQTT-style topic to the NATS subject of the stream by the topicLevels, whose wildcards are the
+// "*" and ">"
+func natsSubject(stream, topic string) string {
+	levels := topicLevels(topic, "*", ">")
+	if stream != "" {
+		levels = append([]string{stream}, levels...)
+	}
+	return strings.Join(levels, ".")
+}
+
+// natsDurableName returns the name of the durable consumer of the prefix and the subject
+func natsDurableName(prefix, subject string) string {
+	return invalidDurableChars.ReplaceAllString(prefix+"-"+subject, "_")
+}
+
+// ensureStream creates the stream capturing all the subjects prefixed with its name if it doesn't exist
+func ensureStream(js nats.JetStreamContext, stream string) error {
+	_, err := js.StreamInfo(stream)
+	if err == nil || !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{stream + ".>"}})
+	if errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		return nil
+	}
+	return err
+}
+
+// ensureDurableConsumer creates the durable push consumer of the subject if it doesn't exist. A new consumer only
+// delivers the messages published after it's created, and an existing one resumes from its last acked message. The
+// subscriptions bind to the consumer instead of creating it, so that it's kept once they're closed.
+func ensureDurableConsumer(js nats.JetStreamContext, stream, durable, subject string) error {
+	_, err := js.ConsumerInfo(stream, durable)
+	if err == nil || !errors.Is(err, nats.ErrConsumerNotFound) {
+		return err
+	}
+	_, err = js.AddConsumer(stream, &nats.ConsumerConfig{
+		Durable:        durable,
+		DeliverSubject: nats.NewInbox(),
+		DeliverPolicy:  nats.DeliverNewPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		FilterSubject:  subject,
+	})
+	return err
+}
+
+// natsTransport sends and receives the messages by the core NATS, or by the JetStream if the stream is specified. The
+// receivers of the JetStream consume the durable consumer named after the durable prefix and the subject, which acks
+// a message only after it has been handed over to all the receivers, and resumes from the unacked messages once the
+// transport is restarted.
+type natsTransport struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	stream  string
+	durable string
+
+	mutex         sync.RWMutex
+	receivers     map[string][]*defaultReceiver
+	stateHandlers []func(state ConnectionState)
+	// dispatchMutex is held by the in-flight message handlers, the receivers are stopped after they return
+	dispatchMutex sync.RWMutex
+}
+
+// NewNatsTransport connects the servers(the comma separated opt.Broker), the JetStream is enabled by the stream of the
+// NatsOptions.
+func NewNatsTransport(ctx context.Context, opt *option.Options) (*natsTransport, error) {
+	natsOpt := natsOptions(opt)
+	ctx, cancel := context.WithCancel(ctx)
+	t := &natsTransport{
+		ctx:       ctx,
+		cancel:    cancel,
+		stream:    natsOpt.Stream,
+		durable:   natsOpt.Durable,
+		receivers: map[string][]*defaultReceiver{},
+	}
+
+	conn, err := natsConnect(opt,
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			klog.Warningf("transport is disconnected: %v", err)
+			t.notify(Disconnected)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			klog.Infof("transport is reconnected to %s", conn.ConnectedUrl())
+			t.notify(Connected)
+		}))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	t.conn = conn
+
+	if t.stream != "" {
+		if t.js, err = conn.JetStream(); err == nil {
+			err = ensureStream(t.js, t.stream)
+		}
+		if err != nil {
+			cancel()
+			conn.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// AddConnectionStateHandler registers the handler invoked when the connection is up or down
+func (t *natsTransport) AddConnectionStateHandler(handler func(state ConnectionState)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stateHandlers = append(t.stateHandlers, handler)
+}
+
+func (t *natsTransport) notify(state ConnectionState) {
+	t.mutex.RLock()
+	handlers := append([]func(ConnectionState){}, t.stateHandlers...)
+	t.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(state)
+	}
+}
+
+// Send publishes the message, it returns once the message is persisted by the stream if the JetStream is enabled
+func (t *natsTransport) Send(topic string, msg apis.TransportMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	subject := natsSubject(t.stream, topic)
+	if t.js == nil {
+		return t.conn.Publish(subject, payload)
+	}
+	_, err = t.js.Publish(subject, payload, nats.Context(t.ctx))
+	return err
+}
+
+// Receive subscribes the topic, each receiver of the same topic gets all the messages
+func (t *natsTransport) Receive(topic string) (Receiver, error) {
+	receiver := NewDefaultReceiver(make(chan apis.TransportMessage))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	subscribed := len(t.receivers[topic]) > 0
+	t.receivers[topic] = append(t.receivers[topic], receiver)
+	if subscribed {
+		return receiver, nil
+	}
+
+	if err := t.subscribe(topic); err != nil {
+		t.receivers[topic] = nil
+		return nil, err
+	}
+	return receiver, nil
+}
+
+func (t *natsTransport) subscribe(topic string) error {
+	subject := natsSubject(t.stream, topic)
+	handler := func(msg *nats.Msg) { t.handle(topic, msg) }
+	if t.js == nil {
+		_, err := t.conn.Subscribe(subject, handler)
+		return err
+	}
+
+	durable := natsDurableName(t.durable, subject)
+	if err := ensureDurableConsumer(t.js, t.stream, durable, subject); err != nil {
+		return err
+	}
+	_, err := t.js.Subscribe(subject, handler, nats.Bind(t.stream, durable), nats.ManualAck())
+	return err
+}
+
+// handle hands over the message to all the receivers of the topic, and then acks it if it's from the JetStream
+func (t *natsTransport) handle(topic string, msg *nats.Msg) {
+	t.dispatchMutex.RLock()
+	defer t.dispatchMutex.RUnlock()
+	if t.ctx.Err() != nil {
+		return
+	}
+
+	transportMsg := apis.TransportMessage{}
+	if err := json.Unmarshal(msg.Data, &transportMsg); err != nil {
+		// skip the message can never be processed
+		klog.Errorf("failed to unmarshal message from %s: %v", msg.Subject, err)
+		t.ack(msg)
+		return
+	}
+	for _, receiver := range t.topicReceivers(topic) {
+		select {
+		case receiver.msgChan <- transportMsg:
+		case <-t.ctx.Done():
+			return
+		}
+	}
+	t.ack(msg)
+}
+
+func (t *natsTransport) ack(msg *nats.Msg) {
+	if t.js == nil {
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		klog.Errorf("failed to ack message from %s: %v", msg.Subject, err)
+	}
+}
+
+func (t *natsTransport) topicReceivers(topic string) []*defaultReceiver {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.receivers[topic]
+}
+
+// Stop closes the connection, the durable consumers are kept by the stream
+func (t *natsTransport) Stop() {
+	t.cancel()
+	t.conn.Close()
+	// wait for the in-flight handlers
+	t.dispatchMutex.Lock()
+	defer t.dispatchMutex.Unlock()
+
+	t.mutex.Lock()
+	for topic, receivers := range t.receivers {
+		for _, receiver := range receivers {
+			receiver.Stop()
+		}
+		klog.Infof("transport receiver(%s) stopped!", topic)
+	}
+	t.receivers = map[string][]*defaultReceiver{}
+	t.mutex.Unlock()
+	klog.Info("transport is disconnected!")
+}
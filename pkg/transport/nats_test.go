@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+)
+
+func runNatsServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("the nats server isn't ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func natsTestOptions(s *server.Server, clientID, stream string) *option.Options {
+	return &option.Options{
+		TLSConfig: &option.TLSConfig{},
+		Broker:    strings.TrimPrefix(s.ClientURL(), "nats://"),
+		ClientID:  clientID,
+		TransportOptions: map[string]interface{}{
+			"nats": &NatsOptions{Stream: stream},
+		},
+	}
+}
+
+func receiveMessage(t *testing.T, receiver Receiver, id string) {
+	select {
+	case msg := <-receiver.MessageChan():
+		if msg.ID != id {
+			t.Fatalf("expected the message %s, got %s", id, msg.ID)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for the message %s", id)
+	}
+}
+
+func TestNatsSubject(t *testing.T) {
+	cases := map[string]string{
+		"/event/signal":                      "event.signal",
+		"straw/cluster1/request/v1.secrets.": "straw.cluster1.request.v1_secrets_",
+		"straw/+/response/#":                 "straw.*.response.>",
+	}
+	for topic, expected := range cases {
+		if subject := natsSubject("", topic); subject != expected {
+			t.Errorf("expected the subject of %s is %s, got %s", topic, expected, subject)
+		}
+	}
+	if subject := natsSubject("straw", "/event/signal"); subject != "straw.event.signal" {
+		t.Errorf("expected the subject is prefixed with the stream, got %s", subject)
+	}
+}
+
+func TestNatsTransport(t *testing.T) {
+	s := runNatsServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport, err := NewNatsTransport(ctx, natsTestOptions(s, "straw", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Stop()
+
+	receiver, err := transport.Receive("straw/+/response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Send("straw/cluster1/response", apis.TransportMessage{ID: "sent"}); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, receiver, "sent")
+}
+
+func TestNatsJetStreamTransport(t *testing.T) {
+	const (
+		stream = "straw"
+		topic  = "straw/hub/response/v1.secrets."
+	)
+	s := runNatsServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender, err := NewNatsTransport(ctx, natsTestOptions(s, "provider", stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Stop()
+
+	informer, err := NewNatsTransport(ctx, natsTestOptions(s, "informer", stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := informer.Receive(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(topic, apis.TransportMessage{ID: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, receiver, "first")
+	informer.Stop()
+
+	// the message sent while the informer is down is replayed by its durable consumer after the restart
+	if err := sender.Send(topic, apis.TransportMessage{ID: "missed"}); err != nil {
+		t.Fatal(err)
+	}
+	informer, err = NewNatsTransport(ctx, natsTestOptions(s, "informer", stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer informer.Stop()
+	receiver, err = informer.Receive(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, receiver, "missed")
+}
+
+func TestNatsCloudeventsClient(t *testing.T) {
+	s := runNatsServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opt := natsTestOptions(s, "informer", "straw")
+	opt.SendTopic = "/event/signal"
+	opt.ReceiveTopic = "/event/payload"
+	receiverClient, err := natsCloudeventsClient(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		_ = receiverClient.StartReceiver(ctx, func(evt cloudevents.Event) {
+			received <- evt.Type()
+		})
+	}()
+
+	opt = natsTestOptions(s, "provider", "straw")
+	opt.SendTopic = "/event/payload"
+	opt.ReceiveTopic = "/event/signal"
+	senderClient, err := natsCloudeventsClient(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := cloudevents.NewEvent()
+	evt.SetSource("provider")
+	evt.SetType("watch.v1.secrets.")
+	if result := senderClient.Send(ctx, evt); cloudevents.IsUndelivered(result) {
+		t.Fatal(result)
+	}
+
+	select {
+	case eventType := <-received:
+		if eventType != "watch.v1.secrets." {
+			t.Fatalf("expected the event watch.v1.secrets., got %s", eventType)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+}
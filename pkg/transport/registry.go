@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/yanmxa/straw/pkg/option"
+)
+
+// defaultScheme is used when the broker has no scheme, e.g. 127.0.0.1:1883
+const defaultScheme = "mqtt"
+
+// TransportFactory creates the transport connecting the broker URL
+type TransportFactory func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error)
+
+// CloudeventsClientFactory creates the cloudevents client connecting the broker URL, which sends the events to the
+// opt.SendTopic and receives them from the opt.ReceiveTopic.
+type CloudeventsClientFactory func(ctx context.Context, broker *url.URL, opt *option.Options) (
+	cloudevents.Client, error)
+
+var (
+	registryMutex      sync.RWMutex
+	transports         = map[string]TransportFactory{}
+	cloudeventsClients = map[string]CloudeventsClientFactory{}
+)
+
+// RegisterTransport registers the factory of the transport for the URL scheme, it's invoked on init by the
+// implementations.
+func RegisterTransport(scheme string, factory TransportFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	transports[scheme] = factory
+}
+
+// RegisterCloudeventsClient registers the factory of the cloudevents client for the URL scheme
+func RegisterCloudeventsClient(scheme string, factory CloudeventsClientFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	cloudeventsClients[scheme] = factory
+}
+
+// New creates the transport registered for the scheme of the opt.Broker
+func New(ctx context.Context, opt *option.Options) (Transport, error) {
+	broker, err := parseBroker(opt.Broker)
+	if err != nil {
+		return nil, err
+	}
+	registryMutex.RLock()
+	factory, ok := transports[broker.Scheme]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported transport scheme %q, the supported schemes are: %s", broker.Scheme,
+			strings.Join(schemes(transports), ", "))
+	}
+	return factory(ctx, broker, opt)
+}
+
+// NewCloudeventsClient creates the cloudevents client registered for the scheme of the opt.Broker
+func NewCloudeventsClient(ctx context.Context, opt *option.Options) (cloudevents.Client, error) {
+	broker, err := parseBroker(opt.Broker)
+	if err != nil {
+		return nil, err
+	}
+	registryMutex.RLock()
+	factory, ok := cloudeventsClients[broker.Scheme]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported cloudevents client scheme %q, the supported schemes are: %s",
+			broker.Scheme, strings.Join(schemes(cloudeventsClients), ", "))
+	}
+	return factory(ctx, broker, opt)
+}
+
+// parseBroker parses the broker into the URL, the broker without the scheme is treated as the MQTT server
+func parseBroker(broker string) (*url.URL, error) {
+	if broker == "" {
+		return nil, fmt.Errorf("the broker is not specified")
+	}
+	if !strings.Contains(broker, "://") {
+		broker = defaultScheme + "://" + broker
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker %q: %v", broker, err)
+	}
+	return u, nil
+}
+
+func schemes[T any](factories map[string]T) []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for scheme := range factories {
+		names = append(names, scheme)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withBrokerAddress returns a copy of the options connecting the address of the broker URL. The TLS is enabled if
+// the scheme is secure, e.g. mqtts.
+func withBrokerAddress(opt *option.Options, address string, secure bool) *option.Options {
+	o := *opt
+	o.Broker = address
+	if secure {
+		tlsConfig := option.TLSConfig{}
+		if opt.TLSConfig != nil {
+			tlsConfig = *opt.TLSConfig
+		}
+		tlsConfig.EnableTLS = true
+		o.TLSConfig = &tlsConfig
+	}
+	return &o
+}
+
+// topicLevels splits the MQTT-style topic into the levels of the dot separated names, e.g. the NATS subjects and the
+// AMQP routing keys. The "." within a level(e.g. the gvr "v1.secrets.") is replaced with the "_", the wildcards "+"/"#"
+// are replaced with the single and multi level wildcards of the names, and the empty levels are dropped.
+func topicLevels(topic, singleWildcard, multiWildcard string) []string {
+	levels := []string{}
+	for _, level := range strings.Split(topic, "/") {
+		switch level {
+		case "":
+			continue
+		case "+":
+			levels = append(levels, singleWildcard)
+		case "#":
+			levels = append(levels, multiWildcard)
+		default:
+			levels = append(levels, strings.ReplaceAll(level, ".", "_"))
+		}
+	}
+	return levels
+}
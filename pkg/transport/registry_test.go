@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+)
+
+func TestParseBroker(t *testing.T) {
+	cases := []struct {
+		broker string
+		scheme string
+		host   string
+	}{
+		{broker: "127.0.0.1:1883", scheme: "mqtt", host: "127.0.0.1:1883"},
+		{broker: "mqtts://broker:8883", scheme: "mqtts", host: "broker:8883"},
+		{broker: "kafka://broker1:9092,broker2:9092", scheme: "kafka", host: "broker1:9092,broker2:9092"},
+		{broker: "mem://hub", scheme: "mem", host: "hub"},
+	}
+	for _, c := range cases {
+		u, err := parseBroker(c.broker)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", c.broker, err)
+		}
+		if u.Scheme != c.scheme || u.Host != c.host {
+			t.Errorf("expected %s to be parsed into %s://%s, got %s://%s", c.broker, c.scheme, c.host, u.Scheme, u.Host)
+		}
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the transports of the same broker name are connected in the process
+	opt := &option.Options{Broker: "mem://registry-test"}
+	sender, err := New(ctx, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Stop()
+	receiver, err := New(ctx, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Stop()
+
+	r, err := receiver.Receive("straw/registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send("straw/registry", apis.TransportMessage{ID: "registry"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-r.MessageChan():
+		if msg.ID != "registry" {
+			t.Fatalf("expected the message registry, got %s", msg.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+
+	if _, err := New(ctx, &option.Options{Broker: "unknown://broker"}); err == nil {
+		t.Fatal("expected the error of the unsupported scheme")
+	}
+}
@@ -0,0 +1,444 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+	"k8s.io/klog/v2"
+)
+
+var _ Transport = (*spoolTransport)(nil)
+
+const (
+	spoolOutbox        = "outbox"
+	spoolInbox         = "inbox"
+	spoolStateFile     = "state.json"
+	spoolSegmentSuffix = ".segment"
+	spoolCorruptSuffix = ".corrupt"
+)
+
+// SpoolOptions are the options of the spool transport, they're kept in the option.Options.TransportOptions["file"]
+type SpoolOptions struct {
+	// SegmentMessages is the max number of the messages in a segment
+	SegmentMessages int
+	// FlushInterval is the interval to write the sent messages into a segment
+	FlushInterval time.Duration
+	// PollInterval is the interval to scan the incoming segments
+	PollInterval time.Duration
+}
+
+func init() {
+	option.AddTransportFlags("file", func(fs *flag.FlagSet) interface{} {
+		o := &SpoolOptions{}
+		fs.IntVarP(&o.SegmentMessages, "spool-segment-messages", "", 1000, "the max number of the messages in a segment")
+		fs.DurationVarP(&o.FlushInterval, "spool-flush-interval", "", time.Second,
+			"the interval to write the sent messages into a segment")
+		fs.DurationVarP(&o.PollInterval, "spool-poll-interval", "", time.Second,
+			"the interval to scan the incoming segments")
+		return o
+	})
+	// the spool directory is the path of the URL, e.g. file:///var/spool/straw
+	RegisterTransport("file", func(ctx context.Context, broker *url.URL, opt *option.Options) (Transport, error) {
+		return NewSpoolTransport(ctx, withBrokerAddress(opt, broker.Path, false))
+	})
+}
+
+// spoolOptions returns the spool options of the opt, or the defaults if they're not set
+func spoolOptions(opt *option.Options) *SpoolOptions {
+	if o, ok := opt.TransportOptions["file"].(*SpoolOptions); ok {
+		return o
+	}
+	return &SpoolOptions{SegmentMessages: 1000, FlushInterval: time.Second, PollInterval: time.Second}
+}
+
+// spoolMessage is a message of the segment with its topic
+type spoolMessage struct {
+	Topic   string                `json:"topic"`
+	Message apis.TransportMessage `json:"message"`
+}
+
+// spoolSegment is the content of a segment file, the checksum is the sha256 of the raw messages
+type spoolSegment struct {
+	Source   string          `json:"source"`
+	Seq      uint64          `json:"seq"`
+	Checksum string          `json:"checksum"`
+	Messages json.RawMessage `json:"messages"`
+}
+
+// spoolState is persisted in the state file: the seq of the last segment written, and the seq of the last segment
+// applied from each source
+type spoolState struct {
+	Seq      uint64            `json:"seq"`
+	Received map[string]uint64 `json:"received"`
+}
+
+// spoolSegmentName returns the file name of the segment, which is ordered by the seq within the source
+func spoolSegmentName(source string, seq uint64) string {
+	return fmt.Sprintf("%s-%020d%s", source, seq, spoolSegmentSuffix)
+}
+
+// parseSpoolSegmentName returns the source and the seq of the segment file name
+func parseSpoolSegmentName(name string) (string, uint64, bool) {
+	if !strings.HasSuffix(name, spoolSegmentSuffix) {
+		return "", 0, false
+	}
+	name = strings.TrimSuffix(name, spoolSegmentSuffix)
+	i := strings.LastIndex(name, "-")
+	if i <= 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(name[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return name[:i], seq, true
+}
+
+// spoolTransport exchanges the messages by the segment files for the air-gapped clusters, which can't keep a live
+// connection. The sent messages are written into the ordered and checksummed segments of the <dir>/outbox, and the
+// segments moved into the <dir>/inbox(e.g. by hand) are consumed in the order of their seq. The seq of the last
+// segment applied from each source is kept in the <dir>/state.json, so that a bundle of the segments can be replayed
+// idempotently: the applied segments are skipped, and the segments after a missing one wait for it.
+type spoolTransport struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	dir      string
+	clientID string
+	options  *SpoolOptions
+	wg       sync.WaitGroup
+
+	// stateMutex guards the state and the pending messages
+	stateMutex sync.Mutex
+	state      spoolState
+	pending    []spoolMessage
+
+	mutex     sync.RWMutex
+	receivers map[string][]*defaultReceiver
+	polling   bool
+}
+
+// NewSpoolTransport creates the outbox and inbox of the spool directory(opt.Broker), the segments are named after the
+// opt.ClientID.
+func NewSpoolTransport(ctx context.Context, opt *option.Options) (*spoolTransport, error) {
+	if opt.Broker == "" {
+		return nil, fmt.Errorf("the spool directory is not specified")
+	}
+	if opt.ClientID == "" || strings.ContainsAny(opt.ClientID, `/\`) {
+		return nil, fmt.Errorf("invalid client id %q of the segments", opt.ClientID)
+	}
+	for _, dir := range []string{spoolOutbox, spoolInbox} {
+		if err := os.MkdirAll(filepath.Join(opt.Broker, dir), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t := &spoolTransport{
+		ctx:       ctx,
+		cancel:    cancel,
+		dir:       opt.Broker,
+		clientID:  opt.ClientID,
+		options:   spoolOptions(opt),
+		state:     spoolState{Received: map[string]uint64{}},
+		receivers: map[string][]*defaultReceiver{},
+	}
+	if err := t.loadState(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.options.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.flush(); err != nil {
+					klog.Errorf("failed to write the segment: %v", err)
+				}
+			case <-t.ctx.Done():
+				return
+			}
+		}
+	}()
+	return t, nil
+}
+
+// loadState loads the state file, the seq continues from the segments left in the outbox if it's behind them
+func (t *spoolTransport) loadState() error {
+	data, err := os.ReadFile(filepath.Join(t.dir, spoolStateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &t.state); err != nil {
+			return fmt.Errorf("failed to decode the spool state: %v", err)
+		}
+		if t.state.Received == nil {
+			t.state.Received = map[string]uint64{}
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(t.dir, spoolOutbox))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if source, seq, ok := parseSpoolSegmentName(entry.Name()); ok && source == t.clientID && seq > t.state.Seq {
+			t.state.Seq = seq
+		}
+	}
+	return nil
+}
+
+// saveState writes the state file atomically, it's invoked with the stateMutex held
+func (t *spoolTransport) saveState() error {
+	data, err := json.Marshal(t.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filepath.Join(t.dir, spoolStateFile), data)
+}
+
+// writeFileAtomically writes the data into a temporary file and renames it to the path, so that the readers never see
+// a partial file
+func writeFileAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Send queues the message into the next segment, the segment is written once it's full or the flush interval is due
+func (t *spoolTransport) Send(topic string, msg apis.TransportMessage) error {
+	t.stateMutex.Lock()
+	t.pending = append(t.pending, spoolMessage{Topic: topic, Message: msg})
+	full := len(t.pending) >= t.options.SegmentMessages
+	t.stateMutex.Unlock()
+	if full {
+		return t.flush()
+	}
+	return nil
+}
+
+// flush writes the pending messages into a new segment of the outbox
+func (t *spoolTransport) flush() error {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+	if len(t.pending) == 0 {
+		return nil
+	}
+	messages, err := json.Marshal(t.pending)
+	if err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(messages)
+	segment := spoolSegment{
+		Source:   t.clientID,
+		Seq:      t.state.Seq + 1,
+		Checksum: hex.EncodeToString(checksum[:]),
+		Messages: messages,
+	}
+	data, err := json.Marshal(segment)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(t.dir, spoolOutbox, spoolSegmentName(segment.Source, segment.Seq))
+	if err := writeFileAtomically(path, data); err != nil {
+		return err
+	}
+	t.state.Seq = segment.Seq
+	t.pending = nil
+	klog.V(4).Infof("wrote the segment %s", path)
+	return t.saveState()
+}
+
+// Receive subscribes the topic filter(the MQTT wildcards are supported), each receiver gets all the messages matched
+// with the filter. The inbox is scanned after the first receiver is added, so that the messages aren't consumed
+// without any receiver.
+func (t *spoolTransport) Receive(topic string) (Receiver, error) {
+	receiver := NewDefaultReceiver(make(chan apis.TransportMessage))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.receivers[topic] = append(t.receivers[topic], receiver)
+	if !t.polling {
+		t.polling = true
+		t.wg.Add(1)
+		go t.poll()
+	}
+	return receiver, nil
+}
+
+func (t *spoolTransport) poll() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.consume(); err != nil && t.ctx.Err() == nil {
+				klog.Errorf("failed to consume the segments: %v", err)
+			}
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// consume applies the segments of the inbox in the order of their seq within each source
+func (t *spoolTransport) consume() error {
+	inbox := filepath.Join(t.dir, spoolInbox)
+	entries, err := os.ReadDir(inbox)
+	if err != nil {
+		return err
+	}
+	segments := map[string][]uint64{}
+	for _, entry := range entries {
+		source, seq, ok := parseSpoolSegmentName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		segments[source] = append(segments[source], seq)
+	}
+
+	for source, seqs := range segments {
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		for _, seq := range seqs {
+			path := filepath.Join(inbox, spoolSegmentName(source, seq))
+			received := t.received(source)
+			if source == t.clientID || seq <= received {
+				// the segment is sent by itself or has been applied, e.g. the bundle is replayed
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+				continue
+			}
+			if seq != received+1 {
+				klog.Warningf("the segment %d of %s is missing, the later segments wait for it", received+1, source)
+				break
+			}
+			if err := t.apply(path, source, seq); err != nil {
+				klog.Errorf("failed to apply the segment %s: %v", path, err)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (t *spoolTransport) received(source string) uint64 {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+	return t.state.Received[source]
+}
+
+// apply hands over the messages of the segment to the receivers, and then records it as applied and removes it. The
+// segment fails the checksum is renamed with the .corrupt suffix, the source waits for the segment to be moved in
+// again.
+func (t *spoolTransport) apply(path, source string, seq uint64) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	segment := spoolSegment{}
+	if err := json.Unmarshal(data, &segment); err != nil {
+		// the segment might be being copied, it's retried in the next scan
+		return fmt.Errorf("the segment is incomplete: %v", err)
+	}
+	messages := []spoolMessage{}
+	checksum := sha256.Sum256(segment.Messages)
+	if hex.EncodeToString(checksum[:]) != segment.Checksum || segment.Source != source || segment.Seq != seq {
+		err = fmt.Errorf("the checksum or the header mismatches")
+	} else {
+		err = json.Unmarshal(segment.Messages, &messages)
+	}
+	if err != nil {
+		if renameErr := os.Rename(path, path+spoolCorruptSuffix); renameErr != nil {
+			klog.Error(renameErr)
+		}
+		return fmt.Errorf("the segment is corrupted: %v", err)
+	}
+
+	for _, msg := range messages {
+		for _, receiver := range t.topicReceivers(msg.Topic) {
+			select {
+			case receiver.msgChan <- msg.Message:
+			case <-t.ctx.Done():
+				return t.ctx.Err()
+			}
+		}
+	}
+
+	t.stateMutex.Lock()
+	t.state.Received[source] = seq
+	err = t.saveState()
+	t.stateMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	klog.V(4).Infof("applied the segment %s with %d messages", path, len(messages))
+	return os.Remove(path)
+}
+
+// topicReceivers returns the receivers whose topic filters match the topic
+func (t *spoolTransport) topicReceivers(topic string) []*defaultReceiver {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	receivers := []*defaultReceiver{}
+	for filter, filterReceivers := range t.receivers {
+		if MatchTopic(filter, topic) {
+			receivers = append(receivers, filterReceivers...)
+		}
+	}
+	return receivers
+}
+
+// Stop writes the pending messages into a segment, and stops consuming the inbox
+func (t *spoolTransport) Stop() {
+	t.cancel()
+	t.wg.Wait()
+	if err := t.flush(); err != nil {
+		klog.Errorf("failed to write the segment: %v", err)
+	}
+
+	t.mutex.Lock()
+	for topic, receivers := range t.receivers {
+		for _, receiver := range receivers {
+			receiver.Stop()
+		}
+		klog.Infof("transport receiver(%s) stopped!", topic)
+	}
+	t.receivers = map[string][]*defaultReceiver{}
+	t.mutex.Unlock()
+	klog.Info("transport is disconnected!")
+}
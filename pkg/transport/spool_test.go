@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/option"
+)
+
+func newSpoolTestTransport(t *testing.T, dir, clientID string) *spoolTransport {
+	transport, err := NewSpoolTransport(context.Background(), &option.Options{
+		Broker:   dir,
+		ClientID: clientID,
+		TransportOptions: map[string]interface{}{
+			"file": &SpoolOptions{SegmentMessages: 2, FlushInterval: time.Hour, PollInterval: 10 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport
+}
+
+// moveSegments copies the segments of the outbox to the inbox, like moving a bundle by hand
+func moveSegments(t *testing.T, outbox, inbox string) []string {
+	entries, err := os.ReadDir(outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(outbox, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomically(filepath.Join(inbox, entry.Name()), data); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func expectNoMessage(t *testing.T, receiver Receiver) {
+	select {
+	case msg := <-receiver.MessageChan():
+		t.Fatalf("expected no message, got %s", msg.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSpoolTransport(t *testing.T) {
+	siteDir, hubDir := t.TempDir(), t.TempDir()
+	outbox, inbox := filepath.Join(siteDir, spoolOutbox), filepath.Join(hubDir, spoolInbox)
+	if err := os.MkdirAll(inbox, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	site := newSpoolTestTransport(t, siteDir, "cluster1")
+	for _, id := range []string{"1", "2", "3"} {
+		if err := site.Send("straw/cluster1/response", apis.TransportMessage{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the full segment is written on sending, and the rest is written on stopping
+	site.Stop()
+	names := moveSegments(t, outbox, inbox)
+	if len(names) != 2 {
+		t.Fatalf("expected 2 segments, got %v", names)
+	}
+
+	// the segments wait for the missing one
+	if err := os.Rename(filepath.Join(inbox, names[0]), filepath.Join(hubDir, names[0])); err != nil {
+		t.Fatal(err)
+	}
+	hub := newSpoolTestTransport(t, hubDir, "hub")
+	defer hub.Stop()
+	receiver, err := hub.Receive("straw/+/response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNoMessage(t, receiver)
+
+	// the segments are applied in order once the missing one arrives
+	if err := os.Rename(filepath.Join(hubDir, names[0]), filepath.Join(inbox, names[0])); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		receiveMessage(t, receiver, id)
+	}
+
+	// the replayed bundle is skipped
+	moveSegments(t, outbox, inbox)
+	expectNoMessage(t, receiver)
+
+	// the corrupted segment isn't applied
+	site = newSpoolTestTransport(t, siteDir, "cluster1")
+	if err := site.Send("straw/cluster1/response", apis.TransportMessage{ID: "4"}); err != nil {
+		t.Fatal(err)
+	}
+	site.Stop()
+	name := spoolSegmentName("cluster1", 3)
+	data, err := os.ReadFile(filepath.Join(outbox, name))
+	if err != nil {
+		t.Fatalf("expected the seq continues from the state: %v", err)
+	}
+	corrupted := bytes.Replace(data, []byte(`"4"`), []byte(`"5"`), 1)
+	if err := writeFileAtomically(filepath.Join(inbox, name), corrupted); err != nil {
+		t.Fatal(err)
+	}
+	expectNoMessage(t, receiver)
+	if _, err := os.Stat(filepath.Join(inbox, name+spoolCorruptSuffix)); err != nil {
+		t.Fatalf("expected the segment is marked as corrupted: %v", err)
+	}
+
+	if err := writeFileAtomically(filepath.Join(inbox, name), data); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, receiver, "4")
+}
@@ -11,4 +11,20 @@ const (
 
 	// ClusterLabelKey is the label key for the cluster name
 	ClusterLabelKey = "cluster"
+
+	// ManagedByLabelKey marks the resources applied by the field manager of straw on the spoke cluster
+	ManagedByLabelKey = "hub.transport-informer/managed-by"
+	// FieldManager is the field manager of the server-side apply
+	FieldManager = "straw"
+	// WorkManager is the value of the ManagedByLabelKey of the resources applied from the manifests of the works,
+	// they're deleted with the works rather than cleaned up as the mirrored resources
+	WorkManager = "straw-work"
+
+	// StatusFeedbackAnnotationKey carries the feedback extracted from the spoke resource
+	StatusFeedbackAnnotationKey = "hub.transport-informer/status-feedback"
+	// StatusFeedbackRecordPrefix is the prefix of the annotations recording the feedback of each cluster on the hub
+	StatusFeedbackRecordPrefix = "feedback.hub.transport-informer"
+
+	// StaleAnnotationKey marks the cached objects of the cluster whose lease is expired
+	StaleAnnotationKey = "hub.transport-informer/stale"
 )
@@ -0,0 +1,159 @@
+package workclient
+
+import (
+	"context"
+
+	workv1alpha1 "github.com/yanmxa/straw/pkg/apis/work/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// ManifestWorksGetter has a method to return a ManifestWorkInterface.
+type ManifestWorksGetter interface {
+	ManifestWorks(namespace string) ManifestWorkInterface
+}
+
+// ManifestWorkInterface has methods to work with the ManifestWork resources.
+type ManifestWorkInterface interface {
+	Create(ctx context.Context, work *workv1alpha1.ManifestWork, opts metav1.CreateOptions) (
+		*workv1alpha1.ManifestWork, error)
+	Update(ctx context.Context, work *workv1alpha1.ManifestWork, opts metav1.UpdateOptions) (
+		*workv1alpha1.ManifestWork, error)
+	UpdateStatus(ctx context.Context, work *workv1alpha1.ManifestWork, opts metav1.UpdateOptions) (
+		*workv1alpha1.ManifestWork, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*workv1alpha1.ManifestWork, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*workv1alpha1.ManifestWorkList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+type workClient struct {
+	client dynamic.Interface
+}
+
+// NewForDynamicClient creates the typed client of the ManifestWorks on top of the dynamic client, so that it shares
+// the client(and the fake client in tests) with the other resources
+func NewForDynamicClient(client dynamic.Interface) ManifestWorksGetter {
+	return &workClient{client: client}
+}
+
+func (c *workClient) ManifestWorks(namespace string) ManifestWorkInterface {
+	return &manifestWorks{resource: c.client.Resource(workv1alpha1.ManifestWorkGVR).Namespace(namespace)}
+}
+
+type manifestWorks struct {
+	resource dynamic.ResourceInterface
+}
+
+func (c *manifestWorks) Create(ctx context.Context, work *workv1alpha1.ManifestWork, opts metav1.CreateOptions) (
+	*workv1alpha1.ManifestWork, error,
+) {
+	obj, err := ToUnstructured(work)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = c.resource.Create(ctx, obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return FromUnstructured(obj)
+}
+
+func (c *manifestWorks) Update(ctx context.Context, work *workv1alpha1.ManifestWork, opts metav1.UpdateOptions) (
+	*workv1alpha1.ManifestWork, error,
+) {
+	obj, err := ToUnstructured(work)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = c.resource.Update(ctx, obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return FromUnstructured(obj)
+}
+
+func (c *manifestWorks) UpdateStatus(ctx context.Context, work *workv1alpha1.ManifestWork,
+	opts metav1.UpdateOptions,
+) (*workv1alpha1.ManifestWork, error) {
+	obj, err := ToUnstructured(work)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = c.resource.UpdateStatus(ctx, obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return FromUnstructured(obj)
+}
+
+func (c *manifestWorks) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.resource.Delete(ctx, name, opts)
+}
+
+func (c *manifestWorks) Get(ctx context.Context, name string, opts metav1.GetOptions) (
+	*workv1alpha1.ManifestWork, error,
+) {
+	obj, err := c.resource.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return FromUnstructured(obj)
+}
+
+func (c *manifestWorks) List(ctx context.Context, opts metav1.ListOptions) (*workv1alpha1.ManifestWorkList, error) {
+	list, err := c.resource.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	works := &workv1alpha1.ManifestWorkList{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.UnstructuredContent(), works); err != nil {
+		return nil, err
+	}
+	return works, nil
+}
+
+// Watch converts the watched objects into the ManifestWorks, the error events are passed through
+func (c *manifestWorks) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := c.resource.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		obj, ok := in.Object.(*unstructured.Unstructured)
+		if !ok || in.Type == watch.Error {
+			return in, true
+		}
+		work, err := FromUnstructured(obj)
+		if err != nil {
+			klog.Errorf("failed to convert the watched object %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+			return in, false
+		}
+		in.Object = work
+		return in, true
+	}), nil
+}
+
+// ToUnstructured converts the work into the unstructured object with its kind
+func ToUnstructured(work *workv1alpha1.ManifestWork) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(work)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetGroupVersionKind(workv1alpha1.SchemeGroupVersion.WithKind("ManifestWork"))
+	return obj, nil
+}
+
+// FromUnstructured converts the unstructured object into the work
+func FromUnstructured(obj *unstructured.Unstructured) (*workv1alpha1.ManifestWork, error) {
+	work := &workv1alpha1.ManifestWork{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, work); err != nil {
+		return nil, err
+	}
+	return work, nil
+}
@@ -0,0 +1,87 @@
+package workclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	workv1alpha1 "github.com/yanmxa/straw/pkg/apis/work/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestManifestWorks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewForDynamicClient(fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{workv1alpha1.ManifestWorkGVR: "ManifestWorkList"}))
+	works := client.ManifestWorks("cluster1")
+
+	work := &workv1alpha1.ManifestWork{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "foo"},
+		Spec: workv1alpha1.ManifestWorkSpec{
+			Workload: workv1alpha1.ManifestsTemplate{Manifests: []workv1alpha1.Manifest{
+				{RawExtension: runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap"}`)}},
+			}},
+		},
+	}
+	if _, err := works.Create(ctx, work, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	informer := NewManifestWorkInformer(client, "cluster1", 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		t.Fatal("failed to sync the informer")
+	}
+	lister := NewManifestWorkLister(informer.GetIndexer())
+	cached, err := lister.ManifestWorks("cluster1").Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached.Spec.Workload.Manifests) != 1 {
+		t.Fatalf("expected the manifest of the work, got %v", cached.Spec.Workload)
+	}
+	if _, err := lister.ManifestWorks("cluster2").Get("foo"); !errors.IsNotFound(err) {
+		t.Fatalf("expected the work not found in cluster2, got %v", err)
+	}
+
+	// the status is watched by the informer
+	work = cached.DeepCopy()
+	work.Status.Clusters = []workv1alpha1.ClusterStatus{{ClusterName: "cluster1"}}
+	if _, err := works.UpdateStatus(ctx, work, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(func() bool {
+		list, err := lister.List(labels.Everything())
+		return err == nil && len(list) == 1 && len(list[0].Status.Clusters) == 1
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := works.Delete(ctx, "foo", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(func() bool {
+		list, err := lister.ManifestWorks("cluster1").List(labels.Everything())
+		return err == nil && len(list) == 0
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wait(condition func() bool) error {
+	for i := 0; i < 50; i++ {
+		if condition() {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return context.DeadlineExceeded
+}
@@ -0,0 +1,90 @@
+package workclient
+
+import (
+	"context"
+	"time"
+
+	workv1alpha1 "github.com/yanmxa/straw/pkg/apis/work/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NewManifestWorkInformer constructs an informer of the ManifestWorks watched from the apiserver.
+func NewManifestWorkInformer(client ManifestWorksGetter, namespace string, resyncPeriod time.Duration,
+	indexers cache.Indexers,
+) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return client.ManifestWorks(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return client.ManifestWorks(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&workv1alpha1.ManifestWork{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+// ManifestWorkLister helps list the ManifestWorks from the indexer of the informer.
+type ManifestWorkLister interface {
+	// List lists all the ManifestWorks in the indexer.
+	List(selector labels.Selector) ([]*workv1alpha1.ManifestWork, error)
+	// ManifestWorks returns an object that can list and get the ManifestWorks of the namespace.
+	ManifestWorks(namespace string) ManifestWorkNamespaceLister
+}
+
+// ManifestWorkNamespaceLister helps list and get the ManifestWorks of a namespace.
+type ManifestWorkNamespaceLister interface {
+	List(selector labels.Selector) ([]*workv1alpha1.ManifestWork, error)
+	Get(name string) (*workv1alpha1.ManifestWork, error)
+}
+
+type manifestWorkLister struct {
+	indexer cache.Indexer
+}
+
+// NewManifestWorkLister returns a new ManifestWorkLister, the indexer should be indexed by the namespace.
+func NewManifestWorkLister(indexer cache.Indexer) ManifestWorkLister {
+	return &manifestWorkLister{indexer: indexer}
+}
+
+func (l *manifestWorkLister) List(selector labels.Selector) (ret []*workv1alpha1.ManifestWork, err error) {
+	err = cache.ListAll(l.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*workv1alpha1.ManifestWork))
+	})
+	return ret, err
+}
+
+func (l *manifestWorkLister) ManifestWorks(namespace string) ManifestWorkNamespaceLister {
+	return &manifestWorkNamespaceLister{indexer: l.indexer, namespace: namespace}
+}
+
+type manifestWorkNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+func (l *manifestWorkNamespaceLister) List(selector labels.Selector) (ret []*workv1alpha1.ManifestWork, err error) {
+	err = cache.ListAllByNamespace(l.indexer, l.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*workv1alpha1.ManifestWork))
+	})
+	return ret, err
+}
+
+func (l *manifestWorkNamespaceLister) Get(name string) (*workv1alpha1.ManifestWork, error) {
+	obj, exists, err := l.indexer.GetByKey(l.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(workv1alpha1.Resource("manifestworks"), name)
+	}
+	return obj.(*workv1alpha1.ManifestWork), nil
+}
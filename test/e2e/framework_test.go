@@ -0,0 +1,284 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/informer"
+	"github.com/yanmxa/straw/pkg/provider"
+	"github.com/yanmxa/straw/pkg/transport"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const (
+	requestTopic  = "straw/e2e/request"
+	responseTopic = "straw/e2e/response"
+
+	// the list responses are sent in chunks of the size
+	listChunkSize = 10
+
+	timeout = 10 * time.Second
+)
+
+var secretGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// pair starts a provider and returns the informer factory connected to it through the memory broker
+type pair struct {
+	name  string
+	start func(t *testing.T, providerCtx, informerCtx context.Context, client dynamic.Interface,
+		resync time.Duration) informer.SharedInformerFactory
+}
+
+var pairs = []pair{
+	{
+		name: "message",
+		start: func(t *testing.T, providerCtx, informerCtx context.Context, client dynamic.Interface,
+			resync time.Duration,
+		) informer.SharedInformerFactory {
+			broker := transport.NewMemoryBroker()
+			// the provider and informer use their own transports, the broker doesn't echo the message to its sender
+			providerTransport := transport.NewMemoryTransport(broker)
+			informerTransport := transport.NewMemoryTransport(broker)
+			t.Cleanup(providerTransport.Stop)
+			t.Cleanup(informerTransport.Stop)
+
+			p := provider.NewDefaultProvider("cluster1", client, providerTransport, responseTopic, requestTopic,
+				listChunkSize, nil)
+			go p.Run(providerCtx)
+
+			return informer.NewSharedMessageInformerFactory(informerCtx, informerTransport, resync,
+				requestTopic, responseTopic, metav1.NamespaceAll, nil)
+		},
+	},
+	{
+		name: "event",
+		start: func(t *testing.T, providerCtx, informerCtx context.Context, client dynamic.Interface,
+			resync time.Duration,
+		) informer.SharedInformerFactory {
+			broker := transport.NewMemoryBroker()
+			providerClient, err := transport.MemoryCloudeventsClient(broker, responseTopic, requestTopic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			informerClient, err := transport.MemoryCloudeventsClient(broker, requestTopic, responseTopic)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := provider.NewProvider("cluster1", client, providerClient, listChunkSize, nil)
+			go p.Run(providerCtx)
+
+			return informer.NewSharedEventInformerFactory(informerCtx, informerClient, resync, metav1.NamespaceAll,
+				nil)
+		},
+	},
+}
+
+// watchRecorder serves the watch requests of the fake client from its tracker, and records the watchers so that the
+// tests can wait for the provider to start watching and check whether the watchers are stopped.
+type watchRecorder struct {
+	mutex    sync.Mutex
+	watchers []*recordedWatcher
+	// expired makes the watch requests with a resourceVersion fail with the 410 Expired
+	expired bool
+}
+
+type recordedWatcher struct {
+	watch.Interface
+	resourceVersion string
+	stopOnce        sync.Once
+	stopped         chan struct{}
+}
+
+// close closes the result chan as the apiserver ends the watch, it isn't stopped by the provider
+func (w *recordedWatcher) close() {
+	w.Interface.Stop()
+}
+
+func (w *recordedWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stopped) })
+	w.Interface.Stop()
+}
+
+func newFakeClient(objects ...runtime.Object) (*fake.FakeDynamicClient, *watchRecorder) {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{secretGVR: "SecretList"}, objects...)
+
+	recorder := &watchRecorder{}
+	client.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		resourceVersion := action.(clienttesting.WatchAction).GetWatchRestrictions().ResourceVersion
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		if recorder.expired && resourceVersion != "" {
+			return true, nil, apierrors.NewResourceExpired("too old resource version")
+		}
+
+		w, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		recorded := &recordedWatcher{Interface: w, resourceVersion: resourceVersion, stopped: make(chan struct{})}
+		recorder.watchers = append(recorder.watchers, recorded)
+		return true, recorded, nil
+	})
+	return client, recorder
+}
+
+// waitForWatcher waits until the provider has started a watcher, and returns the latest one
+func (r *watchRecorder) waitForWatcher(t *testing.T) *recordedWatcher {
+	t.Helper()
+	return r.waitForWatchers(t, 1)
+}
+
+// waitForWatchers waits until the provider has started n watchers, and returns the latest one
+func (r *watchRecorder) waitForWatchers(t *testing.T, n int) *recordedWatcher {
+	t.Helper()
+	var w *recordedWatcher
+	eventually(t, fmt.Sprintf("the provider starts %d watchers", n), func() bool {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if len(r.watchers) < n {
+			return false
+		}
+		w = r.watchers[len(r.watchers)-1]
+		return true
+	})
+	return w
+}
+
+func (r *watchRecorder) setExpired(expired bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expired = expired
+}
+
+// listCount returns the number of the list requests served by the client
+func listCount(client *fake.FakeDynamicClient) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" {
+			count++
+		}
+	}
+	return count
+}
+
+func newSecret(namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace(namespace)
+	secret.SetName(name)
+	secret.SetLabels(labels)
+	return secret
+}
+
+// cachedLabels returns the labels of the object in the informer cache, and whether the object exists
+func cachedLabels(t *testing.T, factory informer.SharedInformerFactory, key string) (map[string]string, bool) {
+	t.Helper()
+	obj, exists, err := factory.ForResource(secretGVR).Informer().GetStore().GetByKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		return nil, false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accessor.GetLabels(), true
+}
+
+func eventually(t *testing.T, desc string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// pagingClient serves the lists page by page with the Limit/Continue, which are ignored by the fake client. It
+// records the limits of the list requests.
+type pagingClient struct {
+	dynamic.Interface
+	mutex  sync.Mutex
+	limits []int64
+}
+
+func (c *pagingClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &pagingResource{NamespaceableResourceInterface: c.Interface.Resource(gvr), client: c}
+}
+
+func (c *pagingClient) listLimits() []int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]int64{}, c.limits...)
+}
+
+type pagingResource struct {
+	dynamic.NamespaceableResourceInterface
+	client *pagingClient
+}
+
+func (r *pagingResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &pagingNamespacedResource{ResourceInterface: r.NamespaceableResourceInterface.Namespace(namespace),
+		client: r.client}
+}
+
+type pagingNamespacedResource struct {
+	dynamic.ResourceInterface
+	client *pagingClient
+}
+
+func (r *pagingNamespacedResource) List(ctx context.Context, opts metav1.ListOptions) (
+	*unstructured.UnstructuredList, error,
+) {
+	r.client.mutex.Lock()
+	r.client.limits = append(r.client.limits, opts.Limit)
+	r.client.mutex.Unlock()
+
+	list, err := r.ResourceInterface.List(ctx, opts)
+	if err != nil || opts.Limit <= 0 {
+		return list, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].GetNamespace()+"/"+list.Items[i].GetName() <
+			list.Items[j].GetNamespace()+"/"+list.Items[j].GetName()
+	})
+
+	// the continue token is the offset of the next page
+	offset := 0
+	if opts.Continue != "" {
+		if offset, err = strconv.Atoi(opts.Continue); err != nil {
+			return nil, err
+		}
+	}
+	end := offset + int(opts.Limit)
+	list.SetContinue("")
+	if end < len(list.Items) {
+		list.SetContinue(strconv.Itoa(end))
+	} else {
+		end = len(list.Items)
+	}
+	list.Items = list.Items[offset:end]
+	list.SetResourceVersion(strconv.Itoa(100 + end))
+	return list, nil
+}
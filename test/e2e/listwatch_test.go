@@ -0,0 +1,331 @@
+package e2e
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/informer"
+	"github.com/yanmxa/straw/pkg/provider"
+	"github.com/yanmxa/straw/pkg/transport"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestListWatch(t *testing.T) {
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client, recorder := newFakeClient(newSecret("default", "existing", map[string]string{"app": "foo"}))
+			factory := p.start(t, ctx, ctx, client, 0)
+			factory.ForResource(secretGVR)
+			factory.Start()
+			for gvr, synced := range factory.WaitForCacheSync(ctx.Done()) {
+				if !synced {
+					t.Fatalf("the informer of %s isn't synced", gvr)
+				}
+			}
+
+			// the listed object
+			if labels, exists := cachedLabels(t, factory, "default/existing"); !exists || labels["app"] != "foo" {
+				t.Fatalf("expected the listed secret with label app=foo, got %v(exists=%v)", labels, exists)
+			}
+			recorder.waitForWatcher(t)
+
+			secrets := client.Resource(secretGVR).Namespace("default")
+
+			// add
+			_, err := secrets.Create(ctx, newSecret("default", "added", map[string]string{"app": "bar"}),
+				metav1.CreateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the added secret", func() bool {
+				labels, exists := cachedLabels(t, factory, "default/added")
+				return exists && labels["app"] == "bar"
+			})
+
+			// update
+			_, err = secrets.Update(ctx, newSecret("default", "added", map[string]string{"app": "baz"}),
+				metav1.UpdateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the updated secret", func() bool {
+				labels, exists := cachedLabels(t, factory, "default/added")
+				return exists && labels["app"] == "baz"
+			})
+
+			// delete
+			if err := secrets.Delete(ctx, "existing", metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the deleted secret", func() bool {
+				_, exists := cachedLabels(t, factory, "default/existing")
+				return !exists
+			})
+		})
+	}
+}
+
+func TestStopWatch(t *testing.T) {
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			providerCtx, providerCancel := context.WithCancel(context.Background())
+			defer providerCancel()
+			informerCtx, informerCancel := context.WithCancel(providerCtx)
+			defer informerCancel()
+
+			client, recorder := newFakeClient()
+			factory := p.start(t, providerCtx, informerCtx, client, 0)
+			factory.ForResource(secretGVR)
+			factory.Start()
+			factory.WaitForCacheSync(informerCtx.Done())
+
+			w := recorder.waitForWatcher(t)
+
+			// stopping the informer stops its watcher, which should stop the watcher of the provider as well
+			informerCancel()
+			select {
+			case <-w.stopped:
+			case <-time.After(timeout):
+				t.Fatal("the provider doesn't stop the watcher after the informer is stopped")
+			}
+		})
+	}
+}
+
+func TestResync(t *testing.T) {
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client, _ := newFakeClient(newSecret("default", "existing", nil))
+			factory := p.start(t, ctx, ctx, client, 100*time.Millisecond)
+
+			var resyncs int32
+			factory.ForResource(secretGVR).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					atomic.AddInt32(&resyncs, 1)
+				},
+			})
+			factory.Start()
+			factory.WaitForCacheSync(ctx.Done())
+
+			// nothing is changed, so the updates are delivered by the resync
+			eventually(t, "the resync", func() bool {
+				return atomic.LoadInt32(&resyncs) >= 2
+			})
+		})
+	}
+}
+
+func TestChunkedList(t *testing.T) {
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			secrets := []runtime.Object{}
+			for i := 0; i < 25; i++ {
+				secrets = append(secrets, newSecret("default", fmt.Sprintf("secret-%02d", i), nil))
+			}
+			fakeClient, _ := newFakeClient(secrets...)
+			client := &pagingClient{Interface: fakeClient}
+
+			factory := p.start(t, ctx, ctx, client, 0)
+			factory.ForResource(secretGVR)
+			factory.Start()
+			factory.WaitForCacheSync(ctx.Done())
+
+			if n := len(factory.ForResource(secretGVR).Informer().GetStore().List()); n != 25 {
+				t.Fatalf("expected 25 secrets in the informer, got %d", n)
+			}
+			// the apiserver is listed once by the cache of the provider, the informer's list is served from the cache
+			// in the chunks of 10 objects
+			if limits := client.listLimits(); len(limits) != 1 {
+				t.Fatalf("expected the apiserver to be listed once, got the limits %v", limits)
+			}
+			// the resourceVersion of the list is the one of the cache
+			if rv := factory.ForResource(secretGVR).Informer().LastSyncResourceVersion(); rv != "125" {
+				t.Fatalf("expected the resourceVersion 125 of the cache, got %q", rv)
+			}
+		})
+	}
+}
+
+func TestSharedWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, recorder := newFakeClient(newSecret("default", "existing", nil))
+	broker := transport.NewMemoryBroker()
+	providerTransport := transport.NewMemoryTransport(broker)
+	defer providerTransport.Stop()
+	p := provider.NewDefaultProvider("cluster1", client, providerTransport, responseTopic, requestTopic,
+		listChunkSize, nil)
+	go p.Run(ctx)
+
+	// the informers of the same resources share the cache of the provider
+	factories := []informer.SharedInformerFactory{}
+	for i := 0; i < 2; i++ {
+		informerTransport := transport.NewMemoryTransport(broker)
+		defer informerTransport.Stop()
+		factory := informer.NewSharedMessageInformerFactory(ctx, informerTransport, 0, requestTopic, responseTopic,
+			metav1.NamespaceAll, nil)
+		factory.ForResource(secretGVR)
+		factory.Start()
+		factory.WaitForCacheSync(ctx.Done())
+		factories = append(factories, factory)
+	}
+
+	if _, err := client.Resource(secretGVR).Namespace("default").Create(ctx,
+		newSecret("default", "added", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, factory := range factories {
+		eventually(t, "the added secret", func() bool {
+			_, exists := cachedLabels(t, factory, "default/added")
+			return exists
+		})
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if n := len(recorder.watchers); n != 1 {
+		t.Fatalf("expected a single watcher against the apiserver, got %d", n)
+	}
+	if n := listCount(client); n != 1 {
+		t.Fatalf("expected the apiserver to be listed once, got %d", n)
+	}
+}
+
+func TestFullObjects(t *testing.T) {
+	informer.RegisterObjectFunc(apis.ToGVRString(secretGVR), func() runtime.Object {
+		return &corev1.Secret{}
+	})
+	// the data of the secret cached in the mode
+	modes := map[informer.ObjectMode]func(obj interface{}) string{
+		informer.UnstructuredObject: func(obj interface{}) string {
+			data, _, _ := unstructured.NestedString(obj.(*unstructured.Unstructured).Object, "data", "key")
+			return data
+		},
+		informer.TypedObject: func(obj interface{}) string {
+			return base64.StdEncoding.EncodeToString(obj.(*corev1.Secret).Data["key"])
+		},
+	}
+
+	for _, p := range pairs {
+		for mode, secretData := range modes {
+			t.Run(p.name+"/"+mode.String(), func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				existing := newSecret("default", "existing", nil)
+				if err := unstructured.SetNestedField(existing.Object, "Zm9v", "data", "key"); err != nil {
+					t.Fatal(err)
+				}
+				client, recorder := newFakeClient(existing)
+				factory := p.start(t, ctx, ctx, client, 0)
+				secretInformer := factory.ForResourceWithMode(secretGVR, mode)
+				factory.Start()
+				factory.WaitForCacheSync(ctx.Done())
+
+				// the listed object
+				obj, err := secretInformer.Lister().ByNamespace("default").Get("existing")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data := secretData(obj); data != "Zm9v" {
+					t.Fatalf("expected the listed secret with the data Zm9v, got %q", data)
+				}
+				recorder.waitForWatcher(t)
+
+				// the watched object
+				added := newSecret("default", "added", nil)
+				if err := unstructured.SetNestedField(added.Object, "YmFy", "data", "key"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := client.Resource(secretGVR).Namespace("default").Create(ctx, added,
+					metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				eventually(t, "the added secret with data", func() bool {
+					obj, exists, err := secretInformer.Informer().GetStore().GetByKey("default/added")
+					return err == nil && exists && secretData(obj) == "YmFy"
+				})
+			})
+		}
+	}
+}
+
+// receiveCountingTransport counts the receivers subscribed to the transport
+type receiveCountingTransport struct {
+	transport.Transport
+	receivers atomic.Int32
+}
+
+func (t *receiveCountingTransport) Receive(topic string) (transport.Receiver, error) {
+	t.receivers.Add(1)
+	return t.Transport.Receive(topic)
+}
+
+func TestModesShareListWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, recorder := newFakeClient(newSecret("default", "existing", nil))
+	broker := transport.NewMemoryBroker()
+	providerTransport := transport.NewMemoryTransport(broker)
+	defer providerTransport.Stop()
+	p := provider.NewDefaultProvider("cluster1", client, providerTransport, responseTopic, requestTopic,
+		listChunkSize, nil)
+	go p.Run(ctx)
+
+	// the informers of the resource in the modes share the receiver of the topic
+	informerTransport := &receiveCountingTransport{Transport: transport.NewMemoryTransport(broker)}
+	defer informerTransport.Stop()
+	factory := informer.NewSharedMessageInformerFactory(ctx, informerTransport, 0, requestTopic, responseTopic,
+		metav1.NamespaceAll, nil)
+	metadataInformer := factory.ForResourceWithMode(secretGVR, informer.MetadataObject)
+	unstructuredInformer := factory.ForResourceWithMode(secretGVR, informer.UnstructuredObject)
+	factory.Start()
+	factory.WaitForCacheSync(ctx.Done())
+	if n := informerTransport.receivers.Load(); n != 1 {
+		t.Fatalf("expected a single receiver of the topic, got %d", n)
+	}
+	recorder.waitForWatcher(t)
+
+	// each informer caches the objects in its own type from its own watcher
+	if _, err := client.Resource(secretGVR).Namespace("default").Create(ctx,
+		newSecret("default", "added", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the added secret of the informers", func() bool {
+		metadata, exists, err := metadataInformer.Informer().GetStore().GetByKey("default/added")
+		if err != nil || !exists {
+			return false
+		}
+		if _, ok := metadata.(*metav1.PartialObjectMetadata); !ok {
+			t.Fatalf("expected the metadata of the secret, got %T", metadata)
+		}
+		obj, exists, err := unstructuredInformer.Informer().GetStore().GetByKey("default/added")
+		if err != nil || !exists {
+			return false
+		}
+		if _, ok := obj.(*unstructured.Unstructured); !ok {
+			t.Fatalf("expected the unstructured secret, got %T", obj)
+		}
+		return true
+	})
+}
@@ -0,0 +1,88 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/cluster"
+	"github.com/yanmxa/straw/pkg/provider"
+	"github.com/yanmxa/straw/pkg/reflector"
+	"github.com/yanmxa/straw/pkg/transport"
+	"github.com/yanmxa/straw/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestClusterReflector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := transport.NewMemoryBroker()
+
+	// each cluster serves its own secret, and renews its lease by the heartbeat
+	heartbeats := map[string]context.CancelFunc{}
+	clients := map[string]*fake.FakeDynamicClient{}
+	for _, name := range []string{"cluster1", "cluster2"} {
+		client, _ := newFakeClient(newSecret("default", name+"-secret", nil))
+		providerClient, err := transport.MemoryCloudeventsClient(broker, responseTopic, requestTopic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go provider.NewProvider(name, client, providerClient, listChunkSize, nil).Run(ctx)
+
+		heartbeatCtx, stop := context.WithCancel(ctx)
+		heartbeats[name] = stop
+		go cluster.Heartbeat(heartbeatCtx, providerClient, name, 100*time.Millisecond)
+		clients[name] = client
+	}
+
+	hubClient, err := transport.MemoryCloudeventsClient(broker, requestTopic, responseTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := reflector.NewReflectorFactory(ctx, secretGVR, hubClient, time.Minute)
+	go factory.Run(ctx.Done())
+	store := factory.GetStore()
+
+	// the objects of the clusters are listed into the shared store, keyed by the clusters
+	eventually(t, "the secrets of both clusters are listed", func() bool {
+		_, exists1, _ := store.GetByKey("cluster1#default/cluster1-secret")
+		_, exists2, _ := store.GetByKey("cluster2#default/cluster2-secret")
+		return exists1 && exists2 && len(store.ListKeys()) == 2
+	})
+
+	// the watched object is added only for its cluster
+	if _, err := clients["cluster2"].Resource(secretGVR).Namespace("default").Create(ctx,
+		newSecret("default", "watched", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the watched secret of cluster2 is added", func() bool {
+		_, exists, _ := store.GetByKey("cluster2#default/watched")
+		return exists && len(store.ListKeys()) == 3
+	})
+
+	// cluster1 leaves, its objects are kept as stale
+	heartbeats["cluster1"]()
+	eventually(t, "cluster1 is unregistered", func() bool {
+		_, ok := factory.Registry().Get("cluster1")
+		return !ok
+	})
+	for key, stale := range map[string]bool{
+		"cluster1#default/cluster1-secret": true,
+		"cluster2#default/cluster2-secret": false,
+		"cluster2#default/watched":         false,
+	} {
+		obj, exists, err := store.GetByKey(key)
+		if err != nil || !exists {
+			t.Fatalf("expected %s cached, got %v", key, err)
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (accessor.GetAnnotations()[utils.StaleAnnotationKey] == "true") != stale {
+			t.Fatalf("expected %s stale %v, got %v", key, stale, accessor.GetAnnotations())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package e2e
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/informer"
+	"github.com/yanmxa/straw/pkg/provider"
+	"github.com/yanmxa/straw/pkg/transport"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResumeWatch(t *testing.T) {
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client, recorder := newFakeClient()
+			factory := p.start(t, ctx, ctx, client, 0)
+			factory.ForResource(secretGVR)
+			factory.Start()
+			factory.WaitForCacheSync(ctx.Done())
+			w := recorder.waitForWatcher(t)
+
+			secret := newSecret("default", "foo", nil)
+			secret.SetResourceVersion("5")
+			if _, err := client.Resource(secretGVR).Namespace("default").Create(ctx, secret,
+				metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the created secret", func() bool {
+				_, exists := cachedLabels(t, factory, "default/foo")
+				return exists
+			})
+
+			// the provider resumes the watcher from the last delivered resourceVersion without a relist
+			w.close()
+			resumed := recorder.waitForWatchers(t, 2)
+			if resumed.resourceVersion != "5" {
+				t.Fatalf("expected the watcher to be resumed from the resourceVersion 5, got %q",
+					resumed.resourceVersion)
+			}
+			if n := listCount(client); n != 1 {
+				t.Fatalf("expected the informer doesn't relist, got %d lists", n)
+			}
+
+			secret.SetLabels(map[string]string{"app": "bar"})
+			secret.SetResourceVersion("6")
+			if _, err := client.Resource(secretGVR).Namespace("default").Update(ctx, secret,
+				metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the updated secret", func() bool {
+				labels, _ := cachedLabels(t, factory, "default/foo")
+				return labels["app"] == "bar"
+			})
+		})
+	}
+}
+
+func TestResumeWatchExpired(t *testing.T) {
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client, recorder := newFakeClient()
+			factory := p.start(t, ctx, ctx, client, 0)
+			factory.ForResource(secretGVR)
+			factory.Start()
+			factory.WaitForCacheSync(ctx.Done())
+			w := recorder.waitForWatcher(t)
+
+			secret := newSecret("default", "foo", nil)
+			secret.SetResourceVersion("5")
+			if _, err := client.Resource(secretGVR).Namespace("default").Create(ctx, secret,
+				metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the created secret", func() bool {
+				_, exists := cachedLabels(t, factory, "default/foo")
+				return exists
+			})
+
+			// the resourceVersion is too old to resume, the informer should relist by the 410 Expired
+			recorder.setExpired(true)
+			w.close()
+			eventually(t, "the relist", func() bool {
+				return listCount(client) == 2
+			})
+		})
+	}
+}
+
+// notifyingTransport reports the connection state of the transport to the informers
+type notifyingTransport struct {
+	transport.Transport
+	mutex    sync.Mutex
+	handlers []func(state transport.ConnectionState)
+}
+
+func (t *notifyingTransport) AddConnectionStateHandler(handler func(state transport.ConnectionState)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.handlers = append(t.handlers, handler)
+}
+
+func (t *notifyingTransport) notify(state transport.ConnectionState) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, handler := range t.handlers {
+		handler(state)
+	}
+}
+
+func TestRelistAfterReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, recorder := newFakeClient()
+	broker := transport.NewMemoryBroker()
+	providerTransport := transport.NewMemoryTransport(broker)
+	defer providerTransport.Stop()
+	informerTransport := &notifyingTransport{Transport: transport.NewMemoryTransport(broker)}
+	defer informerTransport.Stop()
+
+	p := provider.NewDefaultProvider("cluster1", client, providerTransport, responseTopic, requestTopic,
+		listChunkSize, nil)
+	go p.Run(ctx)
+	factory := informer.NewSharedMessageInformerFactory(ctx, informerTransport, 0, requestTopic, responseTopic,
+		metav1.NamespaceAll, nil)
+	factory.ForResource(secretGVR)
+	factory.Start()
+	factory.WaitForCacheSync(ctx.Done())
+	w := recorder.waitForWatcher(t)
+
+	// the messages might be lost during the outage, so the informer relists after the transport is reconnected
+	informerTransport.notify(transport.Disconnected)
+	informerTransport.notify(transport.Connected)
+	eventually(t, "the relist", func() bool {
+		return listCount(client) == 2
+	})
+	select {
+	case <-w.stopped:
+	case <-time.After(timeout):
+		t.Fatal("the provider doesn't stop the watcher before the relist")
+	}
+}
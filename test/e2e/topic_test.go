@@ -0,0 +1,122 @@
+package e2e
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yanmxa/straw/pkg/apis"
+	"github.com/yanmxa/straw/pkg/cluster"
+	"github.com/yanmxa/straw/pkg/informer"
+	"github.com/yanmxa/straw/pkg/provider"
+	"github.com/yanmxa/straw/pkg/transport"
+	"github.com/yanmxa/straw/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestTopicTemplate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	template := apis.TopicTemplate("straw/{cluster}/{direction}/{gvr}")
+	broker := transport.NewMemoryBroker()
+
+	// each provider only serves the requests addressed to its own cluster, the cluster3 isn't listed by the hub since
+	// it's neither in the clusters nor registered. The secret default/shared of each cluster is cached as its own
+	// object
+	clients := map[string]*fake.FakeDynamicClient{}
+	transports := map[string]transport.Transport{}
+	for _, cluster := range []string{"cluster1", "cluster2", "cluster3"} {
+		client, _ := newFakeClient(newSecret("default", cluster+"-secret", nil),
+			newSecret("default", "shared", map[string]string{"owner": cluster}))
+		clients[cluster] = client
+
+		providerTransport := transport.NewMemoryTransport(broker)
+		t.Cleanup(providerTransport.Stop)
+		transports[cluster] = providerTransport
+		p := provider.NewDefaultProvider(cluster, client, providerTransport,
+			template.Render(cluster, apis.DirectionResponse), template.Render(cluster, apis.DirectionRequest),
+			listChunkSize, nil)
+		go p.Run(ctx)
+	}
+
+	// the hub caches the cluster1 of the clusters, and the cluster2 once it's registered by its heartbeats
+	hubTransport := transport.NewMemoryTransport(broker)
+	t.Cleanup(hubTransport.Stop)
+	factory := informer.NewSharedMultiClusterMessageInformerFactory(ctx, hubTransport, 0,
+		template.Render(apis.ClusterPlaceholder, apis.DirectionRequest),
+		template.Render(apis.ClusterPlaceholder, apis.DirectionResponse),
+		[]string{"cluster1"}, metav1.NamespaceAll, nil)
+	added := map[string]bool{}
+	var mutex sync.Mutex
+	_, err := factory.ForResource(secretGVR).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			added[obj.(metav1.Object).GetLabels()[utils.ClusterLabelKey]] = true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory.Start()
+	for gvr, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			t.Fatalf("the informer of %s isn't synced", gvr)
+		}
+	}
+
+	heartbeatTopic := template.HeartbeatTopic(utils.HubClusterName)
+	registry := cluster.NewRegistry(time.Minute, factory.AddCluster, func(string) {})
+	go func() {
+		if err := registry.Receive(ctx, hubTransport, heartbeatTopic); err != nil {
+			t.Error(err)
+		}
+	}()
+	go cluster.TransportHeartbeat(ctx, transports["cluster2"], heartbeatTopic, "cluster2", 10*time.Millisecond)
+	eventually(t, "the registered cluster2 is synced", func() bool {
+		_, exists := cachedLabels(t, factory, "cluster2#default/cluster2-secret")
+		return exists && factory.ForResource(secretGVR).Informer().HasSynced()
+	})
+
+	// the store aggregates the objects of both clusters, which are labeled with their clusters
+	for _, cluster := range []string{"cluster1", "cluster2"} {
+		if _, exists := cachedLabels(t, factory, cluster+"#default/"+cluster+"-secret"); !exists {
+			t.Fatalf("expected the listed secret of %s", cluster)
+		}
+		labels, exists := cachedLabels(t, factory, cluster+"#default/shared")
+		if !exists || labels["owner"] != cluster || labels[utils.ClusterLabelKey] != cluster {
+			t.Fatalf("expected the shared secret of %s, got %v", cluster, labels)
+		}
+	}
+	if _, exists := cachedLabels(t, factory, "cluster3#default/cluster3-secret"); exists {
+		t.Fatal("expected the secret of cluster3 isn't listed")
+	}
+	if count := len(factory.ForResource(secretGVR).Informer().GetStore().List()); count != 4 {
+		t.Fatalf("expected 4 secrets of the clusters, got %d", count)
+	}
+	if count := listCount(clients["cluster3"]); count != 0 {
+		t.Fatalf("expected no request is routed to cluster3, got %d lists", count)
+	}
+
+	// the handler is notified of the objects of the registered cluster as well
+	eventually(t, "the handler is notified of both clusters", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return added["cluster1"] && added["cluster2"]
+	})
+
+	// the watch events of each cluster are aggregated as well
+	_, err = clients["cluster2"].Resource(secretGVR).Namespace("default").Create(ctx,
+		newSecret("default", "added", map[string]string{"app": "foo"}), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the added secret of cluster2", func() bool {
+		labels, exists := cachedLabels(t, factory, "cluster2#default/added")
+		return exists && labels["app"] == "foo" && labels[utils.ClusterLabelKey] == "cluster2"
+	})
+}